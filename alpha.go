@@ -0,0 +1,60 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// AlphaBlend describes how the controller blends a 1bpp overlay over the image
+// already displayed.
+//
+// The UP0SR bit(s) enabling the blending are not part of the public programming
+// guide and may differ between firmwares, so they have to be given by the caller.
+type AlphaBlend struct {
+	Enable UpdateParam // UP0SR bit(s) enabling alpha blending
+	Value  uint16      // alpha blend value written to LUT0ABFRV
+	Off    uint8       // gray value of 0 bits
+	On     uint8       // gray value of 1 bits
+}
+
+// LoadOverlay1bpp loads a 1bpp overlay at targetAddress, e.g. a spare buffer
+// after the displayed image, without displaying it
+func LoadOverlay1bpp(buffer DataBuffer, area image.Rectangle, targetAddress uint32) {
+	Debug("Loading 1bpp overlay %v at %x", area, targetAddress)
+	Write1bppRect(buffer, area, targetAddress, true, Rotate0)
+}
+
+// Display blends the 1bpp overlay loaded at overlayAddress over the displayed
+// image, then restores the update parameters
+func (blend AlphaBlend) Display(area image.Rectangle, mode DisplayMode, overlayAddress uint32) {
+	Debug("Alpha blending overlay %v from %x", area, overlayAddress)
+	WaitForDisplayReady()
+	previousValue := ReadAlphaFillValue()
+
+	SetUpdateParams(UP1SR, BitmapMode, true)
+	SetUpdateParams(UP0SR, blend.Enable, true)
+	WriteAlphaFillValue(blend.Value)
+	SetBitmapColors(blend.Off, blend.On)
+
+	DisplayRectBuffer(area, mode, overlayAddress)
+	WaitForDisplayReady()
+
+	SetUpdateParams(UP0SR, blend.Enable, false)
+	SetUpdateParams(UP1SR, BitmapMode, false)
+	WriteAlphaFillValue(previousValue)
+}
@@ -0,0 +1,104 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Attach connects to a controller that was already initialized, typically by
+// a previous run of the program, without resetting it: the panel content is
+// left untouched. The controller is only woken up and probed; Attach fails
+// with ErrNotInitialized when the system info does not look sane, when the
+// VCOM is not the expected one (a vcom of 0 skips this check) or when the
+// packed mode setting differs from the configuration, and with ErrNotReady
+// when the controller does not answer at all. In that case peripherals are
+// closed again and Init should be used instead:
+//
+//	devInfo, err := it8951.Attach(vcom)
+//	if err != nil {
+//		devInfo, err = it8951.Init(vcom)
+//	}
+func Attach(vcom uint16, options ...Option) (*DevInfo, error) {
+	Debug("Attach")
+	config = DefaultConfig()
+	for _, option := range options {
+		option(&config)
+	}
+	model, err := namedPanelModel()
+	if err != nil {
+		return nil, err
+	}
+	if err := Open(); err != nil {
+		return nil, err
+	}
+	// release reset in case the previous owner left it asserted (see Close):
+	// the controller then boots afresh and the probe fails
+	bus.Reset(false)
+	SystemRun()
+	devInfo := RefreshDevInfo()
+	err = Err()
+	if err == nil {
+		err = probe(devInfo, vcom)
+	}
+	if err != nil {
+		Debug("Attach failed: %v", err)
+		invalidateDevInfo()
+		Close()
+		return nil, err
+	}
+	vcomSetting = vcom
+	if vcom == 0 {
+		vcomSetting = ReadVCOM()
+	}
+	applyPanelModel(model, devInfo)
+	applyModes(devInfo.Firmware())
+	applyMemorySize(devInfo)
+	applyChunkSize(devInfo)
+	defaultDriving = ReadRegister(DRVCR)
+	if config.DrivingStrength != DrivingDefault {
+		SetDrivingStrength(config.DrivingStrength)
+	}
+	return devInfo, Err()
+}
+
+// probe checks the controller state matches an initialized one
+func probe(devInfo *DevInfo, vcom uint16) error {
+	if devInfo.PanelW == 0 || devInfo.PanelW == 0xffff ||
+		devInfo.PanelH == 0 || devInfo.PanelH == 0xffff {
+		return fmt.Errorf("%w: bad panel size %dx%d", ErrNotInitialized, devInfo.PanelW, devInfo.PanelH)
+	}
+	version := trimVersion(wordsToString(devInfo.FWVersion))
+	if version == "" {
+		return fmt.Errorf("%w: empty firmware version", ErrNotInitialized)
+	}
+	for _, r := range version {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("%w: bad firmware version %q", ErrNotInitialized, version)
+		}
+	}
+	if packed := ReadRegister(I80CPCR); packed != packedModeValue() {
+		return fmt.Errorf("%w: packed mode is %04x", ErrNotInitialized, packed)
+	}
+	if current := ReadVCOM(); vcom != 0 && current != vcom {
+		return fmt.Errorf("%w: VCOM is %d, not %d", ErrNotInitialized, current, vcom)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// AuditMode is what the protocol audit does with violations (see WithAudit)
+type AuditMode uint8
+
+// Audit modes
+const (
+	AuditOff   AuditMode = iota
+	AuditLog             // log violations as errors and count them (see Stats)
+	AuditPanic           // panic on the first violation
+)
+
+// WithAudit checks every transfer against the host interface protocol, for
+// development against a mock transport or on a bench: the chip select
+// framing each preamble and its words, the ready line being read high before
+// each transfer, command preambles followed by a single word, write
+// preambles by words sent only, and read preambles by a dummy word then
+// data. Violations are logged or panic, depending on mode.
+func WithAudit(mode AuditMode) Option {
+	return func(c *Config) {
+		c.Audit = mode
+	}
+}
+
+// auditPhase is where an audited transport is in the protocol
+type auditPhase uint8
+
+// audit phases
+const (
+	auditIdle     auditPhase = iota // chip deselected
+	auditSelected                   // chip selected, preamble expected
+	auditCommand                    // command preamble sent
+	auditWrite                      // write preamble sent
+	auditRead                       // read preamble sent
+)
+
+// auditTransport checks the transfers of the transport it wraps
+type auditTransport struct {
+	Transport
+	mode  AuditMode
+	phase auditPhase
+	ready bool // ready line read high since the last transfer
+	words int  // words transferred since the preamble
+	reads int  // receptions since a read preamble
+}
+
+// auditWaiter is an auditTransport of a transport waiting for the ready line
+type auditWaiter struct {
+	*auditTransport
+	waiter ReadyWaiter
+}
+
+// auditing returns transport wrapped for the audit mode
+func auditing(transport Transport, mode AuditMode) Transport {
+	if mode == AuditOff {
+		return transport
+	}
+	audit := &auditTransport{Transport: transport, mode: mode}
+	if waiter, ok := transport.(ReadyWaiter); ok {
+		return auditWaiter{auditTransport: audit, waiter: waiter}
+	}
+	return audit
+}
+
+// violation reports a protocol violation
+func (t *auditTransport) violation(format string, args ...any) {
+	message := "it8951: protocol violation: " + fmt.Sprintf(format, args...)
+	if t.mode == AuditPanic {
+		panic(message)
+	}
+	stats.ProtocolViolations++
+	logf(slog.LevelError, "%s", message)
+}
+
+func (t *auditTransport) Select(selected bool) {
+	switch {
+	case selected && t.phase != auditIdle:
+		t.violation("chip selected twice")
+	case !selected:
+		t.endPhase()
+	}
+	t.Transport.Select(selected)
+	if selected {
+		t.phase = auditSelected
+	}
+}
+
+// endPhase checks the transfers done while the chip was selected
+func (t *auditTransport) endPhase() {
+	switch t.phase {
+	case auditSelected:
+		t.violation("chip deselected without a preamble")
+	case auditCommand:
+		if t.words != 1 {
+			t.violation("%d words after a command preamble", t.words)
+		}
+	case auditWrite:
+		if t.words == 0 {
+			t.violation("write preamble without data")
+		}
+	case auditRead:
+		if t.reads < 2 {
+			t.violation("read preamble without a dummy word and data")
+		}
+	}
+	t.phase = auditIdle
+}
+
+func (t *auditTransport) Ready() bool {
+	ready := t.Transport.Ready()
+	t.ready = t.ready || ready
+	return ready
+}
+
+func (t auditWaiter) WaitReady(timeout time.Duration) bool {
+	ready := t.waiter.WaitReady(timeout)
+	t.ready = t.ready || ready
+	return ready
+}
+
+// transfer checks a transfer of n bytes is allowed
+func (t *auditTransport) transfer(n int) {
+	if t.phase == auditIdle {
+		t.violation("%d bytes transferred with the chip deselected", n)
+	}
+	if !t.ready {
+		t.violation("%d bytes transferred without checking the ready line", n)
+	}
+	if n%2 != 0 {
+		t.violation("odd transfer of %d bytes", n)
+	}
+	t.ready = false
+}
+
+func (t *auditTransport) Transmit(data ...byte) {
+	t.transfer(len(data))
+	switch t.phase {
+	case auditSelected:
+		if len(data) != 2 {
+			t.violation("%d bytes sent as a preamble", len(data))
+			break
+		}
+		t.words = 0
+		switch preamble := Preamble(data[0])<<8 | Preamble(data[1]); preamble {
+		case CommandPreamble:
+			t.phase = auditCommand
+		case WritePreamble:
+			t.phase = auditWrite
+		case ReadPreamble:
+			t.phase, t.reads = auditRead, 0
+		default:
+			t.violation("unknown preamble %04x", uint16(preamble))
+		}
+	case auditRead:
+		t.violation("%d bytes sent after a read preamble", len(data))
+	default:
+		t.words += len(data) / 2
+	}
+	t.Transport.Transmit(data...)
+}
+
+func (t *auditTransport) Receive(n int) []byte {
+	t.transfer(n)
+	switch t.phase {
+	case auditRead:
+		if t.reads == 0 && n != 2 {
+			t.violation("%d bytes read instead of the dummy word", n)
+		}
+		t.reads++
+		t.words += n / 2
+	default:
+		t.violation("%d bytes read without a read preamble", n)
+	}
+	return t.Transport.Receive(n)
+}
+
+func (t *auditTransport) Reset(asserted bool) {
+	if t.phase != auditIdle {
+		t.violation("reset with the chip selected")
+	}
+	t.Transport.Reset(asserted)
+}
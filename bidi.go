@@ -0,0 +1,222 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "golang.org/x/text/unicode/bidi"
+
+// Shaper turns a logical string into the characters to draw, e.g. Arabic
+// letters into their contextual presentation forms
+type Shaper func(text string) string
+
+var (
+	shaper Shaper
+)
+
+// SetShaper sets the shaper applied to text before it is laid out (none by
+// default). Font faces have no shaping tables, so scripts such as Arabic
+// need one to join their letters.
+func SetShaper(s Shaper) {
+	shaper = s
+}
+
+// VisualText returns text shaped (see SetShaper) and in display order: runs
+// of right to left characters (Hebrew, Arabic...) are reversed, brackets
+// mirrored, and the runs laid out following the paragraph direction given by
+// the first strong character. This is a simplified version of the Unicode
+// bidirectional algorithm, without explicit embeddings, which is enough for
+// single lines mixing a few scripts and numbers. Labels and text measurement
+// use it; call it before drawing text with a font.Drawer.
+func VisualText(text string) string {
+	if shaper != nil {
+		text = shaper(text)
+	}
+	runes := []rune(text)
+	levels := bidiLevels(runes)
+	rtl := false
+	for _, level := range levels {
+		rtl = rtl || level%2 == 1
+	}
+	if !rtl {
+		return text
+	}
+	for i, r := range runes {
+		if levels[i]%2 == 1 {
+			runes[i] = mirror(r)
+		}
+	}
+	// reverse every sequence at each level and above, from the highest level
+	for level := uint8(2); level >= 1; level-- {
+		for start := 0; start < len(runes); {
+			if levels[start] < level {
+				start++
+				continue
+			}
+			end := start
+			for end < len(runes) && levels[end] >= level {
+				end++
+			}
+			for i, j := start, end-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+				levels[i], levels[j] = levels[j], levels[i]
+			}
+			start = end
+		}
+	}
+	return string(runes)
+}
+
+// bidiLevels returns the embedding level of each rune: even for left to
+// right, odd for right to left. Numbers take the direction of the previous
+// strong character, bracket pairs the direction of their content, and other
+// neutral characters the direction of their neighbours when both agree.
+func bidiLevels(runes []rune) []uint8 {
+	const none, ltr, rtl = 0, 1, 2
+	kinds := make([]int, len(runes))
+	number := make([]bool, len(runes))
+	base := none
+	for i, r := range runes {
+		properties, _ := bidi.LookupRune(r)
+		switch properties.Class() {
+		case bidi.L:
+			kinds[i] = ltr
+		case bidi.R, bidi.AL:
+			kinds[i] = rtl
+		case bidi.EN, bidi.AN:
+			number[i] = true
+			continue
+		default:
+			continue
+		}
+		if base == none {
+			base = kinds[i]
+		}
+	}
+	if base == none {
+		base = ltr
+	}
+	previous := base
+	for i := range runes {
+		if number[i] {
+			kinds[i] = previous
+		} else if kinds[i] != none {
+			previous = kinds[i]
+		}
+	}
+
+	// bracket pairs
+	var open []int
+	for i, r := range runes {
+		switch {
+		case kinds[i] != none || number[i]:
+		case r == '(' || r == '[' || r == '{':
+			open = append(open, i)
+		case (r == ')' || r == ']' || r == '}') && len(open) > 0:
+			o := open[len(open)-1]
+			if mirror(runes[o]) != r {
+				continue
+			}
+			open = open[:len(open)-1]
+			inside := none
+			for j := o + 1; j < i && inside != base; j++ {
+				if number[j] {
+					inside = rtl
+				} else if kinds[j] != none {
+					inside = kinds[j]
+				}
+			}
+			if inside == none {
+				continue
+			}
+			if inside != base {
+				context := base
+				for j := o - 1; j >= 0; j-- {
+					if number[j] {
+						context = rtl
+						break
+					} else if kinds[j] != none {
+						context = kinds[j]
+						break
+					}
+				}
+				inside = context
+			}
+			kinds[o], kinds[i] = inside, inside
+		}
+	}
+
+	paragraph := uint8(0)
+	if base == rtl {
+		paragraph = 1
+	}
+	levels := make([]uint8, len(runes))
+	for i := range runes {
+		kind := kinds[i]
+		if kind == none { // neutral: direction of both neighbours if they agree
+			before, after := none, none
+			for j := i - 1; j >= 0 && before == none; j-- {
+				before = kinds[j]
+			}
+			for j := i + 1; j < len(runes) && after == none; j++ {
+				after = kinds[j]
+			}
+			if before == after {
+				kind = before
+			}
+		}
+		switch {
+		case number[i] && kind == rtl:
+			levels[i] = 2
+		case kind == rtl:
+			levels[i] = 1
+		case kind == ltr && paragraph == 1:
+			levels[i] = 2
+		default:
+			levels[i] = paragraph
+		}
+	}
+	return levels
+}
+
+// mirror returns the mirrored form of brackets, shown reversed in right to
+// left text
+func mirror(r rune) rune {
+	switch r {
+	case '(':
+		return ')'
+	case ')':
+		return '('
+	case '[':
+		return ']'
+	case ']':
+		return '['
+	case '{':
+		return '}'
+	case '}':
+		return '{'
+	case '<':
+		return '>'
+	case '>':
+		return '<'
+	case '«':
+		return '»'
+	case '»':
+		return '«'
+	}
+	return r
+}
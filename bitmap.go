@@ -0,0 +1,108 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"math/bits"
+)
+
+// BitOrder is the order of pixels within the bytes of a 1bpp bitmap
+type BitOrder uint8
+
+// Bit orders
+const (
+	LSBFirst BitOrder = iota // first pixel in bit 0, as expected by the controller
+	MSBFirst                 // first pixel in bit 7, as in PBM files
+)
+
+// The converters below build buffers for Write1bppRect and Refresh1bppRect.
+// 1bpp images are loaded as 8bpp data, every byte holding 8 pixels, first
+// pixel in bit 0, and bytes are packed little endian in words: pixel 0 is bit 0
+// of the first word, pixel 15 its bit 15. Each row starts on a new word.
+// With the default bitmap colors, 1 bits are white and 0 bits black.
+
+// Pack1bppGray converts a grayscale image to a 1bpp buffer, pixels at or
+// above threshold giving 1 bits
+func Pack1bppGray(gray *image.Gray, threshold uint8) DataBuffer {
+	area := gray.Bounds()
+	w, h := area.Dx(), area.Dy()
+	stride := GetWidthInWords(w, 1)
+	buffer := make(DataBuffer, stride*h)
+	for y := 0; y < h; y++ {
+		pix := gray.Pix[y*gray.Stride : y*gray.Stride+w]
+		row := buffer[y*stride : (y+1)*stride]
+		for x, value := range pix {
+			if value >= threshold {
+				row[x/16] |= 1 << (x % 16)
+			}
+		}
+	}
+	return buffer
+}
+
+// Pack1bppBools converts a bitmap of width pixels per row to a 1bpp buffer,
+// true values giving 1 bits
+func Pack1bppBools(bitmap []bool, width int) DataBuffer {
+	if width <= 0 {
+		return nil
+	}
+	stride := GetWidthInWords(width, 1)
+	h := len(bitmap) / width
+	buffer := make(DataBuffer, stride*h)
+	for y := 0; y < h; y++ {
+		row := buffer[y*stride : (y+1)*stride]
+		for x, set := range bitmap[y*width : (y+1)*width] {
+			if set {
+				row[x/16] |= 1 << (x % 16)
+			}
+		}
+	}
+	return buffer
+}
+
+// Pack1bppBytes converts a bitmap of width pixels per row, each row starting
+// on a new byte, to a 1bpp buffer. Bits beyond width in the last byte of a row
+// are cleared.
+func Pack1bppBytes(bitmap []byte, width int, order BitOrder) DataBuffer {
+	if width <= 0 {
+		return nil
+	}
+	rowBytes := (width + 7) / 8
+	stride := GetWidthInWords(width, 1)
+	h := len(bitmap) / rowBytes
+	buffer := make(DataBuffer, stride*h)
+	var lastMask byte = 0xff
+	if width%8 != 0 {
+		lastMask = 1<<(width%8) - 1
+	}
+	for y := 0; y < h; y++ {
+		row := buffer[y*stride : (y+1)*stride]
+		for i, b := range bitmap[y*rowBytes : (y+1)*rowBytes] {
+			if order == MSBFirst {
+				b = bits.Reverse8(b)
+			}
+			if i == rowBytes-1 {
+				b &= lastMask
+			}
+			row[i/2] |= uint16(b) << (8 * (i % 2))
+		}
+	}
+	return buffer
+}
@@ -0,0 +1,136 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951_test
+
+import (
+	"image"
+	"math/bits"
+	"math/rand"
+	"slices"
+	"testing"
+
+	it "github.com/peergum/IT8951-go"
+)
+
+// TestPack1bppGray checks the threshold and the packing of gray images,
+// including sub-images and rows padded to whole words
+func TestPack1bppGray(t *testing.T) {
+	narrow := &image.Gray{Pix: []uint8{255, 0, 128, 0, 200, 127}, Stride: 3, Rect: image.Rect(0, 0, 3, 2)}
+	wide := image.NewGray(image.Rect(0, 0, 24, 2))
+	for _, x := range []int{4, 20, 23} {
+		wide.Pix[wide.PixOffset(x, 1)] = 0xff
+	}
+	for _, test := range []struct {
+		name string
+		gray *image.Gray
+		want it.DataBuffer
+	}{
+		{"3x2", narrow, it.DataBuffer{0x0005, 0x0002}},
+		{"sub-image 20x1", wide.SubImage(image.Rect(4, 1, 24, 2)).(*image.Gray), it.DataBuffer{0x0001, 0x0009}},
+	} {
+		if got := it.Pack1bppGray(test.gray, 128); !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %#04x, want %#04x", test.name, got, test.want)
+		}
+	}
+}
+
+// TestPack1bppBools checks the packing of bool bitmaps, first pixel in bit
+// 0, with rows padded to whole words
+func TestPack1bppBools(t *testing.T) {
+	bitmap := make([]bool, 2*17)
+	bitmap[0], bitmap[15], bitmap[16] = true, true, true // row 0
+	bitmap[17+1] = true                                  // row 1
+	want := it.DataBuffer{0x8001, 0x0001, 0x0002, 0x0000}
+	if got := it.Pack1bppBools(bitmap, 17); !slices.Equal(got, want) {
+		t.Errorf("got %#04x, want %#04x", got, want)
+	}
+	if got := it.Pack1bppBools(bitmap, 0); got != nil {
+		t.Errorf("width 0: got %#04x, want nil", got)
+	}
+}
+
+// TestPack1bppBytes checks both bit orders, the little endian packing of
+// bytes in words, the padding of rows to whole words and the clearing of
+// bits beyond the width
+func TestPack1bppBytes(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		bitmap []byte
+		width  int
+		order  it.BitOrder
+		want   it.DataBuffer
+	}{
+		{"lsb 16", []byte{0x01, 0x80}, 16, it.LSBFirst, it.DataBuffer{0x8001}},
+		{"msb 16", []byte{0x80, 0x01}, 16, it.MSBFirst, it.DataBuffer{0x8001}},
+		{"lsb 24", []byte{0x12, 0x34, 0x56}, 24, it.LSBFirst, it.DataBuffer{0x3412, 0x0056}},
+		{"msb 24", []byte{0x48, 0x2c, 0x6a}, 24, it.MSBFirst, it.DataBuffer{0x3412, 0x0056}},
+		{"lsb 12x2", []byte{0xff, 0xff, 0x00, 0x08}, 12, it.LSBFirst, it.DataBuffer{0x0fff, 0x0800}},
+		{"msb 12x2", []byte{0xff, 0xff, 0x00, 0x10}, 12, it.MSBFirst, it.DataBuffer{0x0fff, 0x0800}},
+		{"msb 20", []byte{0x80, 0x00, 0xff}, 20, it.MSBFirst, it.DataBuffer{0x0001, 0x000f}},
+		{"lsb 3x2 partial row", []byte{0x05, 0xfa, 0x01}, 3, it.LSBFirst, it.DataBuffer{0x0005, 0x0002, 0x0001}},
+		{"lsb 9 partial row", []byte{0xff, 0xff, 0xff}, 9, it.LSBFirst, it.DataBuffer{0x01ff}},
+	} {
+		if got := it.Pack1bppBytes(test.bitmap, test.width, test.order); !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %#04x, want %#04x", test.name, got, test.want)
+		}
+	}
+	if got := it.Pack1bppBytes([]byte{0xff}, 0, it.LSBFirst); got != nil {
+		t.Errorf("width 0: got %#04x, want nil", got)
+	}
+}
+
+// TestPack1bppAgree checks that the converters pack the same random bitmaps
+// alike, at widths on either side of word boundaries
+func TestPack1bppAgree(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for width := 1; width <= 40; width++ {
+		const height = 3
+		gray := image.NewGray(image.Rect(0, 0, width, height))
+		bools := make([]bool, width*height)
+		rowBytes := (width + 7) / 8
+		lsb := make([]byte, rowBytes*height)
+		msb := make([]byte, rowBytes*height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if random.Intn(2) == 0 {
+					continue
+				}
+				gray.Pix[gray.PixOffset(x, y)] = 0xff
+				bools[y*width+x] = true
+				lsb[y*rowBytes+x/8] |= 1 << (x % 8)
+			}
+		}
+		for i, b := range lsb {
+			msb[i] = bits.Reverse8(b)
+		}
+		want := it.Pack1bppGray(gray, 0x80)
+		if len(want) != it.GetWidthInWords(width, 1)*height {
+			t.Fatalf("width %d: %d words, want %d", width, len(want), it.GetWidthInWords(width, 1)*height)
+		}
+		for name, got := range map[string]it.DataBuffer{
+			"bools": it.Pack1bppBools(bools, width),
+			"lsb":   it.Pack1bppBytes(lsb, width, it.LSBFirst),
+			"msb":   it.Pack1bppBytes(msb, width, it.MSBFirst),
+		} {
+			if !slices.Equal(got, want) {
+				t.Errorf("width %d: %s packed %#04x, gray %#04x", width, name, got, want)
+			}
+		}
+	}
+}
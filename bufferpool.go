@@ -0,0 +1,121 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+)
+
+// BufferPool splits the controller memory, from the image buffer address
+// (see DevInfo.TargetAddress), into frame slots of one panel each. Frames
+// are loaded into the back slot while the front one is displayed, then
+// flipped, for tear-free animations; slots can also hold pre-loaded frames,
+// e.g. for slideshows, displayed with Show.
+//
+// The controller memory holds one byte per pixel whatever the bpp loads
+// use, so each slot takes PanelW*PanelH bytes: the number of slots is
+// limited by the SDRAM of the board (see FrameCapacity).
+type BufferPool struct {
+	slots []uint32 // slot addresses
+	front int      // slot displayed
+	bpp   int      // bits per pixel of the loads
+}
+
+// NewBufferPool returns a pool of n slots (at least 2) loaded at bpp (2, 4
+// or 8). The first slot is the image buffer itself, initially in front.
+// Pools get fewer slots when the controller memory does not hold n frames
+// (see FrameCapacity), so check Slots.
+func NewBufferPool(n int, bpp int) (*BufferPool, error) {
+	if n < 2 {
+		return nil, fmt.Errorf("it8951: a buffer pool needs 2 slots or more, not %d", n)
+	}
+	switch bpp {
+	case 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("it8951: unsupported buffer pool bpp %d", bpp)
+	}
+	if err := checkFrames(2); err != nil {
+		return nil, err
+	}
+	if frameCapacity > 0 && n > frameCapacity {
+		Debug("Buffer pool limited to %d slots out of %d by the controller memory", frameCapacity, n)
+		n = frameCapacity
+	}
+	devInfo := DeviceInfo()
+	size := devInfo.frameSize()
+	pool := &BufferPool{slots: make([]uint32, n), bpp: bpp}
+	for i := range pool.slots {
+		pool.slots[i] = devInfo.TargetAddress() + uint32(i)*size
+	}
+	return pool, nil
+}
+
+// Slots returns the number of slots
+func (pool *BufferPool) Slots() int {
+	return len(pool.slots)
+}
+
+// Address returns the memory address of a slot
+func (pool *BufferPool) Address(slot int) uint32 {
+	return pool.slots[slot]
+}
+
+// Front returns the slot displayed
+func (pool *BufferPool) Front() int {
+	return pool.front
+}
+
+// Back returns the slot the next frame goes to, the one after the front slot
+func (pool *BufferPool) Back() int {
+	return (pool.front + 1) % len(pool.slots)
+}
+
+// Load loads a region of img (at the same logical position on the panel)
+// into the back slot, without waiting for the refresh of the front slot to
+// complete. The back slot keeps what it held before elsewhere, e.g. a frame
+// from Slots() flips ago: load whole frames unless that is wanted.
+func (pool *BufferPool) Load(img image.Image, region image.Rectangle) error {
+	return pool.LoadSlot(pool.Back(), img, region)
+}
+
+// LoadSlot loads a region of img into a slot, like Load
+func (pool *BufferPool) LoadSlot(slot int, img image.Image, region image.Rectangle) error {
+	Debug("Loading %v into slot %d", region, slot)
+	loadImage(img, region, pool.bpp, pool.slots[slot])
+	return Err()
+}
+
+// Flip displays the back slot with mode, once the refresh in progress is
+// over, and makes it the front slot
+func (pool *BufferPool) Flip(mode DisplayMode) error {
+	return pool.Show(pool.Back(), mode)
+}
+
+// Show displays a slot with mode, once the refresh in progress is over, and
+// makes it the front slot
+func (pool *BufferPool) Show(slot int, mode DisplayMode) error {
+	Debug("Showing slot %d", slot)
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
+	DisplayRectBuffer(DeviceInfo().Bounds(), mode, pool.slots[slot])
+	pool.front = slot
+	return Err()
+}
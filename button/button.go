@@ -0,0 +1,103 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package button reads the pushbuttons found on many e-paper HATs and
+// enclosures, turning debounced GPIO changes into named actions (e.g. "up",
+// "down", "select" for menu navigation). Pins are numbered as BCM GPIOs and
+// read through go-rpio, which it8951.Open (or Init) sets up.
+package button
+
+import (
+	"context"
+	"time"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+// pollInterval is the time between two readings of the pins
+const pollInterval = 5 * time.Millisecond
+
+// Button is a pushbutton wired to a GPIO pin
+type Button struct {
+	Pin       int    // BCM GPIO number
+	Action    string // name of the action triggered
+	ActiveLow bool   // the button pulls the pin to ground, a pull-up is enabled
+}
+
+// Event is a debounced button change
+type Event struct {
+	Action  string
+	Pressed bool // pressed or released
+	Time    time.Time
+}
+
+// state is the debouncing state of a button
+type state struct {
+	pressed bool      // debounced state
+	reading bool      // last reading
+	since   time.Time // time of the last reading change
+}
+
+// Watch polls the buttons and sends an event on every debounced press and
+// release until ctx is done. A change is accepted once the pin kept its new
+// level for the debounce duration (typically 20 to 50ms).
+func Watch(ctx context.Context, buttons []Button, debounce time.Duration, events chan<- Event) error {
+	states := make([]state, len(buttons))
+	for i, b := range buttons {
+		pin := rpio.Pin(b.Pin)
+		pin.Input()
+		if b.ActiveLow {
+			pin.PullUp()
+		} else {
+			pin.PullDown()
+		}
+		states[i].pressed = read(b)
+		states[i].reading = states[i].pressed
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			for i, b := range buttons {
+				s := &states[i]
+				if reading := read(b); reading != s.reading {
+					s.reading = reading
+					s.since = now
+				}
+				if s.reading == s.pressed || now.Sub(s.since) < debounce {
+					continue
+				}
+				s.pressed = s.reading
+				select {
+				case events <- Event{Action: b.Action, Pressed: s.pressed, Time: now}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// read returns whether a button is pressed
+func read(b Button) bool {
+	high := rpio.Pin(b.Pin).Read() == rpio.High
+	return high != b.ActiveLow
+}
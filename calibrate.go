@@ -0,0 +1,111 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"encoding/json"
+	"image"
+	"time"
+)
+
+// calibrationArea is the panel area refreshed to measure waveforms: one
+// word wide at any bpp, since waveforms last as long whatever the area
+var calibrationArea = image.Rect(0, 0, 16, 16)
+
+// PanelProfile holds the refresh durations measured on a panel at a given
+// temperature. Applied, it replaces the typical durations used for pacing,
+// deadlines (see DisplayWithin) and estimates (see EstimateRefreshDuration)
+// until refreshes are measured again.
+type PanelProfile struct {
+	Temperature int                           `json:"temperature"` // °C
+	Measured    time.Time                     `json:"measured"`
+	Refresh     map[DisplayMode]time.Duration `json:"refresh"`
+}
+
+// Calibrate measures the duration of each mode (GC16 and A2 when none is
+// given) over count refreshes of a small area in the top left corner of the
+// panel, redisplaying what the controller memory holds there, and applies
+// the result. Calibrating INIT leaves the area white until it is redisplayed
+// with another mode, which Calibrate does at the end.
+func Calibrate(count int, modes ...DisplayMode) (PanelProfile, error) {
+	if len(modes) == 0 {
+		modes = []DisplayMode{GC16Mode, A2Mode}
+	}
+	count = max(count, 1)
+	temperature, err := ReadTemperature()
+	if err != nil {
+		return PanelProfile{}, err
+	}
+	profile := PanelProfile{
+		Temperature: temperature,
+		Measured:    time.Now(),
+		Refresh:     map[DisplayMode]time.Duration{},
+	}
+	targetAddress := DeviceInfo().TargetAddress()
+	if err := WaitForDisplayReady(); err != nil {
+		return PanelProfile{}, err
+	}
+	for _, mode := range modes {
+		var total time.Duration
+		for i := 0; i < count; i++ {
+			start := time.Now()
+			DisplayRectBuffer(calibrationArea, mode, targetAddress)
+			if err := WaitForDisplayReady(); err != nil {
+				return PanelProfile{}, err
+			}
+			total += time.Since(start)
+		}
+		profile.Refresh[mode] = total / time.Duration(count)
+		Debug("Mode %d calibrated at %v (%d°C)", mode, profile.Refresh[mode], temperature)
+	}
+	if modes[len(modes)-1] == InitMode {
+		DisplayRectBuffer(calibrationArea, GC16Mode, targetAddress)
+	}
+	ApplyProfile(profile)
+	return profile, nil
+}
+
+// ApplyProfile uses the refresh durations of a profile
+func ApplyProfile(profile PanelProfile) {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+	for mode, duration := range profile.Refresh {
+		refreshDuration[mode] = duration
+	}
+}
+
+// LoadProfile reads a profile saved by SaveProfile
+func LoadProfile(path string) (PanelProfile, error) {
+	var profile PanelProfile
+	data, err := store.Load(path)
+	if err != nil {
+		return profile, err
+	}
+	err = json.Unmarshal(data, &profile)
+	return profile, err
+}
+
+// SaveProfile saves a profile as JSON, to the file at path (see SetStore)
+func SaveProfile(path string, profile PanelProfile) error {
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Save(path, data)
+}
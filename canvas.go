@@ -0,0 +1,220 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Canvas is a host side grayscale frame, in logical coordinates, with text
+// printing helpers: a cursor moves as text is printed, wrapping at the right
+// edge and going to the next line on newlines, like on character displays.
+//
+// Canvas is a draw.Image keeping track of what was drawn since the last
+// Flush, which then only loads and refreshes the changed areas. Drawing
+// directly into Pix bypasses that tracking: call MarkDirty afterwards.
+type Canvas struct {
+	*image.Gray
+	Face      font.Face   // face used by Print and Println
+	TextColor color.Color // black by default
+	Bpp       int         // bits per pixel used by Flush, 4 by default
+
+	dirty []image.Rectangle
+
+	cursor image.Point // top left of the next character
+	margin int         // x where lines start
+}
+
+// NewCanvas returns a canvas of the given bounds filled with the background
+// gray (see WithBackground). The whole canvas starts dirty, so that the first
+// Flush displays all of it.
+func NewCanvas(bounds image.Rectangle) *Canvas {
+	c := &Canvas{Gray: image.NewGray(bounds), TextColor: color.Black, Bpp: 4}
+	draw.Draw(c.Gray, bounds, image.NewUniform(color.Gray{Y: config.Background}), image.Point{}, draw.Src)
+	c.SetCursor(bounds.Min.X, bounds.Min.Y)
+	c.MarkDirty(bounds)
+	return c
+}
+
+// Set sets the color of a pixel and marks it dirty
+func (c *Canvas) Set(x, y int, col color.Color) {
+	c.Gray.Set(x, y, col)
+	c.MarkDirty(image.Rect(x, y, x+1, y+1))
+}
+
+// SetGray sets the gray of a pixel and marks it dirty
+func (c *Canvas) SetGray(x, y int, col color.Gray) {
+	c.Gray.SetGray(x, y, col)
+	c.MarkDirty(image.Rect(x, y, x+1, y+1))
+}
+
+// SetRGBA64 sets the color of a pixel and marks it dirty. It is used by
+// image/draw in place of Set.
+func (c *Canvas) SetRGBA64(x, y int, col color.RGBA64) {
+	c.Gray.SetRGBA64(x, y, col)
+	c.MarkDirty(image.Rect(x, y, x+1, y+1))
+}
+
+// MarkDirty records that an area changed and has to be displayed by the next
+// Flush. Overlapping or touching areas are merged, as loading them apart
+// would cost more than loading them at once.
+func (c *Canvas) MarkDirty(area image.Rectangle) {
+	area = area.Intersect(c.Rect)
+	if area.Empty() {
+		return
+	}
+	for merged := true; merged; {
+		merged = false
+		for i, rect := range c.dirty {
+			if rect.Inset(-1).Overlaps(area) {
+				area = area.Union(rect)
+				c.dirty = append(c.dirty[:i], c.dirty[i+1:]...)
+				merged = true
+				break
+			}
+		}
+	}
+	c.dirty = append(c.dirty, area)
+	if len(c.dirty) > maxDirtyRects {
+		// too many small updates: one larger one is cheaper
+		union := image.Rectangle{}
+		for _, rect := range c.dirty {
+			union = union.Union(rect)
+		}
+		c.dirty = []image.Rectangle{union}
+	}
+}
+
+// maxDirtyRects is the number of separate dirty areas above which Canvas
+// merges them all
+const maxDirtyRects = 16
+
+// Dirty returns the areas changed since the last Flush
+func (c *Canvas) Dirty() []image.Rectangle {
+	return append([]image.Rectangle(nil), c.dirty...)
+}
+
+// Flush displays the areas changed since the last call with mode, each of
+// them loaded as a word aligned area at c.Bpp, then forgets them
+func (c *Canvas) Flush(mode DisplayMode) error {
+	bpp := c.Bpp
+	if bpp == 0 {
+		bpp = 4
+	}
+	for _, area := range c.dirty {
+		if err := displayImage(c.Gray, area, bpp, mode); err != nil {
+			return err
+		}
+	}
+	c.dirty = c.dirty[:0]
+	return nil
+}
+
+// SetCursor moves the cursor, lines then starting at x
+func (c *Canvas) SetCursor(x, y int) {
+	c.cursor = image.Pt(x, y)
+	c.margin = x
+}
+
+// Cursor returns the top left corner of the next character
+func (c *Canvas) Cursor() image.Point {
+	return c.cursor
+}
+
+// Printf moves the cursor to x, y, sets the face and prints formatted text
+func (c *Canvas) Printf(x, y int, face font.Face, format string, args ...any) {
+	c.SetCursor(x, y)
+	c.Face = face
+	c.print(fmt.Sprintf(format, args...))
+}
+
+// DrawText draws a line of text with face and the text color, rotated
+// clockwise by degrees (0, 90, 180 or 270) with its top left corner at x, y.
+// The cursor does not move.
+func (c *Canvas) DrawText(x, y int, face font.Face, text string, degrees int) error {
+	area, err := DrawText(c.Gray, image.Pt(x, y), face, text, image.NewUniform(c.TextColor), degrees)
+	c.MarkDirty(area)
+	return err
+}
+
+// Print prints its operands at the cursor, as fmt.Sprint formats them
+func (c *Canvas) Print(args ...any) {
+	c.print(fmt.Sprint(args...))
+}
+
+// Println prints its operands at the cursor, as fmt.Sprintln formats them,
+// the cursor then moving to the start of the next line
+func (c *Canvas) Println(args ...any) {
+	c.print(fmt.Sprintln(args...))
+}
+
+// print draws text at the cursor, word wrapping at the right edge
+func (c *Canvas) print(text string) {
+	if c.Face == nil {
+		return
+	}
+	metrics := c.Face.Metrics()
+	height := metrics.Height.Ceil()
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			c.newline(height)
+		}
+		if w, _, _ := MeasureText(c.Face, line); c.cursor.X+w <= c.Rect.Max.X {
+			// fits as is, spaces included
+			c.drawText(line, metrics.Ascent.Ceil())
+			continue
+		}
+		if c.cursor.X != c.margin {
+			c.newline(height)
+		}
+		for j, part := range WrapText(c.Face, line, c.Rect.Max.X-c.margin) {
+			if j > 0 {
+				c.newline(height)
+			}
+			c.drawText(part, metrics.Ascent.Ceil())
+		}
+	}
+}
+
+// newline moves the cursor to the start of the next line
+func (c *Canvas) newline(height int) {
+	c.cursor = image.Pt(c.margin, c.cursor.Y+height)
+}
+
+// drawText draws a line at the cursor and moves the cursor after it
+func (c *Canvas) drawText(text string, ascent int) {
+	drawer := font.Drawer{
+		Dst:  c.Gray,
+		Src:  image.NewUniform(c.TextColor),
+		Face: c.Face,
+		Dot:  fixed.P(c.cursor.X, c.cursor.Y+ascent),
+	}
+	text = VisualText(text)
+	bounds, _ := drawer.BoundString(text)
+	drawer.DrawString(text)
+	c.MarkDirty(image.Rect(bounds.Min.X.Floor(), bounds.Min.Y.Floor(), bounds.Max.X.Ceil(), bounds.Max.Y.Ceil()))
+	c.cursor.X = drawer.Dot.X.Ceil()
+}
@@ -0,0 +1,139 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"slices"
+)
+
+// CapabilityReport describes the attached panel and what the driver does with
+// it, so that generic client software adapts to any panel: the sizes to
+// render at, the gray levels and modes to pick from, how long refreshes take
+// and how areas are aligned. It encodes to JSON, e.g. for the /capabilities
+// endpoint of epd-ipc.
+type CapabilityReport struct {
+	Width         int    `json:"width"` // panel size in pixels, native orientation
+	Height        int    `json:"height"`
+	LogicalWidth  int    `json:"logical_width"` // panel size in drawing coordinates, current orientation
+	LogicalHeight int    `json:"logical_height"`
+	Model         string `json:"model,omitempty"` // panel model name, when known
+	Color         bool   `json:"color"`           // color filter panel
+	GrayLevels    int    `json:"gray_levels"`     // levels the waveforms render, whatever the bpp
+	Bpp           []int  `json:"bpp"`             // load depths supported
+	// Alignment is the number of pixels area rows start and end on, for
+	// each bpp: areas are grown to these boundaries, so drawing on them
+	// avoids reloading neighboring pixels
+	Alignment []Alignment   `json:"alignment"`
+	Modes     []ModeInfo    `json:"modes"`
+	Frames    int           `json:"frames"`   // panel frames held in the controller memory, image buffer included (0 when unknown)
+	Features  []string      `json:"features"` // driver features enabled, e.g. "high-contrast"
+	Stats     TransferStats `json:"stats"`
+	Firmware  string        `json:"firmware"`
+	LUT       string        `json:"lut"`
+}
+
+// Alignment is the pixel boundary of the area rows at a bpp
+type Alignment struct {
+	Bpp    int `json:"bpp"`
+	Pixels int `json:"pixels"`
+}
+
+// ModeInfo describes a display mode of the panel LUT
+type ModeInfo struct {
+	Waveform string      `json:"waveform"` // e.g. "GC16"
+	Mode     DisplayMode `json:"mode"`     // number given to display functions
+	Levels   int         `json:"levels"`   // gray levels rendered: 1 (white) for INIT, 2 for DU and A2...
+	// RefreshMs is the time the waveform takes, in milliseconds: measured
+	// on this panel when Measured, or else typical
+	RefreshMs int64 `json:"refresh_ms"`
+	Measured  bool  `json:"measured"`
+}
+
+// waveformLevels are the gray levels each waveform renders
+var waveformLevels = map[Waveform]int{
+	WaveformINIT: 1, WaveformDU: 2, WaveformA2: 2, WaveformDU4: 4,
+	WaveformGC16: 16, WaveformGL16: 16, WaveformGLR16: 16, WaveformGLD16: 16,
+}
+
+// Capabilities describes the attached panel and the driver settings (see
+// CapabilityReport). It sends no command once the system info is known.
+func Capabilities() CapabilityReport {
+	devInfo := DeviceInfo()
+	bounds := devInfo.Bounds()
+	logical := orientation.LogicalBounds(bounds)
+	fw := devInfo.Firmware()
+	c := CapabilityReport{
+		Width:         bounds.Dx(),
+		Height:        bounds.Dy(),
+		LogicalWidth:  logical.Dx(),
+		LogicalHeight: logical.Dy(),
+		Color:         fw.ColorPanel,
+		GrayLevels:    16,
+		Bpp:           []int{1, 2, 4, 8},
+		Frames:        FrameCapacity(),
+		Features:      enabledFeatures(),
+		Stats:         Stats(),
+		Firmware:      fw.Version,
+		LUT:           fw.LUT,
+	}
+	if model, ok := CurrentPanelModel(); ok {
+		c.Model = model.Name
+	}
+	for _, bpp := range c.Bpp {
+		c.Alignment = append(c.Alignment, Alignment{Bpp: bpp, Pixels: alignStep(bpp)})
+	}
+	for w := WaveformINIT; w <= WaveformDU4; w++ {
+		mode, ok := fw.Modes[w]
+		if !ok {
+			continue
+		}
+		refreshLock.Lock()
+		duration, measured := refreshDuration[mode]
+		refreshLock.Unlock()
+		if !measured {
+			duration = waveformDuration(mode)
+		}
+		c.Modes = append(c.Modes, ModeInfo{Waveform: w.String(), Mode: mode, Levels: waveformLevels[w], RefreshMs: duration.Milliseconds(), Measured: measured})
+	}
+	return c
+}
+
+// enabledFeatures names the optional driver features in use
+func enabledFeatures() []string {
+	features := []string{}
+	for name, on := range map[string]bool{
+		"packed-mode":      config.PackedMode,
+		"verified-uploads": config.VerifyRetries > 0,
+		"ready-edge":       stats.ReadyWait == ReadyEdge,
+		"audit":            config.Audit != AuditOff,
+		"tone-curve":       currentToneCurve() != nil,
+		"compensation":     currentCompensation() != nil,
+		"high-contrast":    highContrast,
+		"pixel-shift":      len(shiftOffsets) > 0,
+		"refresh-manager":  refreshes != nil,
+		"wear-tracking":    wear != nil,
+		"mirror":           mirroring != nil,
+	} {
+		if on {
+			features = append(features, name)
+		}
+	}
+	slices.Sort(features)
+	return features
+}
@@ -0,0 +1,106 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// frameCapture saves displayed frames
+type frameCapture struct {
+	dir   string
+	every int // save one frame out of every
+	keep  int // files kept
+	count int // frames seen
+}
+
+var (
+	capture *frameCapture
+)
+
+// EnableCapture saves one displayed frame out of every (1 for all of them)
+// as a PNG file in dir, keeping the keep most recent files (0 for no limit).
+// Frames are saved as sent to the panel, after conversion, in panel
+// coordinates; file names hold the time and the area, e.g.
+// 20240102T150405.000-x0-y0-w1872-h1404.png. This helps reconstructing what a
+// device showed, at the cost of encoding a PNG per saved frame.
+func EnableCapture(dir string, every int, keep int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	capture = &frameCapture{dir: dir, every: max(every, 1), keep: keep}
+	return nil
+}
+
+// DisableCapture stops saving frames
+func DisableCapture() {
+	capture = nil
+}
+
+// captureFrame saves a converted frame if capture is enabled
+func captureFrame(gray *image.Gray) {
+	if capture == nil {
+		return
+	}
+	capture.count++
+	if (capture.count-1)%capture.every != 0 {
+		return
+	}
+	if err := capture.save(gray); err != nil {
+		Debug("Frame capture failed: %v", err)
+	}
+}
+
+// save writes a frame and removes the oldest files over the limit
+func (c *frameCapture) save(gray *image.Gray) error {
+	r := gray.Rect
+	name := fmt.Sprintf("%s-x%d-y%d-w%d-h%d.png", time.Now().Format("20060102T150405.000"), r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+	file, err := os.Create(filepath.Join(c.dir, name))
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, gray); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if c.keep <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.png"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files) // names start with the time
+	for len(files) > c.keep {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
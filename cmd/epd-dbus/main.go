@@ -0,0 +1,209 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Command epd-dbus exposes an IT8951 panel on D-Bus, so that desktop
+// components and scripts can drive it with standard tools:
+//
+//	busctl --user call io.github.peergum.IT8951 /io/github/peergum/IT8951 \
+//		io.github.peergum.IT8951 DisplayImage siiq /tmp/weather.png 0 0 2
+//
+// Methods:
+//
+//	DisplayImage(path s, x i, y i, mode q)
+//	    displays an image file at 4bpp, its top left corner at x, y
+//	Clear(mode q)
+//	    clears the panel to white
+//	GetInfo() -> a{sv}
+//	    panel size, VCOM, firmware and LUT versions, display modes and the
+//	    ID of the last frame displayed
+//
+// The Refreshed(x i, y i, w i, h i, mode q, frame t) signal is emitted after
+// each refresh, frame being the driver frame ID (see it8951.FrameID) to find
+// it in the logs. The service takes the panel lease (see it8951.AcquireLease).
+//
+// Usage:
+//
+//	epd-dbus [-system] [-vcom=1500] [-force] [-epd]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/peergum/IT8951-go"
+)
+
+const (
+	serviceName = "io.github.peergum.IT8951"
+	objectPath  = dbus.ObjectPath("/io/github/peergum/IT8951")
+)
+
+const introspection = `<node>
+	<interface name="` + serviceName + `">
+		<method name="DisplayImage">
+			<arg name="path" type="s" direction="in"/>
+			<arg name="x" type="i" direction="in"/>
+			<arg name="y" type="i" direction="in"/>
+			<arg name="mode" type="q" direction="in"/>
+		</method>
+		<method name="Clear">
+			<arg name="mode" type="q" direction="in"/>
+		</method>
+		<method name="GetInfo">
+			<arg name="info" type="a{sv}" direction="out"/>
+		</method>
+		<signal name="Refreshed">
+			<arg name="x" type="i"/>
+			<arg name="y" type="i"/>
+			<arg name="w" type="i"/>
+			<arg name="h" type="i"/>
+			<arg name="mode" type="q"/>
+			<arg name="frame" type="t"/>
+		</signal>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+// service implements the D-Bus methods, one call at a time
+type service struct {
+	mu      sync.Mutex
+	conn    *dbus.Conn
+	devInfo *it8951.DevInfo
+}
+
+// DisplayImage displays an image file
+func (s *service) DisplayImage(path string, x, y int32, mode uint16) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	img, _, err := it8951.DecodeFile(path)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	if x < 0 || y < 0 {
+		return dbus.MakeFailedError(fmt.Errorf("negative position %d,%d", x, y))
+	}
+	if err := it8951.DrawImage(img, uint16(x), uint16(y), 4, it8951.DisplayMode(mode), it8951.Rotate0); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	s.refreshed(img.Bounds().Sub(img.Bounds().Min).Add(image.Pt(int(x), int(y))), mode)
+	return nil
+}
+
+// Clear clears the panel
+func (s *service) Clear(mode uint16) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devInfo.ClearRefresh(s.devInfo.TargetAddress(), it8951.DisplayMode(mode), it8951.Rotate0)
+	if err := it8951.Err(); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	s.refreshed(it8951.CurrentOrientation().LogicalBounds(s.devInfo.Bounds()), mode)
+	return nil
+}
+
+// GetInfo describes the panel
+func (s *service) GetInfo() (map[string]dbus.Variant, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fw := s.devInfo.Firmware()
+	modes := map[string]uint16{}
+	for name, mode := range fw.Modes {
+		modes[name.String()] = uint16(mode)
+	}
+	return map[string]dbus.Variant{
+		"width":    dbus.MakeVariant(int32(s.devInfo.PanelW)),
+		"height":   dbus.MakeVariant(int32(s.devInfo.PanelH)),
+		"vcom":     dbus.MakeVariant(it8951.ReadVCOM()),
+		"firmware": dbus.MakeVariant(fw.Version),
+		"lut":      dbus.MakeVariant(fw.LUT),
+		"modes":    dbus.MakeVariant(modes),
+		"frame":    dbus.MakeVariant(it8951.FrameID()),
+	}, nil
+}
+
+// refreshed emits the Refreshed signal
+func (s *service) refreshed(area image.Rectangle, mode uint16) {
+	err := s.conn.Emit(objectPath, serviceName+".Refreshed",
+		int32(area.Min.X), int32(area.Min.Y), int32(area.Dx()), int32(area.Dy()), mode, it8951.FrameID())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "epd-dbus:", err)
+	}
+}
+
+func main() {
+	system := flag.Bool("system", false, "register on the system bus instead of the session bus")
+	vcom := flag.Uint("vcom", 1500, "VCOM in mV, as written on the panel cable")
+	force := flag.Bool("force", false, "drive the panel even when another process holds it")
+	debug := flag.Bool("epd", false, "log the driver commands to stderr")
+	flag.Parse()
+	if *debug {
+		it8951.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
+	if err := run(*system, uint16(*vcom), *force); err != nil {
+		fmt.Fprintln(os.Stderr, "epd-dbus:", err)
+		os.Exit(1)
+	}
+}
+
+func run(system bool, vcom uint16, force bool) error {
+	lease, err := it8951.AcquireLease(it8951.DefaultLeasePath, force)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+	devInfo, err := it8951.Init(vcom)
+	if err != nil {
+		return err
+	}
+	defer it8951.Exit()
+
+	connect := dbus.ConnectSessionBus
+	if system {
+		connect = dbus.ConnectSystemBus
+	}
+	conn, err := connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s := &service{conn: conn, devInfo: devInfo}
+	if err := conn.Export(s, objectPath, serviceName); err != nil {
+		return err
+	}
+	if err := conn.Export(introspect.Introspectable(introspection), objectPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return err
+	}
+	reply, err := conn.RequestName(serviceName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("%s is already taken", serviceName)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	return nil
+}
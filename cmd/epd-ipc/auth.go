@@ -0,0 +1,213 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/peergum/IT8951-go/ipc"
+)
+
+// Scopes granted to tokens, client certificates and ipc clients: view reads
+// the panel state and content, display changes it, and region:name only
+// draws in the region of that name (see regions)
+const (
+	scopeView    = "view"
+	scopeDisplay = "display"
+	scopeRegion  = "region:"
+)
+
+// access tells what the clients of the control panel may do. Clients are
+// identified by a bearer token (Authorization header, or the epd_token
+// cookie set by the page for its screenshots), or by the common name of
+// their TLS client certificate. When neither tokens nor a client CA are
+// configured, the control panel is open. ipc clients are identified by the
+// user ID of their process (see ipc.Server.Grant).
+type access struct {
+	tokens  map[string][]string // scopes by token
+	clients map[string][]string // scopes by client certificate common name
+	ipc     map[string][]string // scopes by ipc client, nil when the socket is open
+	open    bool
+}
+
+// newAccess returns the access rules of the features
+func newAccess(f features) access {
+	return access{
+		tokens:  f.HTTPTokens,
+		clients: f.HTTPClients,
+		ipc:     f.IPCClients,
+		open:    len(f.HTTPTokens) == 0 && f.HTTPClientCA == "",
+	}
+}
+
+// scopes returns the scopes granted to the client of a request, and whether
+// it identified itself with a known token or a client certificate
+func (a access) scopes(r *http.Request) ([]string, bool) {
+	var granted []string
+	identified := false
+	if token := requestToken(r); token != "" {
+		for known, scopes := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				granted = append(granted, scopes...)
+				identified = true
+			}
+		}
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		identified = true
+		granted = append(granted, a.clients[r.TLS.PeerCertificates[0].Subject.CommonName]...)
+	}
+	return granted, identified
+}
+
+// client names the client of a request for rate limiting: by its known
+// token or client certificate, so that it keeps its name from any address,
+// by its host otherwise
+func (a access) client(r *http.Request) string {
+	if token := requestToken(r); token != "" {
+		for known := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				return "token " + known
+			}
+		}
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return "certificate " + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// requestToken returns the bearer token of a request, empty when none
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if cookie, err := r.Cookie("epd_token"); err == nil {
+		token, _ := url.QueryUnescape(cookie.Value)
+		return token
+	}
+	return ""
+}
+
+// allow wraps the handler of an endpoint requiring scope
+func (a access) allow(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	if a.open {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.serve(w, r, handler, scope)
+	}
+}
+
+// allowRegion wraps the handler of an endpoint drawing in the region named
+// by the request path, requiring the display scope or that of the region
+func (a access) allowRegion(handler http.HandlerFunc) http.HandlerFunc {
+	if a.open {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.serve(w, r, handler, scopeDisplay, regionScope(r.PathValue("name")))
+	}
+}
+
+// serve runs handler when the client of a request is granted one of scopes,
+// and replies with an error otherwise
+func (a access) serve(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc, scopes ...string) {
+	granted, identified := a.scopes(r)
+	switch {
+	case !identified:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="epd-ipc"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+	case !slices.ContainsFunc(scopes, func(scope string) bool { return slices.Contains(granted, scope) }):
+		http.Error(w, strings.Join(scopes, " or ")+" scope required", http.StatusForbidden)
+	default:
+		handler(w, r)
+	}
+}
+
+// grant tells whether an ipc client may send requests of a type (see
+// ipc.Server.Grant): info needs the view scope, region requests the display
+// scope or that of their region, and others the display scope. Without
+// ipc_clients, all the local processes which can open the socket may.
+func (a access) grant(client string, kind byte, region string) bool {
+	if a.ipc == nil {
+		return true
+	}
+	scopes := a.ipc[client]
+	switch kind {
+	case ipc.TypeInfo:
+		return slices.Contains(scopes, scopeView)
+	case ipc.TypeRegion:
+		return slices.Contains(scopes, scopeDisplay) || slices.Contains(scopes, regionScope(region))
+	}
+	return slices.Contains(scopes, scopeDisplay)
+}
+
+// regionScope returns the scope of a region, granting to draw in it only
+func regionScope(name string) string {
+	return scopeRegion + name
+}
+
+// checkScopes returns an error when grants name unknown scopes
+func checkScopes(key string, grants map[string][]string) error {
+	for _, scopes := range grants {
+		for _, scope := range scopes {
+			region, isRegion := strings.CutPrefix(scope, scopeRegion)
+			if scope != scopeView && scope != scopeDisplay && (!isRegion || region == "") {
+				return fmt.Errorf("%s: unknown scope %q, expecting %s, %s or %sname", key, scope, scopeView, scopeDisplay, scopeRegion)
+			}
+		}
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS settings of the web server, nil without a
+// certificate. With a client CA, clients must present a certificate it
+// signed (mutual TLS).
+func tlsConfig(f features) (*tls.Config, error) {
+	if f.HTTPCert == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if f.HTTPClientCA != "" {
+		data, err := os.ReadFile(f.HTTPClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no PEM certificate", f.HTTPClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
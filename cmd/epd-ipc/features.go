@@ -0,0 +1,205 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/peergum/IT8951-go"
+	"github.com/peergum/IT8951-go/schedule"
+)
+
+// features are the optional parts of the daemon a deployment runs, so that
+// the same binary serves kiosks, headless signs and lab benches alike. They
+// are read from a JSON file, then from environment variables, then from the
+// command line (-http):
+//
+//	key            variable           feature
+//	http           EPD_HTTP           web control panel address, localhost for a bare :port, off when empty
+//	http_cert      EPD_HTTP_CERT      PEM certificate of the web server, serving HTTPS (HTTP when empty)
+//	http_key       EPD_HTTP_KEY       PEM private key of the certificate
+//	http_client_ca EPD_HTTP_CLIENT_CA PEM CA certificates clients must present a certificate of (mutual TLS)
+//	http_tokens                       scopes of each bearer token, e.g. {"secret": ["view", "display"]}
+//	http_clients                      scopes of each client certificate, by common name
+//	ipc_clients                       scopes of each ipc client, by user ID, e.g. {"uid 1000": ["region:weather"]} (all granted when empty)
+//	regions                           panel regions defined on start, e.g. [{"name": "weather", "x": 0, "y": 0, "w": 800, "h": 600, "mode": 2, "bpp": 4}]
+//	auto_sleep     EPD_AUTO_SLEEP     idle time before the controller sleeps, e.g. "5m" (never when empty)
+//	sleep_state    EPD_SLEEP_STATE    standby (default) or sleep
+//	maintenance    EPD_MAINTENANCE    cron window of the nightly full refresh, e.g. "* 3-4 * * *", off when empty
+//	queue          EPD_QUEUE          directory keeping the web display requests received while the controller sleeps or is busy, off when empty
+//	queue_hold     EPD_QUEUE_HOLD     longest time queued requests wait for the controller to wake up, e.g. "15m" (not at all when empty)
+//	schedule                          content rules shown while clients leave the panel alone, e.g. [{"name": "night", "cron": "* 22-23,0-6 * * *", "clock": "15:04", "power": "sleep"}] (see scheduleRule)
+//	schedule_idle  EPD_SCHEDULE_IDLE  time after a client display before the schedule shows its content again, e.g. "30m" (at the next minute when empty)
+//	rate_limit     EPD_RATE_LIMIT     requests per second of each web or ipc client, e.g. 2 or 0.5 (unlimited when 0)
+//	rate_burst     EPD_RATE_BURST     requests a client may send at once before rate_limit applies, 1 when 0
+//
+// Tokens, client scopes, regions and schedule rules are only read from the file, which
+// should only be readable by the daemon. The web server is open to anyone
+// who can reach it unless tokens or a client CA are set: each endpoint then
+// requires the view or display scope (see controlPanel.handler), or that of
+// its region, for clients sharing the panel (see regionSpec). Clients over the rate limit get 429 Too
+// Many Requests from the web server and an error reply on the ipc socket
+// (see rateLimiter).
+type features struct {
+	HTTP         string              `json:"http"`
+	HTTPCert     string              `json:"http_cert"`
+	HTTPKey      string              `json:"http_key"`
+	HTTPClientCA string              `json:"http_client_ca"`
+	HTTPTokens   map[string][]string `json:"http_tokens"`
+	HTTPClients  map[string][]string `json:"http_clients"`
+	IPCClients   map[string][]string `json:"ipc_clients"`
+	Regions      []regionSpec        `json:"regions"`
+	AutoSleep    duration            `json:"auto_sleep"`
+	SleepState   string              `json:"sleep_state"`
+	Maintenance  string              `json:"maintenance"`
+	Queue        string              `json:"queue"`
+	QueueHold    duration            `json:"queue_hold"`
+	Schedule     []scheduleRule      `json:"schedule"`
+	ScheduleIdle duration            `json:"schedule_idle"`
+	RateLimit    float64             `json:"rate_limit"`
+	RateBurst    int                 `json:"rate_burst"`
+}
+
+// duration is a time.Duration written as a string in JSON, e.g. "5m"
+type duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration %s: expecting a string like \"5m\"", data)
+	}
+	parsed, err := time.ParseDuration(value)
+	*d = duration(parsed)
+	return err
+}
+
+// loadFeatures reads the features from the file at path (none when empty),
+// then from the environment
+func loadFeatures(path string) (features, error) {
+	f := features{SleepState: "standby"}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return f, err
+		}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return f, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for name, set := range map[string]func(string) error{
+		"EPD_HTTP":           func(value string) error { f.HTTP = value; return nil },
+		"EPD_HTTP_CERT":      func(value string) error { f.HTTPCert = value; return nil },
+		"EPD_HTTP_KEY":       func(value string) error { f.HTTPKey = value; return nil },
+		"EPD_HTTP_CLIENT_CA": func(value string) error { f.HTTPClientCA = value; return nil },
+		"EPD_AUTO_SLEEP":     func(value string) error { return f.AutoSleep.UnmarshalJSON(strconv.AppendQuote(nil, value)) },
+		"EPD_SLEEP_STATE":    func(value string) error { f.SleepState = value; return nil },
+		"EPD_MAINTENANCE":    func(value string) error { f.Maintenance = value; return nil },
+		"EPD_QUEUE":          func(value string) error { f.Queue = value; return nil },
+		"EPD_QUEUE_HOLD":     func(value string) error { return f.QueueHold.UnmarshalJSON(strconv.AppendQuote(nil, value)) },
+		"EPD_SCHEDULE_IDLE":  func(value string) error { return f.ScheduleIdle.UnmarshalJSON(strconv.AppendQuote(nil, value)) },
+		"EPD_RATE_LIMIT":     func(value string) (err error) { f.RateLimit, err = strconv.ParseFloat(value, 64); return err },
+		"EPD_RATE_BURST":     func(value string) (err error) { f.RateBurst, err = strconv.Atoi(value); return err },
+	} {
+		if value, ok := os.LookupEnv(name); ok {
+			if err := set(value); err != nil {
+				return f, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+// check returns an error when features do not go together
+func (f features) check() error {
+	if (f.HTTPCert == "") != (f.HTTPKey == "") {
+		return fmt.Errorf("http_cert and http_key go together")
+	}
+	if f.HTTPClientCA != "" && f.HTTPCert == "" {
+		return fmt.Errorf("http_client_ca needs HTTPS (http_cert)")
+	}
+	if len(f.HTTPClients) > 0 && f.HTTPClientCA == "" {
+		return fmt.Errorf("http_clients needs client certificates (http_client_ca)")
+	}
+	if f.Queue != "" && f.HTTP == "" {
+		return fmt.Errorf("queue needs the web server (http)")
+	}
+	if f.RateLimit < 0 || f.RateBurst < 0 {
+		return fmt.Errorf("rate_limit and rate_burst may not be negative")
+	}
+	if f.RateBurst > 0 && f.RateLimit == 0 {
+		return fmt.Errorf("rate_burst needs a rate limit (rate_limit)")
+	}
+	if err := checkScopes("http_tokens", f.HTTPTokens); err != nil {
+		return err
+	}
+	if err := checkScopes("http_clients", f.HTTPClients); err != nil {
+		return err
+	}
+	if err := checkScopes("ipc_clients", f.IPCClients); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for i, rule := range f.Schedule {
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("schedule rule %d: missing or duplicate name %q", i+1, rule.Name)
+		}
+		names[rule.Name] = true
+		if err := rule.check(); err != nil {
+			return fmt.Errorf("schedule %s: %w", rule.Name, err)
+		}
+	}
+	if _, err := f.maintenance(); err != nil {
+		return err
+	}
+	_, err := f.sleepState()
+	return err
+}
+
+// maintenanceIdle is the time without commands before maintenance runs
+const maintenanceIdle = 10 * time.Minute
+
+// maintenance returns the maintenance settings, nil when off. Frames are
+// not scrubbed: the requests of ipc clients are not presented through the
+// Display, which keeps no copy of them.
+func (f features) maintenance() (*it8951.Maintenance, error) {
+	if f.Maintenance == "" {
+		return nil, nil
+	}
+	window, err := schedule.ParseCron(f.Maintenance)
+	if err != nil {
+		return nil, err
+	}
+	return &it8951.Maintenance{Window: window, Idle: maintenanceIdle}, nil
+}
+
+// sleepState returns the power state of auto sleep
+func (f features) sleepState() (it8951.PowerState, error) {
+	switch f.SleepState {
+	case "standby", "":
+		return it8951.PowerStandby, nil
+	case "sleep":
+		return it8951.PowerSleep, nil
+	}
+	return 0, fmt.Errorf("unknown sleep state %q, expecting standby or sleep", f.SleepState)
+}
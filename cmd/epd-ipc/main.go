@@ -0,0 +1,154 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Command epd-ipc serves an IT8951 panel to local programs over a Unix
+// socket, with the protocol of the ipc package. It takes the panel lease
+// (see it8951.AcquireLease). With -http, it also serves a web control panel
+// showing the panel content, device info and transfer statistics, to clear
+// the panel and display test patterns or images. It listens on localhost
+// unless the address names a host.
+//
+// The web server, the controller auto sleep, the nightly maintenance, the
+// queue of web requests received while the controller sleeps, the content
+// schedule and the rate limit of each client are features enabled per
+// deployment, from the -features JSON file (EPD_FEATURES) and EPD_*
+// environment variables: see features.
+//
+// Usage:
+//
+//	epd-ipc [-socket=/run/it8951.sock] [-journal=file] [-features=file] [-http=:8080] [-vcom=1500] [-force] [-epd]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/peergum/IT8951-go"
+	"github.com/peergum/IT8951-go/ipc"
+)
+
+func main() {
+	socket := flag.String("socket", "/run/it8951.sock", "path of the Unix socket")
+	journal := flag.String("journal", "", "file keeping the ID of the last frame applied (none when empty)")
+	featuresFile := flag.String("features", os.Getenv("EPD_FEATURES"), "JSON file of the features enabled (see EPD_* variables)")
+	web := flag.String("http", "", "address to serve the web control panel on, localhost for a bare :port (none when empty)")
+	vcom := flag.Uint("vcom", 1500, "VCOM in mV, as written on the panel cable")
+	force := flag.Bool("force", false, "drive the panel even when another process holds it")
+	debug := flag.Bool("epd", false, "log the driver commands to stderr")
+	flag.Parse()
+	if *debug {
+		it8951.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
+	f, err := loadFeatures(*featuresFile)
+	flag.Visit(func(given *flag.Flag) {
+		if given.Name == "http" {
+			f.HTTP = *web
+		}
+	})
+	if err == nil {
+		err = f.check()
+	}
+	if err == nil {
+		err = run(*socket, *journal, f, uint16(*vcom), *force)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "epd-ipc:", err)
+		os.Exit(1)
+	}
+}
+
+func run(socket, journal string, f features, vcom uint16, force bool) error {
+	secure, err := tlsConfig(f)
+	if err != nil {
+		return err
+	}
+	lease, err := it8951.AcquireLease(it8951.DefaultLeasePath, force)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+	if _, err := it8951.Init(vcom); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	display := it8951.NewDisplay()
+	if err := defineRegions(display, f.Regions); err != nil {
+		return err
+	}
+	if len(f.Schedule) > 0 {
+		if err := runSchedule(display, f.Schedule, time.Duration(f.ScheduleIdle)); err != nil {
+			return err
+		}
+	}
+	var jobs *jobQueue
+	if f.Queue != "" {
+		if jobs, err = newJobQueue(f.Queue, display, time.Duration(f.QueueHold)); err != nil {
+			return err
+		}
+	}
+	rules, limits := newAccess(f), newRateLimiter(f)
+	server := &ipc.Server{Display: display, Journal: journal, Grant: rules.grant}
+	if limits != nil {
+		server.Allow = limits.allow
+	}
+	errs := make(chan error, 2)
+	go func() { errs <- server.ListenAndServe(socket) }()
+	var webServer *http.Server
+	var panel *controlPanel
+	if f.HTTP != "" {
+		it8951.SetDecodeLimits(it8951.DecodeLimits{MaxBytes: maxImageBytes, MaxPixels: maxImagePixels})
+		panel = &controlPanel{display: display, access: rules, limits: limits, maxFrame: ipc.MessageLimit(it8951.DeviceInfo().Bounds().Size()), jobs: jobs}
+		webServer = &http.Server{Addr: listenAddress(f.HTTP), Handler: panel.handler(), TLSConfig: secure}
+		go func() {
+			if secure != nil {
+				errs <- webServer.ListenAndServeTLS(f.HTTPCert, f.HTTPKey)
+				return
+			}
+			errs <- webServer.ListenAndServe()
+		}()
+	}
+	if f.AutoSleep > 0 {
+		state, _ := f.sleepState()
+		display.AutoSleep(time.Duration(f.AutoSleep), state)
+	}
+	if maintenance, _ := f.maintenance(); maintenance != nil {
+		display.Maintain(*maintenance)
+	}
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+	if webServer != nil {
+		webServer.Shutdown(context.Background())
+		panel.uploads.close()
+	}
+	os.Remove(socket)
+	if closeErr := display.Close(context.Background()); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,326 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// maxJobs is the number of display requests the job queue holds
+const maxJobs = 64
+
+var (
+	// errQueueFull is returned when a request would exceed maxJobs
+	errQueueFull = errors.New("job queue full")
+	// errAsleep is returned by the queue worker while jobs are held for
+	// the controller to wake up
+	errAsleep = errors.New("controller asleep")
+)
+
+// job is a display request of the web API. Queued, it is kept in a file
+// holding its JSON header on one line, then its body.
+type job struct {
+	Kind   string             `json:"kind"` // clear, pattern, image, frame or region
+	Mode   it8951.DisplayMode `json:"mode"`
+	X      uint16             `json:"x,omitempty"` // position of images
+	Y      uint16             `json:"y,omitempty"`
+	Region string             `json:"region,omitempty"` // region images are drawn in
+	Area   image.Rectangle    `json:"area"`             // area displayed
+	Space  string             `json:"space,omitempty"`  // coordinates of Area, the whole panel when empty
+
+	seq    uint64    // queue sequence number, 0 when not queued
+	queued time.Time // time the job was queued
+	body   []byte    // pattern name, image file or packed frame
+}
+
+// prepare checks a job, setting the area it displays, and returns the
+// function displaying it, to run within Display.Do
+func (j *job) prepare() (func() error, error) {
+	switch j.Kind {
+	case "clear":
+		return func() error {
+			devInfo := it8951.DeviceInfo()
+			devInfo.ClearRefresh(devInfo.TargetAddress(), j.Mode, it8951.Rotate0)
+			return it8951.Err()
+		}, nil
+	case "pattern":
+		shade, ok := patterns[string(j.body)]
+		if !ok {
+			return nil, fmt.Errorf("unknown pattern %q", j.body)
+		}
+		return func() error {
+			bounds := it8951.CurrentOrientation().LogicalBounds(it8951.DeviceInfo().Bounds())
+			return it8951.DrawImage(testPattern(shade, bounds), 0, 0, 4, j.Mode, it8951.Rotate0)
+		}, nil
+	case "image":
+		img, _, err := it8951.DecodeImage(bytes.NewReader(j.body))
+		if err != nil {
+			return nil, err
+		}
+		bounds := img.Bounds()
+		j.Area, j.Space = bounds.Sub(bounds.Min).Add(image.Pt(int(j.X), int(j.Y))), "logical"
+		return func() error {
+			return it8951.DrawImage(img, j.X, j.Y, 4, j.Mode, it8951.Rotate0)
+		}, nil
+	case "region":
+		region, ok := findRegion(j.Region)
+		if !ok {
+			return nil, fmt.Errorf("unknown region %q", j.Region)
+		}
+		img, _, err := it8951.DecodeImage(bytes.NewReader(j.body))
+		if err != nil {
+			return nil, err
+		}
+		j.Mode, j.Area, j.Space = region.Mode, region.Bounds, "logical"
+		return func() error {
+			return it8951.DrawRegion(j.Region, img)
+		}, nil
+	case "frame":
+		frame := &it8951.PackedFrame{}
+		if err := frame.UnmarshalBinary(j.body); err != nil {
+			return nil, err
+		}
+		j.Area, j.Space = frame.Area, "frame/"+strconv.Itoa(int(frame.Rotation))
+		return func() error {
+			return frame.Display(j.Mode)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown job %q", j.Kind)
+}
+
+// supersedes tells if a job displayed after earlier hides all of it
+func (j *job) supersedes(earlier *job) bool {
+	return j.Space == "" || j.Space == earlier.Space && earlier.Area.In(j.Area)
+}
+
+// jobQueue keeps the web display requests received while the controller
+// sleeps or is busy in a directory, and applies them in order once it runs
+// again (or at once when it is busy, as soon as it is free). Jobs hidden by
+// a later one are dropped. Jobs left by a previous run are applied on start.
+type jobQueue struct {
+	dir     string
+	display *it8951.Display
+	hold    time.Duration // longest time jobs wait for the controller to wake up
+
+	mu      sync.Mutex
+	pending []*job // in order, the first one being applied
+	running bool   // the first job is being applied
+	next    uint64 // sequence number of the next job
+	wake    chan struct{}
+}
+
+// newJobQueue returns the queue of the jobs in dir, loading those left by a
+// previous run, and starts its worker
+func newJobQueue(dir string, display *it8951.Display, hold time.Duration) (*jobQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	q := &jobQueue{dir: dir, display: display, hold: hold, next: 1, wake: make(chan struct{}, 1)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ".job"), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), ".job") {
+			continue // temporary file of an interrupted save
+		}
+		j, err := q.load(seq)
+		if err != nil {
+			it8951.Debug("Dropping queued job %d: %v", seq, err)
+			os.Remove(q.path(seq))
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			j.queued = info.ModTime()
+		}
+		q.pending = append(q.pending, j)
+		q.next = max(q.next, seq+1)
+	}
+	if len(q.pending) > 0 {
+		q.wake <- struct{}{}
+	}
+	display.Go(q.run)
+	return q, nil
+}
+
+// path returns the file of a job
+func (q *jobQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.job", seq))
+}
+
+// load reads the header of a queued job
+func (q *jobQueue) load(seq uint64) (*job, error) {
+	file, err := os.Open(q.path(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	j := &job{seq: seq}
+	return j, json.Unmarshal(header, j)
+}
+
+// body reads the body of a queued job
+func (q *jobQueue) body(j *job) ([]byte, error) {
+	data, err := os.ReadFile(q.path(j.seq))
+	if err != nil {
+		return nil, err
+	}
+	_, body, _ := bytes.Cut(data, []byte("\n"))
+	return body, nil
+}
+
+// offer applies a prepared job at once when the controller runs, is free
+// and no job is queued, returning the driver ID of the frame displayed.
+// Otherwise it queues the job, returning its sequence number.
+func (q *jobQueue) offer(j *job, apply func() error) (seq, frame uint64, err error) {
+	q.mu.Lock()
+	idle := len(q.pending) == 0
+	q.mu.Unlock()
+	if idle {
+		ran, err := q.display.TryDo(func() error {
+			if it8951.Power() != it8951.PowerRun {
+				return errAsleep
+			}
+			err := apply()
+			frame = it8951.FrameID()
+			return err
+		})
+		if ran && !errors.Is(err, errAsleep) {
+			return 0, frame, err
+		}
+	}
+	seq, err = q.add(j)
+	return seq, 0, err
+}
+
+// add queues a job, dropping the queued ones it hides
+func (q *jobQueue) add(j *job) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kept := q.pending[:0]
+	for i, earlier := range q.pending {
+		if (i > 0 || !q.running) && j.supersedes(earlier) {
+			it8951.Debug("Queued job %d superseded", earlier.seq)
+			os.Remove(q.path(earlier.seq))
+			continue
+		}
+		kept = append(kept, earlier)
+	}
+	clear(q.pending[len(kept):])
+	q.pending = kept
+	if len(q.pending) >= maxJobs {
+		return 0, errQueueFull
+	}
+	header, err := json.Marshal(j)
+	if err != nil {
+		return 0, err
+	}
+	j.seq, j.queued = q.next, time.Now()
+	if err := it8951.DirStore(q.dir).Save(filepath.Base(q.path(j.seq)), append(append(header, '\n'), j.body...)); err != nil {
+		return 0, err
+	}
+	j.body = nil // read again when applied
+	q.next++
+	q.pending = append(q.pending, j)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return j.seq, nil
+}
+
+// run applies the queued jobs until ctx is canceled, checking every second
+// for the controller to wake up while some are held
+func (q *jobQueue) run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-q.wake:
+		case <-ticker.C:
+		}
+		for q.apply() {
+		}
+	}
+}
+
+// apply applies the first queued job, unless the controller sleeps and the
+// job was queued less than hold ago. It returns whether a job was applied.
+func (q *jobQueue) apply() bool {
+	q.mu.Lock()
+	if len(q.pending) == 0 {
+		q.mu.Unlock()
+		return false
+	}
+	j := q.pending[0]
+	q.running = true
+	q.mu.Unlock()
+
+	var frame uint64
+	var err error
+	if j.body, err = q.body(j); err == nil {
+		var display func() error
+		if display, err = j.prepare(); err == nil {
+			err = q.display.Do(func() error {
+				if it8951.Power() != it8951.PowerRun && time.Since(j.queued) < q.hold {
+					return errAsleep
+				}
+				err := display()
+				frame = it8951.FrameID()
+				return err
+			})
+		}
+	}
+	j.body = nil
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running = false
+	if errors.Is(err, errAsleep) {
+		return false
+	}
+	if err != nil {
+		it8951.Debug("Queued job %d failed: %v", j.seq, err)
+	} else {
+		it8951.Debug("Queued job %d displayed as frame %d", j.seq, frame)
+	}
+	os.Remove(q.path(j.seq))
+	q.pending = q.pending[1:]
+	return true
+}
@@ -0,0 +1,109 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter limits the requests of each web and ipc client, so that one
+// of them cannot keep the controller to itself. Each client gets a token
+// bucket of burst requests, refilled at the rate limit.
+type rateLimiter struct {
+	limit  rate.Limit
+	burst  int
+	mu     sync.Mutex
+	byName map[string]*clientRate
+	pruned time.Time // last time idle clients were forgotten
+}
+
+// clientRate is the token bucket of a client
+type clientRate struct {
+	limiter *rate.Limiter
+	seen    time.Time // last request
+}
+
+// newRateLimiter returns the rate limiter of the features, nil when off
+func newRateLimiter(f features) *rateLimiter {
+	if f.RateLimit <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		limit:  rate.Limit(f.RateLimit),
+		burst:  max(f.RateBurst, 1),
+		byName: map[string]*clientRate{},
+		pruned: time.Now(),
+	}
+}
+
+// allow tells whether a client may send a request now, always when l is nil
+func (l *rateLimiter) allow(client string) bool {
+	if l == nil {
+		return true
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	c := l.byName[client]
+	if c == nil {
+		c = &clientRate{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.byName[client] = c
+	}
+	c.seen = now
+	return c.limiter.AllowN(now, 1)
+}
+
+// prune forgets the clients whose bucket has been full for a minute, which
+// a new bucket replaces as well
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for name, c := range l.byName {
+		if now.Sub(c.seen) > refill+time.Minute {
+			delete(l.byName, name)
+		}
+	}
+}
+
+// wrap wraps the handler of an endpoint, replying 429 Too Many Requests to
+// the clients over their rate (see access.client)
+func (l *rateLimiter) wrap(a access, handler http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return handler
+	}
+	retry := strconv.Itoa(int(math.Ceil(1 / float64(l.limit))))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(a.client(r)) {
+			w.Header().Set("Retry-After", retry)
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// regionSpec describes a region of the panel (see it8951.DefineRegion) in
+// the features and the web API, in logical coordinates. Clients share a
+// large panel by each drawing in their own region: a token, client
+// certificate or ipc client granted the region:name scope alone may only
+// draw in the region of that name, with the region mode and depth. The web
+// API of regions is:
+//
+//	GET    /api/regions               regions of the panel (JSON)
+//	PUT    /api/regions/{name}        defines or replaces a region, described by the JSON body
+//	DELETE /api/regions/{name}        removes a region
+//	POST   /api/regions/{name}/image  displays the image file sent as body in a region
+//
+// Defining and removing regions requires the display scope, drawing in one
+// the display scope or that of the region. ipc clients use the region and
+// define requests (see ipc.TypeRegion).
+type regionSpec struct {
+	Name string             `json:"name"`
+	X    uint16             `json:"x"`
+	Y    uint16             `json:"y"`
+	W    uint16             `json:"w"`
+	H    uint16             `json:"h"`
+	Mode it8951.DisplayMode `json:"mode"`
+	Bpp  int                `json:"bpp"`
+}
+
+// region returns the region described
+func (spec regionSpec) region() it8951.Region {
+	return it8951.Region{Name: spec.Name, Bounds: it8951.Rect(spec.X, spec.Y, spec.W, spec.H), Mode: spec.Mode, Bpp: spec.Bpp}
+}
+
+// specOf describes a region
+func specOf(region it8951.Region) regionSpec {
+	bounds := region.Bounds
+	return regionSpec{
+		Name: region.Name,
+		X:    uint16(bounds.Min.X),
+		Y:    uint16(bounds.Min.Y),
+		W:    uint16(bounds.Dx()),
+		H:    uint16(bounds.Dy()),
+		Mode: region.Mode,
+		Bpp:  region.Bpp,
+	}
+}
+
+// findRegion returns the region of the given name
+func findRegion(name string) (it8951.Region, bool) {
+	for _, region := range it8951.Regions() {
+		if region.Name == name {
+			return region, true
+		}
+	}
+	return it8951.Region{}, false
+}
+
+// defineRegions defines the regions of the features
+func defineRegions(display *it8951.Display, specs []regionSpec) error {
+	return display.Do(func() error {
+		modes := it8951.DeviceInfo().Firmware().Modes
+		for _, spec := range specs {
+			if !modes.Has(spec.Mode) {
+				return fmt.Errorf("regions: %s: unknown mode %d", spec.Name, spec.Mode)
+			}
+			if err := it8951.DefineRegion(spec.region()); err != nil {
+				return fmt.Errorf("regions: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// regions lists the regions
+func (p *controlPanel) regions(w http.ResponseWriter, r *http.Request) {
+	specs := []regionSpec{}
+	for _, region := range it8951.Regions() {
+		specs = append(specs, specOf(region))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(specs)
+}
+
+// defineRegion defines the region named by the path as the JSON body
+// describes it (its name omitted), or replaces it
+func (p *controlPanel) defineRegion(w http.ResponseWriter, r *http.Request) {
+	var spec regionSpec
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&spec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	spec.Name = r.PathValue("name")
+	err := p.checkMode(spec.Mode)
+	if err == nil {
+		err = p.display.Do(func() error {
+			return it8951.DefineRegion(spec.region())
+		})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeRegion removes the region named by the path
+func (p *controlPanel) removeRegion(w http.ResponseWriter, r *http.Request) {
+	it8951.RemoveRegion(r.PathValue("name"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// drawRegion displays the image file sent as request body in the region
+// named by the path, with the region mode (see it8951.DrawRegion)
+func (p *controlPanel) drawRegion(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := findRegion(name); !ok {
+		http.Error(w, fmt.Sprintf("unknown region %q", name), http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImageBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.submit(w, &job{Kind: "region", Region: name, body: body})
+}
@@ -0,0 +1,237 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"time"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+
+	"github.com/peergum/IT8951-go"
+	"github.com/peergum/IT8951-go/schedule"
+)
+
+// scheduleRule is a content rule of the schedule feature: during the minutes
+// matched by Cron, and while When holds if set (see
+// schedule.Scheduler.AddWhen), the panel shows the time in the Clock layout
+// (e.g. "15:04"), an image file, read again every minute so that other
+// programs may update it, or a test pattern, then enters the Power state if
+// set, e.g. sleep under a night clock. Rules are tried in order, the first
+// matching one winning; the panel is left alone when none matches.
+type scheduleRule struct {
+	Name    string             `json:"name"`
+	Cron    string             `json:"cron"`
+	When    string             `json:"when,omitempty"`
+	Clock   string             `json:"clock,omitempty"`
+	Image   string             `json:"image,omitempty"`
+	Pattern string             `json:"pattern,omitempty"`
+	Mode    it8951.DisplayMode `json:"mode,omitempty"`  // GC16 when 0
+	Power   string             `json:"power,omitempty"` // standby or sleep once shown, as auto sleep otherwise
+}
+
+// source returns the source of the content of a checked rule, for a panel of
+// the given logical bounds
+func (rule scheduleRule) source(bounds image.Rectangle) (schedule.Source, error) {
+	switch {
+	case rule.Clock != "":
+		f, err := opentype.Parse(gobold.TTF)
+		if err != nil {
+			return nil, err
+		}
+		face, err := it8951.NewFace(f, float64(bounds.Dy()/4))
+		if err != nil {
+			return nil, err
+		}
+		return func(now time.Time) image.Image {
+			return it8951.RenderText(now.Format(rule.Clock), bounds, it8951.TextOptions{Face: face, Align: it8951.Center})
+		}, nil
+	case rule.Image != "":
+		return func(time.Time) image.Image {
+			data, err := os.ReadFile(rule.Image)
+			if err != nil {
+				it8951.Debug("Schedule %s: %v", rule.Name, err)
+				return nil
+			}
+			img, _, err := it8951.DecodeImage(bytes.NewReader(data))
+			if err != nil {
+				it8951.Debug("Schedule %s: %s: %v", rule.Name, rule.Image, err)
+				return nil
+			}
+			return img
+		}, nil
+	}
+	pattern := testPattern(patterns[rule.Pattern], bounds)
+	return func(time.Time) image.Image { return pattern }, nil
+}
+
+// check returns an error when the rule is invalid
+func (rule scheduleRule) check() error {
+	if _, err := schedule.ParseCron(rule.Cron); err != nil {
+		return err
+	}
+	if rule.When != "" {
+		if _, err := schedule.ParseExpr(rule.When); err != nil {
+			return err
+		}
+	}
+	contents := 0
+	for _, content := range []string{rule.Clock, rule.Image, rule.Pattern} {
+		if content != "" {
+			contents++
+		}
+	}
+	if contents != 1 {
+		return fmt.Errorf("expecting one content of clock, image or pattern")
+	}
+	if _, ok := patterns[rule.Pattern]; rule.Pattern != "" && !ok {
+		return fmt.Errorf("unknown pattern %q", rule.Pattern)
+	}
+	_, err := rule.powerState()
+	return err
+}
+
+// powerState returns the power state the rule enters once its content is
+// shown, PowerRun when it does not
+func (rule scheduleRule) powerState() (it8951.PowerState, error) {
+	switch rule.Power {
+	case "":
+		return it8951.PowerRun, nil
+	case "standby":
+		return it8951.PowerStandby, nil
+	case "sleep":
+		return it8951.PowerSleep, nil
+	}
+	return 0, fmt.Errorf("unknown power state %q, expecting standby or sleep", rule.Power)
+}
+
+// newScheduler returns the scheduler of checked rules, for a panel of the
+// given logical bounds
+func newScheduler(rules []scheduleRule, bounds image.Rectangle) (*schedule.Scheduler, error) {
+	scheduler := &schedule.Scheduler{}
+	for _, rule := range rules {
+		source, err := rule.source(bounds)
+		if err == nil && rule.When != "" {
+			err = scheduler.AddWhen(rule.Name, rule.Cron, rule.When, source)
+		} else if err == nil {
+			err = scheduler.Add(rule.Name, rule.Cron, source)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", rule.Name, err)
+		}
+	}
+	return scheduler, nil
+}
+
+// scheduledContent shows the content of the schedule rules through the
+// display, while clients leave the panel alone: after a display of a web or
+// ipc client, the schedule waits for idle without any before showing its
+// content again. Unchanged content is not displayed again, so that the
+// controller may sleep (see features.AutoSleep).
+type scheduledContent struct {
+	display *it8951.Display
+	rules   map[string]scheduleRule
+	idle    time.Duration
+
+	shown  [sha256.Size]byte // digest of the rule and content shown last
+	frame  uint64            // driver frame ID once it was shown
+	client time.Time         // last time a client display was noticed
+}
+
+// runSchedule starts a worker showing the content of the schedule rules
+func runSchedule(display *it8951.Display, rules []scheduleRule, idle time.Duration) error {
+	var bounds image.Rectangle
+	c := &scheduledContent{display: display, rules: map[string]scheduleRule{}, idle: idle}
+	err := display.Do(func() error {
+		bounds = it8951.CurrentOrientation().LogicalBounds(it8951.DeviceInfo().Bounds())
+		c.frame = it8951.FrameID()
+		modes := it8951.DeviceInfo().Firmware().Modes
+		for _, rule := range rules {
+			if rule.Mode != 0 && !modes.Has(rule.Mode) {
+				return fmt.Errorf("schedule %s: unknown mode %d", rule.Name, rule.Mode)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	scheduler, err := newScheduler(rules, bounds)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		c.rules[rule.Name] = rule
+	}
+	display.Go(func(ctx context.Context) error {
+		return scheduler.Run(ctx, c.show)
+	})
+	return nil
+}
+
+// show displays the content of a rule unless a client displayed something
+// less than idle ago, or it is already shown
+func (c *scheduledContent) show(name string, img image.Image) {
+	if img == nil {
+		return
+	}
+	rule := c.rules[name]
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Rect, img, img.Bounds().Min, draw.Src)
+	digest := sha256.New()
+	fmt.Fprintf(digest, "%s\x00%v\x00", name, gray.Rect)
+	digest.Write(gray.Pix)
+	var key [sha256.Size]byte
+	digest.Sum(key[:0])
+	err := c.display.Do(func() error {
+		if frame := it8951.FrameID(); frame != c.frame {
+			c.client, c.frame, c.shown = time.Now(), frame, [sha256.Size]byte{}
+		}
+		if time.Since(c.client) < c.idle || key == c.shown {
+			return nil
+		}
+		it8951.Debug("Schedule: showing %s", name)
+		mode := rule.Mode
+		if mode == 0 {
+			mode = it8951.GC16Mode
+		}
+		if err := it8951.DrawImage(gray, uint16(gray.Rect.Min.X), uint16(gray.Rect.Min.Y), 4, mode, it8951.Rotate0); err != nil {
+			return err
+		}
+		c.shown, c.frame = key, it8951.FrameID()
+		state, _ := rule.powerState()
+		switch state {
+		case it8951.PowerStandby:
+			return it8951.StandBy()
+		case it8951.PowerSleep:
+			return it8951.Sleep()
+		}
+		return nil
+	})
+	if err != nil {
+		it8951.Debug("Schedule %s: %v", name, err)
+	}
+}
@@ -0,0 +1,268 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxUploads is the number of uploads in progress at once, and uploadExpiry
+// the time after which an upload receiving no chunk is dropped
+const (
+	maxUploads   = 8
+	uploadExpiry = time.Hour
+)
+
+// uploads assembles images and packed frames sent in chunks, so that a
+// client losing its connection resumes where it stopped instead of sending
+// everything again:
+//
+//	POST   /api/uploads?kind=&size=&x=&y=&mode=  starts an upload of size bytes
+//	                                               (kind image or frame), replying
+//	                                               201 with its URL in Location
+//	PUT    /api/uploads/{id}                       sends the bytes of Content-Range
+//	HEAD   /api/uploads/{id}                       tells what was received
+//	DELETE /api/uploads/{id}                       drops the upload
+//
+// Chunks are sent in order, with a "bytes first-last/size" Content-Range.
+// Replies give the bytes received so far in a "bytes=0-last" Range header,
+// a chunk not starting there being rejected with 409 Conflict. Once the
+// last byte is in, the upload is displayed as /api/image or /api/frame
+// would display it, with the same reply.
+type uploads struct {
+	dir     string // files of the uploads in progress, created on first use
+	mu      sync.Mutex
+	pending map[string]*upload // by ID
+}
+
+// upload is an upload in progress
+type upload struct {
+	mu       sync.Mutex // serializes the chunks
+	job      job        // what is displayed once complete
+	file     string
+	size     int64
+	received int64
+	touched  time.Time // time of the last chunk
+}
+
+// startUpload starts an upload
+func (p *controlPanel) startUpload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	j := job{Kind: query.Get("kind")}
+	var err error
+	if j.Mode, err = p.displayMode(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := int64(p.maxFrame)
+	switch j.Kind {
+	case "image":
+		x, errX := strconv.ParseUint(query.Get("x"), 10, 16)
+		y, errY := strconv.ParseUint(query.Get("y"), 10, 16)
+		if errX != nil || errY != nil {
+			http.Error(w, "invalid position", http.StatusBadRequest)
+			return
+		}
+		j.X, j.Y, limit = uint16(x), uint16(y), maxImageBytes
+	case "frame":
+	default:
+		http.Error(w, fmt.Sprintf("unknown upload kind %q, expecting image or frame", j.Kind), http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil || size <= 0 || size > limit {
+		http.Error(w, fmt.Sprintf("invalid size, expecting 1 to %d bytes", limit), http.StatusBadRequest)
+		return
+	}
+	id, err := p.uploads.start(j, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Location", "/api/uploads/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// start creates the file of an upload, dropping the expired ones, and
+// returns its ID
+func (u *uploads) start(j job, size int64) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for id, pending := range u.pending {
+		if pending.mu.TryLock() {
+			if time.Since(pending.touched) > uploadExpiry {
+				delete(u.pending, id)
+				os.Remove(pending.file)
+			}
+			pending.mu.Unlock()
+		}
+	}
+	if len(u.pending) >= maxUploads {
+		return "", fmt.Errorf("too many uploads in progress")
+	}
+	if u.dir == "" {
+		dir, err := os.MkdirTemp("", "epd-uploads")
+		if err != nil {
+			return "", err
+		}
+		u.dir = dir
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(random)
+	file, err := os.OpenFile(filepath.Join(u.dir, id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	if u.pending == nil {
+		u.pending = map[string]*upload{}
+	}
+	u.pending[id] = &upload{job: j, file: file.Name(), size: size, touched: time.Now()}
+	return id, nil
+}
+
+// close drops the uploads in progress
+func (u *uploads) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.dir != "" {
+		os.RemoveAll(u.dir)
+	}
+	u.pending, u.dir = nil, ""
+}
+
+// lookup returns an upload, replying 404 Not Found when there is none
+func (u *uploads) lookup(w http.ResponseWriter, r *http.Request) *upload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	pending := u.pending[r.PathValue("id")]
+	if pending == nil {
+		http.Error(w, "no such upload", http.StatusNotFound)
+	}
+	return pending
+}
+
+// drop forgets an upload and removes its file
+func (u *uploads) drop(id string, pending *upload) {
+	u.mu.Lock()
+	if u.pending[id] == pending {
+		delete(u.pending, id)
+	}
+	u.mu.Unlock()
+	os.Remove(pending.file)
+}
+
+// setRange sets the Range header telling what was received
+func (pending *upload) setRange(w http.ResponseWriter) {
+	if pending.received > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", pending.received-1))
+	}
+}
+
+// putChunk writes a chunk of an upload, and displays it once complete
+func (p *controlPanel) putChunk(w http.ResponseWriter, r *http.Request) {
+	pending := p.uploads.lookup(w, r)
+	if pending == nil {
+		return
+	}
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	var first, last, size int64
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &size); err != nil ||
+		size != pending.size || first > last || last >= size {
+		pending.setRange(w)
+		http.Error(w, fmt.Sprintf("invalid Content-Range, expecting bytes first-last/%d", pending.size), http.StatusBadRequest)
+		return
+	}
+	if first != pending.received {
+		pending.setRange(w)
+		http.Error(w, fmt.Sprintf("chunk starts at byte %d instead of %d", first, pending.received), http.StatusConflict)
+		return
+	}
+	file, err := os.OpenFile(pending.file, os.O_WRONLY, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// keep what arrived of a chunk cut short, to resume from there
+	n, err := io.Copy(io.NewOffsetWriter(file, first), io.LimitReader(r.Body, last+1-first))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	pending.received += n
+	pending.touched = time.Now()
+	if err == nil && pending.received != last+1 {
+		err = fmt.Errorf("chunk cut short at byte %d", pending.received)
+	}
+	if err != nil {
+		pending.setRange(w)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pending.received < pending.size {
+		pending.setRange(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	body, err := os.ReadFile(pending.file)
+	p.uploads.drop(r.PathValue("id"), pending)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	j := pending.job
+	j.body = body
+	p.submit(w, &j)
+}
+
+// uploadStatus tells what was received of an upload
+func (p *controlPanel) uploadStatus(w http.ResponseWriter, r *http.Request) {
+	pending := p.uploads.lookup(w, r)
+	if pending == nil {
+		return
+	}
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	pending.setRange(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cancelUpload drops an upload
+func (p *controlPanel) cancelUpload(w http.ResponseWriter, r *http.Request) {
+	pending := p.uploads.lookup(w, r)
+	if pending == nil {
+		return
+	}
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	p.uploads.drop(r.PathValue("id"), pending)
+	w.WriteHeader(http.StatusNoContent)
+}
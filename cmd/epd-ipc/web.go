@@ -0,0 +1,321 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// maxImageBytes and maxImagePixels bound the image files the control panel
+// decodes (see it8951.SetDecodeLimits)
+const (
+	maxImageBytes  = 16 << 20
+	maxImagePixels = 8 << 20
+)
+
+// assets is the control panel page
+//
+//go:embed panel
+var assets embed.FS
+
+// controlPanel serves a web page showing the panel content, device info and
+// transfer statistics, with controls to clear the panel and display test
+// patterns or uploaded images
+type controlPanel struct {
+	display  *it8951.Display
+	access   access
+	limits   *rateLimiter // requests of each client, nil when unlimited
+	maxFrame int          // largest packed frame body, see ipc.MessageLimit
+	jobs     *jobQueue    // requests held while the controller sleeps or is busy, nil when off
+	uploads  uploads      // images and frames sent in chunks
+}
+
+// handler returns the page and its API:
+//
+//	GET  /api/info            device info and statistics (JSON)
+//	GET  /capabilities        what the panel and driver can do (JSON, see it8951.Capabilities)
+//	GET  /api/screenshot.png  panel content
+//	POST /api/clear           clears the panel
+//	POST /api/pattern?name=   displays a test pattern (gradient, checker)
+//	POST /api/image?x=&y=     displays the image file sent as body
+//	POST /api/frame           displays the packed frame sent as body
+//	     /api/uploads/...     resumable uploads of images and frames (see uploads)
+//	     /api/regions/...     regions clients share the panel with (see regionSpec)
+//
+// Requests displaying something take an optional mode (GC16 by default), and
+// reply with the driver ID of the last frame displayed (see it8951.FrameID)
+// in the X-Frame-Id header, or, with the job queue on, 202 Accepted and the
+// job sequence number in X-Job-Id when it is queued (see jobQueue). With
+// access rules, GET endpoints require the view scope and the others the
+// display scope, except for drawing in a region, which the scope of the
+// region grants too; the page itself is public. With a rate limit, clients
+// sending more API requests get 429 Too Many Requests.
+func (p *controlPanel) handler() http.Handler {
+	mux := http.NewServeMux()
+	page, _ := fs.Sub(assets, "panel")
+	mux.Handle("GET /", http.FileServer(http.FS(page)))
+	mux.HandleFunc("GET /api/info", p.allow(scopeView, p.info))
+	mux.HandleFunc("GET /capabilities", p.allow(scopeView, p.capabilities))
+	mux.HandleFunc("GET /api/screenshot.png", p.allow(scopeView, p.screenshot))
+	mux.HandleFunc("POST /api/clear", p.allow(scopeDisplay, p.clear))
+	mux.HandleFunc("POST /api/pattern", p.allow(scopeDisplay, p.pattern))
+	mux.HandleFunc("POST /api/image", p.allow(scopeDisplay, p.image))
+	mux.HandleFunc("POST /api/frame", p.allow(scopeDisplay, p.frame))
+	mux.HandleFunc("POST /api/uploads", p.allow(scopeDisplay, p.startUpload))
+	mux.HandleFunc("PUT /api/uploads/{id}", p.allow(scopeDisplay, p.putChunk))
+	mux.HandleFunc("HEAD /api/uploads/{id}", p.allow(scopeDisplay, p.uploadStatus))
+	mux.HandleFunc("DELETE /api/uploads/{id}", p.allow(scopeDisplay, p.cancelUpload))
+	mux.HandleFunc("GET /api/regions", p.allow(scopeView, p.regions))
+	mux.HandleFunc("PUT /api/regions/{name}", p.allow(scopeDisplay, p.defineRegion))
+	mux.HandleFunc("DELETE /api/regions/{name}", p.allow(scopeDisplay, p.removeRegion))
+	mux.HandleFunc("POST /api/regions/{name}/image", p.allowRegion(p.drawRegion))
+	return mux
+}
+
+// allow wraps the handler of an endpoint with the access rules of its scope,
+// within the rate limit of the client
+func (p *controlPanel) allow(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return p.limits.wrap(p.access, p.access.allow(scope, handler))
+}
+
+// allowRegion wraps the handler of an endpoint drawing in a region with the
+// access rules of the region, within the rate limit of the client
+func (p *controlPanel) allowRegion(handler http.HandlerFunc) http.HandlerFunc {
+	return p.limits.wrap(p.access, p.access.allowRegion(handler))
+}
+
+// listenAddress returns the address the control panel listens on: a bare
+// port (":8080") means localhost, so that serving the network takes an
+// explicit host such as 0.0.0.0
+func listenAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("localhost", port)
+}
+
+// info describes the panel and the transfers so far
+func (p *controlPanel) info(w http.ResponseWriter, r *http.Request) {
+	info := status(p.display)
+	p.display.Do(func() error {
+		temperature, _ := it8951.ReadTemperature()
+		info["vcom"] = it8951.ReadVCOM()
+		info["temperature"] = temperature
+		return nil
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// capabilities describes the panel for generic clients
+func (p *controlPanel) capabilities(w http.ResponseWriter, r *http.Request) {
+	var report it8951.CapabilityReport
+	p.display.Do(func() error {
+		report = it8951.Capabilities()
+		return nil
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// powerStates names the power states
+var powerStates = map[it8951.PowerState]string{
+	it8951.PowerRun:       "run",
+	it8951.PowerStandby:   "standby",
+	it8951.PowerSleep:     "sleep",
+	it8951.PowerDeepSleep: "deep-sleep",
+}
+
+// status describes the panel and the transfers so far from what the driver
+// knows, without commands that would wake the controller up
+func status(display *it8951.Display) map[string]any {
+	info := map[string]any{}
+	display.Do(func() error {
+		devInfo := it8951.DeviceInfo()
+		fw := devInfo.Firmware()
+		info["width"] = devInfo.PanelW
+		info["height"] = devInfo.PanelH
+		info["firmware"] = fw.Version
+		info["lut"] = fw.LUT
+		info["power"] = powerStates[it8951.Power()]
+		info["frame"] = it8951.FrameID()
+		info["stats"] = it8951.Stats()
+		return nil
+	})
+	return info
+}
+
+// screenshot sends the panel content read back from the controller memory
+func (p *controlPanel) screenshot(w http.ResponseWriter, r *http.Request) {
+	var snapshot *image.Gray
+	err := p.display.Do(func() error {
+		bounds := it8951.DeviceInfo().Bounds()
+		snapshot = it8951.Snapshot(it8951.CurrentOrientation().LogicalBounds(bounds))
+		return it8951.Err()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	png.Encode(w, snapshot)
+}
+
+// clear clears the panel
+func (p *controlPanel) clear(w http.ResponseWriter, r *http.Request) {
+	p.run(w, r, &job{Kind: "clear"})
+}
+
+// pattern displays a test pattern covering the panel
+func (p *controlPanel) pattern(w http.ResponseWriter, r *http.Request) {
+	p.run(w, r, &job{Kind: "pattern", body: []byte(r.URL.Query().Get("name"))})
+}
+
+// image displays the image file sent as request body
+func (p *controlPanel) image(w http.ResponseWriter, r *http.Request) {
+	x, errX := strconv.ParseUint(r.URL.Query().Get("x"), 10, 16)
+	y, errY := strconv.ParseUint(r.URL.Query().Get("y"), 10, 16)
+	if errX != nil || errY != nil {
+		http.Error(w, "invalid position", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImageBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.run(w, r, &job{Kind: "image", X: uint16(x), Y: uint16(y), body: body})
+}
+
+// frame displays the packed frame sent as request body (see
+// it8951.PackedFrame)
+func (p *controlPanel) frame(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(p.maxFrame)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.run(w, r, &job{Kind: "frame", body: body})
+}
+
+// run displays a request with the mode it asks for (see submit)
+func (p *controlPanel) run(w http.ResponseWriter, r *http.Request, j *job) {
+	var err error
+	if j.Mode, err = p.displayMode(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.submit(w, j)
+}
+
+// submit displays a job and replies with the ID of the frame displayed, or,
+// when the job queue holds it, with its sequence number
+func (p *controlPanel) submit(w http.ResponseWriter, j *job) {
+	apply, err := j.prepare()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var seq, frame uint64
+	if p.jobs != nil {
+		seq, frame, err = p.jobs.offer(j, apply)
+	} else {
+		err = p.display.Do(func() error {
+			err := apply()
+			frame = it8951.FrameID()
+			return err
+		})
+	}
+	switch {
+	case errors.Is(err, errQueueFull):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case seq != 0:
+		w.Header().Set("X-Job-Id", strconv.FormatUint(seq, 10))
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.Header().Set("X-Frame-Id", strconv.FormatUint(frame, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// displayMode reads the mode parameter of a request, GC16 when missing,
+// returning an error unless it is in the panel LUT
+func (p *controlPanel) displayMode(r *http.Request) (it8951.DisplayMode, error) {
+	value := r.URL.Query().Get("mode")
+	if value == "" {
+		return it8951.GC16Mode, nil
+	}
+	mode, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q", value)
+	}
+	return it8951.DisplayMode(mode), p.checkMode(it8951.DisplayMode(mode))
+}
+
+// checkMode returns an error unless mode is in the panel LUT
+func (p *controlPanel) checkMode(mode it8951.DisplayMode) error {
+	return p.display.Do(func() error {
+		if !it8951.DeviceInfo().Firmware().Modes.Has(mode) {
+			return fmt.Errorf("unknown mode %d", mode)
+		}
+		return nil
+	})
+}
+
+// patterns are the test patterns, giving the gray of each pixel from its
+// offset in the panel of the given width: 16 bands of the panel gray levels
+// (gradient) or black and white squares (checker)
+var patterns = map[string]func(x, y, width int) uint8{
+	"gradient": func(x, y, width int) uint8 {
+		return uint8(16*x/width) * 0x11
+	},
+	"checker": func(x, y, width int) uint8 {
+		if (x/64+y/64)%2 == 0 {
+			return 0xff
+		}
+		return 0
+	},
+}
+
+// testPattern draws a test pattern over bounds
+func testPattern(shade func(x, y, width int) uint8, bounds image.Rectangle) image.Image {
+	img := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.Pix[img.PixOffset(x, y)] = shade(x-bounds.Min.X, y-bounds.Min.Y, bounds.Dx())
+		}
+	}
+	return img
+}
@@ -0,0 +1,83 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// bench measures full frame uploads. Frames go to the controller memory
+// right after the displayed image buffer, so the panel and its image buffer
+// are left untouched.
+func bench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	bpp := flags.Int("bpp", 4, "bits per pixel of the frames (2, 4 or 8)")
+	chunk := flags.Int("chunk", it8951.ChunkAuto, "SPI transfer size in bytes (-1: tuned)")
+	count := flags.Int("count", 3, "number of frames uploaded")
+	vcom := flags.Uint("vcom", 0, "expected VCOM in mV (0: not checked)")
+	if _, err := parseArgs(flags, args); err != nil {
+		return 2
+	}
+	if *bpp != 2 && *bpp != 4 && *bpp != 8 {
+		return fail(fmt.Errorf("unsupported bpp %d", *bpp))
+	}
+
+	devInfo, err := it8951.Attach(uint16(*vcom), it8951.WithChunkSize(*chunk))
+	if err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+
+	bounds := devInfo.Bounds()
+	spare := devInfo.TargetAddress() + uint32(bounds.Dx()*bounds.Dy())
+	if !it8951.MemoryFits(spare, bounds.Dx()*bounds.Dy()) {
+		return fail(fmt.Errorf("no spare frame in the controller memory (%d frames)", it8951.FrameCapacity()))
+	}
+	buffer := make(it8951.DataBuffer, it8951.GetWidthInWords(bounds.Dx(), *bpp)*bounds.Dy())
+	for i := range buffer {
+		buffer[i] = uint16(i)
+	}
+	imageInfo := it8951.LoadImgInfo{
+		SourceBufferAddr: buffer,
+		EndianType:       it8951.LoadImgLittleEndian,
+		PixelFormat:      it8951.Bpp(*bpp),
+		Rotate:           it8951.Rotate0,
+		TargetMemAddr:    spare,
+	}
+	var total time.Duration
+	for i := 0; i < *count; i++ {
+		start := time.Now()
+		imageInfo.HostAreaPackedPixelWrite(it8951.AreaFromRect(bounds), *bpp, true)
+		if err := it8951.Err(); err != nil {
+			return fail(err)
+		}
+		elapsed := time.Since(start)
+		total += elapsed
+		fmt.Printf("frame %d: %v\n", i+1, elapsed.Round(time.Millisecond))
+	}
+	bytes := float64(2 * len(buffer) * *count)
+	fmt.Printf("%dx%d at %dbpp, %d byte transfers: %v per frame, %.2f MB/s\n",
+		bounds.Dx(), bounds.Dy(), *bpp, it8951.Stats().ChunkSize,
+		(total / time.Duration(*count)).Round(time.Millisecond), bytes/total.Seconds()/1e6)
+	return 0
+}
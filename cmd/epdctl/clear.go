@@ -0,0 +1,50 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// clearPanel clears the panel
+func clearPanel(args []string) int {
+	flags := flag.NewFlagSet("clear", flag.ContinueOnError)
+	mode := flags.String("mode", "init", "display mode, by waveform name or number")
+	vcom := flags.Uint("vcom", 0, "expected VCOM in mV (0: not checked)")
+	if _, err := parseArgs(flags, args); err != nil {
+		return 2
+	}
+
+	devInfo, err := it8951.Attach(uint16(*vcom))
+	if err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+	displayMode, err := parseMode(*mode)
+	if err != nil {
+		return fail(err)
+	}
+	devInfo.ClearRefresh(devInfo.TargetAddress(), displayMode, it8951.Rotate0)
+	if err := it8951.Err(); err != nil {
+		return fail(err)
+	}
+	return 0
+}
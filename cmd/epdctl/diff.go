@@ -0,0 +1,128 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// diff colors
+var (
+	diffChanged = color.RGBA{R: 0xff, A: 0xff} // pixels differing
+	diffMissing = color.RGBA{B: 0xff, A: 0xff} // pixels in only one of the frames
+)
+
+// diffFrames compares the pixels of two packed frame files, without a panel
+func diffFrames(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	out := flags.String("o", "", "image of the differences (default: none)")
+	tolerance := flags.Uint("tolerance", 0, "gray level difference under which pixels are the same")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 2 {
+		return fail(errors.New("diff needs two frames"))
+	}
+	var frames [2]*it8951.PackedFrame
+	var images [2]*image.Gray
+	for i, path := range positional {
+		if frames[i], err = readFrame(path); err != nil {
+			return fail(fmt.Errorf("%s: %w", path, err))
+		}
+		if images[i], err = frames[i].Image(); err != nil {
+			return fail(fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	a, b := frames[0], frames[1]
+	if a.Panel != b.Panel || a.Bpp != b.Bpp || a.Rotation != b.Rotation || a.Area != b.Area {
+		fmt.Printf("headers differ:\n  %s\n  %s\n", describeFrame(a), describeFrame(b))
+	}
+
+	// pixels are compared over both areas, those of only one counting as
+	// different
+	area := a.Area.Union(b.Area)
+	result := image.NewRGBA(area)
+	var changed int
+	var changes image.Rectangle
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			p := image.Pt(x, y)
+			inA, inB := p.In(a.Area), p.In(b.Area)
+			var level uint8
+			if inA {
+				level = images[0].GrayAt(x, y).Y
+			} else if inB {
+				level = images[1].GrayAt(x, y).Y
+			}
+			// unchanged pixels are faded, so that differences stand out
+			c := color.RGBA{R: 0x80 + level/2, G: 0x80 + level/2, B: 0x80 + level/2, A: 0xff}
+			switch {
+			case inA != inB:
+				c = diffMissing
+			case inA && absDiff(level, images[1].GrayAt(x, y).Y) > *tolerance:
+				c = diffChanged
+			default:
+				result.SetRGBA(x, y, c)
+				continue
+			}
+			changed++
+			changes = changes.Union(image.Rectangle{Min: p, Max: p.Add(image.Pt(1, 1))})
+			result.SetRGBA(x, y, c)
+		}
+	}
+	if *out != "" {
+		encode, err := encoderFor(*out)
+		if err != nil {
+			return fail(err)
+		}
+		file, err := os.Create(*out)
+		if err != nil {
+			return fail(err)
+		}
+		if err := encode(file, result); err != nil {
+			file.Close()
+			return fail(err)
+		}
+		if err := file.Close(); err != nil {
+			return fail(err)
+		}
+	}
+	if changed == 0 {
+		fmt.Println("pixels are the same")
+		return 0
+	}
+	fmt.Printf("%d of %d pixels differ (%.2f%%), within %v\n", changed, area.Dx()*area.Dy(), 100*float64(changed)/float64(area.Dx()*area.Dy()), changes)
+	return 1
+}
+
+// absDiff returns the difference between two gray levels
+func absDiff(a, b uint8) uint {
+	if a > b {
+		return uint(a - b)
+	}
+	return uint(b - a)
+}
@@ -0,0 +1,68 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// info prints the controller system info and firmware details
+func info(args []string) int {
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the info as JSON")
+	registers := flags.Bool("registers", false, "also dump the controller registers")
+	vcom := flags.Uint("vcom", 0, "expected VCOM in mV (0: not checked)")
+	if _, err := parseArgs(flags, args); err != nil {
+		return 2
+	}
+
+	devInfo, err := it8951.Attach(uint16(*vcom))
+	if err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(devInfo); err != nil {
+			return fail(err)
+		}
+		return 0
+	}
+	fmt.Print(devInfo)
+	fmt.Print(devInfo.Firmware())
+	if model, ok := it8951.CurrentPanelModel(); ok {
+		fmt.Printf("Panel model  : %s\n", model.Name)
+	}
+	fmt.Printf("VCOM         : %dmV\n", it8951.ReadVCOM())
+	temperature, err := it8951.ReadTemperature()
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("Temperature  : %d°C\n", temperature)
+	if *registers {
+		fmt.Print(it8951.DumpRegisters())
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/peergum/IT8951-go"
+	"github.com/peergum/IT8951-go/labels"
+)
+
+// labelBatch renders a label for every record of a CSV or JSON file into
+// packed frame files, without a panel
+func labelBatch(args []string) int {
+	flags := flag.NewFlagSet("labels", flag.ContinueOnError)
+	panel := flags.String("panel", "", "panel model (e.g. 10.3, 6inHD) or size as WxH")
+	out := flags.String("out", ".", "output directory")
+	name := flags.String("name", "label-%04d.epd", "output file name, given the record number")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 2 {
+		return fail(errors.New("labels needs a template and a records file"))
+	}
+	size, err := parsePanel(*panel)
+	if err != nil {
+		return fail(err)
+	}
+	template, err := labels.LoadTemplate(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+	records, err := labels.ReadRecords(positional[1])
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return fail(err)
+	}
+
+	err = template.Batch(records, size, func(i int, frame *it8951.PackedFrame) error {
+		return saveFrame(frame, filepath.Join(*out, fmt.Sprintf(*name, i+1)))
+	})
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("saved %d labels to %s\n", len(records), *out)
+	return 0
+}
+
+// saveFrame writes a packed frame file
+func saveFrame(frame *it8951.PackedFrame, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := frame.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
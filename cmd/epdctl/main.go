@@ -0,0 +1,233 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Command epdctl operates an IT8951 panel from the command line, mostly for
+// maintenance of deployed devices. Except for diff, labels, pack, preview and
+// unpack, its commands attach to the controller without resetting it, so the panel
+// content is left untouched.
+// They also take the panel lease (see it8951.AcquireLease), failing when a
+// running program holds it unless -force is given.
+//
+// Usage:
+//
+//	epdctl [-epd] [-epd-trace] [-force] <command> [arguments]
+//
+// Commands:
+//
+//	bench [--bpp=4] [--chunk=-1] [--count=3] [--vcom=0]
+//	    measures full frame uploads (to off-screen memory) and prints the
+//	    SPI throughput
+//
+//	clear [--mode=init] [--vcom=0]
+//	    clears the panel to white
+//
+//	diff a.epd b.epd [-o diff.png] [--tolerance=0]
+//	    compares the pixels of two packed frame files, printing how many
+//	    differ and where, and with -o saves them with the differences in
+//	    red (blue for pixels of only one frame); exits with status 1 when
+//	    they differ; needs no panel
+//
+//	info [--json] [--registers] [--vcom=0]
+//	    prints the controller system info, firmware, panel model, VCOM and
+//	    temperature, and with --registers the register values; the system
+//	    info as JSON for inventory tools with --json
+//
+//	labels template.json records.csv|records.json --panel=6HD|WxH [--out=.] [--name=label-%04d.epd]
+//	    renders a label for every record into a packed frame file (see
+//	    package labels), e.g. for shelf or price labels, which raw then
+//	    displays; needs no panel
+//
+//	pack image --panel=6HD|WxH [-o frame.epd] [--bpp=4] [--dither=none] [--profile=photo] [--compress=deflate]
+//	    packs an image placed at the panel origin into a frame file (see
+//	    it8951.PackedFrame), which raw or the network endpoints display
+//	    without converting it, e.g. to prepare frames for slow devices on
+//	    a workstation, compressed with deflate, zstd, lz4 or none; needs
+//	    no panel
+//
+//	preview image... [--bpp=4] [--dither=none] [--profile=photo] [--out=dir]
+//	    saves each image as the panel would show it, to image.preview.png;
+//	    needs no panel
+//
+//	raw frame.epd... [--mode=gc16] [--dwell=5s] [--vcom=0]
+//	    displays packed frame files, in sequence, each for --dwell
+//
+//	show image [--mode=gc16] [--bpp=4] [--rotate=0] [--fit=inside] [--dither=none] [--profile=photo] [--crop] [--vcom=0]
+//	    displays an image file scaled to the panel (inside, fill or center),
+//	    the panel being rotated clockwise by 0, 90, 180 or 270 degrees, with
+//	    --crop after trimming its uniform margins (the document profile does
+//	    it too)
+//
+//	screenshot out.png|out.bmp [--region=x,y,w,h] [--vcom=0]
+//	    saves what the panel shows, read back from the controller memory
+//
+//	soak [--hours=24] [--pattern=mixed] [--interval=1s] [--report=file.json] [--vcom=0]
+//	    cycles full and partial refreshes, sleep and wake, and snapshots
+//	    checked against what was displayed (or only one of them: full,
+//	    partial, power or snapshot), recovering the controller after
+//	    errors, then reports errors and latencies; exits with status 1
+//	    when an operation failed
+//
+//	verify golden.png [--max-diff=1%] [--tolerance=16] [--region=x,y,w,h] [--vcom=0]
+//	    compares what the panel shows with a reference image; exits with
+//	    status 1 when more pixels than allowed differ
+//
+//	unpack frame.epd... [-o out.png] [--panel]
+//	    decodes packed frame files back to images, to frame.unpacked.png,
+//	    with --panel placed on a white image of the whole panel, e.g. to
+//	    check what pack or the network endpoints made of an image; needs no
+//	    panel
+//
+//	vcom [value]
+//	    prints the VCOM, or sets it, given in V (-1.58) or mV (1580)
+//
+// The --profile of pack, preview and show picks the conversion settings
+// suited to a kind of content: photo, document or screenshot (see
+// it8951.SourceProfile), --bpp and --dither overriding them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// command is an epdctl subcommand, returning the exit status
+type command func(args []string) int
+
+var commands = map[string]command{
+	"bench":      bench,
+	"info":       info,
+	"labels":     labelBatch,
+	"clear":      clearPanel,
+	"diff":       diffFrames,
+	"pack":       pack,
+	"preview":    preview,
+	"raw":        raw,
+	"screenshot": screenshot,
+	"show":       show,
+	"soak":       soak,
+	"unpack":     unpack,
+	"vcom":       vcomCmd,
+	"verify":     verify,
+}
+
+// offline are the commands which need no panel, and so no lease
+var offline = map[string]bool{
+	"diff":    true,
+	"labels":  true,
+	"pack":    true,
+	"preview": true,
+	"unpack":  true,
+}
+
+var (
+	force = flag.Bool("force", false, "drive the panel even when another process holds it")
+	debug = flag.Bool("epd", false, "log the driver commands to stderr")
+	trace = flag.Bool("epd-trace", false, "log the driver commands and data transfers to stderr")
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *debug || *trace {
+		level := slog.LevelDebug
+		if *trace {
+			level = it8951.LevelTrace
+		}
+		it8951.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	}
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "epdctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if offline[flag.Arg(0)] {
+		os.Exit(run(flag.Args()[1:]))
+	}
+	lease, err := it8951.AcquireLease(it8951.DefaultLeasePath, *force)
+	if err != nil {
+		os.Exit(fail(fmt.Errorf("%w; use -force to take over", err)))
+	}
+	status := run(flag.Args()[1:])
+	if err := lease.Release(); err != nil {
+		fmt.Fprintln(os.Stderr, "epdctl:", err)
+	}
+	os.Exit(status)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: epdctl [-epd] [-epd-trace] [-force] <command> [arguments]")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+name)
+	}
+}
+
+// parseArgs parses flags placed anywhere among the arguments, returning the
+// positional ones
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseMode returns the display mode of a waveform given by name (e.g.
+// "gc16") for the LUT of the attached panel, or a mode given by number
+func parseMode(value string) (it8951.DisplayMode, error) {
+	for w := it8951.WaveformINIT; w <= it8951.WaveformDU4; w++ {
+		if strings.EqualFold(value, w.String()) {
+			return it8951.Mode(w)
+		}
+	}
+	mode, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown mode %q", value)
+	}
+	return it8951.DisplayMode(mode), nil
+}
+
+// fail prints an error and returns the error exit status
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "epdctl:", err)
+	return 2
+}
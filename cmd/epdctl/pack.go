@@ -0,0 +1,121 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// pack converts an image to a packed frame file, without a panel
+func pack(args []string) int {
+	flags := flag.NewFlagSet("pack", flag.ContinueOnError)
+	panel := flags.String("panel", "", "panel model (e.g. 10.3, 6inHD) or size as WxH")
+	out := flags.String("o", "", "output file (default: the image name with .epd)")
+	bpp := flags.Int("bpp", 4, "bits per pixel (1, 2, 4 or 8)")
+	dither := flags.String("dither", "none", "ditherer: none, floyd-steinberg (fs), atkinson or bayer")
+	profileName := flags.String("profile", "", "source profile: photo, document, screenshot")
+	compress := flags.String("compress", "deflate", "pixel compression: none, deflate, zstd or lz4")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		return fail(errors.New("pack needs an image"))
+	}
+	d, ok := ditherers[*dither]
+	if !ok {
+		return fail(fmt.Errorf("unknown ditherer %q", *dither))
+	}
+	profile, err := parseProfile(flags, *profileName, bpp, &d)
+	if err != nil {
+		return fail(err)
+	}
+	switch *bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fail(fmt.Errorf("unsupported bpp %d", *bpp))
+	}
+	compression, ok := compressions[*compress]
+	if !ok {
+		return fail(fmt.Errorf("unknown compression %q", *compress))
+	}
+	size, err := parsePanel(*panel)
+	if err != nil {
+		return fail(err)
+	}
+	target := *out
+	if target == "" {
+		target = strings.TrimSuffix(positional[0], filepath.Ext(positional[0])) + ".epd"
+	}
+	var img image.Image
+	img, _, err = it8951.DecodeFile(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+	if profile != nil {
+		img = profile.Prepare(img, img.Bounds())
+	}
+
+	// the image is placed at the panel origin, its width rounded up to
+	// whole words at 1bpp
+	area := img.Bounds().Sub(img.Bounds().Min)
+	area.Max.X = (area.Max.X + 15) &^ 15
+	area = area.Intersect(image.Rectangle{Max: size})
+	it8951.SetDitherer(d)
+	frame := &it8951.PackedFrame{
+		Panel:       size,
+		Area:        area,
+		Bpp:         *bpp,
+		Compression: compression,
+		Pixels:      it8951.PackImage(img, area.Add(img.Bounds().Min), *bpp),
+	}
+	if err := saveFrame(frame, target); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("saved %v at %dbpp to %s\n", area, *bpp, target)
+	return 0
+}
+
+// compressions are the frame compressions by name
+var compressions = map[string]it8951.FrameCompression{
+	"none":    it8951.CompressNone,
+	"deflate": it8951.CompressDeflate,
+	"zstd":    it8951.CompressZstd,
+	"lz4":     it8951.CompressLZ4,
+}
+
+// parsePanel returns the size of a panel given by model name, optionally
+// with "in" after the diagonal ("6inHD", "10.3in"), or as WxH
+func parsePanel(value string) (image.Point, error) {
+	if model, ok := it8951.LookupPanelModel(strings.Replace(value, "in", "", 1)); ok {
+		return model.Size, nil
+	}
+	var size image.Point
+	if _, err := fmt.Sscanf(value, "%dx%d", &size.X, &size.Y); err != nil || size.X <= 0 || size.Y <= 0 {
+		return image.Point{}, fmt.Errorf("unknown panel %q, expecting a model name or WxH", value)
+	}
+	return size, nil
+}
@@ -0,0 +1,116 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// ditherers are the ditherers selectable by name
+var ditherers = map[string]it8951.Ditherer{
+	"none":            it8951.Quantize,
+	"floyd-steinberg": it8951.FloydSteinberg,
+	"fs":              it8951.FloydSteinberg,
+	"atkinson":        it8951.Atkinson,
+	"bayer":           it8951.Bayer,
+}
+
+// parseProfile returns the source profile given by name, nil when empty. Its
+// bpp and ditherer replace those of the -bpp and -dither flags, unless they
+// were given.
+func parseProfile(flags *flag.FlagSet, name string, bpp *int, d *it8951.Ditherer) (*it8951.SourceProfile, error) {
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := it8951.LookupSourceProfile(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, expecting one of %s", name, strings.Join(it8951.SourceProfileNames(), ", "))
+	}
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if !given["bpp"] && profile.Bpp != 0 {
+		*bpp = profile.Bpp
+	}
+	if !given["dither"] && profile.Ditherer != nil {
+		*d = profile.Ditherer
+	}
+	return &profile, nil
+}
+
+// preview renders images as the panel would show them, without a panel
+func preview(args []string) int {
+	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
+	bpp := flags.Int("bpp", 4, "bits per pixel (1, 2, 4 or 8)")
+	dither := flags.String("dither", "none", "ditherer: none, floyd-steinberg (fs), atkinson or bayer")
+	profileName := flags.String("profile", "", "source profile: photo, document, screenshot")
+	out := flags.String("out", "", "output directory (default: next to each image)")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	d, ok := ditherers[*dither]
+	if !ok {
+		return fail(fmt.Errorf("unknown ditherer %q", *dither))
+	}
+	profile, err := parseProfile(flags, *profileName, bpp, &d)
+	if err != nil {
+		return fail(err)
+	}
+	switch *bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fail(fmt.Errorf("unsupported bpp %d", *bpp))
+	}
+	if len(positional) == 0 {
+		return fail(errors.New("preview needs at least one image"))
+	}
+
+	opts := it8951.PreviewOptions{Bpp: *bpp, Ditherer: d, Profile: profile}
+	for _, path := range positional {
+		img, _, err := it8951.DecodeFile(path)
+		if err != nil {
+			return fail(err)
+		}
+		target := strings.TrimSuffix(path, filepath.Ext(path)) + ".preview.png"
+		if *out != "" {
+			target = filepath.Join(*out, filepath.Base(target))
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return fail(err)
+		}
+		encode, _ := encoderFor(target)
+		if err := encode(file, it8951.Preview(img, opts)); err != nil {
+			file.Close()
+			return fail(err)
+		}
+		if err := file.Close(); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("saved %s\n", target)
+	}
+	return 0
+}
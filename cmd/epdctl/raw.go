@@ -0,0 +1,80 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// raw displays packed frame files, in sequence
+func raw(args []string) int {
+	flags := flag.NewFlagSet("raw", flag.ContinueOnError)
+	mode := flags.String("mode", "gc16", "display mode, by waveform name or number")
+	dwell := flags.Duration("dwell", 5*time.Second, "time each frame is shown, when several are given")
+	vcom := flags.Uint("vcom", 0, "expected VCOM in mV (0: not checked)")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) == 0 {
+		return fail(errors.New("raw needs a packed frame file"))
+	}
+	frames := make([]*it8951.PackedFrame, 0, len(positional))
+	for _, path := range positional {
+		frame, err := readFrame(path)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", path, err))
+		}
+		frames = append(frames, frame)
+	}
+
+	if _, err := it8951.Attach(uint16(*vcom)); err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+	displayMode, err := parseMode(*mode)
+	if err != nil {
+		return fail(err)
+	}
+	for i, frame := range frames {
+		if i > 0 {
+			time.Sleep(*dwell)
+		}
+		if err := frame.Display(displayMode); err != nil {
+			return fail(fmt.Errorf("%s: %w", positional[i], err))
+		}
+	}
+	return 0
+}
+
+// readFrame reads a packed frame file
+func readFrame(path string) (*it8951.PackedFrame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return it8951.ReadPackedFrame(file)
+}
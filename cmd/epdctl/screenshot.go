@@ -0,0 +1,90 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peergum/IT8951-go"
+	"golang.org/x/image/bmp"
+)
+
+// screenshot saves the panel content to an image file
+func screenshot(args []string) int {
+	flags := flag.NewFlagSet("screenshot", flag.ContinueOnError)
+	region := flags.String("region", "", "area to save as x,y,w,h (default: the whole panel)")
+	vcom := flags.Uint("vcom", 0, "expected VCOM in mV (0: not checked)")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		return fail(errors.New("screenshot needs an output file (.png or .bmp)"))
+	}
+	encode, err := encoderFor(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+
+	devInfo, err := it8951.Attach(uint16(*vcom))
+	if err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+	area := it8951.CurrentOrientation().LogicalBounds(devInfo.Bounds())
+	if *region != "" {
+		if area, err = parseRect(*region); err != nil {
+			return fail(err)
+		}
+	}
+	snapshot := it8951.Snapshot(area)
+
+	file, err := os.Create(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+	if err := encode(file, snapshot); err != nil {
+		file.Close()
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("saved %v to %s\n", snapshot.Rect, positional[0])
+	return 0
+}
+
+// encoderFor returns the image encoder matching a file extension
+func encoderFor(path string) (func(io.Writer, image.Image) error, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return png.Encode, nil
+	case ".bmp":
+		return bmp.Encode, nil
+	}
+	return nil, fmt.Errorf("unsupported output format %q", filepath.Ext(path))
+}
@@ -0,0 +1,84 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// fitModes are the fit modes selectable by name
+var fitModes = map[string]it8951.FitMode{
+	"inside": it8951.FitInside,
+	"fill":   it8951.FitFill,
+	"center": it8951.FitCenter,
+}
+
+// show displays an image file scaled to the panel
+func show(args []string) int {
+	flags := flag.NewFlagSet("show", flag.ContinueOnError)
+	mode := flags.String("mode", "gc16", "display mode, by waveform name or number")
+	bpp := flags.Int("bpp", 4, "bits per pixel (1, 2, 4 or 8)")
+	rotate := flags.Int("rotate", 0, "clockwise rotation of the panel in degrees (0, 90, 180 or 270)")
+	fit := flags.String("fit", "inside", "scaling: inside, fill or center")
+	dither := flags.String("dither", "none", "ditherer: none, floyd-steinberg (fs), atkinson or bayer")
+	profileName := flags.String("profile", "", "source profile: photo, document, screenshot")
+	crop := flags.Bool("crop", false, "trim the uniform margins of the image first")
+	vcom := flags.Uint("vcom", 0, "expected VCOM in mV (0: not checked)")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		return fail(errors.New("show needs an image"))
+	}
+	if *rotate%90 != 0 || *rotate < 0 || *rotate > 270 {
+		return fail(fmt.Errorf("unsupported rotation %d", *rotate))
+	}
+	fitMode, ok := fitModes[*fit]
+	if !ok {
+		return fail(fmt.Errorf("unknown fit %q", *fit))
+	}
+	d, ok := ditherers[*dither]
+	if !ok {
+		return fail(fmt.Errorf("unknown ditherer %q", *dither))
+	}
+	profile, err := parseProfile(flags, *profileName, bpp, &d)
+	if err != nil {
+		return fail(err)
+	}
+
+	if _, err := it8951.Attach(uint16(*vcom)); err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+	displayMode, err := parseMode(*mode)
+	if err != nil {
+		return fail(err)
+	}
+	it8951.SetOrientation(it8951.Orientation(*rotate / 90))
+	opts := it8951.FileOptions{Fit: fitMode, Bpp: *bpp, Ditherer: d, Mode: displayMode, Profile: profile, Crop: *crop}
+	if err := it8951.DisplayFile(positional[0], opts); err != nil {
+		return fail(err)
+	}
+	return 0
+}
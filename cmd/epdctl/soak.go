@@ -0,0 +1,282 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// soakPatterns are the operation sequences a soak test cycles through
+var soakPatterns = map[string][]string{
+	"full":     {"full"},
+	"partial":  {"partial"},
+	"power":    {"power"},
+	"snapshot": {"snapshot"},
+	"mixed":    {"full", "partial", "partial", "partial", "snapshot", "power", "partial", "snapshot"},
+}
+
+// opStats are the results of one kind of soak test operation
+type opStats struct {
+	Count     int           `json:"count"`
+	Errors    int           `json:"errors"`
+	Min       time.Duration `json:"min"`
+	Max       time.Duration `json:"max"`
+	Total     time.Duration `json:"total"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+// soakReport is the outcome of a soak test
+type soakReport struct {
+	Pattern    string               `json:"pattern"`
+	Started    time.Time            `json:"started"`
+	Elapsed    time.Duration        `json:"elapsed"`
+	Recoveries int                  `json:"recoveries"`
+	Dead       string               `json:"dead,omitempty"`
+	Operations map[string]*opStats  `json:"operations"`
+	Transfers  it8951.TransferStats `json:"transfers"`
+}
+
+// soaker runs soak test operations, keeping the expected panel content to
+// check snapshots against
+type soaker struct {
+	shown  *image.Gray
+	cycle  int
+	random *rand.Rand
+}
+
+// soak cycles refreshes, sleep and wake, and snapshots for hours, then
+// reports errors and latencies
+func soak(args []string) int {
+	flags := flag.NewFlagSet("soak", flag.ContinueOnError)
+	hours := flags.Float64("hours", 24, "test duration in hours")
+	pattern := flags.String("pattern", "mixed", "operations: full, partial, power, snapshot or mixed")
+	interval := flags.Duration("interval", time.Second, "pause between operations")
+	reportPath := flags.String("report", "", "JSON report file (default: none)")
+	vcom := flags.Uint("vcom", 0, "expected VCOM in mV (0: not checked)")
+	if _, err := parseArgs(flags, args); err != nil {
+		return 2
+	}
+	sequence, ok := soakPatterns[*pattern]
+	if !ok {
+		return fail(fmt.Errorf("unknown pattern %q", *pattern))
+	}
+
+	devInfo, err := it8951.Attach(uint16(*vcom))
+	if err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*hours*float64(time.Hour)))
+	defer cancel()
+
+	report := soakReport{Pattern: *pattern, Started: time.Now(), Operations: map[string]*opStats{}}
+	s := &soaker{
+		shown:  image.NewGray(it8951.CurrentOrientation().LogicalBounds(devInfo.Bounds())),
+		random: rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0)),
+	}
+	supervisor := &it8951.Supervisor{}
+	// the panel content is unknown until the first full refresh
+	if err := s.full(); err != nil {
+		return fail(err)
+	}
+	lastProgress := time.Now()
+	for i := 0; ctx.Err() == nil; i++ {
+		name := sequence[i%len(sequence)]
+		stats := report.Operations[name]
+		if stats == nil {
+			stats = &opStats{}
+			report.Operations[name] = stats
+		}
+		start := time.Now()
+		err := s.run(name)
+		elapsed := time.Since(start)
+		stats.Count++
+		stats.Total += elapsed
+		if stats.Count == 1 || elapsed < stats.Min {
+			stats.Min = elapsed
+		}
+		stats.Max = max(stats.Max, elapsed)
+		if err != nil {
+			stats.Errors++
+			stats.LastError = err.Error()
+			fmt.Fprintf(os.Stderr, "%s: %s: %v\n", time.Now().Format(time.TimeOnly), name, err)
+			report.Recoveries++
+			if err := supervisor.Recover(); errors.Is(err, it8951.ErrDead) {
+				report.Dead = err.Error()
+				break
+			}
+			// the panel content was lost with the reset
+			s.full()
+		}
+		if time.Since(lastProgress) >= 10*time.Minute {
+			lastProgress = time.Now()
+			fmt.Printf("%v: %d operations, %d errors\n",
+				time.Since(report.Started).Round(time.Second), report.operations(), report.failures())
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(*interval):
+		}
+	}
+	report.Elapsed = time.Since(report.Started)
+	report.Transfers = it8951.Stats()
+
+	report.print()
+	if *reportPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*reportPath, data, 0644)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if report.failures() > 0 || report.Dead != "" {
+		return 1
+	}
+	return 0
+}
+
+// run runs an operation
+func (s *soaker) run(name string) error {
+	switch name {
+	case "full":
+		return s.full()
+	case "partial":
+		return s.partial()
+	case "power":
+		return s.power()
+	}
+	return s.snapshot()
+}
+
+// full displays a test pattern over the panel with GC16: gray bands and
+// checkers in turn
+func (s *soaker) full() error {
+	s.cycle++
+	bounds := s.shown.Rect
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray := uint8(16*(x-bounds.Min.X)/bounds.Dx()) * 0x11
+			if s.cycle%2 == 0 {
+				gray = 0
+				if ((x-bounds.Min.X)/64+(y-bounds.Min.Y)/64)%2 == 0 {
+					gray = 0xff
+				}
+			}
+			s.shown.Pix[s.shown.PixOffset(x, y)] = gray
+		}
+	}
+	return it8951.DrawImage(s.shown, uint16(bounds.Min.X), uint16(bounds.Min.Y), 4, it8951.GC16Mode, it8951.Rotate0)
+}
+
+// partial fills a random box with black or white, with DU. Boxes are
+// aligned on 32 pixels, so that no background is added around them.
+func (s *soaker) partial() error {
+	bounds := s.shown.Rect
+	size := image.Pt(min(128, bounds.Dx()), min(128, bounds.Dy()))
+	at := image.Pt(s.random.IntN(bounds.Dx()-size.X+1)&^31, s.random.IntN(bounds.Dy()-size.Y+1)&^31)
+	box := image.Rectangle{Min: at, Max: at.Add(size)}.Add(bounds.Min)
+	gray := uint8(0)
+	if s.random.IntN(2) == 0 {
+		gray = 0xff
+	}
+	draw.Draw(s.shown, box, image.NewUniform(color.Gray{Y: gray}), image.Point{}, draw.Src)
+	return it8951.DrawImage(s.shown.SubImage(box), uint16(box.Min.X), uint16(box.Min.Y), 4, it8951.DUMode, it8951.Rotate0)
+}
+
+// power puts the controller to sleep and wakes it up
+func (s *soaker) power() error {
+	it8951.Sleep()
+	if err := it8951.Err(); err != nil {
+		return err
+	}
+	return it8951.Wake()
+}
+
+// snapshot reads a random area back and compares it with what was displayed
+func (s *soaker) snapshot() error {
+	bounds := s.shown.Rect
+	size := image.Pt(min(256, bounds.Dx()), min(256, bounds.Dy()))
+	at := image.Pt(s.random.IntN(bounds.Dx()-size.X+1), s.random.IntN(bounds.Dy()-size.Y+1))
+	area := image.Rectangle{Min: at, Max: at.Add(size)}.Add(bounds.Min)
+	snapshot := it8951.Snapshot(area)
+	if err := it8951.Err(); err != nil {
+		return err
+	}
+	if ratio := it8951.DiffRatio(snapshot, s.shown.SubImage(area), 16); ratio > 0.01 {
+		return fmt.Errorf("%.2f%% of %v differs from what was displayed", 100*ratio, area)
+	}
+	return nil
+}
+
+// operations returns the number of operations run
+func (report *soakReport) operations() (count int) {
+	for _, stats := range report.Operations {
+		count += stats.Count
+	}
+	return count
+}
+
+// failures returns the number of operations failed
+func (report *soakReport) failures() (count int) {
+	for _, stats := range report.Operations {
+		count += stats.Errors
+	}
+	return count
+}
+
+// print prints the report as a table
+func (report *soakReport) print() {
+	fmt.Printf("soak %s: %v, %d operations, %d errors, %d recoveries\n", report.Pattern,
+		report.Elapsed.Round(time.Second), report.operations(), report.failures(), report.Recoveries)
+	if report.Dead != "" {
+		fmt.Println("gave up:", report.Dead)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "operation\tcount\terrors\tmin\tavg\tmax\tlast error")
+	for _, name := range []string{"full", "partial", "power", "snapshot"} {
+		stats, ok := report.Operations[name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%v\t%v\t%v\t%s\n", name, stats.Count, stats.Errors,
+			stats.Min.Round(time.Millisecond), (stats.Total / time.Duration(stats.Count)).Round(time.Millisecond),
+			stats.Max.Round(time.Millisecond), stats.LastError)
+	}
+	w.Flush()
+	fmt.Printf("transfers: %d words written, %d byte chunks\n", report.Transfers.WordsWritten, report.Transfers.ChunkSize)
+}
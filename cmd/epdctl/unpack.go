@@ -0,0 +1,104 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// unpack decodes packed frame files back to images, without a panel
+func unpack(args []string) int {
+	flags := flag.NewFlagSet("unpack", flag.ContinueOnError)
+	out := flags.String("o", "", "output file (default: the frame name with .unpacked.png), with a single frame")
+	panel := flags.Bool("panel", false, "place the frame area on a white image of the whole panel")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) == 0 {
+		return fail(errors.New("unpack needs at least one frame"))
+	}
+	if *out != "" && len(positional) > 1 {
+		return fail(errors.New("-o needs a single frame"))
+	}
+	for _, path := range positional {
+		frame, err := readFrame(path)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", path, err))
+		}
+		img, err := frame.Image()
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", path, err))
+		}
+		var result image.Image = img
+		if *panel {
+			result = onPanel(img, frame)
+		}
+		target := *out
+		if target == "" {
+			target = strings.TrimSuffix(path, filepath.Ext(path)) + ".unpacked.png"
+		}
+		encode, err := encoderFor(target)
+		if err != nil {
+			return fail(err)
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return fail(err)
+		}
+		if err := encode(file, result); err != nil {
+			file.Close()
+			return fail(err)
+		}
+		if err := file.Close(); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("%s: %s, saved to %s\n", path, describeFrame(frame), target)
+	}
+	return 0
+}
+
+// onPanel returns the unpacked pixels of a frame on a white image of the
+// panel, in the frame coordinates
+func onPanel(img *image.Gray, frame *it8951.PackedFrame) *image.Gray {
+	size := frame.Panel
+	if frame.Rotation == it8951.Rotate90 || frame.Rotation == it8951.Rotate270 {
+		size = image.Pt(size.Y, size.X)
+	}
+	canvas := image.NewGray(image.Rectangle{Max: size})
+	for i := range canvas.Pix {
+		canvas.Pix[i] = 0xff
+	}
+	draw.Draw(canvas, img.Rect, img, img.Rect.Min, draw.Src)
+	return canvas
+}
+
+// describeFrame returns the header fields of a packed frame
+func describeFrame(frame *it8951.PackedFrame) string {
+	return fmt.Sprintf("%dx%d panel, area %v at %dbpp, rotation %d", frame.Panel.X, frame.Panel.Y, frame.Area, frame.Bpp, frame.Rotation)
+}
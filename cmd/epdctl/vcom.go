@@ -0,0 +1,73 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// vcomCmd prints or sets the VCOM
+func vcomCmd(args []string) int {
+	flags := flag.NewFlagSet("vcom", flag.ContinueOnError)
+	// negative values in V would be taken for flags: numbers are picked
+	// out first
+	var values, rest []string
+	for _, arg := range args {
+		if _, err := strconv.ParseFloat(arg, 64); err == nil {
+			values = append(values, arg)
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	positional, err := parseArgs(flags, rest)
+	if err != nil {
+		return 2
+	}
+	values = append(values, positional...)
+	if len(values) > 1 {
+		return fail(errors.New("vcom takes at most one value"))
+	}
+	var setting it8951.VCOM
+	if len(values) == 1 {
+		if setting, err = it8951.ParseVCOM(values[0]); err != nil {
+			return fail(err)
+		}
+	}
+
+	if _, err := it8951.Attach(0); err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+	if setting != 0 {
+		if err := it8951.SetPanelVCOM(setting); err != nil {
+			return fail(err)
+		}
+	}
+	current, err := it8951.PanelVCOM()
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("VCOM: %v (%dmV)\n", current, current)
+	return 0
+}
@@ -0,0 +1,98 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// verify compares the panel content with a reference image
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	maxDiff := flags.String("max-diff", "1%", "largest share of differing pixels allowed")
+	tolerance := flags.Uint("tolerance", 16, "gray level difference under which pixels are the same")
+	region := flags.String("region", "", "area to compare as x,y,w,h (default: the reference image size at 0,0)")
+	vcom := flags.Uint("vcom", 0, "expected VCOM in mV (0: not checked)")
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		return fail(errors.New("verify needs a reference image"))
+	}
+	limit, err := parsePercent(*maxDiff)
+	if err != nil {
+		return fail(err)
+	}
+	golden, _, err := it8951.DecodeFile(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+	area := golden.Bounds().Sub(golden.Bounds().Min)
+	if *region != "" {
+		if area, err = parseRect(*region); err != nil {
+			return fail(err)
+		}
+	}
+
+	if _, err := it8951.Attach(uint16(*vcom)); err != nil {
+		return fail(err)
+	}
+	defer it8951.Exit()
+	snapshot := it8951.Snapshot(area)
+
+	ratio := it8951.DiffRatio(snapshot, golden, uint8(min(*tolerance, 255)))
+	fmt.Printf("%.2f%% of pixels differ (max %.2f%%)\n", 100*ratio, 100*limit)
+	if ratio > limit {
+		return 1
+	}
+	return 0
+}
+
+// parsePercent parses a ratio given as a percentage ("1%") or a fraction ("0.01")
+func parsePercent(value string) (float64, error) {
+	if number, ok := strings.CutSuffix(value, "%"); ok {
+		percent, err := strconv.ParseFloat(number, 64)
+		return percent / 100, err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// parseRect parses a rectangle given as x,y,w,h
+func parseRect(value string) (image.Rectangle, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 4 {
+		return image.Rectangle{}, fmt.Errorf("bad rectangle %q, expecting x,y,w,h", value)
+	}
+	var n [4]int
+	for i, field := range fields {
+		var err error
+		if n[i], err = strconv.Atoi(strings.TrimSpace(field)); err != nil {
+			return image.Rectangle{}, fmt.Errorf("bad rectangle %q: %v", value, err)
+		}
+	}
+	return image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3]), nil
+}
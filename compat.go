@@ -0,0 +1,94 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// Shims for the original x, y, w, h signatures.
+
+// DisplayArea display current area
+//
+// Deprecated: use DisplayRect
+func DisplayArea(x, y, w, h uint16, mode DisplayMode) error {
+	return DisplayRect(Rect(x, y, w, h), mode)
+}
+
+// DisplayAreaBuffer displays target address area
+//
+// Deprecated: use DisplayRectBuffer
+func DisplayAreaBuffer(x, y, w, h uint16, mode DisplayMode, targetAddress uint32) error {
+	return DisplayRectBuffer(Rect(x, y, w, h), mode, targetAddress)
+}
+
+// Display1bpp display in monochrome (1bpp mode)
+//
+// Deprecated: use Display1bppRect
+func Display1bpp(x, y, w, h uint16, mode DisplayMode, targetAddress uint32, backGreyValue uint8, frontGreyValue uint8) error {
+	return Display1bppRect(Rect(x, y, w, h), mode, targetAddress, backGreyValue, frontGreyValue)
+}
+
+// Refresh1bpp writes and displays a 1bpp buffer, X and W being given in
+// bytes for the write (see Write1bpp) and as is for the display, as they
+// always were
+//
+// Deprecated: use Refresh1bppRect
+func Refresh1bpp(buffer DataBuffer, X, Y, W, H uint16, mode DisplayMode, targetAddress uint32, packedWrite bool, rotation Rotate) error {
+	if err := Write1bpp(buffer, X, Y, W, H, targetAddress, packedWrite, rotation); err != nil {
+		return err
+	}
+	return Display1bppRect(Rect(X, Y, W, H), mode, targetAddress, 0xF0, 0x00)
+}
+
+// Write1bpp writes a 1bpp buffer without displaying it, X and W being given
+// in bytes (8 pixels), the 8bpp units the controller loads 1bpp areas in.
+// Write1bppRect takes pixels instead.
+//
+// Deprecated: use Write1bppRect
+func Write1bpp(buffer DataBuffer, X, Y, W, H uint16, targetAddress uint32, packedWrite bool, rotation Rotate) error {
+	area := image.Rect(8*int(X), int(Y), 8*(int(X)+int(W)), int(Y)+int(H))
+	return Write1bppRect(buffer, area, targetAddress, packedWrite, rotation)
+}
+
+// MultiFrameRefresh1bpp displays an area already loaded at targetAddress in A2 mode
+//
+// Deprecated: use MultiFrameRefresh1bppRect
+func MultiFrameRefresh1bpp(X, Y, W, H uint16, targetAddress uint32) error {
+	return MultiFrameRefresh1bppRect(Rect(X, Y, W, H), targetAddress)
+}
+
+// Refresh2bpp writes and displays a 2bpp buffer
+//
+// Deprecated: use Refresh2bppRect
+func Refresh2bpp(buffer DataBuffer, X, Y, W, H uint16, hold bool, targetAddress uint32, packedWrite bool, rotation Rotate) error {
+	return Refresh2bppRect(buffer, Rect(X, Y, W, H), hold, targetAddress, packedWrite, rotation)
+}
+
+// Refresh4bpp writes and displays a 4bpp buffer
+//
+// Deprecated: use Refresh4bppRect
+func Refresh4bpp(buffer DataBuffer, X, Y, W, H uint16, hold bool, targetAddress uint32, packedWrite bool, rotation Rotate) error {
+	return Refresh4bppRect(buffer, Rect(X, Y, W, H), hold, targetAddress, packedWrite, rotation)
+}
+
+// Refresh8bpp writes and displays an 8bpp buffer
+//
+// Deprecated: use Refresh8bppRect
+func Refresh8bpp(buffer DataBuffer, X, Y, W, H uint16, hold bool, targetAddress uint32, rotation Rotate) error {
+	return Refresh8bppRect(buffer, Rect(X, Y, W, H), hold, targetAddress, rotation)
+}
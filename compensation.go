@@ -0,0 +1,137 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Compensation describes how a weathered panel renders the gray levels
+// compared with a reference one, to correct images for it: panels yellow and
+// lose contrast as they age, so the same content looks different across a
+// fleet. Measure the lightness of the 16 panel levels on the aged panel and
+// on a reference panel, e.g. with a colorimeter or photos taken under the
+// same light (white at 255), and images are remapped so that the aged panel
+// shows what the reference does, as far as its range allows.
+//
+// Compensations are per device: set them in the configuration of each (see
+// WithCompensation and StageConfig), or keep them in files (see
+// LoadCompensation).
+type Compensation struct {
+	Panel    string    `json:"panel,omitempty"` // panel measured, for reference
+	Measured time.Time `json:"measured"`
+	// Levels is the lightness of each panel level (0x00, 0x11, ... 0xff) on
+	// the panel
+	Levels [16]uint8 `json:"levels"`
+	// Reference is the lightness of each level on the reference panel, the
+	// levels themselves when all zero
+	Reference [16]uint8 `json:"reference"`
+}
+
+var (
+	// compensationCurve caches the curve of the configured compensation
+	compensationCurve struct {
+		from  Compensation
+		curve *ToneCurve
+	}
+)
+
+// Curve returns the tone curve mapping each gray to the one the panel shows
+// as the reference panel shows the first
+func (c Compensation) Curve() *ToneCurve {
+	reference := c.Reference
+	if reference == [16]uint8{} {
+		for i := range reference {
+			reference[i] = uint8(i * 0x11)
+		}
+	}
+	shown, wanted := LevelCurve(c.Levels), LevelCurve(reference)
+	curve := &ToneCurve{}
+	for value := range curve {
+		// the closest lightness, the closest gray on ties
+		best := 0
+		for gray := range shown {
+			distance, bestDistance := abs(int(shown[gray])-int(wanted[value])), abs(int(shown[best])-int(wanted[value]))
+			if distance < bestDistance || distance == bestDistance && abs(gray-value) < abs(best-value) {
+				best = gray
+			}
+		}
+		curve[value] = uint8(best)
+	}
+	return curve
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	return n * sign(n)
+}
+
+// check returns an error when the compensation has no measures
+func (c Compensation) check() error {
+	if c.Levels == [16]uint8{} {
+		return fmt.Errorf("it8951: compensation with no measured levels")
+	}
+	return nil
+}
+
+// WithCompensation corrects images for a weathered panel (see Compensation),
+// none when nil
+func WithCompensation(c *Compensation) Option {
+	return func(config *Config) {
+		config.Compensation = c
+	}
+}
+
+// currentCompensation returns the tone curve of the configured compensation,
+// nil when there is none
+func currentCompensation() *ToneCurve {
+	c := config.Compensation
+	if c == nil || c.check() != nil {
+		return nil
+	}
+	if compensationCurve.curve == nil || compensationCurve.from != *c {
+		compensationCurve.from, compensationCurve.curve = *c, c.Curve()
+	}
+	return compensationCurve.curve
+}
+
+// LoadCompensation reads a compensation saved by SaveCompensation
+func LoadCompensation(path string) (Compensation, error) {
+	var c Compensation
+	data, err := store.Load(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	return c, c.check()
+}
+
+// SaveCompensation saves a compensation as JSON, to the file at path (see
+// SetStore)
+func SaveCompensation(path string, c Compensation) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Save(path, data)
+}
@@ -0,0 +1,238 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Config holds the settings applied by Init
+type Config struct {
+	// PackedMode enables the I80 command parameter packed mode (I80CPCR).
+	// When disabled, load image parameters are written to the memory
+	// converter registers before sending the command instead of following it,
+	// which some clone boards need at high SPI clocks.
+	PackedMode bool
+	// WakeSettle is the delay left to the controller after SystemRun before
+	// checking it is ready again
+	WakeSettle time.Duration
+	// WakeTimeout is how long Wake polls the controller before giving up
+	WakeTimeout time.Duration
+	// ReadyTimeout is how long a transfer waits for the controller ready
+	// line before failing with ErrNotReady (forever when 0)
+	ReadyTimeout time.Duration
+	// ReadyWait is how transfers wait for the ready line: polling it, or
+	// sleeping until it rises for transports supporting it, which saves CPU
+	// during long transfers and refreshes
+	ReadyWait ReadyWait
+	// Audit checks the transfers against the host interface protocol (see
+	// WithAudit)
+	Audit AuditMode
+	// ReadyInterval is the time between two reads of the ready line when
+	// polling it. When 0, it is tuned at Init by timing the waits for the
+	// line, which also picks ReadyEdge or ReadyPoll with ReadyAuto (see
+	// Stats).
+	ReadyInterval time.Duration
+	// DisplayTimeout is how long WaitForDisplayReady waits for a refresh
+	// to end before failing with ErrTimeout (forever when 0)
+	DisplayTimeout time.Duration
+	// DrivingStrength is the driving capability set at Init
+	DrivingStrength DrivingStrength
+	// VCOMTolerance is how far, in mV, the VCOM of the controller may be
+	// from the requested one for Init and SetPanelVCOM to leave it as is
+	VCOMTolerance uint16
+	// VerifyRetries enables verified uploads when positive: image areas are
+	// written with memory bursts, read back and checked, each failed chunk
+	// being retried up to VerifyRetries times, with the Retry backoff (see
+	// WriteAreaVerified)
+	VerifyRetries int
+	// ChunkSize is the number of bytes sent per SPI transfer in bulk writes,
+	// up to the 2048 bytes of the controller FIFO, which is used when 0.
+	// With ChunkAuto, it is tuned by the first Init of the process by
+	// measuring the throughput of several sizes in a spare frame of the
+	// controller memory, and reused by later Inits (see Stats).
+	ChunkSize int
+	// MemorySize is the size of the controller SDRAM in bytes, which limits
+	// the frames kept after the image buffer (see FrameCapacity). When 0, it
+	// is probed at Init.
+	MemorySize int
+	// TileWords is the largest number of words loaded in one image load
+	// transaction: larger areas are loaded in horizontal bands, each with
+	// its own load start and end, leaving the controller a chance to catch
+	// up between them. Areas are loaded at once when 0.
+	TileWords int
+	// PanelModel is the name of the panel model (see PanelModel), recognized
+	// from the panel resolution when empty
+	PanelModel string
+	// Background is the gray level used for pixels added around images, when
+	// areas are aligned or extend past the image bounds (white by default)
+	Background uint8
+	// HighContrast starts the device in high contrast mode (see
+	// SetHighContrast)
+	HighContrast bool
+	// SafeMode makes Init display an error screen when it fails while the
+	// controller answers (see WithSafeMode)
+	SafeMode bool
+	// Retry is the policy applied to checked register writes and verified
+	// upload chunks
+	Retry RetryPolicy
+	// Transport talks to the controller (go-rpio when nil). It is not saved
+	// by StageConfig.
+	Transport Transport `json:"-"`
+	// Ditherer converts images to the panel gray levels (the one set with
+	// SetDitherer when nil). It is not saved by StageConfig.
+	Ditherer Ditherer `json:"-"`
+	// ToneCurve maps gray levels before images are reduced to the panel
+	// levels (the one set with SetToneCurve when nil)
+	ToneCurve *ToneCurve
+	// Compensation corrects images for the aging of this panel, after the
+	// tone curve (see Compensation)
+	Compensation *Compensation
+	// Logger receives the driver messages (the one set with SetLogger when
+	// nil). It is not saved by StageConfig.
+	Logger *slog.Logger `json:"-"`
+}
+
+// Option modifies the configuration used by Init
+type Option func(*Config)
+
+var (
+	config = DefaultConfig()
+)
+
+// DefaultConfig returns the default settings
+func DefaultConfig() Config {
+	return Config{
+		PackedMode:     true,
+		WakeTimeout:    500 * time.Millisecond,
+		ReadyTimeout:   time.Second,
+		DisplayTimeout: 10 * time.Second,
+		TileWords:      64 * 1024,
+		Background:     0xff,
+		Retry:          DefaultRetryPolicy(),
+	}
+}
+
+// WithPackedMode enables or disables the I80 command parameter packed mode
+func WithPackedMode(packed bool) Option {
+	return func(c *Config) {
+		c.PackedMode = packed
+	}
+}
+
+// WithWake sets the settle delay and timeout used when waking the controller up
+func WithWake(settle, timeout time.Duration) Option {
+	return func(c *Config) {
+		c.WakeSettle = settle
+		c.WakeTimeout = timeout
+	}
+}
+
+// WithVerifiedUploads makes image uploads check every chunk written and retry
+// failed ones, for installations where the link to the panel is unreliable.
+// A retries value of 0 disables verification.
+func WithVerifiedUploads(retries int) Option {
+	return func(c *Config) {
+		c.VerifyRetries = retries
+	}
+}
+
+// WithTimeouts sets how long transfers wait for the controller to be ready
+// and how long WaitForDisplayReady waits for a refresh to end
+func WithTimeouts(ready, display time.Duration) Option {
+	return func(c *Config) {
+		c.ReadyTimeout = ready
+		c.DisplayTimeout = display
+	}
+}
+
+// WithReadyWait sets how transfers wait for the controller ready line
+func WithReadyWait(wait ReadyWait) Option {
+	return func(c *Config) {
+		c.ReadyWait = wait
+	}
+}
+
+// WithReadyInterval sets the time between two reads of the ready line when
+// polling it instead of tuning it at Init
+func WithReadyInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		c.ReadyInterval = interval
+	}
+}
+
+// WithMemorySize sets the size of the controller SDRAM in bytes instead of
+// probing it at Init
+func WithMemorySize(size int) Option {
+	return func(c *Config) {
+		c.MemorySize = size
+	}
+}
+
+// WithChunkSize sets the bulk SPI transfer size, or ChunkAuto to tune it at
+// Init
+func WithChunkSize(size int) Option {
+	return func(c *Config) {
+		c.ChunkSize = size
+	}
+}
+
+// WithTileSize sets the largest number of words loaded in one image load
+// transaction, 0 loading areas at once
+func WithTileSize(words int) Option {
+	return func(c *Config) {
+		c.TileWords = words
+	}
+}
+
+// WithVCOMTolerance sets how far, in mV, the VCOM of the controller may be
+// from the requested one before Init writes it, e.g. to keep the value
+// tuned for the panel in flash when it is close enough
+func WithVCOMTolerance(millivolts uint16) Option {
+	return func(c *Config) {
+		c.VCOMTolerance = millivolts
+	}
+}
+
+// WithBackground sets the gray level of the pixels added around images, e.g.
+// black on dark themed screens so that alignment does not leave white fringes
+func WithBackground(gray uint8) Option {
+	return func(c *Config) {
+		c.Background = gray
+	}
+}
+
+// CurrentConfig returns the settings in use
+func CurrentConfig() Config {
+	return config
+}
+
+// packedModeValue returns the I80CPCR value for the packed mode setting
+func packedModeValue() uint16 {
+	if config.PackedMode {
+		return 0x0001
+	}
+	return 0x0000
+}
+
+// applyPackedMode writes the packed mode setting to I80CPCR
+func applyPackedMode() {
+	WriteRegister(I80CPCR, packedModeValue())
+}
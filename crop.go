@@ -0,0 +1,127 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"image/color"
+)
+
+// ContentBounds returns the bounds of the content of img, inside the uniform
+// margins around it (e.g. the white page margins of a rendered PDF or the
+// scanner bed around a scan). The margin gray is the one of most corners,
+// pixels within tolerance of it counting as margin. Rows and columns with
+// fewer than 1 pixel in 200 off the margin gray are taken for noise (dust,
+// scan specks). The whole bounds are returned when there is no margin to
+// trim, and empty bounds for uniform images.
+func ContentBounds(img image.Image, tolerance uint8) image.Rectangle {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return bounds
+	}
+	gray := toGray(img, bounds)
+	margin := marginGray(gray)
+	differs := func(x, y int) bool {
+		value := int(gray.Pix[gray.PixOffset(x, y)])
+		return value > margin+int(tolerance) || value < margin-int(tolerance)
+	}
+	rows := make([]int, bounds.Dy())
+	cols := make([]int, bounds.Dx())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if differs(x, y) {
+				rows[y-bounds.Min.Y]++
+				cols[x-bounds.Min.X]++
+			}
+		}
+	}
+	first, last := contentSpan(rows, max(1, bounds.Dx()/200))
+	if last < first {
+		return image.Rectangle{}
+	}
+	left, right := contentSpan(cols, max(1, bounds.Dy()/200))
+	return image.Rect(left, first, right+1, last+1).Add(bounds.Min)
+}
+
+// marginGray returns the gray of most corners of gray, or of the top left one
+func marginGray(gray *image.Gray) int {
+	r := gray.Rect
+	corners := []uint8{
+		gray.GrayAt(r.Min.X, r.Min.Y).Y,
+		gray.GrayAt(r.Max.X-1, r.Min.Y).Y,
+		gray.GrayAt(r.Min.X, r.Max.Y-1).Y,
+		gray.GrayAt(r.Max.X-1, r.Max.Y-1).Y,
+	}
+	best, votes := corners[0], 0
+	for _, corner := range corners {
+		count := 0
+		for _, other := range corners {
+			if other == corner {
+				count++
+			}
+		}
+		if count > votes {
+			best, votes = corner, count
+		}
+	}
+	return int(best)
+}
+
+// contentSpan returns the first and last index of counts at or above
+// minimum, last being before first when there are none
+func contentSpan(counts []int, minimum int) (first, last int) {
+	first, last = len(counts), -1
+	for i, count := range counts {
+		if count >= minimum {
+			first, last = min(first, i), max(last, i)
+		}
+	}
+	return first, last
+}
+
+// AutoCrop returns img trimmed to its content (see ContentBounds), keeping a
+// border of pad pixels around it where the image has them. Uniform images are
+// returned as is.
+func AutoCrop(img image.Image, tolerance uint8, pad int) image.Image {
+	content := ContentBounds(img, tolerance)
+	if content.Empty() {
+		return img
+	}
+	content = content.Inset(-pad).Intersect(img.Bounds())
+	return croppedImage{Image: img, bounds: content}
+}
+
+// croppedImage is an image seen through smaller bounds
+type croppedImage struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+// Bounds returns the cropped bounds
+func (c croppedImage) Bounds() image.Rectangle {
+	return c.bounds
+}
+
+// At returns the pixels of the image within the bounds, transparent outside
+func (c croppedImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(c.bounds) {
+		return color.Transparent
+	}
+	return c.Image.At(x, y)
+}
@@ -0,0 +1,109 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"time"
+)
+
+// transferRate is a conservative estimate of the upload speed, in words per second
+const transferRate = 250000
+
+// refreshCandidate is a mode/bpp combination DisplayWithin can pick, best quality first.
+// Modes are referenced by pointer since A2Mode is only known after Init.
+type refreshCandidate struct {
+	mode *DisplayMode
+	bpp  int
+}
+
+var refreshCandidates = []refreshCandidate{
+	{&GC16Mode, 4},
+	{&GC16Mode, 2},
+	{&DUMode, 1},
+	{&A2Mode, 1},
+}
+
+// typicalRefresh is the time each waveform takes on a Waveshare HAT with an
+// M841 LUT (as simulated by sim.Typical)
+var typicalRefresh = map[Waveform]time.Duration{
+	WaveformINIT:  2000 * time.Millisecond,
+	WaveformDU:    260 * time.Millisecond,
+	WaveformGC16:  450 * time.Millisecond,
+	WaveformGL16:  450 * time.Millisecond,
+	WaveformGLR16: 450 * time.Millisecond,
+	WaveformGLD16: 450 * time.Millisecond,
+	WaveformA2:    120 * time.Millisecond,
+	WaveformDU4:   290 * time.Millisecond,
+}
+
+// waveformDuration returns the typical time the panel takes to run a mode's
+// waveform, that of GC16 for modes missing from the LUT
+func waveformDuration(mode DisplayMode) time.Duration {
+	if w, ok := modeWaveforms[mode]; ok {
+		return typicalRefresh[w]
+	}
+	return typicalRefresh[WaveformGC16]
+}
+
+// estimateRefresh estimates the time to upload and display an area at the given bpp
+func estimateRefresh(mode DisplayMode, area image.Rectangle, bpp int) time.Duration {
+	words := GetWidthInWords(area.Dx(), bpp) * area.Dy()
+	upload := time.Duration(words) * time.Second / transferRate
+	return upload + EstimateRefreshDuration(mode, area)
+}
+
+// DisplayWithin displays a region of img (at the same logical position on the
+// panel) using the best quality mode and bpp expected to complete within d,
+// after the refresh in progress (see RemainingRefresh): GC16 at 4 then 2bpp,
+// DU then A2 at 1bpp. If no combination fits, nothing is displayed and
+// ErrDeadline is returned.
+func DisplayWithin(d time.Duration, img image.Image, region image.Rectangle) (DisplayMode, error) {
+	Debug("Display %v within %v", region, d)
+	devInfo := DeviceInfo()
+	bounds := devInfo.Bounds()
+	logical := orientation.LogicalBounds(bounds)
+	img, region = shiftContent(img, region, logical)
+	region = orientation.ToPanelRect(region.Intersect(logical), bounds)
+	if region.Empty() {
+		return GC16Mode, nil
+	}
+	targetAddress := devInfo.TargetAddress()
+	available := d - RemainingRefresh()
+	for _, candidate := range refreshCandidates {
+		mode := *candidate.mode
+		area := alignRect(region, candidate.bpp, bounds)
+		if estimate := estimateRefresh(mode, area, candidate.bpp); estimate > available {
+			Debug("Mode %d at %dbpp needs %v", mode, candidate.bpp, estimate)
+			continue
+		}
+		buffer := convertImage(img, area, bounds, candidate.bpp)
+		var err error
+		switch candidate.bpp {
+		case 1:
+			err = Refresh1bppRect(buffer, area, mode, targetAddress, true, Rotate0)
+		case 2:
+			err = Refresh2bppRect(buffer, area, false, targetAddress, true, Rotate0)
+		default:
+			err = Refresh4bppRect(buffer, area, false, targetAddress, true, Rotate0)
+		}
+		return mode, err
+	}
+	return GC16Mode, ErrDeadline
+}
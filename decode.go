@@ -0,0 +1,175 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"sync"
+)
+
+// Decoder decodes an image format that the standard library does not support,
+// e.g. WebP or HEIF through an external library
+type Decoder interface {
+	// Match reports whether data, the first bytes of a file, are in this format
+	Match(header []byte) bool
+	// Decode decodes an image
+	Decode(r io.Reader) (image.Image, error)
+}
+
+// headerSize is the number of bytes given to Decoder.Match
+const headerSize = 64
+
+// namedDecoder is a registered decoder
+type namedDecoder struct {
+	name    string
+	decoder Decoder
+}
+
+var (
+	decodersMutex sync.RWMutex
+	decoders      []namedDecoder
+)
+
+// RegisterDecoder adds a decoder used by DecodeImage and DecodeFile. Decoders
+// are tried in registration order, before the formats registered with the
+// image package (GIF, JPEG and PNG are always available).
+func RegisterDecoder(name string, decoder Decoder) {
+	decodersMutex.Lock()
+	defer decodersMutex.Unlock()
+	decoders = append(decoders, namedDecoder{name: name, decoder: decoder})
+}
+
+// DecodeLimits bounds what DecodeImage accepts, so that an oversized or
+// malicious file cannot exhaust the memory of the board. Zero values disable
+// a limit.
+type DecodeLimits struct {
+	MaxBytes  int64 // size of the encoded image
+	MaxPixels int   // width × height of the decoded image
+}
+
+var (
+	decodeLimits DecodeLimits
+)
+
+// SetDecodeLimits sets the limits applied by DecodeImage and DecodeFile
+func SetDecodeLimits(limits DecodeLimits) {
+	decodeLimits = limits
+}
+
+// limitedReader fails with ErrTooLarge once more than left bytes are read
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// checkPixels returns ErrTooLarge when an image size is over the limit
+func checkPixels(width, height int) error {
+	if decodeLimits.MaxPixels > 0 && width*height > decodeLimits.MaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels", ErrTooLarge, width, height)
+	}
+	return nil
+}
+
+// DecodeImage decodes an image with the registered decoders or the image
+// package, returning the format name. Images over the decode limits are
+// rejected with ErrTooLarge; for the image package formats, this happens
+// before decoding the pixels.
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	limited := &limitedReader{r: r, left: decodeLimits.MaxBytes}
+	if decodeLimits.MaxBytes > 0 {
+		r = limited
+	}
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(headerSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, "", err
+	}
+	decodersMutex.RLock()
+	for _, d := range decoders {
+		if d.decoder.Match(header) {
+			decodersMutex.RUnlock()
+			Debug("Decoding %s image", d.name)
+			img, err := d.decoder.Decode(reader)
+			if err == nil && limited.left < 0 {
+				err = ErrTooLarge
+			}
+			if err == nil {
+				err = checkPixels(img.Bounds().Dx(), img.Bounds().Dy())
+			}
+			if err != nil {
+				return nil, d.name, err
+			}
+			return img, d.name, nil
+		}
+	}
+	decodersMutex.RUnlock()
+	if decodeLimits.MaxPixels == 0 {
+		img, format, err := image.Decode(reader)
+		if err == nil && limited.left < 0 {
+			return nil, format, ErrTooLarge
+		}
+		return img, format, err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", err
+	}
+	imageConfig, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, format, err
+	}
+	if err := checkPixels(imageConfig.Width, imageConfig.Height); err != nil {
+		return nil, format, err
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// DecodeFile decodes an image file (see DecodeImage)
+func DecodeFile(path string) (image.Image, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	return DecodeImage(file)
+}
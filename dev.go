@@ -1,8 +1,7 @@
 package it8951
 
 import (
-	"github.com/peergum/go-rpio/v5"
-	"log"
+	"fmt"
 	"time"
 )
 
@@ -15,47 +14,35 @@ const (
 )
 
 var (
-	rstPin   rpio.Pin
-	csPin    rpio.Pin
-	readyPin rpio.Pin
+	bus    Transport = RPIO(DefaultRPIOConfig()) // transport in use
+	busErr error                                 // sticky transfer error, see Err
 )
 
-// Open sets the I/O ports and SPI
+// Err returns ErrNotReady if the controller stopped answering since the last
+// Reset. Transfers are then skipped and reads return 0, so that a sequence of
+// commands can be checked once at the end.
+func Err() error {
+	return busErr
+}
+
+// Open sets the I/O ports and SPI, using the configured transport (go-rpio
+// on a Raspberry Pi by default)
 func Open() (err error) {
 	Debug("Init start")
 
-	if err := rpio.Open(); err != nil {
-		log.Fatalln("RPIO Open Error:", err)
+	busErr = nil
+	bus = config.Transport
+	if bus == nil {
+		wiring := DefaultRPIOConfig()
+		if model, _ := namedPanelModel(); model != nil && model.SPISpeed > 0 {
+			wiring.Speed = model.SPISpeed
+		}
+		bus = RPIO(wiring)
 	}
-
-	//
-	// init SPI
-	//
-
-	Debug("Initializing SPI")
-
-	if err := rpio.SpiBegin(rpio.Spi0); err != nil {
-		log.Fatalln("SpiBegin Error:", err)
+	bus = auditing(bus, config.Audit)
+	if err := bus.Open(); err != nil {
+		return fmt.Errorf("it8951: cannot open transport: %w", err)
 	}
-
-	rpio.SpiChipSelect(0)
-	rpio.SpiSpeed(24000000) // 24MHz
-	rpio.SpiMode(0, 0)
-
-	//
-	// init pins
-	//
-
-	Debug("Initializing GPIO pins")
-
-	rstPin = rpio.Pin(EpdRstPin)
-	csPin = rpio.Pin(EpdCsPin)
-	readyPin = rpio.Pin(EpdBusyPin)
-
-	rstPin.Output()
-	csPin.Output()
-	readyPin.Input()
-
 	csOff()
 
 	Debug("EPD initialization complete")
@@ -65,33 +52,32 @@ func Open() (err error) {
 // Close ends SPI usage and restores pins
 func Close() {
 	Debug("Shutting down EPD")
-	csPin.Low()
-	rstPin.Low()
-
-	rpio.SpiEnd(rpio.Spi0)
-
-	rpio.Close()
+	bus.Close()
 }
 
 // csOn selects slave
 func csOn() {
 	//Debug("CS On")
-	csPin.Low()
+	bus.Select(true)
+	traceEvent("cs", 0)
 }
 
 // csOff deselects slave
 func csOff() {
 	//Debug("CS Off")
-	csPin.High()
+	bus.Select(false)
+	traceEvent("cs", 1)
 }
 
 // Reset resets a slave
 func Reset() {
 	Debug("EPD Reset")
-	rstPin.High()
+	invalidateDevInfo()
+	busErr = nil
+	bus.Reset(false)
 	time.Sleep(time.Duration(200) * time.Millisecond)
-	rstPin.Low()
+	bus.Reset(true)
 	time.Sleep(time.Duration(10) * time.Millisecond)
-	rstPin.High()
+	bus.Reset(false)
 	time.Sleep(time.Duration(200) * time.Millisecond)
 }
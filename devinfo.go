@@ -0,0 +1,89 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "encoding/json"
+
+var (
+	cachedDevInfo *DevInfo
+)
+
+// DeviceInfo returns the controller system info, querying it only the first
+// time or after a reset. Use GetSystemInfo to always query the controller.
+func DeviceInfo() *DevInfo {
+	if cachedDevInfo == nil {
+		return RefreshDevInfo()
+	}
+	return cachedDevInfo
+}
+
+// RefreshDevInfo queries the controller system info again and caches it
+func RefreshDevInfo() *DevInfo {
+	cachedDevInfo = GetSystemInfo()
+	return cachedDevInfo
+}
+
+// invalidateDevInfo drops the cached system info, e.g. when resetting the controller
+func invalidateDevInfo() {
+	cachedDevInfo = nil
+}
+
+// devInfoJSON is the JSON form of DevInfo
+type devInfoJSON struct {
+	Width         uint16   `json:"width"`
+	Height        uint16   `json:"height"`
+	MemoryAddress uint32   `json:"memory_address"`
+	Firmware      string   `json:"firmware"`
+	Version       [3]int   `json:"firmware_version"` // major, minor, patch
+	LUT           string   `json:"lut"`
+	LUTFamily     string   `json:"lut_family"`
+	LUTVariant    string   `json:"lut_variant"`
+	ColorPanel    bool     `json:"color_panel"`
+	Quirks        []string `json:"quirks"`
+	PanelModel    string   `json:"panel_model,omitempty"` // see PanelModel
+}
+
+// MarshalJSON encodes the system info with its version strings parsed (see
+// Firmware) and the panel model, when known, e.g. for inventory tools
+func (devInfo DevInfo) MarshalJSON() ([]byte, error) {
+	fw := devInfo.Firmware()
+	info := devInfoJSON{
+		Width:         devInfo.PanelW,
+		Height:        devInfo.PanelH,
+		MemoryAddress: devInfo.TargetAddress(),
+		Firmware:      fw.Version,
+		Version:       [3]int{fw.Major, fw.Minor, fw.Patch},
+		LUT:           fw.LUT,
+		LUTFamily:     fw.LUTFamily,
+		LUTVariant:    fw.LUTVariant,
+		ColorPanel:    fw.ColorPanel,
+		Quirks:        fw.Quirks,
+	}
+	if info.Quirks == nil {
+		info.Quirks = []string{}
+	}
+	model, ok := CurrentPanelModel()
+	if !ok || model.Size != devInfo.Bounds().Size() {
+		model, ok = DetectPanelModel(&devInfo)
+	}
+	if ok {
+		info.PanelModel = model.Name
+	}
+	return json.Marshal(info)
+}
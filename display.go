@@ -0,0 +1,152 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// DrawImage converts img (any image.Image: Gray, RGBA, Paletted...) to bpp
+// gray levels (1, 2, 4 or 8) and displays it with mode, its top left corner
+// at x, y in logical coordinates, once rotated clockwise by rotation (on the
+// host, width and height being swapped by 90 and 270 rotations). Packing,
+// word alignment and orientation are handled here; parts of img off the
+// panel are clipped.
+func DrawImage(img image.Image, x, y uint16, bpp int, mode DisplayMode, rotation Rotate) error {
+	switch bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("it8951: unsupported bpp %d", bpp)
+	}
+	if rotation != Rotate0 {
+		img = rotateGray(toGray(img, img.Bounds()), rotation)
+	}
+	moved := shiftedImage{Image: img, offset: image.Pt(int(x), int(y)).Sub(img.Bounds().Min)}
+	if err := displayImage(moved, moved.Bounds(), bpp, mode); err != nil {
+		return err
+	}
+	return Err()
+}
+
+// DrawImageCtx is DrawImage, giving up when ctx is done: the image is
+// uploaded in bands, ctx being checked before each of them and while waiting
+// for the previous refresh. Nothing is displayed once ctx is done.
+func DrawImageCtx(ctx context.Context, img image.Image, x, y uint16, bpp int, mode DisplayMode, rotation Rotate) error {
+	switch bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("it8951: unsupported bpp %d", bpp)
+	}
+	if rotation != Rotate0 {
+		img = rotateGray(toGray(img, img.Bounds()), rotation)
+	}
+	moved := shiftedImage{Image: img, offset: image.Pt(int(x), int(y)).Sub(img.Bounds().Min)}
+	if err := displayImageCtx(ctx, moved, moved.Bounds(), bpp, mode); err != nil {
+		return err
+	}
+	return Err()
+}
+
+// displayImage loads a region of img (at the same logical position on the
+// panel) at the given bpp (1, 2, 4 or 8) and displays it with mode. Nothing
+// is loaded if the refresh in progress does not end within DisplayTimeout.
+func displayImage(img image.Image, region image.Rectangle, bpp int, mode DisplayMode) error {
+	return displayImageCtx(context.Background(), img, region, bpp, mode)
+}
+
+// displayImageCtx is displayImage, returning the context error as soon as
+// ctx is done
+func displayImageCtx(ctx context.Context, img image.Image, region image.Rectangle, bpp int, mode DisplayMode) error {
+	devInfo := DeviceInfo()
+	bounds := devInfo.Bounds()
+	logical := orientation.LogicalBounds(bounds)
+	img, region = shiftContent(img, region, logical)
+	region = orientation.ToPanelRect(region.Intersect(logical), bounds)
+	if region.Empty() {
+		return nil
+	}
+	area := alignRect(region, bpp, bounds)
+	buffer := convertImage(img, area, bounds, bpp)
+
+	targetAddress := devInfo.TargetAddress()
+	if bpp == 1 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return Refresh1bppRect(buffer, area, mode, targetAddress, true, Rotate0)
+	}
+	if err := WaitForDisplayReadyCtx(ctx); err != nil {
+		return err
+	}
+	if err := loadBufferCtx(ctx, buffer, area, bpp, targetAddress); err != nil {
+		return err
+	}
+	return DisplayRectBuffer(area, mode, targetAddress)
+}
+
+// loadImage loads a region of img (at the same logical position on the
+// panel) at the given bpp (2, 4 or 8) into the image buffer at
+// targetAddress, without displaying it. It returns the panel area loaded,
+// empty when the region is off the panel.
+func loadImage(img image.Image, region image.Rectangle, bpp int, targetAddress uint32) image.Rectangle {
+	bounds := DeviceInfo().Bounds()
+	logical := orientation.LogicalBounds(bounds)
+	img, region = shiftContent(img, region, logical)
+	region = orientation.ToPanelRect(region.Intersect(logical), bounds)
+	if region.Empty() {
+		return image.Rectangle{}
+	}
+	area := alignRect(region, bpp, bounds)
+	loadBuffer(convertImage(img, area, bounds, bpp), area, bpp, targetAddress)
+	return area
+}
+
+// loadBuffer loads a packed buffer covering a panel area into the image
+// buffer at targetAddress
+func loadBuffer(buffer DataBuffer, area image.Rectangle, bpp int, targetAddress uint32) {
+	imageInfo := LoadImgInfo{
+		SourceBufferAddr: buffer,
+		EndianType:       LoadImgLittleEndian,
+		PixelFormat:      Bpp(bpp),
+		Rotate:           Rotate0,
+		TargetMemAddr:    targetAddress,
+	}
+	imageInfo.HostAreaPackedPixelWrite(AreaFromRect(area), bpp, true)
+}
+
+// bandWords is the size of the bands loadBufferCtx uploads, in words
+const bandWords = 32 * 1024
+
+// loadBufferCtx is loadBuffer, in bands of rows of about bandWords words,
+// returning the context error before the next band once ctx is done
+func loadBufferCtx(ctx context.Context, buffer DataBuffer, area image.Rectangle, bpp int, targetAddress uint32) error {
+	stride := GetWidthInWords(area.Dx(), bpp)
+	rows := max(1, bandWords/stride)
+	for y := area.Min.Y; y < area.Max.Y; y += rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		band := image.Rect(area.Min.X, y, area.Max.X, min(y+rows, area.Max.Y))
+		start := (y - area.Min.Y) * stride
+		loadBuffer(buffer[start:start+band.Dy()*stride], band, bpp, targetAddress)
+	}
+	return nil
+}
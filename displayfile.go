@@ -0,0 +1,137 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// FitMode is how an image is scaled to the panel
+type FitMode uint8
+
+// Fit modes
+const (
+	// FitInside scales the image to the largest size showing all of it,
+	// leaving background bands on two sides when the aspect ratios differ
+	FitInside FitMode = iota
+	// FitFill scales the image to the smallest size covering the panel,
+	// cropping two sides when the aspect ratios differ
+	FitFill
+	// FitCenter shows the image at its size, cropped when larger than the panel
+	FitCenter
+)
+
+// FileOptions are the settings of DisplayFile. The zero value fits the image
+// to the panel, at 4bpp with the current ditherer, displayed with GC16.
+type FileOptions struct {
+	Fit      FitMode
+	Bpp      int         // 1, 2, 4 or 8 (4, or the profile one, by default)
+	Ditherer Ditherer    // current ditherer, or the profile one, when nil (see SetDitherer)
+	Mode     DisplayMode // GC16 when 0
+	// Profile, when set, prepares the image for its kind of content (see
+	// SourceProfile)
+	Profile *SourceProfile
+	// Crop trims the uniform margins of the image before fitting it, e.g. to
+	// show the text of documents as large as possible (see AutoCrop). The
+	// profile may ask for it too.
+	Crop bool
+}
+
+// DisplayFile decodes an image file (see DecodeFile), scales it to the safe
+// area of the panel (see SafeArea) and displays it centered, the rest of the
+// area being filled with the background level (see WithBackground)
+func DisplayFile(path string, opts FileOptions) error {
+	img, _, err := DecodeFile(path)
+	if err != nil {
+		return err
+	}
+	bpp := opts.Bpp
+	if bpp == 0 {
+		bpp = 4
+		if opts.Profile != nil {
+			bpp = opts.Profile.bpp()
+		}
+	}
+	if opts.Ditherer == nil && opts.Profile != nil {
+		opts.Ditherer = opts.Profile.Ditherer
+	}
+	switch bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("it8951: unsupported bpp %d", bpp)
+	}
+	mode := opts.Mode
+	if mode == 0 {
+		mode = GC16Mode
+	}
+	if opts.Crop || opts.Profile != nil && opts.Profile.Crop {
+		img = cropDocument(img)
+	}
+	frame := FitImage(img, SafeArea(), opts.Fit)
+	if opts.Profile != nil {
+		frame = opts.Profile.Prepare(frame, frame.Rect)
+	}
+	if opts.Ditherer != nil {
+		ditherer := config.Ditherer
+		config.Ditherer = opts.Ditherer
+		defer func() { config.Ditherer = ditherer }()
+	}
+	return displayImage(frame, frame.Rect, bpp, mode)
+}
+
+// cropDocument trims the margins of a document image, tolerating scanner
+// noise, with a thin border left around the content
+func cropDocument(img image.Image) image.Image {
+	size := img.Bounds().Size()
+	return AutoCrop(img, 32, min(size.X, size.Y)/100)
+}
+
+// FitImage returns img scaled to within as fit says, centered on a
+// background filled gray image covering within
+func FitImage(img image.Image, within image.Rectangle, fit FitMode) *image.Gray {
+	frame := image.NewGray(within)
+	for i := range frame.Pix {
+		frame.Pix[i] = config.Background
+	}
+	src := img.Bounds()
+	if src.Empty() || within.Empty() {
+		return frame
+	}
+	size := src.Size()
+	if fit != FitCenter {
+		// compare the aspect ratios without rounding
+		wider := size.X*within.Dy() > size.Y*within.Dx()
+		if wider == (fit == FitInside) {
+			size = image.Pt(within.Dx(), max(1, size.Y*within.Dx()/size.X))
+		} else {
+			size = image.Pt(max(1, size.X*within.Dy()/size.Y), within.Dy())
+		}
+	}
+	at := within.Min.Add(within.Size().Sub(size).Div(2))
+	dst := image.Rectangle{Min: at, Max: at.Add(size)}
+	if fit == FitCenter {
+		draw.Draw(frame, dst, img, src.Min, draw.Over)
+	} else {
+		draw.CatmullRom.Scale(frame, dst, img, src, draw.Over, nil)
+	}
+	return frame
+}
@@ -0,0 +1,191 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// Ditherer reduces a grayscale image to a number of evenly spaced gray levels
+// (2 for 1bpp, 4 for 2bpp, 16 for 4bpp...). The returned image must only hold
+// level values, i.e. multiples of 255/(levels-1).
+type Ditherer interface {
+	Apply(src *image.Gray, levels int) *image.Gray
+}
+
+// DithererFunc adapts a function to the Ditherer interface
+type DithererFunc func(src *image.Gray, levels int) *image.Gray
+
+// Apply calls f(src, levels)
+func (f DithererFunc) Apply(src *image.Gray, levels int) *image.Gray {
+	return f(src, levels)
+}
+
+// Built-in ditherers
+var (
+	// Quantize maps every pixel to the nearest level, without dithering
+	Quantize Ditherer = DithererFunc(quantize)
+	// FloydSteinberg diffuses the quantization error to the neighbouring pixels
+	FloydSteinberg Ditherer = errorDiffusion{
+		divisor: 16,
+		kernel: []diffusionWeight{
+			{1, 0, 7},
+			{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+		},
+	}
+	// Atkinson diffuses three quarters of the error over a wider area,
+	// keeping more contrast than FloydSteinberg (the classic Mac look)
+	Atkinson Ditherer = errorDiffusion{
+		divisor: 8,
+		kernel: []diffusionWeight{
+			{1, 0, 1}, {2, 0, 1},
+			{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+			{0, 2, 1},
+		},
+	}
+	// Bayer is an ordered ditherer using an 8x8 Bayer matrix. It gives a
+	// regular pattern which, unlike error diffusion, does not change with
+	// the neighbouring pixels: partial updates blend with the rest.
+	Bayer Ditherer = orderedDither(bayerMatrix(8))
+)
+
+var (
+	ditherer = Quantize
+)
+
+// SetDitherer selects the ditherer used when converting images for the panel
+// (Quantize by default)
+func SetDitherer(d Ditherer) {
+	if d == nil {
+		d = Quantize
+	}
+	ditherer = d
+}
+
+// WithDitherer selects the ditherer used when converting images for the
+// panel (see SetDitherer)
+func WithDitherer(d Ditherer) Option {
+	return func(c *Config) {
+		c.Ditherer = d
+	}
+}
+
+// currentDitherer returns the configured ditherer, or the one set with
+// SetDitherer
+func currentDitherer() Ditherer {
+	if config.Ditherer != nil {
+		return config.Ditherer
+	}
+	return ditherer
+}
+
+// quantizeLevel returns the level value nearest to value
+func quantizeLevel(value int, levels int) uint8 {
+	if value <= 0 {
+		return 0
+	}
+	if value >= 255 {
+		return 255
+	}
+	level := (value*(levels-1) + 127) / 255
+	return uint8(level * 255 / (levels - 1))
+}
+
+func quantize(src *image.Gray, levels int) *image.Gray {
+	dst := image.NewGray(src.Bounds())
+	for i, value := range src.Pix {
+		dst.Pix[i] = quantizeLevel(int(value), levels)
+	}
+	return dst
+}
+
+// diffusionWeight is the share of the error sent to the pixel at (dx, dy)
+type diffusionWeight struct {
+	dx, dy int
+	weight int
+}
+
+// errorDiffusion is a ditherer spreading the quantization error with a kernel
+type errorDiffusion struct {
+	divisor int
+	kernel  []diffusionWeight
+}
+
+func (e errorDiffusion) Apply(src *image.Gray, levels int) *image.Gray {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	values := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x, value := range src.Pix[y*src.Stride : y*src.Stride+w] {
+			values[y*w+x] = int(value)
+		}
+	}
+	dst := image.NewGray(bounds)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			value := values[y*w+x]
+			level := quantizeLevel(value, levels)
+			dst.Pix[y*dst.Stride+x] = level
+			diff := value - int(level)
+			for _, k := range e.kernel {
+				nx, ny := x+k.dx, y+k.dy
+				if nx < 0 || nx >= w || ny >= h {
+					continue
+				}
+				values[ny*w+nx] += diff * k.weight / e.divisor
+			}
+		}
+	}
+	return dst
+}
+
+// bayerMatrix returns the n x n Bayer threshold matrix (n a power of 2),
+// holding every value from 0 to n*n-1
+func bayerMatrix(n int) [][]int {
+	matrix := [][]int{{0}}
+	for size := 1; size < n; size *= 2 {
+		next := make([][]int, 2*size)
+		for y := range next {
+			next[y] = make([]int, 2*size)
+			for x := range next[y] {
+				quadrant := [2][2]int{{0, 2}, {3, 1}}[y/size][x/size]
+				next[y][x] = 4*matrix[y%size][x%size] + quadrant
+			}
+		}
+		matrix = next
+	}
+	return matrix
+}
+
+// orderedDither offsets each pixel by up to half a level step, following a
+// threshold matrix, before quantizing it
+func orderedDither(matrix [][]int) Ditherer {
+	n := len(matrix)
+	return DithererFunc(func(src *image.Gray, levels int) *image.Gray {
+		bounds := src.Bounds()
+		step := 255 / (levels - 1)
+		dst := image.NewGray(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := matrix[y&(n-1)]
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				offset := (2*row[x&(n-1)]+1)*step/(2*n*n) - step/2
+				dst.Pix[dst.PixOffset(x, y)] = quantizeLevel(int(src.Pix[src.PixOffset(x, y)])+offset, levels)
+			}
+		}
+		return dst
+	})
+}
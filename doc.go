@@ -0,0 +1,41 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package it8951 drives e-paper panels attached to an ITE IT8951 controller
+// over SPI (e.g. the Waveshare e-Paper HATs).
+//
+// Transfers wait for the controller ready line for at most ReadyTimeout. When
+// it stays low, the transfer fails with ErrNotReady and so do the next ones
+// until Reset; functions returning a value (ReadRegister, ReadVCOM...) then
+// return 0, and Err tells whether a sequence of commands went through.
+// Commands (DisplayRect, the RefreshNbppRect family, Sleep...) return that
+// error too, or ErrTimeout when the refresh in progress does not end within
+// DisplayTimeout, in which case nothing is loaded or displayed.
+//
+// Functions of the package can be called from several goroutines: each call
+// runs as a single transaction (command, parameters and data phase) and is not
+// interleaved with others. Sequences of calls which must run together, such as
+// LoadImageAreaStart, WriteBuffer and LoadImageEnd, or a load followed by its
+// refresh, go through Display.Do, or Display.Submit to queue them without
+// waiting.
+//
+// The package itself needs go-rpio, golang.org/x/image and golang.org/x/text
+// (text rendering and direction).
+// Optional features with heavier dependencies live in subpackages (svg). The
+// sim subpackage simulates a controller in memory, to run without hardware.
+package it8951
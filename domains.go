@@ -0,0 +1,110 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+)
+
+// Domain is a fixed region of the panel refreshed independently of the rest,
+// e.g. a photo pane in GC16 next to a ticker in A2. It has its own Submit
+// queue and quality policy, so that a busy pane degrades or catches up
+// without affecting the others, while all domains still share the
+// controller (see Display.Do). With a RefreshManager, Split gives it its own
+// ghosting accounting too:
+//
+//	photo, _ := display.AddDomain("photo", image.Rect(0, 0, 960, 1200))
+//	ticker, _ := display.AddDomain("ticker", image.Rect(960, 0, 1600, 1200))
+//	ticker.AdaptQuality(it8951.DefaultQualityPolicy())
+//	ghosts.Split(ticker.Region, 20, 10*time.Second)
+//	...
+//	ticker.Submit(func() error {
+//		return ticker.Present(quotes, it8951.A2Mode)
+//	})
+//
+// Region edges should be multiples of 32 pixels across rows, so that the
+// aligned loads of a domain do not spill over its neighbors.
+type Domain struct {
+	Name   string
+	Region image.Rectangle // logical coordinates
+
+	display *Display
+	queue   submitQueue
+	quality *QualityPolicy
+}
+
+// AddDomain declares a region of the panel, in logical coordinates, as a
+// separate refresh domain. Domains may not overlap.
+func (d *Display) AddDomain(name string, region image.Rectangle) (*Domain, error) {
+	if region.Empty() {
+		return nil, fmt.Errorf("it8951: empty domain %q", name)
+	}
+	for _, other := range d.domains {
+		if other.Name == name {
+			return nil, fmt.Errorf("it8951: domain %q already exists", name)
+		}
+		if other.Region.Overlaps(region) {
+			return nil, fmt.Errorf("it8951: domain %q overlaps %q", name, other.Name)
+		}
+	}
+	domain := &Domain{Name: name, Region: region, display: d}
+	d.domains = append(d.domains, domain)
+	return domain, nil
+}
+
+// Domain returns the domain with the given name, or nil
+func (d *Display) Domain(name string) *Domain {
+	for _, domain := range d.domains {
+		if domain.Name == name {
+			return domain
+		}
+	}
+	return nil
+}
+
+// Submit queues fn like Display.Submit, on the domain queue: functions
+// submitted to different domains do not wait for each other, except for the
+// controller access itself
+func (domain *Domain) Submit(fn func() error) <-chan error {
+	return domain.queue.submit(domain.display, fn)
+}
+
+// Backlog returns the number of functions submitted to the domain waiting to
+// run
+func (domain *Domain) Backlog() int {
+	return domain.queue.backlog()
+}
+
+// AdaptQuality makes Present follow policy for this domain, based on its own
+// backlog (see Display.AdaptQuality)
+func (domain *Domain) AdaptQuality(policy *QualityPolicy) {
+	domain.quality = policy
+}
+
+// Present shows the domain region of a frame through the display
+// middlewares, refreshing only what changed since the previous frame.
+// Workers call it from within Do or Submit.
+func (domain *Domain) Present(img image.Image, mode DisplayMode) error {
+	d := domain.display
+	present := PresenterFunc(func(img image.Image, region image.Rectangle, mode DisplayMode) error {
+		return d.presentWith(img, region.Intersect(domain.Region), mode, domain.quality, domain.Backlog())
+	})
+	return Chain(present, d.middlewares...).Present(img, domain.Region, mode)
+}
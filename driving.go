@@ -0,0 +1,56 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+// DrivingStrength is the output driving capability setting of the controller
+// (system register 0x0038). Values other than the named ones are written as-is.
+type DrivingStrength uint16
+
+// Driving strengths
+const (
+	DrivingDefault  DrivingStrength = 0      // setting found at Init
+	DrivingEnhanced DrivingStrength = 0x0602 // recommended by Waveshare for blurred displays or long FPC cables
+)
+
+var (
+	defaultDriving uint16 // register value found at Init
+)
+
+// SetDrivingStrength sets the driving capability of the controller
+func SetDrivingStrength(level DrivingStrength) {
+	value := drivingValue(level)
+	Debug("The reg value before writing is %x", ReadRegister(DRVCR))
+	WriteRegister(DRVCR, value)
+	Debug("The reg value after writing is %x", ReadRegister(DRVCR))
+}
+
+// drivingValue returns the register value for a driving strength
+func drivingValue(level DrivingStrength) uint16 {
+	if level == DrivingDefault {
+		return defaultDriving
+	}
+	return uint16(level)
+}
+
+// WithDrivingStrength sets the driving capability applied by Init
+func WithDrivingStrength(level DrivingStrength) Option {
+	return func(c *Config) {
+		c.DrivingStrength = level
+	}
+}
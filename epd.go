@@ -2,11 +2,10 @@ package it8951
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
-	"flag"
 	"fmt"
-	"github.com/peergum/go-rpio/v5"
-	"log"
+	"image"
 	"time"
 )
 
@@ -17,6 +16,7 @@ type PixelMode uint8
 type EndianType uint8
 type Address uint16
 type VCOMCommand uint16
+type TempCommand uint16
 type DataBuffer []uint16
 type DataWord uint16
 type DisplayMode uint16
@@ -68,6 +68,7 @@ const (
 	UserCmdGetDevInfo Command = 0x0302
 	UserCmdDpyBufArea Command = 0x0037
 	UserCmdVCOM       Command = 0x0039
+	UserCmdTemp       Command = 0x0040
 )
 
 // Preambles
@@ -93,11 +94,17 @@ const (
 	BPP8
 )
 
-// DisplayMode display mode
+// DisplayMode display mode, set by Init for the LUT of the panel (see
+// ModeTable). Waveforms the LUT lacks get the closest one available.
 var (
-	InitMode DisplayMode = 0 // INIT mode, for every init or some time after A2 mode refresh
-	GC16Mode DisplayMode = 2 // GC16 mode, for every time to display 16 grayscale image
-	A2Mode   DisplayMode = 4 // A2 mode, for fast refresh without flash (can be 6 for other displays)
+	InitMode  DisplayMode = 0 // INIT mode, for every init or some time after A2 mode refresh
+	DUMode    DisplayMode = 1 // DU mode, fast black and white update without flash
+	GC16Mode  DisplayMode = 2 // GC16 mode, for every time to display 16 grayscale image
+	GL16Mode  DisplayMode = 3 // GL16 mode, 16 gray levels for text on white, without flash
+	GLR16Mode DisplayMode = 4 // GLR16 mode, GL16 with ghosting reduction
+	GLD16Mode DisplayMode = 5 // GLD16 mode, GLR16 dithered
+	A2Mode    DisplayMode = 6 // A2 mode, for fast refresh without flash (4 on 6" panels)
+	DU4Mode   DisplayMode = 7 // DU4 mode, fast update to 4 gray levels
 )
 
 // Endian Type
@@ -112,6 +119,12 @@ const (
 	SetVCOM
 )
 
+// TempCommand get/set
+const (
+	GetTemp TempCommand = iota
+	SetTemp
+)
+
 // Register Address Map
 const (
 	// Register Base Address
@@ -143,47 +156,102 @@ const (
 	// Address of System Registers
 
 	I80CPCR = SysRegBase + 0x04
+	DRVCR   = SysRegBase + 0x38 // driving capability (see DrivingStrength)
 
 	// Memory Converter Registers
 
 	McsrBase Address = 0x0200
-	MCSR             = McsrBase + 0x0000
-	LISAR            = McsrBase + 0x0008
-)
-
-var (
-	epd bool
+	MCSR             = McsrBase + 0x0000 // memory converter setting (see MemoryConverter)
+	LISAR            = McsrBase + 0x0008 // load image start address (32 bit)
+	PRXSR            = McsrBase + 0x000C // load area X start (unpacked mode)
+	PRYSR            = McsrBase + 0x000E // load area Y start (unpacked mode)
+	PRWR             = McsrBase + 0x0010 // load area width (unpacked mode)
+	PRHR             = McsrBase + 0x0012 // load area height (unpacked mode)
 )
 
-func init() {
-	flag.BoolVar(&epd, "epd", false, "debug mode for EPD")
+// Init the EPD modules with desired VCOM value in mV, or the typical VCOM of
+// the panel model when 0 (see PanelModel), the current VCOM being kept when
+// the model is unknown. Values out of the VCOM range are refused before
+// anything is written (see VCOM). It fails with ErrNotReady when the
+// controller does not answer (e.g. HAT unplugged), peripherals being closed
+// again.
+func Init(vcom uint16, options ...Option) (*DevInfo, error) {
+	return InitCtx(context.Background(), vcom, options...)
 }
 
-func Debug(format string, args ...interface{}) {
-	if epd {
-		log.Printf("[EPD] "+format, args...)
+// InitCtx is Init, giving up when ctx is done: ctx is checked between the
+// initialization steps, peripherals being closed again before returning the
+// context error.
+func InitCtx(ctx context.Context, vcom uint16, options ...Option) (*DevInfo, error) {
+	devInfo, err := initCtx(ctx, vcom, options...)
+	if err != nil && config.SafeMode && ctx.Err() == nil {
+		showSafeMode(devInfo, err)
 	}
+	return devInfo, err
 }
 
-// Init the EPD modules with desired VCOM value
-func Init(vcom uint16) *DevInfo {
-	Open()
+// initCtx is InitCtx, without the safe mode screen
+func initCtx(ctx context.Context, vcom uint16, options ...Option) (*DevInfo, error) {
+	config = DefaultConfig()
+	for _, option := range options {
+		option(&config)
+	}
+	if vcom != 0 {
+		if err := VCOM(vcom).Validate(); err != nil {
+			return nil, err
+		}
+	}
+	model, err := namedPanelModel()
+	if err != nil {
+		return nil, err
+	}
+	if err := Open(); err != nil {
+		return nil, err
+	}
 	Reset()
+	if err := ctx.Err(); err != nil {
+		Close()
+		return nil, err
+	}
 	SystemRun()
-	devInfo := GetSystemInfo()
-	lut := wordsToString(devInfo.LUTVersion)
-	A2Mode = 6
-	// special case for 6" e-ink Paper
-	if lut == "M641" {
-		A2Mode = 4
-	}
-	WriteRegister(I80CPCR, 0x0001) // packed mode
+	devInfo := RefreshDevInfo()
+	err = Err()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		invalidateDevInfo()
+		Close()
+		return nil, err
+	}
+	applyPanelModel(model, devInfo)
+	if vcom == 0 && panelModel != nil {
+		vcom = panelModel.VCOM
+	}
+	applyModes(devInfo.Firmware())
+	applyPackedMode()
+	applyMemorySize(devInfo)
+	applyChunkSize(devInfo)
+	applyReadyInterval()
+	highContrast = config.HighContrast
+	if err := ctx.Err(); err != nil {
+		invalidateDevInfo()
+		Close()
+		return nil, err
+	}
+	defaultDriving = ReadRegister(DRVCR)
+	if config.DrivingStrength != DrivingDefault {
+		SetDrivingStrength(config.DrivingStrength)
+	}
 	waitReady()
-	if vcom != ReadVCOM() {
-		WriteVCOM(vcom)
-		Debug("VCOM = -%.02fV\n", float32(ReadVCOM())/1000)
+	if vcom == 0 {
+		vcomSetting = ReadVCOM()
+		return devInfo, Err()
 	}
-	return devInfo
+	if err := SetPanelVCOM(VCOM(vcom)); err != nil {
+		return devInfo, err
+	}
+	return devInfo, Err()
 }
 
 // Exit properly closes all peripherals used
@@ -191,110 +259,166 @@ func Exit() {
 	Close()
 }
 
-func waitReady() {
+// waitReady waits for the controller to take a transfer, for at most
+// ReadyTimeout. Once it timed out, it fails at once with ErrNotReady until the
+// next Reset (see Err).
+func waitReady() error {
 	//Debug("...")
-	for readyPin.Read() == rpio.Low {
-		time.Sleep(time.Duration(10) * time.Microsecond)
+	if busErr != nil {
+		return busErr
+	}
+	ready := bus.Ready()
+	if !ready {
+		start := time.Now()
+		waiter, ok := bus.(ReadyWaiter)
+		if ok && readyWait() == ReadyEdge {
+			ready = waiter.WaitReady(config.ReadyTimeout)
+		} else {
+			ready = pollReady(bus, config.ReadyTimeout)
+		}
+		if readyLatencies != nil {
+			readyLatencies = append(readyLatencies, time.Since(start))
+		}
+	} else if readyLatencies != nil {
+		readyLatencies = append(readyLatencies, 0)
+	}
+	if !ready {
+		Debug("Controller not ready after %v", config.ReadyTimeout)
+		busErr = ErrNotReady
+		return busErr
 	}
 	//Debug("SPI Ready")
+	return nil
 }
 
 func writeUint16(word uint16) {
 	//Debug("-> %04x", word)
-	rpio.SpiTransmit(byte(word >> 8))
-	rpio.SpiTransmit(byte(word & 0xff))
+	bus.Transmit(byte(word>>8), byte(word&0xff))
 }
 
 func readUint16() (word uint16) {
-	data := rpio.SpiReceive(2)
+	data := bus.Receive(2)
 	word = uint16(data[0])<<8 + uint16(data[1])
 	//Debug("<- %04x", word)
 	return
 }
 
 // WriteCommand writes a Command
-func WriteCommand(command Command) {
+func WriteCommand(command Command) error {
 	Debug("Writing command %04x", command)
-	waitReady()
+	if err := wakeFor(command); err != nil {
+		return err
+	}
+	if err := waitReady(); err != nil {
+		return err
+	}
 	csOn()
 	SendPreamble(CommandPreamble)
 	waitReady()
 	writeUint16(uint16(command))
+	traceEvent("command", int(command))
 	csOff()
-
+	return busErr
 }
 
 func SendPreamble(preamble Preamble) {
 	writeUint16(uint16(preamble))
+	traceEvent("preamble", int(preamble))
 }
 
 // WriteData writes a data word
-func WriteData(data uint16) {
+func WriteData(data uint16) error {
 	//Debug("Writing data %04x", data)
-	waitReady()
+	if err := waitReady(); err != nil {
+		return err
+	}
 	csOn()
 	SendPreamble(WritePreamble)
 	waitReady()
 	writeUint16(data)
+	traceEvent("write", int(data))
 	csOff()
-
+	stats.WordsWritten++
+	return busErr
 }
 
 // WriteBuffer writes a DataBuffer
-func (buffer DataBuffer) WriteBuffer() {
-	Debug("Writing buffer (size=%d)", len(buffer))
-	waitReady()
+func (buffer DataBuffer) WriteBuffer() error {
+	Trace("Writing buffer (size=%d)", len(buffer))
+	if err := waitReady(); err != nil {
+		return err
+	}
 	csOn()
 	SendPreamble(WritePreamble)
-	for _, d := range buffer {
-		waitReady()
-		writeUint16(d)
-	}
+	writeWords(buffer)
+	traceEvent("burst-write", len(buffer))
 	csOff()
-
+	return busErr
 }
 
-// ReadData reads a data word
+// ReadData reads a data word, 0 if the controller is not ready (see Err)
 func ReadData() (data uint16) {
-	waitReady()
+	if waitReady() != nil {
+		return 0
+	}
 	csOn()
 	SendPreamble(ReadPreamble)
 	waitReady()
 	_ = readUint16() // read dummy word
 	waitReady()
 	data = readUint16()
+	traceEvent("read", int(data))
 	csOff()
+	stats.WordsRead++
 	//Debug("Read data %04x", data)
 
 	return
 }
 
 // ReadBuffer reads into a DataBuffer
-func (buffer DataBuffer) ReadBuffer() {
-	Debug("Reading buffer (%d)", len(buffer))
-	waitReady()
+func (buffer DataBuffer) ReadBuffer() error {
+	Trace("Reading buffer (%d)", len(buffer))
+	if err := waitReady(); err != nil {
+		return err
+	}
 	csOn()
 	SendPreamble(ReadPreamble)
 	waitReady()
 	_ = readUint16() // dummy
-	for i, _ := range buffer {
-		waitReady()
-		buffer[i] = readUint16()
-	}
+	readWords(buffer)
+	traceEvent("burst-read", len(buffer))
 	csOff()
-	Debug("Read buffer (size=%d)", len(buffer))
-
+	stats.WordsRead += uint64(len(buffer))
+	Trace("Read buffer (size=%d)", len(buffer))
+	return busErr
 }
 
 // WriteCommandBuffer write a command followed by a DataBuffer
-func (buffer DataBuffer) WriteCommandBuffer(command Command) {
-	Debug("Writing buffer (%d) to command %04x", len(buffer), command)
-	WriteCommand(command)
-	buffer.WriteBuffer()
+func (buffer DataBuffer) WriteCommandBuffer(command Command) error {
+	return transaction(command, func() {
+		buffer.writeCommandBuffer(command)
+	})
+}
+
+// writeCommandBuffer is WriteCommandBuffer, within a transaction
+func (buffer DataBuffer) writeCommandBuffer(command Command) error {
+	Trace("Writing buffer (%d) to command %04x", len(buffer), command)
+	if err := WriteCommand(command); err != nil {
+		return err
+	}
+	return buffer.WriteBuffer()
 }
 
 // ReadRegister reads a register's value
 func ReadRegister(address Address) (data uint16) {
+	transaction(TCONRegRd, func() {
+		data = readRegister(address)
+	})
+	return
+}
+
+// readRegister is ReadRegister, within a transaction
+func readRegister(address Address) (data uint16) {
 	WriteCommand(TCONRegRd)
 	WriteData(uint16(address))
 	data = ReadData()
@@ -304,51 +428,112 @@ func ReadRegister(address Address) (data uint16) {
 }
 
 // WriteRegister sets a register's value
-func WriteRegister(address Address, data uint16) {
+func WriteRegister(address Address, data uint16) error {
+	return transaction(TCONRegWr, func() {
+		writeRegister(address, data)
+	})
+}
+
+// writeRegister is WriteRegister, within a transaction
+func writeRegister(address Address, data uint16) error {
 	Debug("Writing %04x to register %04x", data, address)
 	WriteCommand(TCONRegWr)
-	writeUint16(uint16(address))
-	WriteData(data)
-
+	WriteData(uint16(address))
+	return WriteData(data)
 }
 
 // ReadVCOM reads current VCOM
 func ReadVCOM() (data uint16) {
-	WriteCommand(UserCmdVCOM)
-	WriteData(uint16(GetVCOM)) //
-	data = ReadData()
+	transaction(UserCmdVCOM, func() {
+		WriteCommand(UserCmdVCOM)
+		WriteData(uint16(GetVCOM)) //
+		data = ReadData()
+	})
 	Debug("Read VCOM = %d", data)
 
 	return data
 }
 
+// ReadTemperature returns the panel temperature in °C, as used by the
+// controller to select waveforms
+func ReadTemperature() (int, error) {
+	var temperature int
+	err := transaction(UserCmdTemp, func() {
+		WriteCommand(UserCmdTemp)
+		WriteData(uint16(GetTemp))
+		temperature = int(int16(ReadData()))
+	})
+	Debug("Read temperature = %d", temperature)
+	return temperature, err
+}
+
+// ForceTemperature makes the controller select waveforms for the given
+// temperature in °C instead of the measured one, e.g. for panels in cold
+// places whose sensor is somewhere warmer
+func ForceTemperature(celsius int8) error {
+	Debug("Forcing temperature to %d", celsius)
+	return transaction(UserCmdTemp, func() {
+		WriteCommand(UserCmdTemp)
+		WriteData(uint16(SetTemp))
+		WriteData(uint16(int16(celsius)))
+	})
+}
+
 // WriteVCOM sets current VCOM
-func WriteVCOM(data uint16) {
+func WriteVCOM(data uint16) error {
 	Debug("Setting VCOM to %d", data)
-	WriteCommand(UserCmdVCOM)
-	WriteData(uint16(SetVCOM))
-	WriteData(data)
+	return transaction(UserCmdVCOM, func() {
+		WriteCommand(UserCmdVCOM)
+		WriteData(uint16(SetVCOM))
+		WriteData(data)
+	})
+}
 
+// converterSetting returns the memory converter setting (endianness, bpp, rotation)
+func (imageInfo LoadImgInfo) converterSetting() uint16 {
+	return MemoryConverter{
+		EndianType:  imageInfo.EndianType,
+		PixelFormat: imageInfo.PixelFormat,
+		Rotate:      imageInfo.Rotate,
+	}.Value()
 }
 
-// LoadImageStart starts an image transfer
+// LoadImageStart starts an image transfer. The transfer, up to LoadImageEnd,
+// must not be interleaved with other commands: see Display.Do.
 func (imageInfo LoadImgInfo) LoadImageStart() {
 	Debug("Starting image load")
+	if !config.PackedMode {
+		// unpacked: parameters go to registers before the command
+		writeRegister(MCSR, imageInfo.converterSetting())
+		WriteCommand(TCONLdImg)
+		return
+	}
 	WriteCommand(TCONLdImg)
-	WriteData(uint16(imageInfo.EndianType)<<8 | uint16(imageInfo.PixelFormat)<<4 | uint16(imageInfo.Rotate))
+	WriteData(imageInfo.converterSetting())
 }
 
-// LoadImageAreaStart starts an image area transfer
+// LoadImageAreaStart starts an image area transfer. As for LoadImageStart,
+// the transfer must not be interleaved with other commands.
 func (imageInfo LoadImgInfo) LoadImageAreaStart(imageArea AreaImgInfo) {
 	Debug("Starting image area load")
+	if !config.PackedMode {
+		// unpacked: parameters go to registers before the command
+		writeRegister(MCSR, imageInfo.converterSetting())
+		writeRegister(PRXSR, imageArea.X)
+		writeRegister(PRYSR, imageArea.Y)
+		writeRegister(PRWR, imageArea.W)
+		writeRegister(PRHR, imageArea.H)
+		WriteCommand(TCONLdImgArea)
+		return
+	}
 	data := DataBuffer{
-		uint16(imageInfo.EndianType)<<8 | uint16(imageInfo.PixelFormat)<<4 | uint16(imageInfo.Rotate),
+		imageInfo.converterSetting(),
 		imageArea.X,
 		imageArea.Y,
 		imageArea.W,
 		imageArea.H,
 	}
-	data.WriteCommandBuffer(TCONLdImgArea)
+	data.writeCommandBuffer(TCONLdImgArea)
 }
 
 // LoadImageEnd ends an image or image area transfer
@@ -361,9 +546,11 @@ func LoadImageEnd() {
 func GetSystemInfo() (devInfo *DevInfo) {
 	devInfo = &DevInfo{}
 	Debug("Getting EPD system devInfo")
-	WriteCommand(UserCmdGetDevInfo)
 	data := make(DataBuffer, (binary.Size(devInfo)+1)/2)
-	data.ReadBuffer()
+	transaction(UserCmdGetDevInfo, func() {
+		WriteCommand(UserCmdGetDevInfo)
+		data.ReadBuffer()
+	})
 	devInfo.PanelW = data[0]
 	devInfo.PanelH = data[1]
 	devInfo.MemAddrL = data[2] // Low word is sent first!
@@ -380,29 +567,111 @@ func GetSystemInfo() (devInfo *DevInfo) {
 
 // SetTargetMemoryAddr sets address to transfer to
 func SetTargetMemoryAddr(targetAddress uint32) {
+	transaction(TCONRegWr, func() {
+		setTargetMemoryAddr(targetAddress)
+	})
+}
+
+// setTargetMemoryAddr is SetTargetMemoryAddr, within a transaction
+func setTargetMemoryAddr(targetAddress uint32) {
 	Debug("Set target mem address %x", targetAddress)
-	WriteRegister(LISAR+2, uint16(targetAddress>>16))
-	WriteRegister(LISAR, uint16(targetAddress&0x0000ffff))
-	targetConfirm := uint32(ReadRegister(LISAR+2))<<16 + uint32(ReadRegister(LISAR))
+	writeRegister(LISAR+2, uint16(targetAddress>>16))
+	writeRegister(LISAR, uint16(targetAddress&0x0000ffff))
+	targetConfirm := uint32(readRegister(LISAR+2))<<16 + uint32(readRegister(LISAR))
 	Debug("Target confirmation = %x", targetConfirm)
 }
 
-// WaitForDisplayReady waits for display
-func WaitForDisplayReady() {
+// loadImage loads an image area (the whole buffer when area is nil) to
+// the image buffer at imageInfo.TargetMemAddr in a single transaction,
+// send writing the pixels
+func (imageInfo LoadImgInfo) loadImage(area *AreaImgInfo, send func()) error {
+	return transaction(TCONRegWr, func() {
+		setTargetMemoryAddr(imageInfo.TargetMemAddr)
+		if area == nil {
+			imageInfo.LoadImageStart()
+		} else {
+			imageInfo.LoadImageAreaStart(*area)
+		}
+		send()
+		LoadImageEnd()
+	})
+}
+
+// WaitForDisplayReady waits for display, for at most DisplayTimeout
+func WaitForDisplayReady() error {
+	return WaitForDisplayReadyCtx(context.Background())
+}
+
+// WaitForDisplayReadyCtx is WaitForDisplayReady, returning the context error
+// as soon as ctx is done
+func WaitForDisplayReadyCtx(ctx context.Context) error {
 	Debug("Wait for Display")
+	deadline := time.Now().Add(config.DisplayTimeout)
 	//Check IT8951 Register LUTAFSR => NonZero Busy, Zero - Free
 	for ReadRegister(LUTAFSR) != 0 {
+		if busErr != nil {
+			return busErr
+		}
+		if config.DisplayTimeout > 0 && time.Now().After(deadline) {
+			Debug("Display still busy after %v", config.DisplayTimeout)
+			return ErrTimeout
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		time.Sleep(time.Duration(100) * time.Microsecond)
 	}
+	endRefresh()
+	return busErr
 }
 
-// HostAreaPackedPixelWrite writes an image area
+// HostAreaPackedPixelWrite writes an image area, in bands of rows when it is
+// larger than config.TileWords
 func (imageInfo LoadImgInfo) HostAreaPackedPixelWrite(imageAreaInfo AreaImgInfo, bpp int, packedWrite bool) {
 	Debug("HostAreaPackedPixelWrite")
+	mirrorLoad(imageInfo, imageAreaInfo, bpp)
+	if config.VerifyRetries > 0 {
+		stride := int(DeviceInfo().PanelW)
+		if err := imageInfo.WriteAreaVerified(imageAreaInfo, bpp, stride, config.VerifyRetries); err != nil {
+			Debug("Verified upload failed: %v", err)
+		}
+		return
+	}
 	dataBuffer := imageInfo.SourceBufferAddr
-	SetTargetMemoryAddr(imageInfo.TargetMemAddr)
-	imageInfo.LoadImageAreaStart(imageAreaInfo)
+	rows := imageInfo.tileRows(imageAreaInfo, len(dataBuffer))
+	if rows == imageAreaInfo.H {
+		imageInfo.loadImage(&imageAreaInfo, func() {
+			sendArea(dataBuffer, imageAreaInfo, bpp, packedWrite)
+		})
+		return
+	}
+	stride := len(dataBuffer) / int(imageAreaInfo.H)
+	Debug("Loading %d rows at a time", rows)
+	for y := uint16(0); y < imageAreaInfo.H; y += rows {
+		band := imageAreaInfo
+		band.Y += y
+		band.H = min(rows, imageAreaInfo.H-y)
+		data := dataBuffer[int(y)*stride : int(y+band.H)*stride]
+		if imageInfo.loadImage(&band, func() {
+			sendArea(data, band, bpp, packedWrite)
+		}) != nil {
+			return
+		}
+	}
+}
+
+// tileRows returns the number of rows of an area loaded per transaction,
+// given the number of words of its buffer
+func (imageInfo LoadImgInfo) tileRows(area AreaImgInfo, words int) uint16 {
+	if config.TileWords <= 0 || words <= config.TileWords ||
+		area.H == 0 || words%int(area.H) != 0 || imageInfo.Rotate != Rotate0 {
+		return area.H
+	}
+	return uint16(max(1, config.TileWords/(words/int(area.H))))
+}
 
+// sendArea sends the pixels of an image area being loaded
+func sendArea(dataBuffer DataBuffer, imageAreaInfo AreaImgInfo, bpp int, packedWrite bool) {
 	// send data
 	// always send data fast
 	if true || packedWrite {
@@ -427,7 +696,7 @@ func (imageInfo LoadImgInfo) HostAreaPackedPixelWrite(imageAreaInfo AreaImgInfo,
 		}
 
 		wh := int(imageAreaInfo.H) // buffer height in pixels
-		Debug("Slow write %d bytes", ww*wh)
+		Trace("Slow write %d bytes", ww*wh)
 		for h := 0; h < wh; h++ {
 			// write one word at a time
 			for w := 0; w < ww; w++ {
@@ -435,72 +704,133 @@ func (imageInfo LoadImgInfo) HostAreaPackedPixelWrite(imageAreaInfo AreaImgInfo,
 			}
 		}
 	}
-	LoadImageEnd()
 }
 
-// DisplayArea display current area
-func DisplayArea(x, y, w, h uint16, mode DisplayMode) {
-	Debug("Display Area")
+// DisplayRect displays the given area of the image buffer. It returns the
+// transfer error, if any (see Err).
+func DisplayRect(area image.Rectangle, mode DisplayMode) error {
+	Debug("Display Area %v", area)
+	x, y, w, h := rectWords(area)
 	data := DataBuffer{
 		x, y, w, h, uint16(mode),
 	}
 	data.WriteCommandBuffer(UserCmdDpyArea)
+	startRefresh(area, mode, DeviceInfo().TargetAddress())
+	mirrorDisplay(area, mode, DeviceInfo().TargetAddress())
+	return busErr
 }
 
-// DisplayAreaBuffer displays target address area
-func DisplayAreaBuffer(x, y, w, h uint16, mode DisplayMode, targetAddress uint32) {
-	Debug("Display Area Buffer")
+// DisplayRectBuffer displays the given area of the image buffer at
+// targetAddress. It returns the transfer error, if any (see Err).
+func DisplayRectBuffer(area image.Rectangle, mode DisplayMode, targetAddress uint32) error {
+	Debug("Display Area Buffer %v", area)
+	x, y, w, h := rectWords(area)
 	data := DataBuffer{
 		x, y, w, h, uint16(mode), uint16(targetAddress & 0xffff), uint16(targetAddress >> 16),
 	}
 	data.WriteCommandBuffer(UserCmdDpyBufArea)
+	startRefresh(area, mode, targetAddress)
+	mirrorDisplay(area, mode, targetAddress)
+	return busErr
 }
 
-// Display1bpp display in monochrome (1bpp mode)
-func Display1bpp(x, y, w, h uint16, mode DisplayMode, targetAddress uint32, backGreyValue uint8, frontGreyValue uint8) {
+// Display1bppRect displays an area in monochrome (1bpp mode)
+func Display1bppRect(area image.Rectangle, mode DisplayMode, targetAddress uint32, backGreyValue uint8, frontGreyValue uint8) error {
 	//Set Display mode to 1 bpp mode - Set 0x18001138 Bit[18](0x1800113A Bit[2])to 1
 	Debug("Display 1bpp")
-	WriteRegister(UP1SR+2, ReadRegister(UP1SR+2)|uint16(1<<2))
+	SetUpdateParams(UP1SR, BitmapMode, true)
 
-	WriteRegister(BGVR, uint16(frontGreyValue)<<8|uint16(backGreyValue))
+	bitmapColors = &[2]uint8{backGreyValue, frontGreyValue}
+	if inverted {
+		frontGreyValue, backGreyValue = backGreyValue, frontGreyValue
+	}
+	SetBitmapColors(frontGreyValue, backGreyValue)
 
+	var err error
 	if targetAddress == 0 {
-		DisplayArea(x, y, w, h, mode)
+		err = DisplayRect(area, mode)
 	} else {
-		DisplayAreaBuffer(x, y, w, h, mode, targetAddress)
+		err = DisplayRectBuffer(area, mode, targetAddress)
 	}
-	WaitForDisplayReady()
-	WriteRegister(UP1SR+2, ReadRegister(UP1SR+2) & ^uint16(1<<2))
+	bitmapColors = nil
+	if err == nil {
+		err = WaitForDisplayReady()
+	}
+	SetUpdateParams(UP1SR, BitmapMode, false)
+	return err
 }
 
 // EnhanceDrivingCapability can improve display if it appears blurred
 func EnhanceDrivingCapability() {
 	Debug("Enhancing display capability")
-	value := ReadRegister(0x0038)
-	Debug("The reg value before writing is %x", value)
-
-	WriteRegister(0x0038, 0x0602)
-
-	value = ReadRegister(0x0038)
-	Debug("The reg value after writing is %x", value)
+	SetDrivingStrength(DrivingEnhanced)
 }
 
-// SystemRun switches to RUN mode
-func SystemRun() {
+// SystemRun switches to RUN mode. It returns the transfer error, if any
+// (see Err).
+func SystemRun() error {
 	Debug("System Run mode")
-	WriteCommand(TCONSysRun)
+	transaction(TCONSysRun, func() {
+		WriteCommand(TCONSysRun)
+	})
+	return busErr
 }
 
-// Sleep switches to SLEEP mode
-func Sleep() {
+// Wake switches back to RUN mode from SLEEP or STANDBY and checks the controller
+// answers again, by polling I80CPCR until it reads the configured value.
+// It returns ErrTimeout if the controller isn't back after WakeTimeout.
+// From a deep sleep (see EnterDeepSleep), the controller is reset and
+// reinitialized instead. Commands sent while the controller is not running
+// call Wake first.
+func Wake() error {
+	Debug("Waking up")
+	if power == PowerDeepSleep {
+		if err := reinitialize(); err != nil {
+			return err
+		}
+		restoreLight()
+		return nil
+	}
+	if err := SystemRun(); err != nil {
+		return err
+	}
+	if config.WakeSettle > 0 {
+		time.Sleep(config.WakeSettle)
+	}
+	deadline := time.Now().Add(config.WakeTimeout)
+	for {
+		if ReadRegister(I80CPCR) == packedModeValue() {
+			restoreLight()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			Debug("Controller not ready after wake")
+			return ErrTimeout
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Sleep switches to SLEEP mode. It returns the transfer error, if any (see
+// Err).
+func Sleep() error {
 	Debug("Sleep mode")
-	WriteCommand(TCONSleep)
+	dimLight()
+	transaction(TCONSleep, func() {
+		WriteCommand(TCONSleep)
+	})
+	return busErr
 }
 
-// StandBy switches to STANDBY mode
-func StandBy() {
+// StandBy switches to STANDBY mode. It returns the transfer error, if any
+// (see Err).
+func StandBy() error {
 	Debug("StandBy mode")
-	WriteCommand(TCONStandby)
+	dimLight()
+	transaction(TCONStandby, func() {
+		WriteCommand(TCONStandby)
+	})
+	return busErr
 }
 
 func (devInfo DevInfo) ClearRefresh(targetAddress uint32, mode DisplayMode, rotation Rotate) {
@@ -525,126 +855,140 @@ func (devInfo DevInfo) ClearRefresh(targetAddress uint32, mode DisplayMode, rota
 		Rotate:           rotation,
 		TargetMemAddr:    targetAddress,
 	}
-	areaInfo := AreaImgInfo{
-		X: 0,
-		Y: 0,
-		W: devInfo.PanelW,
-		H: devInfo.PanelH,
-	}
 	WaitForDisplayReady()
 
-	imageInfo.HostAreaPackedPixelWrite(areaInfo, 4, true)
-	DisplayArea(0, 0, devInfo.PanelW, devInfo.PanelH, mode)
+	imageInfo.HostAreaPackedPixelWrite(AreaFromRect(devInfo.Bounds()), 4, true)
+	DisplayRect(devInfo.Bounds(), mode)
 }
 
-func Refresh1bpp(buffer DataBuffer, X, Y, W, H uint16, mode DisplayMode, targetAddress uint32, packedWrite bool, rotation Rotate) {
+// Refresh1bppRect writes and displays a 1bpp buffer
+// The rotation is applied by the host (see TransformBuffer), area being given
+// in the rotated coordinates.
+// Nothing is written if the previous refresh does not end within
+// DisplayTimeout: ErrTimeout is then returned, as are transfer errors.
+func Refresh1bppRect(buffer DataBuffer, area image.Rectangle, mode DisplayMode, targetAddress uint32, packedWrite bool, rotation Rotate) error {
 	Debug("Refresh1bpp")
-	WaitForDisplayReady()
-	Write1bpp(buffer, X, Y, W, H, targetAddress, packedWrite, rotation)
-	Display1bpp(X, Y, W, H, mode, targetAddress, 0xF0, 0x00)
+	if err := Write1bppRect(buffer, area, targetAddress, packedWrite, rotation); err != nil {
+		return err
+	}
+	return Display1bppRect(rotatedArea(area, rotation), mode, targetAddress, 0xF0, 0x00)
 }
 
-func Write1bpp(buffer DataBuffer, X, Y, W, H uint16, targetAddress uint32, packedWrite bool, rotation Rotate) {
+// Write1bppRect writes a 1bpp buffer without displaying it
+// The rotation is applied by the host (see TransformBuffer), area being given
+// in the rotated coordinates, in pixels, its X and width multiples of 8.
+// Nothing is written if the previous refresh does not end within
+// DisplayTimeout: ErrTimeout is then returned, as are transfer errors.
+func Write1bppRect(buffer DataBuffer, area image.Rectangle, targetAddress uint32, packedWrite bool, rotation Rotate) error {
 	Debug("Write1bpp")
-	WaitForDisplayReady()
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
 
+	buffer, area = rotateLoad(buffer, area, 1, rotation)
 	imageInfo := LoadImgInfo{
 		SourceBufferAddr: buffer,
 		EndianType:       LoadImgLittleEndian,
 		PixelFormat:      BPP8, //Use 8bpp to set 1bpp
-		Rotate:           rotation,
+		Rotate:           Rotate0,
 		TargetMemAddr:    targetAddress,
 	}
-	areaInfo := AreaImgInfo{
-		X: X,
-		Y: Y,
-		W: W,
-		H: H,
-	}
-	imageInfo.HostAreaPackedPixelWrite(areaInfo, 1, packedWrite)
+	// 8 pixels per byte: the load area is given in 8bpp units
+	loadArea := AreaFromRect(area)
+	loadArea.X /= 8
+	loadArea.W /= 8
+	imageInfo.HostAreaPackedPixelWrite(loadArea, 1, packedWrite)
+	return busErr
 }
 
-func MultiFrameRefresh1bpp(X, Y, W, H uint16, targetAddress uint32) {
+// MultiFrameRefresh1bppRect displays an area already loaded at targetAddress
+// in A2 mode
+func MultiFrameRefresh1bppRect(area image.Rectangle, targetAddress uint32) error {
 	Debug("MultiFrameRefresh1bpp")
-	WaitForDisplayReady()
-	Display1bpp(X, Y, W, H, A2Mode, targetAddress, 0xF0, 0x00)
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
+	return Display1bppRect(area, A2Mode, targetAddress, 0xF0, 0x00)
 }
 
-func Refresh2bpp(buffer DataBuffer, X, Y, W, H uint16, hold bool, targetAddress uint32, packedWrite bool, rotation Rotate) {
+// Refresh2bppRect writes and displays a 2bpp buffer
+// The rotation is applied by the host (see TransformBuffer), area being given
+// in the rotated coordinates.
+// Nothing is written if the previous refresh does not end within
+// DisplayTimeout: ErrTimeout is then returned, as are transfer errors.
+func Refresh2bppRect(buffer DataBuffer, area image.Rectangle, hold bool, targetAddress uint32, packedWrite bool, rotation Rotate) error {
 	Debug("Refresh2bpp")
-	WaitForDisplayReady()
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
 
+	buffer, area = rotateLoad(buffer, area, 2, rotation)
 	imageInfo := LoadImgInfo{
 		SourceBufferAddr: buffer,
 		EndianType:       LoadImgLittleEndian,
 		PixelFormat:      BPP2,
-		Rotate:           rotation,
+		Rotate:           Rotate0,
 		TargetMemAddr:    targetAddress,
 	}
-	areaInfo := AreaImgInfo{
-		X: X,
-		Y: Y,
-		W: W,
-		H: H,
-	}
-	imageInfo.HostAreaPackedPixelWrite(areaInfo, 2, packedWrite)
+	imageInfo.HostAreaPackedPixelWrite(AreaFromRect(area), 2, packedWrite)
 	if hold {
-		DisplayArea(X, Y, W, H, GC16Mode)
-	} else {
-		DisplayAreaBuffer(X, Y, W, H, GC16Mode, targetAddress)
+		return DisplayRect(area, GC16Mode)
 	}
+	return DisplayRectBuffer(area, GC16Mode, targetAddress)
 }
 
-func Refresh4bpp(buffer DataBuffer, X, Y, W, H uint16, hold bool, targetAddress uint32, packedWrite bool, rotation Rotate) {
+// Refresh4bppRect writes and displays a 4bpp buffer
+// The rotation is applied by the host (see TransformBuffer), area being given
+// in the rotated coordinates.
+// Nothing is written if the previous refresh does not end within
+// DisplayTimeout: ErrTimeout is then returned, as are transfer errors.
+func Refresh4bppRect(buffer DataBuffer, area image.Rectangle, hold bool, targetAddress uint32, packedWrite bool, rotation Rotate) error {
 	Debug("Refresh4bpp")
-	WaitForDisplayReady()
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
 
+	buffer, area = rotateLoad(buffer, area, 4, rotation)
 	imageInfo := LoadImgInfo{
 		SourceBufferAddr: buffer,
 		EndianType:       LoadImgLittleEndian,
 		PixelFormat:      BPP4,
-		Rotate:           rotation,
+		Rotate:           Rotate0,
 		TargetMemAddr:    targetAddress,
 	}
-	areaInfo := AreaImgInfo{
-		X: X,
-		Y: Y,
-		W: W,
-		H: H,
-	}
-	imageInfo.HostAreaPackedPixelWrite(areaInfo, 4, packedWrite)
+	imageInfo.HostAreaPackedPixelWrite(AreaFromRect(area), 4, packedWrite)
 
 	if hold {
-		DisplayArea(X, Y, W, H, GC16Mode)
-	} else {
-		DisplayAreaBuffer(X, Y, W, H, GC16Mode, targetAddress)
+		return DisplayRect(area, GC16Mode)
 	}
+	return DisplayRectBuffer(area, GC16Mode, targetAddress)
 }
 
-func Refresh8bpp(buffer DataBuffer, X, Y, W, H uint16, hold bool, targetAddress uint32, rotation Rotate) {
+// Refresh8bppRect writes and displays an 8bpp buffer
+// The rotation is applied by the host (see TransformBuffer), area being given
+// in the rotated coordinates.
+// Nothing is written if the previous refresh does not end within
+// DisplayTimeout: ErrTimeout is then returned, as are transfer errors.
+func Refresh8bppRect(buffer DataBuffer, area image.Rectangle, hold bool, targetAddress uint32, rotation Rotate) error {
 	Debug("Refresh8bpp")
-	WaitForDisplayReady()
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
 
+	buffer, area = rotateLoad(buffer, area, 8, rotation)
 	imageInfo := LoadImgInfo{
 		SourceBufferAddr: buffer,
 		EndianType:       LoadImgLittleEndian,
 		PixelFormat:      BPP8,
-		Rotate:           rotation,
+		Rotate:           Rotate0,
 		TargetMemAddr:    targetAddress,
 	}
-	areaInfo := AreaImgInfo{
-		X: X,
-		Y: Y,
-		W: W,
-		H: H,
-	}
-	imageInfo.HostAreaPackedPixelWrite(areaInfo, 8, false)
+	imageInfo.HostAreaPackedPixelWrite(AreaFromRect(area), 8, false)
 
 	if hold {
-		DisplayArea(X, Y, W, H, GC16Mode)
-	} else {
-		DisplayAreaBuffer(X, Y, W, H, GC16Mode, targetAddress)
+		return DisplayRect(area, GC16Mode)
 	}
+	return DisplayRectBuffer(area, GC16Mode, targetAddress)
 }
 
 // --- helpers
@@ -699,8 +1043,5 @@ func (devInfo DevInfo) TargetAddress() uint32 {
 
 // GetWidthInWords calculates the number of words for a certain width and resolution
 func GetWidthInWords(width int, bpp int) int {
-	if (width*bpp)%16 == 0 {
-		return width * bpp / 16
-	}
-	return (width*bpp + 1) / 16
+	return (width*bpp + 15) / 16
 }
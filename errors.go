@@ -0,0 +1,47 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "errors"
+
+var (
+	// ErrTimeout is returned when the controller does not answer in time
+	ErrTimeout = errors.New("it8951: timeout")
+	// ErrNotReady is returned when the controller ready line stays low, e.g.
+	// when the HAT is unplugged
+	ErrNotReady = errors.New("it8951: controller not ready")
+	// ErrDeadline is returned when no mode can refresh an area within the requested time
+	ErrDeadline = errors.New("it8951: refresh cannot complete within deadline")
+	// ErrVerify is returned when data read back from the controller memory
+	// does not match what was written
+	ErrVerify = errors.New("it8951: verification failed")
+	// ErrNotInitialized is returned by Attach when the controller was not set up
+	ErrNotInitialized = errors.New("it8951: controller not initialized")
+	// ErrTooLarge is returned when an image is over the decode limits
+	ErrTooLarge = errors.New("it8951: image too large")
+	// ErrDead is returned by a Supervisor once its recovery budget is spent
+	ErrDead = errors.New("it8951: controller does not recover")
+	// ErrLeased is returned when another process holds the panel lease
+	ErrLeased = errors.New("it8951: panel in use by another process")
+	// ErrInvalidConfig is returned by Init for invalid settings, e.g. an
+	// unknown panel model or a VCOM out of range
+	ErrInvalidConfig = errors.New("it8951: invalid configuration")
+	// ErrNoWaveform is returned when the LUT of the panel lacks a waveform
+	ErrNoWaveform = errors.New("it8951: waveform not in LUT")
+)
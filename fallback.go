@@ -0,0 +1,121 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Fallback is a font.Face drawing each rune with the first face of a chain
+// having a glyph for it, e.g. a text font, then a symbol or CJK font, then an
+// embedded bitmap font which always answers:
+//
+//	face := it8951.NewFallback(
+//		it8951.WithCoverage(textFace, textFont),
+//		it8951.WithCoverage(symbolFace, symbolFont),
+//		basicfont.Face7x13,
+//	)
+//
+// A face lacks a rune when it reports no advance for it, or when it has a
+// HasGlyph(rune) bool method returning false (see WithCoverage). Metrics are
+// those of the first face. Runes no face has are drawn by the last one.
+type Fallback struct {
+	faces []font.Face
+	index map[rune]int // face chosen for each rune met
+}
+
+// NewFallback returns a face chaining faces, in order of preference
+func NewFallback(faces ...font.Face) *Fallback {
+	return &Fallback{faces: faces, index: map[rune]int{}}
+}
+
+// WithCoverage wraps an OpenType face so that Fallback skips it for the runes
+// its font maps to no glyph, which would otherwise be drawn as boxes
+func WithCoverage(face font.Face, f *sfnt.Font) font.Face {
+	return coveredFace{Face: face, font: f}
+}
+
+// coveredFace is a face knowing which runes its font has
+type coveredFace struct {
+	font.Face
+	font   *sfnt.Font
+	buffer sfnt.Buffer
+}
+
+func (c coveredFace) HasGlyph(r rune) bool {
+	index, err := c.font.GlyphIndex(&c.buffer, r)
+	return err == nil && index != 0
+}
+
+// face returns the index of the face drawing r
+func (f *Fallback) face(r rune) int {
+	if i, ok := f.index[r]; ok {
+		return i
+	}
+	i := 0
+	for ; i < len(f.faces)-1; i++ {
+		if covered, ok := f.faces[i].(interface{ HasGlyph(rune) bool }); ok && !covered.HasGlyph(r) {
+			continue
+		}
+		if _, ok := f.faces[i].GlyphAdvance(r); ok {
+			break
+		}
+	}
+	f.index[r] = i
+	return i
+}
+
+// Close closes every face of the chain
+func (f *Fallback) Close() error {
+	var err error
+	for _, face := range f.faces {
+		if e := face.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (f *Fallback) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.faces[f.face(r)].Glyph(dot, r)
+}
+
+func (f *Fallback) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.faces[f.face(r)].GlyphBounds(r)
+}
+
+func (f *Fallback) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.faces[f.face(r)].GlyphAdvance(r)
+}
+
+// Kern returns the kerning of two runes drawn by the same face, 0 otherwise
+func (f *Fallback) Kern(r0, r1 rune) fixed.Int26_6 {
+	if i := f.face(r0); i == f.face(r1) {
+		return f.faces[i].Kern(r0, r1)
+	}
+	return 0
+}
+
+func (f *Fallback) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
@@ -0,0 +1,77 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// fastThreshold is the gray level from which pixels are white in RefreshFast
+const fastThreshold = 128
+
+// RefreshFast displays a region of img (at the same logical position on the
+// panel) with the A2 mode, for fast updates such as menus or page turns.
+//
+// A2 (and DU) waveforms only drive pixels to black or white: gray levels are
+// not reached and leave artifacts that only a GC16 or INIT refresh clears.
+// RefreshFast therefore clamps every pixel to black or white before sending it
+// as 4bpp data, so the result is predictable. The region is extended to whole
+// words (multiples of 4 pixels at 4bpp) along the panel rows.
+func RefreshFast(img image.Image, region image.Rectangle) {
+	Debug("Fast refresh %v", region)
+	devInfo := DeviceInfo()
+	bounds := devInfo.Bounds()
+	logical := orientation.LogicalBounds(bounds)
+	img, region = shiftContent(img, region, logical)
+	region = orientation.ToPanelRect(region.Intersect(logical), bounds)
+	if region.Empty() {
+		return
+	}
+	area := alignRect(region, 4, bounds)
+	var gray *image.Gray
+	if highContrast {
+		gray = contrastGray(img, area, bounds)
+	} else {
+		gray = panelGray(img, area, bounds)
+		binarize(gray, fastThreshold)
+	}
+	captureFrame(gray)
+	buffer := packGray(gray, 4)
+
+	targetAddress := devInfo.TargetAddress()
+	WaitForDisplayReady()
+	imageInfo := LoadImgInfo{
+		SourceBufferAddr: buffer,
+		EndianType:       LoadImgLittleEndian,
+		PixelFormat:      BPP4,
+		Rotate:           Rotate0,
+		TargetMemAddr:    targetAddress,
+	}
+	imageInfo.HostAreaPackedPixelWrite(AreaFromRect(area), 4, true)
+	DisplayRectBuffer(area, A2Mode, targetAddress)
+}
+
+// binarize sets pixels of gray to black or white, white from threshold
+func binarize(gray *image.Gray, threshold uint8) {
+	for i, value := range gray.Pix {
+		if value >= threshold {
+			gray.Pix[i] = 0xff
+		} else {
+			gray.Pix[i] = 0
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Firmware describes the controller firmware and waveform (LUT) and what
+// they imply for the driver
+type Firmware struct {
+	Version    string // FW version string, e.g. "SWv_0.1.1"
+	Major      int    // parsed from Version, when it holds a x.y.z number
+	Minor      int
+	Patch      int
+	LUT        string // LUT version string, e.g. "M841_TFA2812"
+	LUTFamily  string // LUT name before the first '_', e.g. "M841"
+	LUTVariant string // LUT name after the first '_', e.g. "TFA2812"
+
+	Modes       ModeTable   // display mode numbers of the LUT waveforms
+	A2Mode      DisplayMode // waveform number of the A2 mode
+	ColorPanel  bool        // panel with a color filter (e.g. 7.8" Kaleido, TFA5210)
+	MaxSPIClock int         // highest SPI clock known to work, in Hz
+	Quirks      []string    // human readable list of the adaptations applied
+}
+
+var versionNumber = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// Firmware parses the version strings of the controller and reports known quirks
+func (devInfo DevInfo) Firmware() Firmware {
+	fw := Firmware{
+		Version:     trimVersion(wordsToString(devInfo.FWVersion)),
+		LUT:         trimVersion(wordsToString(devInfo.LUTVersion)),
+		A2Mode:      6,
+		MaxSPIClock: 24000000,
+	}
+	if m := versionNumber.FindStringSubmatch(fw.Version); m != nil {
+		fw.Major, _ = strconv.Atoi(m[1])
+		fw.Minor, _ = strconv.Atoi(m[2])
+		fw.Patch, _ = strconv.Atoi(m[3])
+	}
+	fw.LUTFamily, fw.LUTVariant, _ = strings.Cut(fw.LUT, "_")
+
+	fw.Modes = modeTable(fw.LUT, fw.LUTFamily)
+	if mode, ok := fw.Modes[WaveformA2]; ok {
+		fw.A2Mode = mode
+	}
+	if fw.A2Mode != 6 {
+		fw.Quirks = append(fw.Quirks, fmt.Sprintf("A2 is waveform %d", fw.A2Mode))
+	}
+	if fw.LUTVariant == "TFA5210" {
+		fw.ColorPanel = true
+		fw.Quirks = append(fw.Quirks, "color filter panel")
+	}
+	return fw
+}
+
+// String returns a readable report
+func (fw Firmware) String() string {
+	report := fmt.Sprintf("FW Version   : %s (%d.%d.%d)\n"+
+		"LUT Version  : %s\n"+
+		"A2 mode      : %d\n"+
+		"Color panel  : %v\n"+
+		"Max SPI clock: %dHz\n",
+		fw.Version, fw.Major, fw.Minor, fw.Patch,
+		fw.LUT,
+		fw.A2Mode,
+		fw.ColorPanel,
+		fw.MaxSPIClock)
+	modes := ""
+	for w := WaveformINIT; w <= WaveformDU4; w++ {
+		if mode, ok := fw.Modes[w]; ok {
+			modes += fmt.Sprintf(" %v=%d", w, mode)
+		}
+	}
+	report += "Modes        :" + modes + "\n"
+	for _, quirk := range fw.Quirks {
+		report += "Quirk        : " + quirk + "\n"
+	}
+	return report
+}
+
+// trimVersion removes the padding of version strings
+func trimVersion(version string) string {
+	return strings.TrimSpace(strings.TrimRight(version, "\x00"))
+}
@@ -0,0 +1,108 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"time"
+)
+
+// FrameSource produces the frames shown by Display.Play. Each frame is an
+// image in logical coordinates, the region to show (the whole image when
+// empty), the display mode and how long to keep the frame before asking for
+// the next one. Returning io.EOF ends the playback.
+type FrameSource interface {
+	NextFrame(ctx context.Context) (img image.Image, region image.Rectangle, mode DisplayMode, dwell time.Duration, err error)
+}
+
+// FrameSourceFunc is a function used as a FrameSource
+type FrameSourceFunc func(ctx context.Context) (image.Image, image.Rectangle, DisplayMode, time.Duration, error)
+
+// NextFrame calls f
+func (f FrameSourceFunc) NextFrame(ctx context.Context) (image.Image, image.Rectangle, DisplayMode, time.Duration, error) {
+	return f(ctx)
+}
+
+// Play shows the frames of src until it returns io.EOF or ctx is canceled.
+// Frames go through the middlewares set with Use, and only the pixels that
+// changed since the previous frame are refreshed. The controller sleeps
+// during dwells of at least SleepDwell. Play is usually started as a worker:
+//
+//	display.Go(func(ctx context.Context) error {
+//		return display.Play(ctx, src)
+//	})
+func (d *Display) Play(ctx context.Context, src FrameSource) error {
+	asleep := false
+	for {
+		img, region, mode, dwell, err := src.NextFrame(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if region.Empty() {
+			region = img.Bounds()
+		}
+		err = d.Do(func() error {
+			if asleep {
+				if err := Wake(); err != nil {
+					return err
+				}
+				asleep = false
+			}
+			if err := d.Present(img, region, mode); err != nil {
+				return err
+			}
+			if d.SleepDwell > 0 && dwell >= d.SleepDwell {
+				if err := WaitForDisplayReady(); err != nil {
+					return err
+				}
+				if err := Sleep(); err != nil {
+					return err
+				}
+				asleep = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(dwell):
+		}
+	}
+}
+
+// diffFrame updates the shadow frame with region of img and returns the area
+// that changed
+func (d *Display) diffFrame(img image.Image, region image.Rectangle) image.Rectangle {
+	if d.shadow == nil {
+		d.shadow = NewShadow(orientation.LogicalBounds(DeviceInfo().Bounds()))
+	}
+	frame := image.NewGray(region)
+	draw.Draw(frame, region, img, region.Min, draw.Src)
+	return d.shadow.Update(frame)
+}
@@ -0,0 +1,73 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// Rect builds an image.Rectangle from the x, y, w, h values used by the controller
+func Rect(x, y, w, h uint16) image.Rectangle {
+	return image.Rect(int(x), int(y), int(x)+int(w), int(y)+int(h))
+}
+
+// rectWords splits a rectangle into the x, y, w, h words sent to the controller
+func rectWords(area image.Rectangle) (x, y, w, h uint16) {
+	area = area.Canon()
+	return uint16(area.Min.X), uint16(area.Min.Y), uint16(area.Dx()), uint16(area.Dy())
+}
+
+// AreaFromRect converts a rectangle to an AreaImgInfo
+func AreaFromRect(area image.Rectangle) AreaImgInfo {
+	x, y, w, h := rectWords(area)
+	return AreaImgInfo{
+		X: x,
+		Y: y,
+		W: w,
+		H: h,
+	}
+}
+
+// Rect returns the area as an image.Rectangle
+func (imageArea AreaImgInfo) Rect() image.Rectangle {
+	return Rect(imageArea.X, imageArea.Y, imageArea.W, imageArea.H)
+}
+
+// Bounds returns the full panel area
+func (devInfo DevInfo) Bounds() image.Rectangle {
+	return Rect(0, 0, devInfo.PanelW, devInfo.PanelH)
+}
+
+// alignStep returns the pixels of a word at the given bpp, or of 4 bytes at
+// 1bpp on panels needing it (see PanelModel.FourByteAlign)
+func alignStep(bpp int) int {
+	if bpp == 1 && fourByteAlign {
+		return 32
+	}
+	return 16 / bpp
+}
+
+// alignRect grows an area so that each of its rows starts and ends on a word
+// boundary at the given bpp, without leaving bounds
+func alignRect(area image.Rectangle, bpp int, bounds image.Rectangle) image.Rectangle {
+	step := alignStep(bpp)
+	area.Min.X -= area.Min.X % step
+	if rem := area.Max.X % step; rem != 0 {
+		area.Max.X += step - rem
+	}
+	return area.Intersect(bounds)
+}
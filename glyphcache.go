@@ -0,0 +1,164 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"container/list"
+	"image"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// glyphKey identifies a rasterized glyph: the cached face, the rune and the
+// dot position within a pixel
+type glyphKey struct {
+	face int
+	r    rune
+	frac fixed.Point26_6
+}
+
+// glyph is a cached rasterized glyph, its bounds relative to the dot pixel
+type glyph struct {
+	key     glyphKey
+	bounds  image.Rectangle
+	mask    *image.Alpha
+	advance fixed.Int26_6
+	ok      bool
+}
+
+// glyphLRU is a least recently used cache of glyphs
+type glyphLRU struct {
+	sync.Mutex
+	size    int
+	faces   int // cached faces created
+	entries map[glyphKey]*list.Element
+	order   *list.List // most recently used first
+	hits    uint64
+	misses  uint64
+}
+
+var (
+	glyphs = &glyphLRU{size: 512, entries: map[glyphKey]*list.Element{}, order: list.New()}
+)
+
+// SetGlyphCacheSize sets the number of glyphs kept for cached faces (512 by
+// default), dropping the least recently used ones over it. 0 disables the
+// cache.
+func SetGlyphCacheSize(n int) {
+	glyphs.Lock()
+	defer glyphs.Unlock()
+	glyphs.size = n
+	glyphs.trim()
+}
+
+// GlyphCacheStats returns the number of glyphs cached and the cache hits and
+// misses so far
+func GlyphCacheStats() (size int, hits, misses uint64) {
+	glyphs.Lock()
+	defer glyphs.Unlock()
+	return glyphs.order.Len(), glyphs.hits, glyphs.misses
+}
+
+// CachedFace wraps face so that its glyphs are rasterized once and kept,
+// their coverage reduced to levels alpha values (the panel gray levels, e.g.
+// 16 at 4bpp; 0 keeps them all). Redrawing the same text, such as the digits
+// of a clock, then costs a copy per glyph. The face must not change (size,
+// hinting...) while in use.
+func CachedFace(face font.Face, levels int) font.Face {
+	glyphs.Lock()
+	defer glyphs.Unlock()
+	glyphs.faces++
+	return cachedFace{Face: face, id: glyphs.faces, levels: levels}
+}
+
+// cachedFace is a face whose glyphs go through the glyph cache
+type cachedFace struct {
+	font.Face
+	id     int
+	levels int
+}
+
+func (c cachedFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	key := glyphKey{face: c.id, r: r, frac: fixed.Point26_6{X: dot.X & 63, Y: dot.Y & 63}}
+	g := glyphs.get(key)
+	if g == nil {
+		g = c.rasterize(key)
+		glyphs.put(g)
+	}
+	if !g.ok {
+		return image.Rectangle{}, nil, image.Point{}, g.advance, false
+	}
+	return g.bounds.Add(image.Pt(dot.X.Floor(), dot.Y.Floor())), g.mask, image.Point{}, g.advance, true
+}
+
+// rasterize draws a glyph at the dot fraction of key, quantizing its coverage
+func (c cachedFace) rasterize(key glyphKey) *glyph {
+	dr, mask, maskp, advance, ok := c.Face.Glyph(key.frac, key.r)
+	g := &glyph{key: key, advance: advance, ok: ok}
+	if !ok {
+		return g
+	}
+	g.bounds = dr
+	g.mask = image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+	draw.Draw(g.mask, g.mask.Rect, mask, maskp, draw.Src)
+	if c.levels > 1 {
+		for i, a := range g.mask.Pix {
+			g.mask.Pix[i] = quantizeLevel(int(a), c.levels)
+		}
+	}
+	return g
+}
+
+// get returns a cached glyph, nil if missing
+func (cache *glyphLRU) get(key glyphKey) *glyph {
+	cache.Lock()
+	defer cache.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		cache.misses++
+		return nil
+	}
+	cache.hits++
+	cache.order.MoveToFront(element)
+	return element.Value.(*glyph)
+}
+
+// put caches a glyph
+func (cache *glyphLRU) put(g *glyph) {
+	cache.Lock()
+	defer cache.Unlock()
+	if _, ok := cache.entries[g.key]; ok || cache.size <= 0 {
+		return
+	}
+	cache.entries[g.key] = cache.order.PushFront(g)
+	cache.trim()
+}
+
+// trim drops the least recently used glyphs over the cache size. The cache
+// must be locked.
+func (cache *glyphLRU) trim() {
+	for cache.order.Len() > max(cache.size, 0) {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*glyph).key)
+	}
+}
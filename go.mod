@@ -2,5 +2,15 @@ module github.com/peergum/IT8951-go
 
 go 1.22.2
 
-require github.com/peergum/go-rpio/v5 v5.0.3
+require (
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/klauspost/compress v1.18.0
+	github.com/peergum/go-rpio/v5 v5.0.3
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.16.0
+	golang.org/x/time v0.10.0
+)
 
+require golang.org/x/net v0.19.0 // indirect
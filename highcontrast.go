@@ -0,0 +1,135 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+)
+
+const (
+	contrastRadius    = 1    // pixels dark strokes grow by in high contrast mode
+	contrastThreshold = 0x80 // lightest gray turning black in high contrast mode
+	contrastFaceSize  = 24   // size of the default face in high contrast mode, in points
+)
+
+var (
+	highContrast bool // see SetHighContrast
+
+	contrastFace     font.Face // see DefaultFace
+	contrastFaceOnce sync.Once
+)
+
+// SetHighContrast switches the high contrast mode, for readers with low
+// vision, e.g. on public signs: images are converted to black and white with
+// no dithering, dark strokes thickened by dilation, frames presented by a
+// Display are sent at 1bpp, and text drawn without a face uses a larger bold
+// one (see DefaultFace). Content already displayed keeps its looks until
+// redrawn (see Display.SetHighContrast). Init sets it from
+// Config.HighContrast.
+func SetHighContrast(on bool) {
+	highContrast = on
+}
+
+// HighContrast tells whether the high contrast mode is on
+func HighContrast() bool {
+	return highContrast
+}
+
+// WithHighContrast starts the device in high contrast mode (see
+// SetHighContrast)
+func WithHighContrast(on bool) Option {
+	return func(c *Config) {
+		c.HighContrast = on
+	}
+}
+
+// DefaultFace returns the face text is drawn with when none is given:
+// basicfont.Face7x13, or Go Bold at 24 points in high contrast mode
+func DefaultFace() font.Face {
+	if !highContrast {
+		return basicfont.Face7x13
+	}
+	contrastFaceOnce.Do(func() {
+		contrastFace = basicfont.Face7x13
+		f, err := opentype.Parse(gobold.TTF)
+		if err == nil {
+			contrastFace, err = NewFace(f, contrastFaceSize)
+		}
+		if err != nil {
+			Debug("High contrast face: %v", err)
+		}
+	})
+	return contrastFace
+}
+
+// contrastGray converts a panel area of img for the high contrast mode: each
+// pixel takes the darkest gray within contrastRadius, the pixels around the
+// area included, then turns black or white
+func contrastGray(img image.Image, area image.Rectangle, panel image.Rectangle) *image.Gray {
+	around := area.Inset(-contrastRadius).Intersect(panel)
+	src := panelGray(img, around, panel)
+	gray := image.NewGray(area)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			window := image.Rect(x-contrastRadius, y-contrastRadius, x+contrastRadius+1, y+contrastRadius+1).Intersect(around)
+			darkest := uint8(0xff)
+			for wy := window.Min.Y; wy < window.Max.Y; wy++ {
+				for _, value := range src.Pix[src.PixOffset(window.Min.X, wy):src.PixOffset(window.Max.X, wy)] {
+					darkest = min(darkest, value)
+				}
+			}
+			gray.Pix[gray.PixOffset(x, y)] = darkest
+		}
+	}
+	binarize(gray, contrastThreshold)
+	return gray
+}
+
+// SetHighContrast switches the high contrast mode (see SetHighContrast) and
+// presents the whole frame again with mode: the one render returns, e.g. the
+// widgets of the application drawn again so that text picks the default face
+// of the new mode, or the last frame presented when render is nil. It must
+// not be called from within Do.
+func (d *Display) SetHighContrast(on bool, mode DisplayMode, render func() image.Image) error {
+	return d.Do(func() error {
+		SetHighContrast(on)
+		var img image.Image
+		switch {
+		case render != nil:
+			img = render()
+		case d.shadow != nil:
+			img = d.shadow.Image()
+		default:
+			return nil
+		}
+		bounds := orientation.LogicalBounds(DeviceInfo().Bounds())
+		d.diffFrame(img, bounds)
+		bpp := d.modeBpp(mode)
+		if highContrast {
+			bpp = 1
+		}
+		return displayImage(img, bounds, bpp, mode)
+	})
+}
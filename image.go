@@ -0,0 +1,150 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// PackImage converts an area of img to a packed buffer at the given bpp (1, 2, 4 or 8),
+// using the current ditherer. Pixels of the area lying outside of img get the
+// background gray (see WithBackground).
+//
+// Pixels are packed little endian, first pixel in the lowest bits of each word,
+// and every row starts on a new word.
+func PackImage(img image.Image, area image.Rectangle, bpp int) DataBuffer {
+	Debug("Packing image area %v at %dbpp", area, bpp)
+	return packGray(currentDitherer().Apply(toGray(img, area), 1<<bpp), bpp)
+}
+
+// convertImage converts a panel area of a logical image to a packed buffer,
+// using the current orientation and ditherer (see reduceLevels)
+func convertImage(img image.Image, area image.Rectangle, panel image.Rectangle, bpp int) DataBuffer {
+	var gray *image.Gray
+	if highContrast {
+		gray = contrastGray(img, area, panel)
+	} else {
+		gray = reduceLevels(img, panelGray(img, area, panel), 1<<bpp, currentDitherer())
+	}
+	captureFrame(gray)
+	return packGray(gray, bpp)
+}
+
+// reduceLevels reduces gray, converted from img, to the given number of
+// levels with d, after applying the tone curve and the compensation of the
+// panel (see Compensation). Paletted images with no more colors than levels
+// are quantized instead, each color then mapping to a level of its own.
+func reduceLevels(img image.Image, gray *image.Gray, levels int, d Ditherer) *image.Gray {
+	if curve := currentToneCurve(); curve != nil {
+		curve.apply(gray)
+	}
+	if curve := currentCompensation(); curve != nil {
+		curve.apply(gray)
+	}
+	if paletted := palettedImage(img); paletted != nil && len(paletted.Palette) <= levels {
+		return quantize(gray, levels)
+	}
+	return d.Apply(gray, levels)
+}
+
+// toGray converts an area of img to an 8 bit grayscale image
+func toGray(img image.Image, area image.Rectangle) *image.Gray {
+	if moved, ok := img.(shiftedImage); ok {
+		gray := toGray(moved.Image, area.Sub(moved.offset))
+		gray.Rect = area
+		return gray
+	}
+	gray := image.NewGray(area)
+	if src, ok := img.(*image.Gray); ok && area.In(src.Bounds()) {
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			copy(gray.Pix[gray.PixOffset(area.Min.X, y):], src.Pix[src.PixOffset(area.Min.X, y):src.PixOffset(area.Max.X, y)])
+		}
+		return gray
+	}
+	if src, ok := img.(*image.Paletted); ok && area.In(src.Bounds()) {
+		var grays [256]uint8
+		for i, c := range src.Palette {
+			grays[i] = color.GrayModel.Convert(c).(color.Gray).Y
+		}
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			row := gray.Pix[gray.PixOffset(area.Min.X, y):]
+			for x, index := range src.Pix[src.PixOffset(area.Min.X, y):src.PixOffset(area.Max.X, y)] {
+				row[x] = grays[index]
+			}
+		}
+		return gray
+	}
+	draw.Draw(gray, area, image.NewUniform(color.Gray{Y: config.Background}), image.Point{}, draw.Src)
+	draw.Draw(gray, area, img, area.Min, draw.Src)
+	return gray
+}
+
+// palettedImage returns the paletted image behind img, if any
+func palettedImage(img image.Image) *image.Paletted {
+	for {
+		switch i := img.(type) {
+		case *image.Paletted:
+			return i
+		case shiftedImage:
+			img = i.Image
+		default:
+			return nil
+		}
+	}
+}
+
+// packGray packs a grayscale image, keeping the bpp most significant bits of each pixel
+func packGray(gray *image.Gray, bpp int) DataBuffer {
+	area := gray.Bounds()
+	w, h := area.Dx(), area.Dy()
+	stride := GetWidthInWords(w, bpp)
+	buffer := make(DataBuffer, stride*h)
+	for y := 0; y < h; y++ {
+		packRow(gray.Pix[y*gray.Stride:y*gray.Stride+w], buffer[y*stride:(y+1)*stride], bpp)
+	}
+	return buffer
+}
+
+// packRow packs a row of gray levels into row, keeping the bpp most significant
+// bits and inverting them in night mode. Pixels padding the row to a whole
+// word get the background gray.
+func packRow(pix []uint8, row DataBuffer, bpp int) {
+	if bpp == 4 {
+		pack4(pix, row)
+	} else {
+		clear(row)
+		shift := 8 - bpp
+		for x, value := range pix {
+			bit := x * bpp
+			row[bit/16] |= uint16(value>>shift) << (bit % 16)
+		}
+	}
+	padding := config.Background >> (8 - bpp)
+	if inverted && bpp != 1 { // 1bpp is inverted through the bitmap colors
+		for i := range row {
+			row[i] ^= 0xffff
+		}
+		padding = ^padding
+	}
+	for x := len(pix); x < len(row)*16/bpp; x++ {
+		row.SetPixel(x, bpp, padding)
+	}
+}
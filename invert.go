@@ -0,0 +1,45 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+var (
+	inverted bool
+)
+
+// SetInverted inverts the gray levels of everything displayed from now on:
+// packed data is inverted while packing, and 1bpp data by swapping the
+// bitmap colors
+func SetInverted(invert bool) {
+	Debug("Inverted: %v", invert)
+	inverted = invert
+}
+
+// Inverted reports whether gray levels are inverted
+func Inverted() bool {
+	return inverted
+}
+
+// SetNightMode switches the inversion and displays the frame kept in shadow
+// (in logical coordinates) again with a single full refresh, so the whole
+// screen changes at once
+func SetNightMode(on bool, shadow *Shadow) {
+	SetInverted(on)
+	frame := shadow.Image()
+	displayImage(frame, frame.Rect, 4, GC16Mode)
+}
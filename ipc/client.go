@@ -0,0 +1,236 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package ipc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"net"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// Client sends requests to a Server. It is not safe for concurrent use.
+type Client struct {
+	conn        net.Conn
+	driverFrame uint64 // see DriverFrame
+}
+
+// Dial connects to a server listening on a Unix socket at path
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Info returns the panel size
+func (c *Client) Info() (image.Point, error) {
+	reply, err := c.request(TypeInfo)
+	if err != nil {
+		return image.Point{}, err
+	}
+	if len(reply) < 4 {
+		return image.Point{}, errors.New("ipc: info reply too short")
+	}
+	return image.Pt(int(binary.BigEndian.Uint16(reply)), int(binary.BigEndian.Uint16(reply[2:]))), nil
+}
+
+// LastFrame returns the ID of the last frame applied, 0 when none
+func (c *Client) LastFrame() (uint64, error) {
+	reply, err := c.request(TypeInfo)
+	if err != nil {
+		return 0, err
+	}
+	if len(reply) < 12 {
+		return 0, errors.New("ipc: info reply too short")
+	}
+	return binary.BigEndian.Uint64(reply[4:]), nil
+}
+
+// DriverFrame returns the driver frame ID (see it8951.FrameID) the server
+// acknowledged the last display with, to find it in the server logs and
+// metrics, 0 when unknown
+func (c *Client) DriverFrame() uint64 {
+	return c.driverFrame
+}
+
+// DisplayRaw displays a packed buffer covering a panel area
+func (c *Client) DisplayRaw(area image.Rectangle, bpp int, mode it8951.DisplayMode, buffer it8951.DataBuffer) error {
+	return c.display(TypeRaw, rawFields(area, bpp, mode, buffer))
+}
+
+// DisplayGray displays a gray image at its position, in logical coordinates
+func (c *Client) DisplayGray(img *image.Gray, mode it8951.DisplayMode) error {
+	return c.display(TypeGray, grayFields(img, mode))
+}
+
+// DisplayPacked displays a packed frame, sent as it is encoded
+func (c *Client) DisplayPacked(frame *it8951.PackedFrame, mode it8951.DisplayMode) error {
+	fields, err := packedFields(frame, mode)
+	if err != nil {
+		return err
+	}
+	return c.display(TypePacked, fields)
+}
+
+// DisplayRawFrame is DisplayRaw for a frame with an ID, which the server
+// journals once the frame is displayed. Sending it again after an error is
+// safe: it is not displayed twice in a row.
+func (c *Client) DisplayRawFrame(id uint64, area image.Rectangle, bpp int, mode it8951.DisplayMode, buffer it8951.DataBuffer) error {
+	return c.display(TypeFrame, frameFields(id, TypeRaw), rawFields(area, bpp, mode, buffer))
+}
+
+// DisplayGrayFrame is DisplayGray for a frame with an ID (see DisplayRawFrame)
+func (c *Client) DisplayGrayFrame(id uint64, img *image.Gray, mode it8951.DisplayMode) error {
+	return c.display(TypeFrame, frameFields(id, TypeGray), grayFields(img, mode))
+}
+
+// DisplayPackedFrame is DisplayPacked for a frame with an ID (see
+// DisplayRawFrame)
+func (c *Client) DisplayPackedFrame(id uint64, frame *it8951.PackedFrame, mode it8951.DisplayMode) error {
+	fields, err := packedFields(frame, mode)
+	if err != nil {
+		return err
+	}
+	return c.display(TypeFrame, frameFields(id, TypePacked), fields)
+}
+
+// DefineRegion defines a region on the server, or replaces it (see
+// it8951.DefineRegion)
+func (c *Client) DefineRegion(region it8951.Region) error {
+	fields, err := appendName(nil, region.Name)
+	if err != nil {
+		return err
+	}
+	fields = append(appendArea(fields, region.Bounds), uint8(region.Bpp))
+	_, err = c.request(TypeDefine, binary.BigEndian.AppendUint16(fields, uint16(region.Mode)))
+	return err
+}
+
+// RemoveRegion removes a region from the server
+func (c *Client) RemoveRegion(name string) error {
+	fields, err := appendName(nil, name)
+	if err != nil {
+		return err
+	}
+	_, err = c.request(TypeDefine, appendArea(fields, image.Rectangle{}), []byte{0, 0, 0})
+	return err
+}
+
+// DrawRegion displays a gray image in a region defined on the server, its
+// top left corner at the region top left corner, with the region mode and
+// depth (see it8951.DrawRegion)
+func (c *Client) DrawRegion(name string, img *image.Gray) error {
+	fields, err := appendName(nil, name)
+	if err != nil {
+		return err
+	}
+	area := img.Rect
+	fields = binary.BigEndian.AppendUint16(fields, uint16(area.Dx()))
+	fields = binary.BigEndian.AppendUint16(fields, uint16(area.Dy()))
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		fields = append(fields, img.Pix[img.PixOffset(area.Min.X, y):img.PixOffset(area.Max.X, y)]...)
+	}
+	return c.display(TypeRegion, fields)
+}
+
+// rawFields returns the fields of a raw request
+func rawFields(area image.Rectangle, bpp int, mode it8951.DisplayMode, buffer it8951.DataBuffer) []byte {
+	fields := appendArea(nil, area)
+	fields = append(fields, uint8(bpp))
+	fields = binary.BigEndian.AppendUint16(fields, uint16(mode))
+	for _, word := range buffer {
+		fields = binary.LittleEndian.AppendUint16(fields, word)
+	}
+	return fields
+}
+
+// grayFields returns the fields of a gray request
+func grayFields(img *image.Gray, mode it8951.DisplayMode) []byte {
+	area := img.Rect
+	fields := appendArea(nil, area)
+	fields = binary.BigEndian.AppendUint16(fields, uint16(mode))
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		fields = append(fields, img.Pix[img.PixOffset(area.Min.X, y):img.PixOffset(area.Max.X, y)]...)
+	}
+	return fields
+}
+
+// packedFields returns the fields of a packed request
+func packedFields(frame *it8951.PackedFrame, mode it8951.DisplayMode) ([]byte, error) {
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(mode)), data...), nil
+}
+
+// frameFields returns the fields of a frame request up to the type of the
+// request it holds
+func frameFields(id uint64, kind byte) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, id), kind)
+}
+
+// display sends a display request, keeping the driver frame ID of its ack
+func (c *Client) display(kind byte, fields ...[]byte) error {
+	reply, err := c.request(kind, fields...)
+	if err != nil {
+		return err
+	}
+	c.driverFrame = 0
+	if len(reply) >= 8 {
+		c.driverFrame = binary.BigEndian.Uint64(reply)
+	}
+	return nil
+}
+
+// request sends a request and waits for its reply, returning the ack fields
+func (c *Client) request(kind byte, fields ...[]byte) ([]byte, error) {
+	if err := writeMessage(c.conn, kind, fields...); err != nil {
+		return nil, err
+	}
+	reply, replyFields, err := readMessage(c.conn, maxReply)
+	if err != nil {
+		return nil, err
+	}
+	switch reply {
+	case TypeAck:
+		return replyFields, nil
+	case TypeError:
+		return nil, fmt.Errorf("ipc: %s", replyFields)
+	}
+	return nil, fmt.Errorf("ipc: unexpected reply type %#02x", reply)
+}
+
+// appendArea appends the x, y, w, h fields of an area
+func appendArea(fields []byte, area image.Rectangle) []byte {
+	for _, v := range []int{area.Min.X, area.Min.Y, area.Dx(), area.Dy()} {
+		fields = binary.BigEndian.AppendUint16(fields, uint16(v))
+	}
+	return fields
+}
@@ -0,0 +1,238 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Client of the epd-ipc daemon for Node.js, speaking the protocol of the Go
+// ipc package over its Unix socket, with no dependency:
+//
+//	const {Client} = require("./it8951-ipc");
+//	const panel = await Client.connect("/run/it8951.sock");
+//	const {width, height} = await panel.info();
+//	await panel.displayGray(0, 0, width, height, 2, Buffer.alloc(width * height));
+//	panel.close();
+//
+// Mode numbers depend on the panel LUT: the web control panel lists them by
+// waveform under /capabilities (2 is GC16 on most).
+// Display methods resolve to the driver frame ID the server acknowledged the
+// display with, as a BigInt; the *Frame variants carry a frame ID chosen by
+// the caller (not 0), journaled by the server, which acknowledges a frame
+// with the ID of the last one applied without displaying it again.
+// Requests are sent one at a time, in call order.
+"use strict";
+
+const net = require("net");
+
+const TYPE_RAW = 0x01;
+const TYPE_GRAY = 0x02;
+const TYPE_INFO = 0x03;
+const TYPE_FRAME = 0x04;
+const TYPE_PACKED = 0x05;
+const TYPE_REGION = 0x06;
+const TYPE_DEFINE = 0x07;
+const TYPE_ACK = 0x80;
+const TYPE_ERROR = 0x81;
+
+const MAX_REPLY = 64 << 10;
+
+class Client {
+	constructor(socket) {
+		this.socket = socket;
+		this.buffer = Buffer.alloc(0);
+		this.waiting = []; // callbacks of the requests sent, in order
+		this.queue = Promise.resolve();
+		socket.on("data", (data) => {
+			this.buffer = Buffer.concat([this.buffer, data]);
+			this.dispatch();
+		});
+		socket.on("error", (err) => this.fail(err));
+		socket.on("close", () => this.fail(new Error("connection closed by the server")));
+	}
+
+	// connect connects to a server listening on a Unix socket at path
+	static connect(path = "/run/it8951.sock") {
+		return new Promise((resolve, reject) => {
+			const socket = net.createConnection(path);
+			socket.once("connect", () => resolve(new Client(socket)));
+			socket.once("error", reject);
+		});
+	}
+
+	close() {
+		this.socket.end();
+	}
+
+	// info resolves to the panel size
+	async info() {
+		const reply = await this.request(TYPE_INFO, Buffer.alloc(0));
+		if (reply.length < 4) {
+			throw new Error("ipc: info reply too short");
+		}
+		return {width: reply.readUInt16BE(0), height: reply.readUInt16BE(2)};
+	}
+
+	// lastFrame resolves to the ID of the last frame applied, 0n when none
+	async lastFrame() {
+		const reply = await this.request(TYPE_INFO, Buffer.alloc(0));
+		if (reply.length < 12) {
+			throw new Error("ipc: info reply too short");
+		}
+		return reply.readBigUInt64BE(4);
+	}
+
+	// displayRaw displays packed pixels covering a panel area: rows of whole
+	// 16 bit little endian words, first pixel in the lowest bits
+	displayRaw(x, y, w, h, bpp, mode, pixels) {
+		return this.display(TYPE_RAW, raw(x, y, w, h, bpp, mode, pixels));
+	}
+
+	// displayGray displays w*h 8 bit gray pixels at a logical position
+	displayGray(x, y, w, h, mode, pixels) {
+		return this.display(TYPE_GRAY, gray(x, y, w, h, mode, pixels));
+	}
+
+	// displayPacked displays a packed frame, as it8951.PackedFrame encodes it
+	displayPacked(mode, frame) {
+		return this.display(TYPE_PACKED, packed(mode, frame));
+	}
+
+	displayRawFrame(id, x, y, w, h, bpp, mode, pixels) {
+		return this.display(TYPE_FRAME, Buffer.concat([frameHeader(id, TYPE_RAW), raw(x, y, w, h, bpp, mode, pixels)]));
+	}
+
+	displayGrayFrame(id, x, y, w, h, mode, pixels) {
+		return this.display(TYPE_FRAME, Buffer.concat([frameHeader(id, TYPE_GRAY), gray(x, y, w, h, mode, pixels)]));
+	}
+
+	displayPackedFrame(id, mode, frame) {
+		return this.display(TYPE_FRAME, Buffer.concat([frameHeader(id, TYPE_PACKED), packed(mode, frame)]));
+	}
+
+	// defineRegion defines a region of the panel in logical coordinates, or
+	// replaces it
+	async defineRegion(name, x, y, w, h, bpp, mode) {
+		const fields = Buffer.alloc(11);
+		[x, y, w, h].forEach((v, i) => fields.writeUInt16BE(v, 2 * i));
+		fields.writeUInt8(bpp, 8);
+		fields.writeUInt16BE(mode, 9);
+		await this.request(TYPE_DEFINE, Buffer.concat([regionName(name), fields]));
+	}
+
+	// removeRegion removes a region
+	async removeRegion(name) {
+		await this.request(TYPE_DEFINE, Buffer.concat([regionName(name), Buffer.alloc(11)]));
+	}
+
+	// drawRegion displays w*h 8 bit gray pixels at the top left corner of a
+	// region, with the region mode and depth
+	drawRegion(name, w, h, pixels) {
+		const size = Buffer.alloc(4);
+		size.writeUInt16BE(w, 0);
+		size.writeUInt16BE(h, 2);
+		return this.display(TYPE_REGION, Buffer.concat([regionName(name), size, Buffer.from(pixels)]));
+	}
+
+	async display(kind, fields) {
+		const reply = await this.request(kind, fields);
+		return reply.length >= 8 ? reply.readBigUInt64BE(0) : 0n;
+	}
+
+	// request sends a request once the previous ones got their reply, and
+	// resolves to the ack fields
+	request(kind, fields) {
+		const sent = this.queue.then(() => new Promise((resolve, reject) => {
+			this.waiting.push({resolve, reject});
+			const header = Buffer.alloc(5);
+			header.writeUInt32BE(1 + fields.length, 0);
+			header.writeUInt8(kind, 4);
+			this.socket.write(Buffer.concat([header, fields]));
+		}));
+		this.queue = sent.catch(() => {});
+		return sent;
+	}
+
+	// dispatch hands the complete replies received to their requests
+	dispatch() {
+		while (this.buffer.length >= 4) {
+			const size = this.buffer.readUInt32BE(0);
+			if (size === 0 || size > MAX_REPLY) {
+				this.fail(new Error(`ipc: invalid reply size ${size}`));
+				this.socket.destroy();
+				return;
+			}
+			if (this.buffer.length < 4 + size) {
+				return;
+			}
+			const message = this.buffer.subarray(4, 4 + size);
+			this.buffer = this.buffer.subarray(4 + size);
+			const waiting = this.waiting.shift();
+			if (!waiting) {
+				continue;
+			}
+			if (message[0] === TYPE_ACK) {
+				waiting.resolve(message.subarray(1));
+			} else if (message[0] === TYPE_ERROR) {
+				waiting.reject(new Error("ipc: " + message.subarray(1).toString("utf8")));
+			} else {
+				waiting.reject(new Error(`ipc: unexpected reply type 0x${message[0].toString(16)}`));
+			}
+		}
+	}
+
+	// fail rejects the requests waiting for a reply
+	fail(err) {
+		for (const waiting of this.waiting.splice(0)) {
+			waiting.reject(err);
+		}
+	}
+}
+
+function raw(x, y, w, h, bpp, mode, pixels) {
+	const header = Buffer.alloc(11);
+	[x, y, w, h].forEach((v, i) => header.writeUInt16BE(v, 2 * i));
+	header.writeUInt8(bpp, 8);
+	header.writeUInt16BE(mode, 9);
+	return Buffer.concat([header, Buffer.from(pixels)]);
+}
+
+function gray(x, y, w, h, mode, pixels) {
+	const header = Buffer.alloc(10);
+	[x, y, w, h, mode].forEach((v, i) => header.writeUInt16BE(v, 2 * i));
+	return Buffer.concat([header, Buffer.from(pixels)]);
+}
+
+function packed(mode, frame) {
+	const header = Buffer.alloc(2);
+	header.writeUInt16BE(mode, 0);
+	return Buffer.concat([header, Buffer.from(frame)]);
+}
+
+function regionName(name) {
+	const encoded = Buffer.from(name, "utf8");
+	if (encoded.length === 0 || encoded.length > 255) {
+		throw new Error(`ipc: region name of ${encoded.length} bytes, expecting 1 to 255`);
+	}
+	return Buffer.concat([Buffer.from([encoded.length]), encoded]);
+}
+
+function frameHeader(id, kind) {
+	const header = Buffer.alloc(9);
+	header.writeBigUInt64BE(BigInt(id), 0);
+	header.writeUInt8(kind, 8);
+	return header;
+}
+
+module.exports = {Client};
@@ -0,0 +1,162 @@
+#   it8951,
+#   Copyright (C) 2024  Phil Hilger
+#
+#   This program is free software: you can redistribute it and/or modify
+#   it under the terms of the GNU General Public License as published by
+#   the Free Software Foundation, either version 3 of the License, or
+#   (at your option) any later version.
+#
+#   This program is distributed in the hope that it will be useful,
+#   but WITHOUT ANY WARRANTY; without even the implied warranty of
+#   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+#   GNU General Public License for more details.
+#
+#   You should have received a copy of the GNU General Public License
+#   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+"""Client of the epd-ipc daemon, speaking the protocol of the Go ipc package
+over its Unix socket, with the standard library only:
+
+    with Client("/run/it8951.sock") as panel:
+        width, height = panel.info()
+        panel.display_gray(0, 0, width, height, 2, bytes(width * height))
+
+Areas are x, y, w, h. Mode numbers depend on the panel LUT: the web control
+panel lists them by waveform under /capabilities (2 is GC16 on most). Display methods return the driver frame ID the server
+acknowledged the display with; the *_frame variants carry a frame ID chosen
+by the caller (not 0), journaled by the server, which acknowledges a frame
+with the ID of the last one applied without displaying it again.
+"""
+
+import socket
+import struct
+
+TYPE_RAW = 0x01
+TYPE_GRAY = 0x02
+TYPE_INFO = 0x03
+TYPE_FRAME = 0x04
+TYPE_PACKED = 0x05
+TYPE_REGION = 0x06
+TYPE_DEFINE = 0x07
+TYPE_ACK = 0x80
+TYPE_ERROR = 0x81
+
+MAX_REPLY = 64 << 10
+
+
+class IPCError(Exception):
+    """Error reply of the server, or malformed reply"""
+
+
+class Client:
+    """Connection to the server. It is not safe for concurrent use."""
+
+    def __init__(self, path="/run/it8951.sock"):
+        self.sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        self.sock.connect(path)
+
+    def close(self):
+        self.sock.close()
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        self.close()
+
+    def info(self):
+        """Returns the panel width and height"""
+        reply = self._request(TYPE_INFO, b"")
+        if len(reply) < 4:
+            raise IPCError("info reply too short")
+        return struct.unpack(">HH", reply[:4])
+
+    def last_frame(self):
+        """Returns the ID of the last frame applied, 0 when none"""
+        reply = self._request(TYPE_INFO, b"")
+        if len(reply) < 12:
+            raise IPCError("info reply too short")
+        return struct.unpack(">Q", reply[4:12])[0]
+
+    def display_raw(self, x, y, w, h, bpp, mode, pixels):
+        """Displays packed pixels covering a panel area: rows of whole 16 bit
+        little endian words, first pixel in the lowest bits"""
+        return self._display(TYPE_RAW, _raw(x, y, w, h, bpp, mode, pixels))
+
+    def display_gray(self, x, y, w, h, mode, pixels):
+        """Displays w*h 8 bit gray pixels at a logical position"""
+        return self._display(TYPE_GRAY, _gray(x, y, w, h, mode, pixels))
+
+    def display_packed(self, mode, frame):
+        """Displays a packed frame, as it8951.PackedFrame encodes it"""
+        return self._display(TYPE_PACKED, _packed(mode, frame))
+
+    def display_raw_frame(self, frame_id, x, y, w, h, bpp, mode, pixels):
+        return self._display(TYPE_FRAME, _frame(frame_id, TYPE_RAW) + _raw(x, y, w, h, bpp, mode, pixels))
+
+    def display_gray_frame(self, frame_id, x, y, w, h, mode, pixels):
+        return self._display(TYPE_FRAME, _frame(frame_id, TYPE_GRAY) + _gray(x, y, w, h, mode, pixels))
+
+    def display_packed_frame(self, frame_id, mode, frame):
+        return self._display(TYPE_FRAME, _frame(frame_id, TYPE_PACKED) + _packed(mode, frame))
+
+    def define_region(self, name, x, y, w, h, bpp, mode):
+        """Defines a region of the panel in logical coordinates, or replaces it"""
+        self._request(TYPE_DEFINE, _name(name) + struct.pack(">HHHHBH", x, y, w, h, bpp, mode))
+
+    def remove_region(self, name):
+        """Removes a region"""
+        self._request(TYPE_DEFINE, _name(name) + bytes(11))
+
+    def draw_region(self, name, w, h, pixels):
+        """Displays w*h 8 bit gray pixels at the top left corner of a region,
+        with the region mode and depth"""
+        return self._display(TYPE_REGION, _name(name) + struct.pack(">HH", w, h) + bytes(pixels))
+
+    def _display(self, kind, fields):
+        reply = self._request(kind, fields)
+        return struct.unpack(">Q", reply[:8])[0] if len(reply) >= 8 else 0
+
+    def _request(self, kind, fields):
+        self.sock.sendall(struct.pack(">IB", 1 + len(fields), kind) + fields)
+        size = struct.unpack(">I", self._read(4))[0]
+        if size == 0 or size > MAX_REPLY:
+            raise IPCError("invalid reply size %d" % size)
+        message = self._read(size)
+        if message[0] == TYPE_ACK:
+            return message[1:]
+        if message[0] == TYPE_ERROR:
+            raise IPCError(message[1:].decode("utf-8", "replace"))
+        raise IPCError("unexpected reply type %#04x" % message[0])
+
+    def _read(self, n):
+        data = b""
+        while len(data) < n:
+            chunk = self.sock.recv(n - len(data))
+            if not chunk:
+                raise ConnectionError("connection closed by the server")
+            data += chunk
+        return data
+
+
+def _raw(x, y, w, h, bpp, mode, pixels):
+    return struct.pack(">HHHHBH", x, y, w, h, bpp, mode) + bytes(pixels)
+
+
+def _gray(x, y, w, h, mode, pixels):
+    return struct.pack(">HHHHH", x, y, w, h, mode) + bytes(pixels)
+
+
+def _packed(mode, frame):
+    return struct.pack(">H", mode) + bytes(frame)
+
+
+def _name(name):
+    encoded = name.encode("utf-8")
+    if not 0 < len(encoded) < 256:
+        raise IPCError("region name of %d bytes, expecting 1 to 255" % len(encoded))
+    return struct.pack(">B", len(encoded)) + encoded
+
+
+def _frame(frame_id, kind):
+    return struct.pack(">QB", frame_id, kind)
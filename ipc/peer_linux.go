@@ -0,0 +1,47 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package ipc
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// peerName names the client of a connection by the user ID of its process
+// for Unix sockets, so that a client reconnecting is still the same client,
+// by its host otherwise
+func peerName(conn net.Conn) string {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return remoteHost(conn)
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return "unix"
+	}
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return "unix"
+	}
+	return "uid " + strconv.FormatUint(uint64(cred.Uid), 10)
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package ipc
+
+import "net"
+
+// peerName names the client of a connection by its host. Peer credentials
+// are only read on Linux: elsewhere, the clients of a Unix socket are all
+// one client.
+func peerName(conn net.Conn) string {
+	if _, ok := conn.(*net.UnixConn); ok {
+		return "unix"
+	}
+	return remoteHost(conn)
+}
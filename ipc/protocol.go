@@ -0,0 +1,160 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package ipc serves an IT8951 panel to local programs over a Unix socket,
+// with a small binary protocol that is easy to speak from any language and
+// cheaper than HTTP.
+//
+// Every message, both ways, is a 4 byte big endian length followed by that
+// many bytes: a type byte, then its fields, big endian. Requests:
+//
+//	0x01 raw:  x, y, w, h uint16, bpp uint8, mode uint16, packed pixels
+//	           (panel coordinates; rows of whole 16 bit little endian words,
+//	           first pixel in the lowest bits, as it8951.PackImage makes them)
+//	0x02 gray: x, y, w, h uint16, mode uint16, w*h 8 bit gray pixels
+//	           (logical coordinates; converted and dithered by the driver)
+//	0x03 info: no field
+//	0x04 frame: id uint64, then a raw, gray or packed request (type and
+//	            fields)
+//	0x05 packed: mode uint16, a packed frame as it8951.PackedFrame encodes it
+//	             (panel size, area, bpp, rotation, pixels compressed
+//	             with deflate, zstd or LZ4, CRC)
+//	0x06 region: name length uint8, name, w, h uint16, w*h 8 bit gray pixels
+//	             (drawn at the top left corner of a region defined on the
+//	             server, with its mode and depth, see it8951.DrawRegion)
+//	0x07 define: name length uint8, name, x, y, w, h uint16, bpp uint8,
+//	             mode uint16 (logical coordinates, see it8951.DefineRegion;
+//	             an empty area removes the region)
+//
+// Frames carry an ID chosen by the sender (not 0), e.g. a sequence number or
+// a hash, which the server journals once the frame is displayed. A frame with
+// the ID of the last one applied is acknowledged without being displayed
+// again, so senders can retry frames whose ack was lost, and compare the ID
+// reported by info with the last frame they sent.
+//
+// Requests are rejected with an error reply when their mode is not in the
+// panel LUT, their area is empty or off the panel, or, at 1bpp, when x and w
+// are not multiples of 8, when the client sends more than the server allows
+// (see Server.Allow) or is not granted the request (see Server.Grant). Requests over MessageLimit close the connection.
+//
+// Each request gets one reply:
+//
+//	0x80 ack:   for info, panel width and height uint16, the ID of the
+//	            last frame applied uint64 (0 when none) and the driver frame
+//	            ID uint64; for displays, the driver frame ID uint64; for
+//	            define, no field
+//	0x81 error: UTF-8 message
+//
+// Driver frame IDs number every display of the server driver (see
+// it8951.FrameID), to find a frame in the server logs and metrics.
+//
+// Besides Client, the clients directory holds clients for Python
+// (clients/python/it8951_ipc.py) and Node.js (clients/js/it8951-ipc.js),
+// with no dependency, to copy into projects not written in Go.
+package ipc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// Message types
+const (
+	TypeRaw    byte = 0x01
+	TypeGray   byte = 0x02
+	TypeInfo   byte = 0x03
+	TypeFrame  byte = 0x04
+	TypePacked byte = 0x05
+	TypeRegion byte = 0x06
+	TypeDefine byte = 0x07
+	TypeAck    byte = 0x80
+	TypeError  byte = 0x81
+)
+
+// maxReply is the largest reply a client accepts
+const maxReply = 64 << 10
+
+// MessageLimit returns the largest request a server accepts for a panel of
+// the given size: a full 8bpp frame in either orientation, as gray pixels or
+// packed (with the worst overhead of its compressions, LZ4's), and its
+// headers
+func MessageLimit(panel image.Point) int {
+	frame := 2 * max(it8951.GetWidthInWords(panel.X, 8)*panel.Y, it8951.GetWidthInWords(panel.Y, 8)*panel.X)
+	return frame + frame/255 + 256
+}
+
+// ErrTooLarge is returned for messages over the size limit
+var ErrTooLarge = errors.New("ipc: message too large")
+
+// readMessage reads a message of at most limit bytes, returning its type
+// and fields
+func readMessage(r io.Reader, limit int) (byte, []byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return 0, nil, err
+	}
+	if size == 0 {
+		return 0, nil, fmt.Errorf("ipc: empty message")
+	}
+	if int64(size) > int64(limit) {
+		return 0, nil, ErrTooLarge
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return 0, nil, err
+	}
+	return message[0], message[1:], nil
+}
+
+// readName reads the name at the start of region requests, returning it and
+// the fields after it
+func readName(fields []byte) (string, []byte, error) {
+	if len(fields) < 1 || len(fields) < 1+int(fields[0]) {
+		return "", nil, errors.New("region name too short")
+	}
+	return string(fields[1 : 1+fields[0]]), fields[1+fields[0]:], nil
+}
+
+// appendName appends the name field of region requests
+func appendName(fields []byte, name string) ([]byte, error) {
+	if name == "" || len(name) > 255 {
+		return nil, fmt.Errorf("ipc: region name of %d bytes, expecting 1 to 255", len(name))
+	}
+	return append(append(fields, uint8(len(name))), name...), nil
+}
+
+// writeMessage writes a message of the given type and fields
+func writeMessage(w io.Writer, kind byte, fields ...[]byte) error {
+	size := 1
+	for _, field := range fields {
+		size += len(field)
+	}
+	message := make([]byte, 0, 4+size)
+	message = binary.BigEndian.AppendUint32(message, uint32(size))
+	message = append(message, kind)
+	for _, field := range fields {
+		message = append(message, field...)
+	}
+	_, err := w.Write(message)
+	return err
+}
@@ -0,0 +1,382 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package ipc
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"net"
+	"os"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// Server serves a panel, initialized by the caller, to socket clients. The
+// requests of all clients go through Display.Do, one at a time.
+type Server struct {
+	Display *it8951.Display
+	// Journal is the file keeping the ID of the last frame applied across
+	// restarts, in the driver store (see it8951.SetStore), kept in memory
+	// only when empty
+	Journal string
+
+	// MaxClients is the number of clients served at once, 4 when 0. Others
+	// get an error reply and are disconnected.
+	MaxClients int
+
+	// Allow, when set, tells whether a client may send another request,
+	// e.g. to limit the rate of each one. Clients are named by the user ID
+	// of their process ("uid 1000") on Linux. Requests it refuses get an
+	// error reply without being run.
+	Allow func(client string) bool
+
+	// Grant, when set, tells whether a client may send requests of a type,
+	// region naming the region of region and define requests (empty for
+	// others). Requests it refuses get an error reply without being run.
+	Grant func(client string, kind byte, region string) bool
+
+	lastFrame  uint64 // ID of the last frame applied, accessed within Display.Do
+	lastDriver uint64 // driver frame ID it was displayed as
+	limit      int    // largest request, see MessageLimit
+}
+
+// ListenAndServe serves clients on a Unix socket at path, replacing a
+// socket file left by a previous run
+func (s *Server) ListenAndServe(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves the clients of a listener until it is closed
+func (s *Server) Serve(listener net.Listener) error {
+	defer listener.Close()
+	if s.Journal != "" {
+		data, err := it8951.CurrentStore().Load(s.Journal)
+		switch {
+		case err == nil && len(data) == 8:
+			s.lastFrame = binary.BigEndian.Uint64(data)
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+	}
+	s.Display.Do(func() error {
+		s.limit = MessageLimit(it8951.DeviceInfo().Bounds().Size())
+		return nil
+	})
+	clients := make(chan struct{}, cmp.Or(s.MaxClients, 4))
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		select {
+		case clients <- struct{}{}:
+		default:
+			writeMessage(conn, TypeError, []byte("too many clients"))
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer func() { <-clients }()
+			s.serve(conn)
+		}()
+	}
+}
+
+// serve handles the requests of a client until it disconnects
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	client := peerName(conn)
+	for {
+		kind, fields, err := readMessage(conn, s.limit)
+		if err != nil {
+			if errors.Is(err, ErrTooLarge) {
+				writeMessage(conn, TypeError, []byte("message too large"))
+			}
+			return
+		}
+		if s.Allow != nil && !s.Allow(client) {
+			if writeMessage(conn, TypeError, []byte("too many requests")) != nil {
+				return
+			}
+			continue
+		}
+		if s.Grant != nil && !s.Grant(client, kind, requestRegion(kind, fields)) {
+			if writeMessage(conn, TypeError, []byte("request not granted")) != nil {
+				return
+			}
+			continue
+		}
+		var reply []byte
+		err = s.Display.Do(func() error {
+			reply, err = s.handle(kind, fields)
+			return err
+		})
+		if err != nil {
+			err = writeMessage(conn, TypeError, []byte(err.Error()))
+		} else {
+			err = writeMessage(conn, TypeAck, reply)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// remoteHost names the client of a connection by the host of its address,
+// the same for all its connections
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// handle runs a request, returning the fields of the ack
+func (s *Server) handle(kind byte, fields []byte) ([]byte, error) {
+	var err error
+	switch kind {
+	case TypeRaw:
+		err = displayRaw(fields)
+	case TypeGray:
+		err = displayGray(fields)
+	case TypeInfo:
+		bounds := it8951.DeviceInfo().Bounds()
+		reply := binary.BigEndian.AppendUint16(nil, uint16(bounds.Dx()))
+		reply = binary.BigEndian.AppendUint16(reply, uint16(bounds.Dy()))
+		reply = binary.BigEndian.AppendUint64(reply, s.lastFrame)
+		return binary.BigEndian.AppendUint64(reply, it8951.FrameID()), nil
+	case TypeFrame:
+		return s.displayFrame(fields)
+	case TypePacked:
+		err = displayPacked(fields)
+	case TypeRegion:
+		err = drawRegion(fields)
+	case TypeDefine:
+		return nil, defineRegion(fields)
+	default:
+		return nil, fmt.Errorf("unknown message type %#02x", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return binary.BigEndian.AppendUint64(nil, it8951.FrameID()), nil
+}
+
+// requestRegion returns the region named by region and define requests,
+// empty for others
+func requestRegion(kind byte, fields []byte) string {
+	if kind != TypeRegion && kind != TypeDefine {
+		return ""
+	}
+	name, _, _ := readName(fields)
+	return name
+}
+
+// displayFrame displays a frame unless it is the last one applied, then
+// journals its ID. The ack holds the driver frame ID it was displayed as,
+// 0 when applied before a restart.
+func (s *Server) displayFrame(fields []byte) ([]byte, error) {
+	if len(fields) < 9 {
+		return nil, errors.New("frame message too short")
+	}
+	id, kind := binary.BigEndian.Uint64(fields), fields[8]
+	if kind != TypeRaw && kind != TypeGray && kind != TypePacked {
+		return nil, fmt.Errorf("frame of unexpected type %#02x", kind)
+	}
+	if id != s.lastFrame {
+		if _, err := s.handle(kind, fields[9:]); err != nil {
+			return nil, err
+		}
+		s.lastFrame, s.lastDriver = id, it8951.FrameID()
+		it8951.Debug("ipc frame %d displayed as frame %d", id, s.lastDriver)
+		if err := s.journal(); err != nil {
+			return nil, err
+		}
+	}
+	return binary.BigEndian.AppendUint64(nil, s.lastDriver), nil
+}
+
+// journal saves the ID of the last frame applied, the store never leaving it
+// half written
+func (s *Server) journal() error {
+	if s.Journal == "" {
+		return nil
+	}
+	return it8951.CurrentStore().Save(s.Journal, binary.BigEndian.AppendUint64(nil, s.lastFrame))
+}
+
+// area reads the x, y, w, h fields at the start of a request
+func area(fields []byte) image.Rectangle {
+	return it8951.Rect(binary.BigEndian.Uint16(fields), binary.BigEndian.Uint16(fields[2:]),
+		binary.BigEndian.Uint16(fields[4:]), binary.BigEndian.Uint16(fields[6:]))
+}
+
+// checkMode returns an error unless mode is in the panel LUT
+func checkMode(mode it8951.DisplayMode) error {
+	if !it8951.DeviceInfo().Firmware().Modes.Has(mode) {
+		return fmt.Errorf("unknown mode %d", mode)
+	}
+	return nil
+}
+
+// checkArea returns an error unless area is a non empty area of the panel,
+// aligned as Write1bppRect needs it at 1bpp
+func checkArea(area image.Rectangle, bpp int) error {
+	if area.Empty() {
+		return fmt.Errorf("empty area %v", area)
+	}
+	if !area.In(it8951.DeviceInfo().Bounds()) {
+		return fmt.Errorf("area %v off the panel", area)
+	}
+	if bpp == 1 && (area.Min.X%8 != 0 || area.Dx()%8 != 0) {
+		return fmt.Errorf("1bpp area %v: x and w must be multiples of 8", area)
+	}
+	return nil
+}
+
+// displayRaw displays packed pixels
+func displayRaw(fields []byte) error {
+	if len(fields) < 11 {
+		return errors.New("raw message too short")
+	}
+	rect := area(fields)
+	bpp := int(fields[8])
+	mode := it8951.DisplayMode(binary.BigEndian.Uint16(fields[9:]))
+	pixels := fields[11:]
+	switch bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("unsupported bpp %d", bpp)
+	}
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	if err := checkArea(rect, bpp); err != nil {
+		return err
+	}
+	words := it8951.GetWidthInWords(rect.Dx(), bpp) * rect.Dy()
+	if len(pixels) != 2*words {
+		return fmt.Errorf("%d bytes of pixels instead of %d", len(pixels), 2*words)
+	}
+	targetAddress := it8951.DeviceInfo().TargetAddress()
+	if bpp == 1 {
+		buffer := make(it8951.DataBuffer, words)
+		for i := range buffer {
+			buffer[i] = binary.LittleEndian.Uint16(pixels[2*i:])
+		}
+		return it8951.Refresh1bppRect(buffer, rect, mode, targetAddress, true, it8951.Rotate0)
+	}
+	x, y, w, h := uint16(rect.Min.X), uint16(rect.Min.Y), uint16(rect.Dx()), uint16(rect.Dy())
+	if err := it8951.WriteAreaBytes(x, y, w, h, bpp, pixels); err != nil {
+		return err
+	}
+	return it8951.DisplayRectBuffer(rect, mode, targetAddress)
+}
+
+// displayPacked displays a packed frame
+func displayPacked(fields []byte) error {
+	if len(fields) < 2 {
+		return errors.New("packed message too short")
+	}
+	mode := it8951.DisplayMode(binary.BigEndian.Uint16(fields))
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	frame := &it8951.PackedFrame{}
+	if err := frame.UnmarshalBinary(fields[2:]); err != nil {
+		return err
+	}
+	return frame.Display(mode)
+}
+
+// displayGray displays 8 bit gray pixels
+func displayGray(fields []byte) error {
+	if len(fields) < 10 {
+		return errors.New("gray message too short")
+	}
+	rect := area(fields)
+	mode := it8951.DisplayMode(binary.BigEndian.Uint16(fields[8:]))
+	pixels := fields[10:]
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	if rect.Empty() {
+		return fmt.Errorf("empty area %v", rect)
+	}
+	if len(pixels) != rect.Dx()*rect.Dy() {
+		return fmt.Errorf("%d pixels instead of %d", len(pixels), rect.Dx()*rect.Dy())
+	}
+	gray := &image.Gray{Pix: pixels, Stride: rect.Dx(), Rect: rect}
+	return it8951.DrawImage(gray, uint16(rect.Min.X), uint16(rect.Min.Y), 4, mode, it8951.Rotate0)
+}
+
+// drawRegion draws 8 bit gray pixels in a region
+func drawRegion(fields []byte) error {
+	name, fields, err := readName(fields)
+	if err != nil {
+		return err
+	}
+	if len(fields) < 4 {
+		return errors.New("region message too short")
+	}
+	w, h := int(binary.BigEndian.Uint16(fields)), int(binary.BigEndian.Uint16(fields[2:]))
+	pixels := fields[4:]
+	if w == 0 || h == 0 {
+		return fmt.Errorf("empty image %dx%d", w, h)
+	}
+	if len(pixels) != w*h {
+		return fmt.Errorf("%d pixels instead of %d", len(pixels), w*h)
+	}
+	return it8951.DrawRegion(name, &image.Gray{Pix: pixels, Stride: w, Rect: image.Rect(0, 0, w, h)})
+}
+
+// defineRegion defines a region, or removes it when its area is empty
+func defineRegion(fields []byte) error {
+	name, fields, err := readName(fields)
+	if err != nil {
+		return err
+	}
+	if len(fields) < 11 {
+		return errors.New("define message too short")
+	}
+	rect := area(fields)
+	if rect.Empty() {
+		it8951.RemoveRegion(name)
+		return nil
+	}
+	mode := it8951.DisplayMode(binary.BigEndian.Uint16(fields[9:]))
+	if err := checkMode(mode); err != nil {
+		return err
+	}
+	return it8951.DefineRegion(it8951.Region{Name: name, Bounds: rect, Mode: mode, Bpp: int(fields[8])})
+}
@@ -0,0 +1,105 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Label is a line of text displayed in a fixed area, which is updated by
+// refreshing only the character cells that changed, e.g. for counters or
+// temperatures
+type Label struct {
+	Bounds image.Rectangle // in logical coordinates
+	Face   font.Face
+	Mode   DisplayMode // waveform used for updates
+
+	frame *image.Gray // label as last displayed
+}
+
+var (
+	labels = map[string]*Label{}
+)
+
+// DefineLabel adds or replaces a label, blank until its first update. As for
+// regions, bounds falling on multiples of 4 pixels along the panel rows keep
+// updates from repainting the edge of neighbouring content.
+func DefineLabel(id string, bounds image.Rectangle, face font.Face, mode DisplayMode) {
+	Debug("Define label %s %v", id, bounds)
+	labels[id] = &Label{Bounds: bounds, Face: face, Mode: mode}
+}
+
+// UpdateLabel displays a new text in a label, left aligned in black on the
+// background gray. Only the columns of the label where the rendered text
+// changed are refreshed, over the label height.
+func UpdateLabel(id, text string) error {
+	label, ok := labels[id]
+	if !ok {
+		return fmt.Errorf("it8951: unknown label %s", id)
+	}
+	frame := label.render(text)
+	changed := frame.Rect
+	if label.frame != nil {
+		changed = diffColumns(label.frame, frame)
+	}
+	label.frame = frame
+	if changed.Empty() {
+		return nil
+	}
+	Debug("Label %s: refreshing %v", id, changed)
+	return displayImage(frame, changed, 4, label.Mode)
+}
+
+// render draws text on a new image of the label bounds
+func (label *Label) render(text string) *image.Gray {
+	frame := image.NewGray(label.Bounds)
+	draw.Draw(frame, frame.Rect, image.NewUniform(color.Gray{Y: config.Background}), image.Point{}, draw.Src)
+	drawer := font.Drawer{
+		Dst:  frame,
+		Src:  image.Black,
+		Face: label.Face,
+		Dot:  fixed.P(label.Bounds.Min.X, label.Bounds.Min.Y+label.Face.Metrics().Ascent.Ceil()),
+	}
+	drawer.DrawString(VisualText(text))
+	return frame
+}
+
+// diffColumns returns the columns where two images of the same bounds
+// differ, over their whole height
+func diffColumns(a, b *image.Gray) image.Rectangle {
+	bounds := a.Rect
+	first, last := bounds.Max.X, bounds.Min.X-1
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if a.Pix[a.PixOffset(x, y)] != b.Pix[b.PixOffset(x, y)] {
+				first, last = min(first, x), max(last, x)
+			}
+		}
+	}
+	if last < first {
+		return image.Rectangle{}
+	}
+	return image.Rect(first, bounds.Min.Y, last+1, bounds.Max.Y)
+}
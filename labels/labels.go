@@ -0,0 +1,238 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package labels renders batches of shelf or price labels: a template lays
+// out text fields, filled from records read from CSV or JSON, and every
+// record gives a packed frame, to be displayed or saved in the it8951 frame
+// format (e.g. for labels flashed later by another device).
+//
+// Templates are JSON files:
+//
+//	{
+//		"width": 800, "height": 480, "bpp": 2,
+//		"background": "shelf.png",
+//		"fields": [
+//			{"text": "{{.name}}", "box": [16, 16, 768, 96], "font": "Inter-Bold.ttf", "size": 56},
+//			{"text": "{{.price}} €", "box": [16, 300, 768, 160], "font": "Inter-Bold.ttf", "size": 120, "align": "bottomright"}
+//		]
+//	}
+//
+// Field texts are text/template templates executed with the record, whose
+// values are all strings. Paths are relative to the template file.
+package labels
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// Template lays out the fields of a label
+type Template struct {
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Bpp        int     `json:"bpp"`        // 4 when 0
+	Background string  `json:"background"` // image drawn under the fields, if any
+	Fields     []Field `json:"fields"`
+
+	background image.Image
+}
+
+// Field is a text drawn in a box of the label
+type Field struct {
+	Text  string `json:"text"`  // text/template executed with the record
+	Box   [4]int `json:"box"`   // x, y, width, height
+	Font  string `json:"font"`  // TrueType or OpenType font, basic 7x13 when empty
+	Size  int    `json:"size"`  // font size in points at 72dpi (pixels)
+	Align string `json:"align"` // topleft (default), top, ..., center, ..., bottomright
+	Gray  uint8  `json:"gray"`  // text gray, black by default
+
+	text *template.Template
+	face font.Face
+}
+
+// Record holds the values of a label, by field name
+type Record map[string]string
+
+// aligns are the anchors by align name
+var aligns = map[string]it8951.Anchor{
+	"":            it8951.TopLeft,
+	"topleft":     it8951.TopLeft,
+	"top":         it8951.Top,
+	"topright":    it8951.TopRight,
+	"left":        it8951.Left,
+	"center":      it8951.Center,
+	"right":       it8951.Right,
+	"bottomleft":  it8951.BottomLeft,
+	"bottom":      it8951.Bottom,
+	"bottomright": it8951.BottomRight,
+}
+
+// LoadTemplate reads a template file, with its fonts and background
+func LoadTemplate(path string) (*Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("labels: %s: %v", path, err)
+	}
+	if err := t.load(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("labels: %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// load checks the template and loads what it refers to, relative to dir
+func (t *Template) load(dir string) error {
+	if t.Width <= 0 || t.Height <= 0 {
+		return fmt.Errorf("bad label size %dx%d", t.Width, t.Height)
+	}
+	switch t.Bpp {
+	case 0:
+		t.Bpp = 4
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("unsupported bpp %d", t.Bpp)
+	}
+	if t.Background != "" {
+		img, _, err := it8951.DecodeFile(filepath.Join(dir, t.Background))
+		if err != nil {
+			return err
+		}
+		t.background = img
+	}
+	fonts := map[string]*sfnt.Font{}
+	for i := range t.Fields {
+		field := &t.Fields[i]
+		text, err := template.New(fmt.Sprint("field ", i)).Option("missingkey=error").Parse(field.Text)
+		if err != nil {
+			return err
+		}
+		field.text = text
+		if _, ok := aligns[strings.ToLower(field.Align)]; !ok {
+			return fmt.Errorf("field %d: unknown align %q", i, field.Align)
+		}
+		if field.Font == "" {
+			continue
+		}
+		f, ok := fonts[field.Font]
+		if !ok {
+			if f, err = it8951.LoadFont(filepath.Join(dir, field.Font)); err != nil {
+				return err
+			}
+			fonts[field.Font] = f
+		}
+		if field.face, err = it8951.NewFace(f, float64(field.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render draws the label of a record, with the label origin at the origin
+func (t *Template) Render(record Record) (*image.Gray, error) {
+	label, err := t.render(record)
+	if err != nil {
+		return nil, fmt.Errorf("labels: %w", err)
+	}
+	return label, nil
+}
+
+// render is Render, with errors not prefixed
+func (t *Template) render(record Record) (*image.Gray, error) {
+	bounds := image.Rect(0, 0, t.Width, t.Height)
+	label := image.NewGray(bounds)
+	draw.Draw(label, bounds, image.NewUniform(color.Gray{Y: it8951.CurrentConfig().Background}), image.Point{}, draw.Src)
+	if t.background != nil {
+		draw.Draw(label, bounds, t.background, t.background.Bounds().Min, draw.Src)
+	}
+	for _, field := range t.Fields {
+		var text strings.Builder
+		if err := field.text.Execute(&text, record); err != nil {
+			return nil, err
+		}
+		box := image.Rect(field.Box[0], field.Box[1], field.Box[0]+field.Box[2], field.Box[1]+field.Box[3])
+		rendered := it8951.RenderText(text.String(), box, it8951.TextOptions{
+			Face:  field.face,
+			Align: aligns[strings.ToLower(field.Align)],
+			Color: color.Gray{Y: field.Gray},
+		})
+		draw.Draw(label, box, rendered, box.Min, draw.Src)
+	}
+	return label, nil
+}
+
+// Frame renders the label of a record as a frame packed for a panel of the
+// given size, the label at its origin
+func (t *Template) Frame(record Record, panel image.Point) (*it8951.PackedFrame, error) {
+	frame, err := t.frame(record, panel)
+	if err != nil {
+		return nil, fmt.Errorf("labels: %w", err)
+	}
+	return frame, nil
+}
+
+// frame is Frame, with errors not prefixed
+func (t *Template) frame(record Record, panel image.Point) (*it8951.PackedFrame, error) {
+	label, err := t.render(record)
+	if err != nil {
+		return nil, err
+	}
+	// rows start on whole words at 1bpp
+	area := label.Rect
+	area.Max.X = (area.Max.X + 15) &^ 15
+	area = area.Intersect(image.Rectangle{Max: panel})
+	if area.Empty() {
+		return nil, fmt.Errorf("%dx%d label does not fit a %v panel", t.Width, t.Height, panel)
+	}
+	return &it8951.PackedFrame{
+		Panel:       panel,
+		Area:        area,
+		Bpp:         t.Bpp,
+		Compression: it8951.CompressDeflate,
+		Pixels:      it8951.PackImage(label, area, t.Bpp),
+	}, nil
+}
+
+// Batch renders the frames of records in turn, passing each to fn with its
+// index, e.g. to display or save it. It stops at the first error.
+func (t *Template) Batch(records []Record, panel image.Point, fn func(i int, frame *it8951.PackedFrame) error) error {
+	for i, record := range records {
+		frame, err := t.frame(record, panel)
+		if err != nil {
+			return fmt.Errorf("labels: record %d: %w", i+1, err)
+		}
+		if err := fn(i, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
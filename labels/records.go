@@ -0,0 +1,88 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package labels
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadCSV reads records from CSV, the first row naming the fields
+func ReadCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("labels: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	header := rows[0]
+	records := make([]Record, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := Record{}
+		for i, name := range header {
+			record[name] = row[i]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ReadJSON reads records from a JSON array of objects, whose values are
+// converted to strings
+func ReadJSON(r io.Reader) ([]Record, error) {
+	var objects []map[string]any
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber() // numbers keep their text, e.g. prices
+	if err := decoder.Decode(&objects); err != nil {
+		return nil, fmt.Errorf("labels: %v", err)
+	}
+	records := make([]Record, 0, len(objects))
+	for _, object := range objects {
+		record := Record{}
+		for name, value := range object {
+			if value != nil {
+				record[name] = fmt.Sprint(value)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ReadRecords reads a records file, in JSON when its extension is .json and
+// in CSV otherwise
+func ReadRecords(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ReadJSON(file)
+	}
+	return ReadCSV(file)
+}
@@ -0,0 +1,118 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Anchor is the point of a box placed against the same point of its container
+type Anchor uint8
+
+// Anchors
+const (
+	TopLeft Anchor = iota
+	Top
+	TopRight
+	Left
+	Center
+	Right
+	BottomLeft
+	Bottom
+	BottomRight
+)
+
+// layoutStep is the alignment of placed boxes along the panel rows, in pixels:
+// one word at 4bpp
+const layoutStep = 4
+
+// Margins are distances from the panel edges, in logical coordinates
+type Margins struct {
+	Top, Right, Bottom, Left int
+}
+
+var (
+	safeMargins Margins
+)
+
+// SetSafeArea sets the margins kept free by Place and PlaceText, e.g. for
+// the part of the panel hidden by an enclosure bezel
+func SetSafeArea(m Margins) {
+	safeMargins = m
+}
+
+// SafeArea returns the logical panel area inside the safe area margins
+func SafeArea() image.Rectangle {
+	r := orientation.LogicalBounds(DeviceInfo().Bounds())
+	r.Min.X += safeMargins.Left
+	r.Min.Y += safeMargins.Top
+	r.Max.X -= safeMargins.Right
+	r.Max.Y -= safeMargins.Bottom
+	return r.Canon()
+}
+
+// Place returns the position of a box of the given size anchored within a
+// container clipped to the safe area. The box start is moved back to a
+// multiple of 4 pixels along the panel rows, so that partial refreshes of the
+// box do not spill over its neighbours.
+func Place(size image.Point, anchor Anchor, within image.Rectangle) image.Rectangle {
+	within = within.Intersect(SafeArea())
+	var at image.Point
+	switch anchor % 3 { // column
+	case 0:
+		at.X = within.Min.X
+	case 1:
+		at.X = within.Min.X + (within.Dx()-size.X)/2
+	default:
+		at.X = within.Max.X - size.X
+	}
+	switch anchor / 3 { // row
+	case 0:
+		at.Y = within.Min.Y
+	case 1:
+		at.Y = within.Min.Y + (within.Dy()-size.Y)/2
+	default:
+		at.Y = within.Max.Y - size.Y
+	}
+	return snap(image.Rectangle{Min: at, Max: at.Add(size)})
+}
+
+// PlaceText places a line of text like Place, the box spanning from the face
+// ascent to its descent, and returns the box with the dot (baseline origin)
+// to draw the text from
+func PlaceText(face font.Face, text string, anchor Anchor, within image.Rectangle) (image.Rectangle, fixed.Point26_6) {
+	w, h, baseline := MeasureText(face, text)
+	box := Place(image.Pt(w, h), anchor, within)
+	return box, fixed.P(box.Min.X, box.Min.Y+baseline)
+}
+
+// snap moves a logical rectangle so that it starts on a layout step along
+// the panel rows
+func snap(r image.Rectangle) image.Rectangle {
+	bounds := DeviceInfo().Bounds()
+	panel := orientation.ToPanelRect(r, bounds)
+	shift := panel.Min.X % layoutStep
+	if shift == 0 {
+		return r
+	}
+	return orientation.FromPanelRect(panel.Sub(image.Pt(shift, 0)), bounds)
+}
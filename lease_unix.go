@@ -0,0 +1,131 @@
+//go:build unix
+
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DefaultLeasePath is the lease file shared by the programs driving the panel
+const DefaultLeasePath = "/run/lock/it8951.lock"
+
+// Lease is an advisory claim on the panel, so that two processes (e.g. a
+// daemon and epdctl) do not drive the SPI bus at the same time. It is an
+// exclusive flock on the lease file, held through an open descriptor for the
+// life of the lease: the kernel releases it when its owner exits, so a lease
+// is never left stale. The file also holds the owner process id, for error
+// messages.
+type Lease struct {
+	file *os.File
+}
+
+// AcquireLease claims the panel through the lease file at path. If another
+// process holds it, an error wrapping ErrLeased is returned, unless force is
+// set: the lease file is then replaced, the other process keeping a lock on
+// the old one and being left to fail on its own.
+func AcquireLease(path string, force bool) (*Lease, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			owner := leaseOwner(file)
+			file.Close()
+			if !force {
+				return nil, fmt.Errorf("%w (process %d, %s)", ErrLeased, owner, path)
+			}
+			Debug("Taking over lease %s from process %d", path, owner)
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			force = false // the next file is new, unless another process was faster
+			continue
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if !current(path, file) {
+			file.Close() // removed or replaced before we locked it
+			continue
+		}
+		if err := writeOwner(file); err != nil {
+			os.Remove(path)
+			file.Close()
+			return nil, err
+		}
+		Debug("Lease %s acquired", path)
+		return &Lease{file: file}, nil
+	}
+}
+
+// Release gives the lease up. The lease file is removed, unless it was
+// replaced meanwhile (see AcquireLease), before the lock is released.
+func (l *Lease) Release() error {
+	defer l.file.Close()
+	path := l.file.Name()
+	if !current(path, l.file) {
+		return nil
+	}
+	Debug("Lease %s released", path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// current tells whether path still is the file open as file
+func current(path string, file *os.File) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	named, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, named)
+}
+
+// writeOwner writes the process id in a locked lease file
+func writeOwner(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// leaseOwner returns the process id written in a lease file, 0 when it
+// holds none
+func leaseOwner(file *os.File) int {
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, 32))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
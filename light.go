@@ -0,0 +1,88 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "github.com/peergum/go-rpio/v5"
+
+// Light is an auxiliary output following the display power state, such as
+// the front light of an enclosure: it is switched off when the controller
+// sleeps or stands by, and restored on wake
+type Light interface {
+	// SetBrightness sets the output level, from 0 (off) to 1 (full)
+	SetBrightness(level float64) error
+}
+
+var (
+	light           Light
+	lightBrightness float64
+)
+
+// SetLight sets the auxiliary output coordinated with the display power
+// state and its brightness while the controller runs. A nil light removes it.
+func SetLight(l Light, brightness float64) error {
+	light = l
+	lightBrightness = brightness
+	if light == nil {
+		return nil
+	}
+	return light.SetBrightness(brightness)
+}
+
+// dimLight switches the light off, when the controller stops running
+func dimLight() {
+	if light == nil {
+		return
+	}
+	if err := light.SetBrightness(0); err != nil {
+		Debug("Light error: %v", err)
+	}
+}
+
+// restoreLight restores the light brightness, when the controller runs again
+func restoreLight() {
+	if light == nil {
+		return
+	}
+	if err := light.SetBrightness(lightBrightness); err != nil {
+		Debug("Light error: %v", err)
+	}
+}
+
+// pwmCycle is the PWM cycle length of PWMLight, in clock ticks
+const pwmCycle = 1024
+
+// PWMLight drives a light (e.g. a LED driver enable input) with the hardware
+// PWM of a GPIO pin: 12, 13, 18 or 19
+type PWMLight struct {
+	Pin       int // BCM GPIO number
+	Frequency int // PWM frequency in Hz, 0 for 1kHz
+}
+
+// SetBrightness sets the PWM duty cycle
+func (l PWMLight) SetBrightness(level float64) error {
+	frequency := l.Frequency
+	if frequency == 0 {
+		frequency = 1000
+	}
+	pin := rpio.Pin(l.Pin)
+	pin.Pwm()
+	pin.Freq(frequency * pwmCycle)
+	pin.DutyCycle(uint32(min(max(level, 0), 1)*pwmCycle), pwmCycle)
+	return nil
+}
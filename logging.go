@@ -0,0 +1,78 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// LevelTrace is the level of the driver data dumps (buffers and bursts
+// transferred), below slog.LevelDebug used for commands, registers and
+// operations
+const LevelTrace = slog.LevelDebug - 4
+
+var (
+	logger *slog.Logger // see SetLogger
+)
+
+// SetLogger sets the logger receiving the driver messages, used when the
+// configuration gives none (see WithLogger). Nothing is logged when nil, the
+// default. Messages carry the ID of the last frame displayed as their frame
+// attribute (see FrameID).
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+// WithLogger sets the logger receiving the driver messages (the one set with
+// SetLogger when nil)
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Config) {
+		c.Logger = l
+	}
+}
+
+// currentLogger returns the logger of the configuration, or else the one set
+// with SetLogger
+func currentLogger() *slog.Logger {
+	if config.Logger != nil {
+		return config.Logger
+	}
+	return logger
+}
+
+// Debug logs a driver message at slog.LevelDebug
+func Debug(format string, args ...any) {
+	logf(slog.LevelDebug, format, args...)
+}
+
+// Trace logs a driver data dump at LevelTrace
+func Trace(format string, args ...any) {
+	logf(LevelTrace, format, args...)
+}
+
+// logf formats and logs a message, unless the logger discards its level
+func logf(level slog.Level, format string, args ...any) {
+	l := currentLogger()
+	if l == nil || !l.Enabled(context.Background(), level) {
+		return
+	}
+	l.Log(context.Background(), level, fmt.Sprintf(format, args...), "frame", frameID.Load())
+}
@@ -0,0 +1,79 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// LUT0Engine holds the raw registers of the LUT0 display engine.
+//
+// This is an expert API: the display commands (DisplayRect, ...) program the
+// engine themselves, and writing inconsistent values can leave the panel in a
+// bad state. Registers are 32 bit wide, x/width in the low word and y/height
+// in the high word.
+type LUT0Engine struct {
+	WidthHeight uint32 // LUT0EWHR: engine width and height
+	XY          uint32 // LUT0XYR: engine start position
+	BaseAddress uint32 // LUT0BADDR: image buffer base address
+	ModeFrame   uint32 // LUT0MFN: waveform mode and frame number
+}
+
+// ReadLUT0Engine reads the LUT0 engine registers
+func ReadLUT0Engine() LUT0Engine {
+	engine := LUT0Engine{
+		WidthHeight: ReadRegister32(LUT0EWHR),
+		XY:          ReadRegister32(LUT0XYR),
+		BaseAddress: ReadRegister32(LUT0BADDR),
+		ModeFrame:   ReadRegister32(LUT0MFN),
+	}
+	Debug("LUT0 engine %+v", engine)
+	return engine
+}
+
+// WriteLUT0Engine programs the LUT0 engine registers (expert API)
+func WriteLUT0Engine(engine LUT0Engine) {
+	Debug("Programming LUT0 engine %+v", engine)
+	WaitForDisplayReady()
+	WriteRegister32(LUT0EWHR, engine.WidthHeight)
+	WriteRegister32(LUT0XYR, engine.XY)
+	WriteRegister32(LUT0BADDR, engine.BaseAddress)
+	WriteRegister32(LUT0MFN, engine.ModeFrame)
+}
+
+// Area returns the engine area
+func (engine LUT0Engine) Area() image.Rectangle {
+	return Rect(uint16(engine.XY), uint16(engine.XY>>16), uint16(engine.WidthHeight), uint16(engine.WidthHeight>>16))
+}
+
+// SetArea sets the engine area
+func (engine *LUT0Engine) SetArea(area image.Rectangle) {
+	x, y, w, h := rectWords(area)
+	engine.XY = uint32(y)<<16 | uint32(x)
+	engine.WidthHeight = uint32(h)<<16 | uint32(w)
+}
+
+// ReadLUTActiveFlags reads the LUT0/LUT1 active flags (LUT01AF)
+func ReadLUTActiveFlags() uint16 {
+	return ReadRegister(LUT01AF)
+}
+
+// WriteLUTActiveFlags writes the LUT0/LUT1 active flags (LUT01AF, expert API)
+func WriteLUTActiveFlags(flags uint16) {
+	Debug("Writing LUT active flags %04x", flags)
+	WriteRegister(LUT01AF, flags)
+}
@@ -0,0 +1,153 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errLZ4 is returned when decoding data that is not a valid LZ4 block
+var errLZ4 = errors.New("invalid LZ4 block")
+
+// lz4Compress compresses src as one LZ4 block (the raw block format, with no
+// frame around it), finding matches through a hash table of the last
+// positions of 4 byte sequences
+func lz4Compress(src []byte) []byte {
+	const hashLog = 14
+	var table [1 << hashLog]int32 // position + 1 of the last sequence hashed
+	dst := make([]byte, 0, len(src)+len(src)/255+16)
+	anchor := 0
+	// the last match starts 12 bytes before the end at the latest, and the
+	// last 5 bytes are literals
+	for i := 0; i < len(src)-12; {
+		sequence := binary.LittleEndian.Uint32(src[i:])
+		hash := sequence * 2654435761 >> (32 - hashLog)
+		candidate := int(table[hash]) - 1
+		table[hash] = int32(i + 1)
+		if candidate < 0 || i-candidate > 0xffff || binary.LittleEndian.Uint32(src[candidate:]) != sequence {
+			i++
+			continue
+		}
+		length := 4
+		for i+length < len(src)-5 && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = lz4Sequence(dst, src[anchor:i], i-candidate, length)
+		i += length
+		anchor = i
+	}
+	return lz4Sequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4Sequence appends a sequence of literals followed by a match, or by
+// nothing for the last sequence (offset 0)
+func lz4Sequence(dst, literals []byte, offset, length int) []byte {
+	token := byte(min(len(literals), 15)) << 4
+	if offset > 0 {
+		token |= byte(min(length-4, 15))
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if offset == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if length-4 >= 15 {
+		dst = lz4AppendLength(dst, length-4-15)
+	}
+	return dst
+}
+
+// lz4AppendLength appends the bytes extending a length over its token
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lz4Decompress decompresses an LZ4 block of size bytes once decompressed,
+// returning errLZ4 for blocks which do not decompress to exactly that
+func lz4Decompress(src []byte, size int) ([]byte, error) {
+	// a block decompresses to 255 times its size at most, whatever size says
+	dst := make([]byte, 0, min(size, 255*len(src)))
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		literals := int(token >> 4)
+		if literals == 15 {
+			n, next, ok := lz4ReadLength(src, i)
+			if !ok {
+				return nil, errLZ4
+			}
+			literals, i = literals+n, next
+		}
+		if literals > len(src)-i || literals > size-len(dst) {
+			return nil, errLZ4
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			break // last sequence
+		}
+		if len(src)-i < 2 {
+			return nil, errLZ4
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		length := int(token&15) + 4
+		if token&15 == 15 {
+			n, next, ok := lz4ReadLength(src, i)
+			if !ok {
+				return nil, errLZ4
+			}
+			length, i = length+n, next
+		}
+		if offset == 0 || offset > len(dst) || length > size-len(dst) {
+			return nil, errLZ4
+		}
+		// byte by byte, matches overlapping what they copy
+		for from := len(dst) - offset; length > 0; length-- {
+			dst = append(dst, dst[from])
+			from++
+		}
+	}
+	if len(dst) != size {
+		return nil, errLZ4
+	}
+	return dst, nil
+}
+
+// lz4ReadLength reads the bytes extending a length over its token from
+// src[i:], returning the extension and the index following it
+func lz4ReadLength(src []byte, i int) (n, next int, ok bool) {
+	for i < len(src) {
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, true
+		}
+	}
+	return 0, 0, false
+}
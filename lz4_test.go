@@ -0,0 +1,72 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+// TestLZ4 checks blocks decompress to what was compressed, around the
+// lengths where the block format changes, and that truncated blocks or
+// wrong sizes are rejected
+func TestLZ4(t *testing.T) {
+	var inputs [][]byte
+	for _, n := range []int{0, 1, 12, 13, 15, 16, 270, 300, 70000} {
+		input := make([]byte, n)
+		for i := range input {
+			input[i] = byte(i % 7 * i % 251)
+		}
+		inputs = append(inputs, input, bytes.Repeat([]byte{0x11}, n))
+	}
+	for _, input := range inputs {
+		block := lz4Compress(input)
+		output, err := lz4Decompress(block, len(input))
+		if err != nil || !bytes.Equal(output, input) {
+			t.Fatalf("%d bytes: %v", len(input), err)
+		}
+		if len(input) == 0 {
+			continue
+		}
+		if _, err := lz4Decompress(block, len(input)+1); !errors.Is(err, errLZ4) {
+			t.Errorf("%d bytes decompressed as %d: %v", len(input), len(input)+1, err)
+		}
+		if _, err := lz4Decompress(block[:len(block)-1], len(input)); !errors.Is(err, errLZ4) {
+			t.Errorf("%d bytes truncated: %v", len(input), err)
+		}
+	}
+}
+
+// TestLZ4LyingSize checks a block claiming a huge size is rejected without
+// allocating it
+func TestLZ4LyingSize(t *testing.T) {
+	block := lz4Compress(bytes.Repeat([]byte{1}, 100))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := lz4Decompress(block, 1<<32)
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, errLZ4) {
+		t.Errorf("decompressed as 4 GB: %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("%d bytes allocated", allocated)
+	}
+}
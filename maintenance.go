@@ -0,0 +1,92 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"context"
+	"time"
+
+	"github.com/peergum/IT8951-go/schedule"
+)
+
+// maintenanceTick is how often the maintenance worker checks its window
+const maintenanceTick = 30 * time.Second
+
+// Maintenance keeps long lived static content crisp: once per idle window,
+// e.g. at night, it refreshes the whole panel with a full GC16 refresh of
+// what it shows, clearing the ghosting and drift partial updates leave over
+// days. With Scrub, the last frame presented through the Display is uploaded
+// again first, rewriting the image buffer in case bits of the controller
+// SDRAM flipped meanwhile.
+type Maintenance struct {
+	Window schedule.Cron // minutes maintenance may run in, e.g. "* 3-4 * * *" (see schedule.ParseCron)
+	Idle   time.Duration // time without commands before running, so that it never interrupts updates
+	Scrub  bool          // upload the last frame presented again (see Display.Present)
+}
+
+// Maintain starts a worker running the maintenance m once per window, as
+// soon as the controller was idle for m.Idle within it. Controller access
+// must go through Do.
+func (d *Display) Maintain(m Maintenance) {
+	d.Go(func(ctx context.Context) error {
+		ticker := time.NewTicker(maintenanceTick)
+		defer ticker.Stop()
+		done := false // run in the current window
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case now := <-ticker.C:
+				if !m.Window.Match(now) {
+					done = false
+					continue
+				}
+				if done {
+					continue
+				}
+				err := d.Do(func() error {
+					if time.Since(lastCommand) < m.Idle || RemainingRefresh() > 0 {
+						return nil
+					}
+					done = true
+					return d.maintain(m.Scrub)
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// maintain refreshes the whole panel with GC16, from the last frame
+// presented when scrubbing and there is one, or else from the image buffer
+func (d *Display) maintain(scrub bool) error {
+	bounds := DeviceInfo().Bounds()
+	if scrub && d.shadow != nil {
+		Debug("Maintenance: scrubbing the image buffer")
+		frame := d.shadow.Image()
+		return displayImage(frame, frame.Rect, d.modeBpp(GC16Mode), GC16Mode)
+	}
+	Debug("Maintenance: refreshing the panel")
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
+	return DisplayRect(bounds, GC16Mode)
+}
@@ -0,0 +1,166 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// marqueeGap is the space between the end of a marquee text and its next
+// start, in spaces
+const marqueeGap = 4
+
+// Marquee scrolls a line of text through a box, wrapping around seamlessly.
+// The text strip is rendered and loaded once into the spare controller
+// memory after the image buffer, in segments of panel rows, and every step
+// only displays a shifted window of it: scrolling sends a display command,
+// not pixels.
+//
+// The strip memory is the one of buffer pool slots after the first (see
+// NewBufferPool): marquees and pools overwrite each other. Marquees work in
+// the landscape orientation only, the panel scanning memory along its rows.
+type Marquee struct {
+	Box  image.Rectangle // panel coordinates, its left edge on an even pixel
+	Mode DisplayMode     // waveform of the steps, A2Mode by default
+	Step int             // pixels scrolled by Advance, rounded down to even, 8 by default
+
+	period int    // strip width: the text and its gap
+	span   int    // strip columns a segment starts after the previous one
+	rows   uint32 // segment size, box rows of the panel width
+	base   uint32 // address of the first segment
+	offset int    // strip column at the left of the box
+}
+
+// NewMarquee renders text on one line at bpp (2, 4 or 8) and loads it into
+// the controller memory, to scroll it through box (see Marquee). opts.Align
+// places the line vertically in the box, its column being ignored. It fails
+// when the strip does not fit in the memory (see FrameCapacity).
+func NewMarquee(text string, box image.Rectangle, opts TextOptions, bpp int) (*Marquee, error) {
+	switch bpp {
+	case 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("it8951: unsupported marquee bpp %d", bpp)
+	}
+	if orientation != Landscape {
+		return nil, fmt.Errorf("it8951: marquee needs the landscape orientation")
+	}
+	devInfo := DeviceInfo()
+	bounds := devInfo.Bounds()
+	box.Min.X &^= 1
+	box = box.Intersect(bounds)
+	if box.Dx() < 2 || box.Dy() == 0 {
+		return nil, fmt.Errorf("it8951: marquee box %v off the panel", box)
+	}
+	face := opts.Face
+	if face == nil {
+		face = DefaultFace()
+	}
+	line := VisualText(text)
+	width := font.MeasureString(face, line).Ceil()
+	m := &Marquee{
+		Box:    box,
+		Mode:   A2Mode,
+		Step:   8,
+		period: width + font.MeasureString(face, " ").Ceil()*marqueeGap,
+		span:   (bounds.Dx() - box.Dx()) &^ 1,
+		rows:   uint32(box.Dy()) * uint32(bounds.Dx()),
+		base:   devInfo.TargetAddress() + devInfo.frameSize(),
+	}
+	if m.span == 0 {
+		return nil, fmt.Errorf("it8951: marquee box %v as wide as the panel", box)
+	}
+	segments := (m.period + m.span - 1) / m.span
+	if !MemoryFits(m.base, segments*int(m.rows)) {
+		return nil, fmt.Errorf("it8951: marquee text too long for the controller memory (%d segments of %d bytes)", segments, m.rows)
+	}
+
+	metrics := face.Metrics()
+	top := 0
+	switch opts.Align / 3 { // row
+	case 1:
+		top = (box.Dy() - metrics.Height.Ceil()) / 2
+	case 2:
+		top = box.Dy() - metrics.Height.Ceil()
+	}
+	src := image.NewUniform(opts.Color)
+	segment := image.NewGray(image.Rect(0, 0, bounds.Dx(), box.Dy()))
+	for k := 0; k < segments; k++ {
+		draw.Draw(segment, segment.Rect, image.NewUniform(color.Gray{Y: config.Background}), image.Point{}, draw.Src)
+		// copies of the text crossing the segment, the strip repeating every period
+		start := k * m.span
+		for left := start / m.period * m.period; left < start+bounds.Dx(); left += m.period {
+			drawer := font.Drawer{Dst: segment, Src: src, Face: face, Dot: fixed.P(left-start, top+metrics.Ascent.Ceil())}
+			drawer.DrawString(line)
+		}
+		Debug("Loading marquee segment %d/%d", k+1, segments)
+		loadImage(segment, segment.Rect, bpp, m.base+uint32(k)*m.rows)
+		if err := Err(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Period returns the width of the strip scrolled, the text and its gap: the
+// marquee shows the same window again every Period pixels
+func (m *Marquee) Period() int {
+	return m.period
+}
+
+// Offset returns the strip column shown at the left of the box
+func (m *Marquee) Offset() int {
+	return m.offset
+}
+
+// SetOffset sets the strip column shown at the left of the box, rounded
+// down to even, for the next Show
+func (m *Marquee) SetOffset(offset int) {
+	m.offset = ((offset%m.period + m.period) % m.period) &^ 1
+}
+
+// Advance scrolls the text left by Step pixels (right when negative) and
+// shows it with Mode
+func (m *Marquee) Advance() error {
+	step := m.Step &^ 1
+	if step == 0 {
+		step = 8
+	}
+	m.SetOffset(m.offset + step)
+	return m.Show(m.Mode)
+}
+
+// Show displays the box with mode, from the strip window at Offset, once the
+// refresh in progress is over
+func (m *Marquee) Show(mode DisplayMode) error {
+	// the window starts in the segment holding it whole: its panel rows are
+	// read from the segment rows, from the window column on
+	k, within := m.offset/m.span, m.offset%m.span
+	skip := uint32(m.Box.Min.Y*DeviceInfo().Bounds().Dx() + m.Box.Min.X)
+	address := m.base + uint32(k)*m.rows + uint32(within) - skip
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
+	return DisplayRectBuffer(m.Box, mode, address)
+}
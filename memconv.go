@@ -0,0 +1,78 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+// MemoryConverter is the memory converter setting (MCSR), also sent as first
+// parameter of the load image commands. The converter turns host pixels into
+// the 8bpp image buffer of the controller:
+//
+//   - bit 8: endianness of the host words
+//   - bits 5-4: host bpp (2, 3, 4 or 8)
+//   - bits 1-0: rotation applied while storing the pixels
+type MemoryConverter struct {
+	EndianType  EndianType
+	PixelFormat PixelMode
+	Rotate      Rotate
+}
+
+// Value returns the MCSR register value
+func (converter MemoryConverter) Value() uint16 {
+	return uint16(converter.EndianType&1)<<8 | uint16(converter.PixelFormat&3)<<4 | uint16(converter.Rotate&3)
+}
+
+// ParseMemoryConverter decodes an MCSR register value
+func ParseMemoryConverter(value uint16) MemoryConverter {
+	return MemoryConverter{
+		EndianType:  EndianType(value >> 8 & 1),
+		PixelFormat: PixelMode(value >> 4 & 3),
+		Rotate:      Rotate(value & 3),
+	}
+}
+
+// ReadMemoryConverter reads the memory converter setting (MCSR)
+func ReadMemoryConverter() MemoryConverter {
+	return ParseMemoryConverter(ReadRegister(MCSR))
+}
+
+// WriteMemoryConverter writes the memory converter setting (MCSR)
+func WriteMemoryConverter(converter MemoryConverter) {
+	WriteRegister(MCSR, converter.Value())
+}
+
+// ReadTargetMemoryAddr reads the load image start address (LISAR)
+func ReadTargetMemoryAddr() uint32 {
+	return ReadRegister32(LISAR)
+}
+
+// ImageAddress returns the address of pixel (x, y) in an image buffer starting
+// at base, stride being the buffer width in pixels (one byte per pixel)
+func ImageAddress(base uint32, x, y, stride int) uint32 {
+	return base + uint32(y*stride+x)
+}
+
+// WriteRow writes packed pixels directly at (x, y) of the image buffer at base,
+// stride being the buffer width in pixels. Pixels are stored sequentially from
+// that address, so the row must not go past the end of the buffer line.
+func (imageInfo LoadImgInfo) WriteRow(row DataBuffer, x, y, stride int) {
+	Debug("Writing row at %d,%d", x, y)
+	imageInfo.TargetMemAddr = ImageAddress(imageInfo.TargetMemAddr, x, y, stride)
+	imageInfo.loadImage(nil, func() {
+		row.WriteBuffer()
+	})
+}
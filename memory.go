@@ -0,0 +1,154 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxProbedFrames is the number of panel frames after the image buffer
+// checked when probing the controller memory
+const maxProbedFrames = 16
+
+// memory probing patterns, the second one being used when a word already
+// holds the first
+const (
+	probePattern    = 0x5aa5
+	probePatternAlt = 0xa55a
+)
+
+var (
+	frameCapacity int // panel frames held from the image buffer, 0 when unknown
+)
+
+// FrameCapacity returns the number of panel frames (PanelW*PanelH bytes) the
+// controller memory holds from the image buffer address, the image buffer
+// included: from Config.MemorySize, or probed at Init up to 16 frames. It
+// returns 0 before Init. Features using spare frames, such as buffer pools,
+// are scaled back to it rather than writing past the memory, which some
+// clone boards have less of.
+func FrameCapacity() int {
+	return frameCapacity
+}
+
+// MemoryFits tells whether size bytes at address lie within the frames of
+// FrameCapacity, or whether the capacity is unknown
+func MemoryFits(address uint32, size int) bool {
+	if frameCapacity == 0 {
+		return true
+	}
+	devInfo := DeviceInfo()
+	end := devInfo.TargetAddress() + uint32(frameCapacity)*devInfo.frameSize()
+	return address >= devInfo.TargetAddress() && uint64(address)+uint64(size) <= uint64(end)
+}
+
+// frameSize returns the size of a panel frame in the controller memory
+func (devInfo DevInfo) frameSize() uint32 {
+	return uint32(devInfo.PanelW) * uint32(devInfo.PanelH)
+}
+
+// applyMemorySize sets the frame capacity from the configured memory size,
+// or probes it when not set
+func applyMemorySize(devInfo *DevInfo) {
+	size := devInfo.frameSize()
+	if size == 0 {
+		frameCapacity = 0
+		return
+	}
+	if config.MemorySize > 0 {
+		frameCapacity = max(0, int((int64(config.MemorySize)-int64(devInfo.TargetAddress()))/int64(size)))
+		return
+	}
+	start := time.Now()
+	frameCapacity = probeFrames(devInfo.TargetAddress(), size)
+	Debug("Controller memory holds %d frames from %08x (probed in %v)", frameCapacity, devInfo.TargetAddress(), time.Since(start))
+}
+
+// probeFrames returns the number of frames of size bytes from base the
+// controller memory holds, up to maxProbedFrames, by writing a pattern to
+// the last word of each of them in turn. A frame is missing when the word
+// does not read back, or when the write showed at a lower address, which
+// happens when the address lines beyond the memory size are ignored: the
+// words at every power of two below are checked. Words are restored.
+func probeFrames(base uint32, size uint32) int {
+	for frame := 1; frame <= maxProbedFrames; frame++ {
+		address := base + uint32(frame)*size - 2
+		var aliases []uint32
+		for offset := uint32(1 << 20); offset < address; offset <<= 1 {
+			aliases = append(aliases, address-offset)
+		}
+		if !probeWord(address, aliases) {
+			return frame - 1
+		}
+	}
+	return maxProbedFrames
+}
+
+// probeWord tells whether a pattern written at address reads back without
+// changing the words at aliases, restoring the word afterwards
+func probeWord(address uint32, aliases []uint32) bool {
+	original := readWord(address)
+	before := make([]uint16, len(aliases))
+	for i, alias := range aliases {
+		before[i] = readWord(alias)
+	}
+	pattern := uint16(probePattern)
+	if original == pattern {
+		pattern = probePatternAlt
+	}
+	writeWord(address, pattern)
+	ok := readWord(address) == pattern
+	for i, alias := range aliases {
+		if ok && readWord(alias) != before[i] {
+			Debug("Memory at %08x shows at %08x", address, alias)
+			ok = false
+		}
+	}
+	writeWord(address, original)
+	for i, alias := range aliases {
+		writeWord(alias, before[i])
+	}
+	return ok && busErr == nil
+}
+
+// readWord reads a word of the controller memory
+func readWord(address uint32) uint16 {
+	word := make(DataBuffer, 1)
+	transaction(TCONMemBstRdT, func() {
+		memBurstRead(address, word)
+	})
+	return word[0]
+}
+
+// writeWord writes a word of the controller memory
+func writeWord(address uint32, word uint16) {
+	transaction(TCONMemBstWr, func() {
+		memBurstWrite(address, DataBuffer{word})
+	})
+}
+
+// checkFrames returns an error when the controller memory does not hold n
+// frames from the image buffer
+func checkFrames(n int) error {
+	if frameCapacity > 0 && n > frameCapacity {
+		return fmt.Errorf("it8951: the controller memory holds %d frames, not %d", frameCapacity, n)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+)
+
+// MirrorSink receives the frames presented on the panel (see SetMirror)
+type MirrorSink interface {
+	// Mirror receives the whole panel content in logical coordinates, once
+	// the refresh of area (logical coordinates too) with mode is sent. frame
+	// is only valid during the call.
+	Mirror(frame *image.Gray, area image.Rectangle, mode DisplayMode) error
+}
+
+// MirrorFunc is a function used as a MirrorSink, e.g. streaming frames to a
+// remote operator
+type MirrorFunc func(frame *image.Gray, area image.Rectangle, mode DisplayMode) error
+
+// Mirror calls fn
+func (fn MirrorFunc) Mirror(frame *image.Gray, area image.Rectangle, mode DisplayMode) error {
+	return fn(frame, area, mode)
+}
+
+// PNGMirror is a MirrorSink writing each frame to a PNG file, replaced
+// through a rename so that readers never see partial files, e.g. for a web
+// page polling what the panel shows
+type PNGMirror string
+
+// Mirror writes frame to the file
+func (path PNGMirror) Mirror(frame *image.Gray, area image.Rectangle, mode DisplayMode) error {
+	var data bytes.Buffer
+	if err := png.Encode(&data, frame); err != nil {
+		return err
+	}
+	temp := string(path) + ".tmp"
+	if err := os.WriteFile(temp, data.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, string(path))
+}
+
+// panelMirror follows the content of the image buffers to pass the frames
+// displayed to a sink
+type panelMirror struct {
+	sink     MirrorSink
+	buffers  map[uint32]*image.Gray // image buffers by address, panel coordinates
+	shown    *image.Gray            // panel content, panel coordinates
+	frame    *image.Gray            // shown in logical coordinates, unless in landscape
+	oriented Orientation            // orientation of frame
+}
+
+var (
+	mirroring *panelMirror
+)
+
+// SetMirror passes every frame presented on the panel to sink (nil to stop),
+// so that another display, file or network stream shows what the panel does.
+// The driver keeps a copy of the image buffers loaded for this, unpacked from
+// the data sent: frames are mirrored whatever the way they were loaded
+// (images, packed frames, raw buffers, buffer pool slots), except 1bpp
+// bitmaps, and areas loaded before SetMirror read as white.
+//
+// sink runs on the goroutine displaying, after the display command is sent,
+// and must not call the driver. Its errors are logged.
+func SetMirror(sink MirrorSink) {
+	if sink == nil {
+		mirroring = nil
+		return
+	}
+	mirroring = &panelMirror{sink: sink, buffers: map[uint32]*image.Gray{}}
+}
+
+// mirrorLoad copies an area loaded at bpp into the image buffer at address
+func mirrorLoad(imageInfo LoadImgInfo, area AreaImgInfo, bpp int) {
+	if mirroring == nil {
+		return
+	}
+	if bpp == 1 || imageInfo.Rotate != Rotate0 {
+		Debug("Mirror: %dbpp load with rotation %d ignored", bpp, imageInfo.Rotate)
+		return
+	}
+	buffer := mirroring.buffer(imageInfo.TargetMemAddr)
+	width, levels := int(area.W), 1<<bpp
+	imageInfo.SourceBufferAddr.Rows(width, bpp)(func(y int, row DataBuffer) bool {
+		if y >= int(area.H) || int(area.Y)+y >= buffer.Rect.Max.Y {
+			return false
+		}
+		start := buffer.PixOffset(int(area.X), int(area.Y)+y)
+		pix := buffer.Pix[start : start+min(width, buffer.Rect.Max.X-int(area.X))]
+		for x := range pix {
+			pix[x] = uint8(int(row.Pixel(x, bpp)) * 255 / (levels - 1))
+		}
+		return true
+	})
+}
+
+// mirrorDisplay passes the frame displayed from the image buffer at address
+// to the sink
+func mirrorDisplay(area image.Rectangle, mode DisplayMode, address uint32) {
+	m := mirroring
+	if m == nil {
+		return
+	}
+	bounds := DeviceInfo().Bounds()
+	area = area.Intersect(bounds)
+	if m.shown == nil || m.shown.Rect != bounds {
+		m.shown = NewShadow(bounds).Image()
+	}
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		start := m.shown.PixOffset(area.Min.X, y)
+		for i, value := range m.read(address+uint32(start), area.Dx()) {
+			m.shown.Pix[start+i] = value >> 4 * 0x11 // waveforms use 16 levels
+		}
+	}
+	region := orientation.FromPanelRect(area, bounds)
+	frame := m.shown
+	if orientation != Landscape {
+		update := region
+		if m.frame == nil || m.oriented != orientation || m.frame.Rect != orientation.LogicalBounds(bounds) {
+			m.frame = image.NewGray(orientation.LogicalBounds(bounds))
+			m.oriented = orientation
+			update = m.frame.Rect
+		}
+		frame = m.frame
+		for y := update.Min.Y; y < update.Max.Y; y++ {
+			for x := update.Min.X; x < update.Max.X; x++ {
+				p := orientation.ToPanel(image.Pt(x, y), bounds)
+				frame.Pix[frame.PixOffset(x, y)] = m.shown.Pix[m.shown.PixOffset(p.X, p.Y)]
+			}
+		}
+	}
+	if err := m.sink.Mirror(frame, region, mode); err != nil {
+		Debug("Mirror failed: %v", err)
+	}
+}
+
+// buffer returns the copy of the image buffer at address, white at first
+func (m *panelMirror) buffer(address uint32) *image.Gray {
+	bounds := DeviceInfo().Bounds()
+	buffer, ok := m.buffers[address]
+	if !ok || buffer.Rect != bounds {
+		buffer = NewShadow(bounds).Image()
+		m.buffers[address] = buffer
+	}
+	return buffer
+}
+
+// read returns n bytes of the copies at address, from the image buffer
+// holding them: displays may start anywhere in a buffer, e.g. marquee
+// windows. Bytes out of the buffers read as white.
+func (m *panelMirror) read(address uint32, n int) []uint8 {
+	var found *image.Gray
+	var offset int
+	for start, buffer := range m.buffers {
+		if address >= start && int(address-start) < len(buffer.Pix) && (found == nil || int(address-start) < offset) {
+			found, offset = buffer, int(address-start)
+		}
+	}
+	if found != nil && offset+n <= len(found.Pix) {
+		return found.Pix[offset : offset+n]
+	}
+	pix := make([]uint8, n)
+	for i := range pix {
+		pix[i] = 0xff
+	}
+	if found != nil {
+		copy(pix, found.Pix[offset:])
+	}
+	return pix
+}
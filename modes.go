@@ -0,0 +1,141 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "fmt"
+
+// Waveform is an update waveform, whose DisplayMode number depends on the
+// LUT loaded in the controller (see ModeTable)
+type Waveform uint8
+
+// Waveforms
+const (
+	WaveformINIT  Waveform = iota // clears to white with flashes, erasing any ghosting
+	WaveformDU                    // direct update to black or white, no flash
+	WaveformGC16                  // 16 gray levels, flashing
+	WaveformGL16                  // 16 gray levels on white backgrounds, without flash
+	WaveformGLR16                 // GL16 with ghosting reduction
+	WaveformGLD16                 // GL16 with ghosting reduction, dithered
+	WaveformA2                    // fast black and white, for animations
+	WaveformDU4                   // direct update to 4 gray levels, no flash
+)
+
+var waveformNames = [...]string{"INIT", "DU", "GC16", "GL16", "GLR16", "GLD16", "A2", "DU4"}
+
+// String returns the waveform name
+func (w Waveform) String() string {
+	if int(w) < len(waveformNames) {
+		return waveformNames[w]
+	}
+	return fmt.Sprintf("Waveform(%d)", uint8(w))
+}
+
+// ModeTable maps the waveforms of a LUT to their display mode numbers
+type ModeTable map[Waveform]DisplayMode
+
+// Has tells whether mode is the display mode number of a waveform of the
+// table
+func (table ModeTable) Has(mode DisplayMode) bool {
+	for _, known := range table {
+		if mode == known {
+			return true
+		}
+	}
+	return false
+}
+
+// waveforms returns the waveform of each mode of the table
+func (table ModeTable) waveforms() map[DisplayMode]Waveform {
+	waveforms := make(map[DisplayMode]Waveform, len(table))
+	for w, mode := range table {
+		waveforms[mode] = w
+	}
+	return waveforms
+}
+
+var (
+	// m841Modes is the layout of most LUTs (M841, and the default for unknown ones)
+	m841Modes = ModeTable{
+		WaveformINIT: 0, WaveformDU: 1, WaveformGC16: 2, WaveformGL16: 3,
+		WaveformGLR16: 4, WaveformGLD16: 5, WaveformA2: 6, WaveformDU4: 7,
+	}
+	// modeTables holds the known LUT layouts, by LUT name or family
+	modeTables = map[string]ModeTable{
+		"M641": {WaveformINIT: 0, WaveformDU: 1, WaveformGC16: 2, WaveformGL16: 3, WaveformA2: 4},
+		"M841": m841Modes,
+	}
+	// modeWaveforms is the waveform of each mode of the panel LUT (see
+	// applyModes)
+	modeWaveforms = m841Modes.waveforms()
+)
+
+// RegisterModeTable sets the mode numbers of a LUT, given by its full name
+// (e.g. "M841_TFA2812") or family ("M841"), for LUTs unknown to the driver
+// or differing from what it expects. It must be called before Init.
+func RegisterModeTable(lut string, table ModeTable) {
+	modeTables[lut] = table
+}
+
+// modeTable returns the mode numbers of a LUT: registered for its full name,
+// or else its family, or else those of M841
+func modeTable(lut, family string) ModeTable {
+	if table, ok := modeTables[lut]; ok {
+		return table
+	}
+	if table, ok := modeTables[family]; ok {
+		return table
+	}
+	return m841Modes
+}
+
+// Mode returns the display mode number of a waveform for this firmware LUT
+func (fw Firmware) Mode(w Waveform) (DisplayMode, error) {
+	mode, ok := fw.Modes[w]
+	if !ok {
+		return 0, fmt.Errorf("%w: %v in %s", ErrNoWaveform, w, fw.LUT)
+	}
+	return mode, nil
+}
+
+// Mode returns the display mode number of a waveform for the attached panel
+func Mode(w Waveform) (DisplayMode, error) {
+	return DeviceInfo().Firmware().Mode(w)
+}
+
+// applyModes sets the mode variables (GC16Mode, A2Mode...) for a firmware,
+// waveforms missing from its LUT getting the closest one available
+func applyModes(fw Firmware) {
+	pick := func(waveforms ...Waveform) DisplayMode {
+		for _, w := range waveforms {
+			if mode, ok := fw.Modes[w]; ok {
+				return mode
+			}
+		}
+		return GC16Mode
+	}
+	InitMode = pick(WaveformINIT)
+	GC16Mode = pick(WaveformGC16)
+	DUMode = pick(WaveformDU, WaveformA2)
+	GL16Mode = pick(WaveformGL16, WaveformGC16)
+	GLR16Mode = pick(WaveformGLR16, WaveformGL16, WaveformGC16)
+	GLD16Mode = pick(WaveformGLD16, WaveformGLR16, WaveformGL16, WaveformGC16)
+	A2Mode = pick(WaveformA2, WaveformDU)
+	DU4Mode = pick(WaveformDU4, WaveformDU, WaveformGC16)
+	modeWaveforms = fw.Modes.waveforms()
+}
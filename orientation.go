@@ -0,0 +1,137 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// Orientation is the logical orientation of the panel, as seen by the application
+type Orientation uint8
+
+// Orientations, rotating the content clockwise from the panel's native layout
+const (
+	Landscape        Orientation = iota // native panel orientation
+	Portrait                            // rotated 90°
+	LandscapeFlipped                    // rotated 180°
+	PortraitFlipped                     // rotated 270°
+)
+
+var (
+	orientation Orientation
+)
+
+// SetOrientation sets the logical orientation used by the image level functions
+// (DisplayWithin, ...): their images and regions are given in logical coordinates
+// and mapped to the panel automatically.
+func SetOrientation(o Orientation) {
+	Debug("Orientation set to %d", o)
+	orientation = o
+}
+
+// SwitchOrientation changes the orientation at runtime, without
+// initializing the controller again: render is called with the new logical
+// bounds to lay the content out again, and its result is displayed with one
+// full GC16 refresh. Regions are laid out for an orientation, so they are
+// removed first and render should define them again if needed.
+func SwitchOrientation(o Orientation, render func(bounds image.Rectangle) image.Image) {
+	Debug("Switching orientation to %d", o)
+	SetOrientation(o)
+	clear(regions)
+	logical := o.LogicalBounds(DeviceInfo().Bounds())
+	displayImage(render(logical), logical, 4, GC16Mode)
+}
+
+// CurrentOrientation returns the logical orientation
+func CurrentOrientation() Orientation {
+	return orientation
+}
+
+// LogicalBounds returns the panel area in logical coordinates
+func (o Orientation) LogicalBounds(panel image.Rectangle) image.Rectangle {
+	if o == Portrait || o == PortraitFlipped {
+		return image.Rect(0, 0, panel.Dy(), panel.Dx())
+	}
+	return image.Rect(0, 0, panel.Dx(), panel.Dy())
+}
+
+// ToPanel maps a logical point to panel coordinates
+func (o Orientation) ToPanel(p image.Point, panel image.Rectangle) image.Point {
+	w, h := panel.Dx(), panel.Dy()
+	switch o {
+	case Portrait:
+		return image.Pt(w-1-p.Y, p.X)
+	case LandscapeFlipped:
+		return image.Pt(w-1-p.X, h-1-p.Y)
+	case PortraitFlipped:
+		return image.Pt(p.Y, h-1-p.X)
+	}
+	return p
+}
+
+// FromPanel maps a panel point to logical coordinates
+func (o Orientation) FromPanel(p image.Point, panel image.Rectangle) image.Point {
+	w, h := panel.Dx(), panel.Dy()
+	switch o {
+	case Portrait:
+		return image.Pt(p.Y, w-1-p.X)
+	case LandscapeFlipped:
+		return image.Pt(w-1-p.X, h-1-p.Y)
+	case PortraitFlipped:
+		return image.Pt(h-1-p.Y, p.X)
+	}
+	return p
+}
+
+// ToPanelRect maps a logical rectangle to panel coordinates
+func (o Orientation) ToPanelRect(r image.Rectangle, panel image.Rectangle) image.Rectangle {
+	return mapRect(r, func(p image.Point) image.Point { return o.ToPanel(p, panel) })
+}
+
+// FromPanelRect maps a panel rectangle to logical coordinates
+func (o Orientation) FromPanelRect(r image.Rectangle, panel image.Rectangle) image.Rectangle {
+	return mapRect(r, func(p image.Point) image.Point { return o.FromPanel(p, panel) })
+}
+
+// mapRect maps the corner pixels of a rectangle
+func mapRect(r image.Rectangle, mapPoint func(image.Point) image.Point) image.Rectangle {
+	if r.Empty() {
+		return image.Rectangle{}
+	}
+	a := mapPoint(r.Min)
+	b := mapPoint(r.Max.Sub(image.Pt(1, 1)))
+	r = image.Rect(a.X, a.Y, b.X, b.Y)
+	r.Max = r.Max.Add(image.Pt(1, 1))
+	return r
+}
+
+// panelGray converts the pixels of a logical image covering a panel area to a
+// grayscale image in panel coordinates
+func panelGray(img image.Image, area image.Rectangle, panel image.Rectangle) *image.Gray {
+	if orientation == Landscape {
+		return toGray(img, area)
+	}
+	src := toGray(img, orientation.FromPanelRect(area, panel))
+	gray := image.NewGray(area)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			p := orientation.FromPanel(image.Pt(x, y), panel)
+			gray.Pix[gray.PixOffset(x, y)] = src.Pix[src.PixOffset(p.X, p.Y)]
+		}
+	}
+	return gray
+}
@@ -0,0 +1,60 @@
+//go:build !purego
+
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+// This file holds unrolled versions of the packing hot paths, written so the
+// compiler can drop bounds checks from the inner loops. They make a
+// difference on slow ARM boards (Pi Zero). Build with the purego tag to use
+// the plain loops instead.
+
+// pack4 packs a row of gray levels at 4bpp, keeping the 4 most significant bits
+func pack4(pix []uint8, row DataBuffer) {
+	full := len(pix) / 4
+	words := row[:full]
+	for i := range words {
+		p := pix[4*i : 4*i+4 : 4*i+4]
+		words[i] = uint16(p[0]>>4) | uint16(p[1]>>4)<<4 | uint16(p[2]>>4)<<8 | uint16(p[3]>>4)<<12
+	}
+	if tail := pix[4*full:]; len(tail) > 0 {
+		var word uint16
+		for x, value := range tail {
+			word |= uint16(value>>4) << (4 * x)
+		}
+		row[full] = word
+	}
+}
+
+// putWords stores words in dst as big endian bytes, the SPI byte order
+func putWords(dst []byte, words DataBuffer) {
+	dst = dst[:2*len(words)]
+	full := len(words) &^ 3
+	for i := 0; i < full; i += 4 {
+		w := words[i : i+4 : i+4]
+		d := dst[2*i : 2*i+8 : 2*i+8]
+		d[0], d[1] = byte(w[0]>>8), byte(w[0])
+		d[2], d[3] = byte(w[1]>>8), byte(w[1])
+		d[4], d[5] = byte(w[2]>>8), byte(w[2])
+		d[6], d[7] = byte(w[3]>>8), byte(w[3])
+	}
+	for i := full; i < len(words); i++ {
+		dst[2*i], dst[2*i+1] = byte(words[i]>>8), byte(words[i])
+	}
+}
@@ -0,0 +1,37 @@
+//go:build purego
+
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+// pack4 packs a row of gray levels at 4bpp, keeping the 4 most significant bits
+func pack4(pix []uint8, row DataBuffer) {
+	clear(row)
+	for x, value := range pix {
+		row[x/4] |= uint16(value>>4) << (4 * (x % 4))
+	}
+}
+
+// putWords stores words in dst as big endian bytes, the SPI byte order
+func putWords(dst []byte, words DataBuffer) {
+	for i, word := range words {
+		dst[2*i] = byte(word >> 8)
+		dst[2*i+1] = byte(word & 0xff)
+	}
+}
@@ -0,0 +1,333 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Packed frames are stored and exchanged as (big endian):
+//
+//	magic       "IT8F"
+//	version     uint8 (1)
+//	panel       width, height uint16
+//	area        x, y, w, h uint16
+//	bpp         uint8
+//	rotation    uint8
+//	compression uint8 (see FrameCompression)
+//	size        uint32, of the payload
+//	payload     packed pixels, compressed
+//	crc         uint32, IEEE CRC-32 of everything before
+//
+// Uncompressed, the payload holds rows of whole 16 bit little endian words,
+// as PackImage makes them.
+const (
+	packedFrameMagic   = "IT8F"
+	packedFrameVersion = 1
+	packedFrameHeader  = 4 + 1 + 4 + 8 + 3 + 4
+)
+
+// FrameCompression is the compression of a packed frame payload
+type FrameCompression uint8
+
+// Frame compressions. Deflate is the most portable; zstd compresses dithered
+// frames best, and LZ4 (a raw block, with no frame format) decompresses
+// fastest on small boards.
+const (
+	CompressNone FrameCompression = iota
+	CompressDeflate
+	CompressZstd
+	CompressLZ4
+)
+
+// ErrBadFrame is returned when decoding data that is not a valid packed frame
+var ErrBadFrame = errors.New("it8951: invalid packed frame")
+
+// PackedFrame is a frame already packed for a panel, which can be saved and
+// displayed again, or sent to another program, without converting it again
+type PackedFrame struct {
+	// Panel is the size of the panel the frame was packed for
+	Panel image.Point
+	// Area is the area covered, in coordinates rotated by Rotation. It must
+	// be aligned as the controller needs it for Bpp.
+	Area image.Rectangle
+	// Bpp is the bits per pixel of the pixels (1, 2, 4 or 8)
+	Bpp int
+	// Rotation is the rotation applied by the host when displaying the frame
+	Rotation Rotate
+	// Compression is the compression used when encoding the frame
+	Compression FrameCompression
+	// Pixels are the packed pixels, GetWidthInWords(Area.Dx(), Bpp) words
+	// per row
+	Pixels DataBuffer
+}
+
+// NewPackedFrame packs an area of img for the panel initialized, with the
+// current ditherer
+func NewPackedFrame(img image.Image, area image.Rectangle, bpp int, rotation Rotate) *PackedFrame {
+	return &PackedFrame{
+		Panel:       DeviceInfo().Bounds().Size(),
+		Area:        area,
+		Bpp:         bpp,
+		Rotation:    rotation,
+		Compression: CompressDeflate,
+		Pixels:      PackImage(img, area, bpp),
+	}
+}
+
+// words returns the number of words of the frame pixels
+func (frame *PackedFrame) words() int {
+	return GetWidthInWords(frame.Area.Dx(), frame.Bpp) * frame.Area.Dy()
+}
+
+// maxFrameSide bounds the panel sides of packed frames, over those of the
+// panels the IT8951 drives, so that decoding a frame never allocates more
+// than a 4096x4096 8bpp buffer whatever its header says
+const maxFrameSide = 4096
+
+// check checks the frame fields are consistent
+func (frame *PackedFrame) check() error {
+	if err := frame.checkHeader(); err != nil {
+		return err
+	}
+	if len(frame.Pixels) != frame.words() {
+		return fmt.Errorf("it8951: %d words of pixels instead of %d", len(frame.Pixels), frame.words())
+	}
+	return nil
+}
+
+// checkHeader checks the fields other than the pixels are consistent: the
+// panel no larger than maxFrameSide, and the area within it
+func (frame *PackedFrame) checkHeader() error {
+	if frame.Panel.X <= 0 || frame.Panel.Y <= 0 || frame.Panel.X > maxFrameSide || frame.Panel.Y > maxFrameSide {
+		return fmt.Errorf("it8951: invalid frame panel %dx%d", frame.Panel.X, frame.Panel.Y)
+	}
+	switch frame.Bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("it8951: unsupported bpp %d", frame.Bpp)
+	}
+	if frame.Rotation > Rotate270 {
+		return fmt.Errorf("it8951: invalid rotation %d", frame.Rotation)
+	}
+	panel := image.Rectangle{Max: frame.Panel}
+	if frame.Rotation == Rotate90 || frame.Rotation == Rotate270 {
+		panel.Max = image.Pt(frame.Panel.Y, frame.Panel.X)
+	}
+	if frame.Area.Empty() || !frame.Area.In(panel) {
+		return fmt.Errorf("it8951: invalid frame area %v", frame.Area)
+	}
+	return nil
+}
+
+// Image unpacks the frame pixels to gray levels over Area, in the frame
+// coordinates (rotated by Rotation from the panel ones), e.g. to check what
+// was packed without a panel. Levels are spread over 0-255 again, and at
+// 1bpp, set bits read as white. Night mode inversion is not undone.
+func (frame *PackedFrame) Image() (*image.Gray, error) {
+	if err := frame.check(); err != nil {
+		return nil, err
+	}
+	gray := image.NewGray(frame.Area)
+	width, levels := frame.Area.Dx(), 1<<frame.Bpp
+	frame.Pixels.Rows(width, frame.Bpp)(func(y int, row DataBuffer) bool {
+		pix := gray.Pix[y*gray.Stride : y*gray.Stride+width]
+		for x := range pix {
+			pix[x] = uint8(int(row.Pixel(x, frame.Bpp)) * 255 / (levels - 1))
+		}
+		return true
+	})
+	return gray, nil
+}
+
+// MarshalBinary encodes the frame
+func (frame *PackedFrame) MarshalBinary() ([]byte, error) {
+	if err := frame.check(); err != nil {
+		return nil, err
+	}
+	pixels := make([]byte, 2*len(frame.Pixels))
+	for i, word := range frame.Pixels {
+		binary.LittleEndian.PutUint16(pixels[2*i:], word)
+	}
+	switch frame.Compression {
+	case CompressNone:
+	case CompressDeflate:
+		var compressed bytes.Buffer
+		w, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+		w.Write(pixels)
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		pixels = compressed.Bytes()
+	case CompressZstd:
+		w, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		pixels = w.EncodeAll(pixels, nil)
+		w.Close()
+	case CompressLZ4:
+		pixels = lz4Compress(pixels)
+	default:
+		return nil, fmt.Errorf("it8951: unknown compression %d", frame.Compression)
+	}
+	data := make([]byte, 0, packedFrameHeader+len(pixels)+4)
+	data = append(data, packedFrameMagic...)
+	data = append(data, packedFrameVersion)
+	for _, field := range []int{frame.Panel.X, frame.Panel.Y,
+		frame.Area.Min.X, frame.Area.Min.Y, frame.Area.Dx(), frame.Area.Dy()} {
+		data = binary.BigEndian.AppendUint16(data, uint16(field))
+	}
+	data = append(data, byte(frame.Bpp), byte(frame.Rotation), byte(frame.Compression))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(pixels)))
+	data = append(data, pixels...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data)), nil
+}
+
+// UnmarshalBinary decodes a frame encoded by MarshalBinary
+func (frame *PackedFrame) UnmarshalBinary(data []byte) error {
+	if len(data) < packedFrameHeader+4 || string(data[:4]) != packedFrameMagic {
+		return ErrBadFrame
+	}
+	if data[4] != packedFrameVersion {
+		return fmt.Errorf("it8951: unsupported packed frame version %d", data[4])
+	}
+	end := len(data) - 4
+	if crc32.ChecksumIEEE(data[:end]) != binary.BigEndian.Uint32(data[end:]) {
+		return fmt.Errorf("%w: bad CRC", ErrBadFrame)
+	}
+	field := func(i int) int {
+		return int(binary.BigEndian.Uint16(data[5+2*i:]))
+	}
+	decoded := PackedFrame{
+		Panel:       image.Pt(field(0), field(1)),
+		Area:        image.Rect(field(2), field(3), field(2)+field(4), field(3)+field(5)),
+		Bpp:         int(data[17]),
+		Rotation:    Rotate(data[18]),
+		Compression: FrameCompression(data[19]),
+	}
+	if int(binary.BigEndian.Uint32(data[20:])) != end-packedFrameHeader {
+		return fmt.Errorf("%w: bad payload size", ErrBadFrame)
+	}
+	// before decompressing anything, the header bounding what is allocated
+	if err := decoded.checkHeader(); err != nil {
+		return err
+	}
+	payload := data[packedFrameHeader:end]
+	size := 2 * decoded.words()
+	switch decoded.Compression {
+	case CompressNone:
+	case CompressDeflate:
+		r := flate.NewReader(bytes.NewReader(payload))
+		defer r.Close()
+		// read one byte more than expected to catch oversized payloads
+		var err error
+		if payload, err = io.ReadAll(io.LimitReader(r, int64(size)+1)); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadFrame, err)
+		}
+	case CompressZstd:
+		// windows over the frame size are of no use to it, other than
+		// making the decoder allocate them
+		r, err := zstd.NewReader(bytes.NewReader(payload), zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true), zstd.WithDecoderMaxWindow(max(uint64(size), 8<<20)))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if payload, err = io.ReadAll(io.LimitReader(r, int64(size)+1)); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadFrame, err)
+		}
+	case CompressLZ4:
+		var err error
+		if payload, err = lz4Decompress(payload, size); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadFrame, err)
+		}
+	default:
+		return fmt.Errorf("it8951: unknown compression %d", decoded.Compression)
+	}
+	if len(payload) != size {
+		return fmt.Errorf("%w: %d bytes of pixels instead of %d", ErrBadFrame, len(payload), size)
+	}
+	decoded.Pixels = make(DataBuffer, size/2)
+	for i := range decoded.Pixels {
+		decoded.Pixels[i] = binary.LittleEndian.Uint16(payload[2*i:])
+	}
+	if err := decoded.check(); err != nil {
+		return err
+	}
+	*frame = decoded
+	return nil
+}
+
+// WriteTo writes the encoded frame to w
+func (frame *PackedFrame) WriteTo(w io.Writer) (int64, error) {
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// ReadPackedFrame reads an encoded frame, up to the end of r
+func ReadPackedFrame(r io.Reader) (*PackedFrame, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	frame := &PackedFrame{}
+	if err := frame.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Display displays the frame with the given mode. The frame must have been
+// packed for a panel of the same size.
+func (frame *PackedFrame) Display(mode DisplayMode) error {
+	if err := frame.check(); err != nil {
+		return err
+	}
+	bounds := DeviceInfo().Bounds()
+	if frame.Panel != bounds.Size() {
+		return fmt.Errorf("it8951: frame packed for a %dx%d panel", frame.Panel.X, frame.Panel.Y)
+	}
+	if area := rotatedArea(frame.Area, frame.Rotation); !area.In(bounds) {
+		return fmt.Errorf("it8951: area %v off the panel", area)
+	}
+	targetAddress := DeviceInfo().TargetAddress()
+	if frame.Bpp == 1 {
+		return Refresh1bppRect(frame.Pixels, frame.Area, mode, targetAddress, true, frame.Rotation)
+	}
+	buffer, area := rotateLoad(frame.Pixels, frame.Area, frame.Bpp, frame.Rotation)
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
+	loadBuffer(buffer, area, frame.Bpp, targetAddress)
+	return DisplayRectBuffer(area, mode, targetAddress)
+}
@@ -0,0 +1,100 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"runtime"
+	"slices"
+	"testing"
+
+	it "github.com/peergum/IT8951-go"
+)
+
+// TestPackedFrameCompressions checks frames survive encoding with each
+// compression
+func TestPackedFrameCompressions(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 320, 240))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*i>>7) & 0xf0
+	}
+	for _, compression := range []it.FrameCompression{it.CompressNone, it.CompressDeflate, it.CompressZstd, it.CompressLZ4} {
+		frame := &it.PackedFrame{
+			Panel:       image.Pt(320, 240),
+			Area:        img.Rect,
+			Bpp:         4,
+			Compression: compression,
+			Pixels:      it.PackImage(img, img.Rect, 4),
+		}
+		data, err := frame.MarshalBinary()
+		if err != nil {
+			t.Fatalf("compression %d: %v", compression, err)
+		}
+		var decoded it.PackedFrame
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("compression %d: %v", compression, err)
+		}
+		if !slices.Equal(decoded.Pixels, frame.Pixels) {
+			t.Errorf("compression %d: pixels differ", compression)
+		}
+
+	}
+}
+
+// TestPackedFrameLyingHeader checks frames claiming more pixels than their
+// panel holds, or a panel larger than any, are rejected before their
+// payload is decompressed
+func TestPackedFrameLyingHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		panel image.Point
+		area  image.Rectangle
+	}{
+		{"huge panel", image.Pt(0xffff, 0xffff), image.Rect(0, 0, 0xffff, 0xffff)},
+		{"area off the panel", image.Pt(320, 240), image.Rect(0, 0, 0xffff, 0xffff)},
+		{"empty panel", image.Pt(0, 0), image.Rect(0, 0, 16, 16)},
+	} {
+		for _, compression := range []it.FrameCompression{it.CompressDeflate, it.CompressZstd, it.CompressLZ4} {
+			// a tiny LZ4 payload expanding to a run of zeros
+			data := []byte("IT8F\x01")
+			for _, field := range []int{tc.panel.X, tc.panel.Y, tc.area.Min.X, tc.area.Min.Y, tc.area.Dx(), tc.area.Dy()} {
+				data = binary.BigEndian.AppendUint16(data, uint16(field))
+			}
+			payload := []byte{0x1f, 0, 1, 0, 0xff, 0xff, 0xff, 0xff}
+			data = append(data, 8, 0, byte(compression))
+			data = binary.BigEndian.AppendUint32(data, uint32(len(payload)))
+			data = append(data, payload...)
+			data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			var frame it.PackedFrame
+			err := frame.UnmarshalBinary(data)
+			runtime.ReadMemStats(&after)
+			if err == nil {
+				t.Errorf("%s, compression %d: accepted", tc.name, compression)
+			}
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+				t.Errorf("%s, compression %d: %d bytes allocated", tc.name, compression, allocated)
+			}
+		}
+	}
+}
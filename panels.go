@@ -0,0 +1,144 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+)
+
+// PanelModel describes a panel model and the settings it works best with
+type PanelModel struct {
+	Name string      // model name, e.g. "10.3" for the Waveshare 10.3" panel
+	Size image.Point // resolution in pixels, used to recognize the model
+	// VCOM is the typical VCOM of the model, in mV, used when Init is given
+	// none. Panels vary: the value printed on the panel cable is better.
+	VCOM uint16
+	// LUT is the LUT family the model ships with, whose mode table is used
+	// when the controller reports a LUT unknown to the driver (see ModeTable)
+	LUT string
+	// FourByteAlign makes 1bpp (A2) areas start and end on 4 byte (32
+	// pixel) boundaries instead of 2, which the model needs
+	FourByteAlign bool
+	// SPISpeed is the preferred SPI clock in Hz, applied to the default
+	// go-rpio transport when the model is given to Init by name
+	SPISpeed int
+}
+
+// panelModels holds the known models. 7.8" and 10.3" panels have the same
+// resolution: the most common 10.3" is recognized, 7.8" ones must be named.
+var panelModels = []PanelModel{
+	{Name: "6", Size: image.Pt(800, 600), VCOM: 1500, LUT: "M641", FourByteAlign: true, SPISpeed: 24000000},
+	{Name: "6HD", Size: image.Pt(1448, 1072), VCOM: 1500, LUT: "M841", SPISpeed: 24000000},
+	{Name: "9.7", Size: image.Pt(1200, 825), VCOM: 1500, LUT: "M841", SPISpeed: 24000000},
+	{Name: "10.3", Size: image.Pt(1872, 1404), VCOM: 1500, LUT: "M841", SPISpeed: 24000000},
+	{Name: "7.8", Size: image.Pt(1872, 1404), VCOM: 1500, LUT: "M841", SPISpeed: 24000000},
+	{Name: "13.3", Size: image.Pt(1600, 1200), VCOM: 1500, LUT: "M841", SPISpeed: 24000000},
+}
+
+var (
+	panelModel    *PanelModel // model in use, if known
+	fourByteAlign bool        // see PanelModel.FourByteAlign
+)
+
+// RegisterPanelModel adds a model, or replaces the one with the same name.
+// It must be called before Init.
+func RegisterPanelModel(model PanelModel) {
+	for i := range panelModels {
+		if panelModels[i].Name == model.Name {
+			panelModels[i] = model
+			return
+		}
+	}
+	panelModels = append(panelModels, model)
+}
+
+// LookupPanelModel returns the model with the given name
+func LookupPanelModel(name string) (PanelModel, bool) {
+	for _, model := range panelModels {
+		if model.Name == name {
+			return model, true
+		}
+	}
+	return PanelModel{}, false
+}
+
+// DetectPanelModel returns the model with the resolution of a panel
+func DetectPanelModel(devInfo *DevInfo) (PanelModel, bool) {
+	size := devInfo.Bounds().Size()
+	for _, model := range panelModels {
+		if model.Size == size {
+			return model, true
+		}
+	}
+	return PanelModel{}, false
+}
+
+// CurrentPanelModel returns the model of the panel in use, named with
+// WithPanelModel or recognized by Init
+func CurrentPanelModel() (PanelModel, bool) {
+	if panelModel == nil {
+		return PanelModel{}, false
+	}
+	return *panelModel, true
+}
+
+// WithPanelModel sets the panel model (see PanelModel) instead of
+// recognizing it from the panel resolution
+func WithPanelModel(name string) Option {
+	return func(c *Config) {
+		c.PanelModel = name
+	}
+}
+
+// namedPanelModel returns the model set with WithPanelModel, nil when none
+func namedPanelModel() (*PanelModel, error) {
+	if config.PanelModel == "" {
+		return nil, nil
+	}
+	model, ok := LookupPanelModel(config.PanelModel)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown panel model %q", ErrInvalidConfig, config.PanelModel)
+	}
+	return &model, nil
+}
+
+// applyPanelModel sets up the driver for the model given, or else the one
+// recognized from devInfo
+func applyPanelModel(model *PanelModel, devInfo *DevInfo) {
+	if model == nil {
+		if detected, ok := DetectPanelModel(devInfo); ok {
+			model = &detected
+		}
+	}
+	panelModel = model
+	fourByteAlign = model != nil && model.FourByteAlign
+	if model == nil {
+		Debug("Unknown panel model")
+		return
+	}
+	Debug("Panel model %s", model.Name)
+	fw := devInfo.Firmware()
+	_, knownLUT := modeTables[fw.LUT]
+	_, knownFamily := modeTables[fw.LUTFamily]
+	if table, ok := modeTables[model.LUT]; ok && !knownLUT && !knownFamily {
+		Debug("Unknown LUT %s, assuming %s", fw.LUT, model.LUT)
+		RegisterModeTable(fw.LUT, table)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteAreaBytes loads packed pixels to the w×h area at x, y of the image
+// buffer, without displaying it (see DisplayRect). pix holds the rows one
+// after the other, each padded to a whole number of 16 bit words (see
+// GetWidthInWords), with the first pixel in the least significant bits of the
+// first byte: the layout of a DataBuffer in memory. The bytes are sent as
+// they are, the controller swapping them back into words (big endian load),
+// so large frames need neither conversion nor copy.
+//
+// At 1bpp, pixels are loaded as bytes of 8 pixels, as by Write1bppRect: x
+// and w must be multiples of 16.
+func WriteAreaBytes(x, y, w, h uint16, bpp int, pix []byte) error {
+	switch bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("it8951: unsupported bpp %d", bpp)
+	}
+	stride := 2 * GetWidthInWords(int(w), bpp)
+	if len(pix) != stride*int(h) {
+		return fmt.Errorf("it8951: %d bytes of pixels instead of %d", len(pix), stride*int(h))
+	}
+	area := AreaImgInfo{X: x, Y: y, W: w, H: h}
+	imageInfo := LoadImgInfo{
+		EndianType:    LoadImgBigEndian,
+		PixelFormat:   Bpp(bpp),
+		Rotate:        Rotate0,
+		TargetMemAddr: DeviceInfo().TargetAddress(),
+	}
+	if bpp == 1 {
+		if x%16 != 0 || w%16 != 0 {
+			return fmt.Errorf("it8951: 1bpp areas need x and width multiple of 16")
+		}
+		area.X /= 8
+		area.W /= 8
+		imageInfo.PixelFormat = BPP8
+	}
+	WaitForDisplayReady()
+	if config.VerifyRetries > 0 {
+		// verified uploads go through memory bursts, which take words
+		buffer := make(DataBuffer, len(pix)/2)
+		for i := range buffer {
+			buffer[i] = uint16(pix[2*i]) | uint16(pix[2*i+1])<<8
+		}
+		imageInfo.EndianType = LoadImgLittleEndian
+		imageInfo.SourceBufferAddr = buffer
+		imageInfo.HostAreaPackedPixelWrite(area, bpp, true)
+		return Err()
+	}
+	return imageInfo.loadImage(&area, func() {
+		writeByteBuffer(pix)
+	})
+}
+
+// writeByteBuffer writes bytes as data, in SPI order: each pair of bytes is
+// a big endian word
+func writeByteBuffer(data []byte) error {
+	Trace("Writing bytes (size=%d)", len(data))
+	if err := waitReady(); err != nil {
+		return err
+	}
+	csOn()
+	SendPreamble(WritePreamble)
+	writeBytes(data)
+	traceEvent("burst-write", len(data)/2)
+	csOff()
+	return busErr
+}
+
+// LoadImageAreaFrom loads the packed pixels of an area of the image buffer
+// read from r, without displaying it. The pixels are streamed from r to the
+// controller in transfers of the SPI chunk size, so that even full frames
+// are never held in memory. r must provide exactly the bytes of the area
+// rows, each padded to a whole number of 16 bit words, which are sent as
+// they are: with a big endian load, the layout of a DataBuffer in memory, as
+// for WriteAreaBytes. The controller is not released while waiting for r,
+// except between bands of rows (see Config.TileWords), and uploads are not
+// verified.
+func LoadImageAreaFrom(r io.Reader, area AreaImgInfo, info LoadImgInfo) error {
+	var bpp int
+	switch info.PixelFormat {
+	case BPP2:
+		bpp = 2
+	case BPP4:
+		bpp = 4
+	case BPP8:
+		bpp = 8
+	default:
+		return fmt.Errorf("it8951: unsupported pixel format %d", info.PixelFormat)
+	}
+	stride := 2 * GetWidthInWords(int(area.W), bpp)
+	rows := info.tileRows(area, stride/2*int(area.H))
+	chunk := make([]byte, stats.ChunkSize)
+	WaitForDisplayReady()
+	for y := uint16(0); y < area.H; y += rows {
+		band := area
+		band.Y += y
+		band.H = min(rows, area.H-y)
+		var readErr error
+		err := info.loadImage(&band, func() {
+			readErr = streamBytes(r, stride*int(band.H), chunk)
+		})
+		if readErr != nil {
+			return readErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamBytes writes size bytes read from r as data, in transfers of at most
+// len(chunk) bytes. Bytes missing from r are sent as zeros so the load
+// started can end, and reported as an error.
+func streamBytes(r io.Reader, size int, chunk []byte) error {
+	if err := waitReady(); err != nil {
+		return err
+	}
+	csOn()
+	defer csOff()
+	SendPreamble(WritePreamble)
+	var readErr error
+	for sent := 0; sent < size; {
+		data := chunk[:min(len(chunk), size-sent)]
+		if readErr == nil {
+			var n int
+			n, readErr = io.ReadFull(r, data)
+			clear(data[n:])
+		}
+		waitReady()
+		bus.Transmit(data...)
+		sent += len(data)
+	}
+	stats.WordsWritten += uint64(size / 2)
+	traceEvent("burst-write", size/2)
+	if readErr != nil {
+		return fmt.Errorf("it8951: reading pixels: %w", readErr)
+	}
+	return busErr
+}
@@ -0,0 +1,119 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"context"
+	"time"
+)
+
+// PowerState is the power mode of the controller
+type PowerState uint8
+
+// Power states
+const (
+	PowerRun       PowerState = iota // running
+	PowerStandby                     // STANDBY: clocks stopped, fast to wake
+	PowerSleep                       // SLEEP: clocks and panel power off
+	PowerDeepSleep                   // SLEEP with the reset line held: state is lost
+)
+
+var (
+	power       PowerState
+	lastCommand time.Time // time of the last command sent
+)
+
+// Power returns the power state of the controller, as set by the last
+// SystemRun, StandBy, Sleep or EnterDeepSleep
+func Power() PowerState {
+	return power
+}
+
+// EnterDeepSleep puts the controller to sleep and holds its reset line, for
+// the lowest consumption. Wake, or any command, resets and reinitializes it,
+// restoring its registers (see RestoreState).
+func EnterDeepSleep() error {
+	Debug("Deep sleep mode")
+	dimLight()
+	return transaction(TCONSleep, func() {
+		if WriteCommand(TCONSleep) != nil {
+			return
+		}
+		bus.Reset(true)
+		power = PowerDeepSleep
+	})
+}
+
+// wakeFor tracks the power state as commands are sent, and wakes the
+// controller up before other commands when it is not running
+func wakeFor(command Command) error {
+	lastCommand = time.Now()
+	switch command {
+	case TCONSysRun:
+		power = PowerRun
+		return nil
+	case TCONStandby:
+		power = PowerStandby
+		return nil
+	case TCONSleep:
+		power = PowerSleep
+		return nil
+	}
+	if power == PowerRun {
+		return nil
+	}
+	Debug("Waking up for command %04x", command)
+	return Wake()
+}
+
+// AutoSleep starts a worker putting the controller in a power state
+// (PowerStandby, PowerSleep or PowerDeepSleep) once no command was sent for
+// idle. The controller wakes up by itself on the next command. Controller
+// access must go through Do.
+func (d *Display) AutoSleep(idle time.Duration, state PowerState) {
+	d.Go(func(ctx context.Context) error {
+		ticker := time.NewTicker(max(idle/10, 10*time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+			err := d.Do(func() error {
+				if power != PowerRun || time.Since(lastCommand) < idle || RemainingRefresh() > 0 {
+					return nil
+				}
+				Debug("Idle for %v, power state %d", idle, state)
+				switch state {
+				case PowerStandby:
+					return StandBy()
+				case PowerSleep:
+					return Sleep()
+				case PowerDeepSleep:
+					return EnterDeepSleep()
+				}
+				return Err()
+			})
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
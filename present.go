@@ -0,0 +1,194 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"golang.org/x/image/font"
+)
+
+// Presenter shows a region of an image, in logical coordinates, with a
+// display mode
+type Presenter interface {
+	Present(img image.Image, region image.Rectangle, mode DisplayMode) error
+}
+
+// PresenterFunc is a function used as a Presenter
+type PresenterFunc func(img image.Image, region image.Rectangle, mode DisplayMode) error
+
+// Present calls f
+func (f PresenterFunc) Present(img image.Image, region image.Rectangle, mode DisplayMode) error {
+	return f(img, region, mode)
+}
+
+// Middleware wraps a Presenter, e.g. to alter frames (watermark, contrast)
+// or observe them (logs, metrics) before calling next
+type Middleware func(next Presenter) Presenter
+
+// Chain returns p wrapped by middlewares, the first one being the outermost
+func Chain(p Presenter, middlewares ...Middleware) Presenter {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		p = middlewares[i](p)
+	}
+	return p
+}
+
+// Use adds middlewares around the frames presented by Play
+func (d *Display) Use(middlewares ...Middleware) {
+	d.middlewares = append(d.middlewares, middlewares...)
+}
+
+// SetModeBpp sets the bits per pixel (1, 2, 4 or 8) frames presented with a
+// mode are converted to, e.g. 1 for A2Mode, which only shows black and white,
+// and 4 for GC16Mode. Modes without a setting use 4bpp. As mode numbers
+// depend on the panel LUT, it must be called after Init, before presenting
+// frames.
+func (d *Display) SetModeBpp(mode DisplayMode, bpp int) error {
+	switch bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("it8951: unsupported bpp %d", bpp)
+	}
+	if d.bpp == nil {
+		d.bpp = map[DisplayMode]int{}
+	}
+	d.bpp[mode] = bpp
+	return nil
+}
+
+// modeBpp returns the bits per pixel frames are presented at with a mode
+func (d *Display) modeBpp(mode DisplayMode) int {
+	if bpp, ok := d.bpp[mode]; ok {
+		return bpp
+	}
+	return 4
+}
+
+// Present shows a frame through the middlewares, refreshing only what
+// changed since the previous frame. Workers call it from within Do.
+func (d *Display) Present(img image.Image, region image.Rectangle, mode DisplayMode) error {
+	return Chain(PresenterFunc(d.present), d.middlewares...).Present(img, region, mode)
+}
+
+// present diffs and displays a frame, degrading it if the quality policy
+// says so
+func (d *Display) present(img image.Image, region image.Rectangle, mode DisplayMode) error {
+	return d.presentWith(img, region, mode, d.quality, d.Backlog())
+}
+
+// presentWith diffs and displays a frame, degrading it if quality, when not
+// nil, says so for the given backlog
+func (d *Display) presentWith(img image.Image, region image.Rectangle, mode DisplayMode, quality *QualityPolicy, backlog int) error {
+	changed := d.diffFrame(img, region)
+	if highContrast && !changed.Empty() {
+		// thickened strokes reach past the pixels that changed
+		changed = changed.Inset(-contrastRadius).Intersect(d.shadow.Image().Rect)
+	}
+	if quality != nil && backlog >= quality.Backlog {
+		if changed.Empty() {
+			return nil
+		}
+		return quality.degrade(img, changed)
+	}
+	if !changed.Empty() {
+		bpp := d.modeBpp(mode)
+		if d.policies != nil {
+			if policy, ok := d.policies.Policy(changed); ok {
+				mode, bpp = policy.Mode, policy.Bpp
+			}
+		}
+		if highContrast {
+			bpp = 1 // black and white anyway
+		}
+		if err := displayImage(img, changed, bpp, mode); err != nil {
+			return err
+		}
+	}
+	if quality != nil && backlog == 0 {
+		return quality.cleanup(d.shadow.Image())
+	}
+	return Err()
+}
+
+// LogFrames is a middleware logging every frame presented (in debug mode)
+func LogFrames(next Presenter) Presenter {
+	return PresenterFunc(func(img image.Image, region image.Rectangle, mode DisplayMode) error {
+		Debug("Presenting %v with mode %d", region, mode)
+		return next.Present(img, region, mode)
+	})
+}
+
+// Measure returns a middleware reporting how long each frame took to present
+func Measure(report func(region image.Rectangle, elapsed time.Duration)) Middleware {
+	return func(next Presenter) Presenter {
+		return PresenterFunc(func(img image.Image, region image.Rectangle, mode DisplayMode) error {
+			start := time.Now()
+			err := next.Present(img, region, mode)
+			report(region, time.Since(start))
+			return err
+		})
+	}
+}
+
+// Annotate returns a middleware stamping a line of text, e.g. the update time
+// or the device name, in black on the background gray in a corner (or any
+// anchor) of the safe area of every frame. text is called for each frame, and
+// the stamp is refreshed along with the frame region.
+func Annotate(anchor Anchor, face font.Face, text func() string) Middleware {
+	return func(next Presenter) Presenter {
+		return PresenterFunc(func(img image.Image, region image.Rectangle, mode DisplayMode) error {
+			line := text()
+			box, dot := PlaceText(face, line, anchor, SafeArea())
+			stamp := image.NewGray(box)
+			draw.Draw(stamp, box, image.NewUniform(color.Gray{Y: config.Background}), image.Point{}, draw.Src)
+			drawer := font.Drawer{Dst: stamp, Src: image.Black, Face: face, Dot: dot}
+			drawer.DrawString(VisualText(line))
+			return next.Present(annotated{Image: img, stamp: stamp}, region.Union(box), mode)
+		})
+	}
+}
+
+// annotated is an image with a stamp drawn over it
+type annotated struct {
+	image.Image
+	stamp *image.Gray
+}
+
+// Bounds returns the image bounds extended to the stamp
+func (a annotated) Bounds() image.Rectangle {
+	return a.Image.Bounds().Union(a.stamp.Rect)
+}
+
+// At returns the stamp pixels over the image ones, and the background gray
+// outside of both
+func (a annotated) At(x, y int) color.Color {
+	p := image.Pt(x, y)
+	switch {
+	case p.In(a.stamp.Rect):
+		return a.stamp.GrayAt(x, y)
+	case p.In(a.Image.Bounds()):
+		return a.Image.At(x, y)
+	}
+	return color.Gray{Y: config.Background}
+}
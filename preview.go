@@ -0,0 +1,73 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// PreviewOptions are the settings of Preview. The zero value previews the
+// whole image at 4bpp with the current ditherer.
+type PreviewOptions struct {
+	Bpp      int             // 1, 2, 4 or 8 (4, or the profile one, by default)
+	Ditherer Ditherer        // current ditherer, or the profile one, when nil (see SetDitherer)
+	Area     image.Rectangle // area of the image, its bounds when empty
+	Profile  *SourceProfile  // see SourceProfile, none when nil
+}
+
+// Preview returns an area of img as the panel would show it: converted to
+// gray, dithered and reduced to the gray levels of opts.Bpp, and inverted in
+// night mode. It needs no controller, so that content can be checked (e.g.
+// saved with png.Encode) before being displayed. Unbounded images (such as
+// image.Uniform) need an area.
+func Preview(img image.Image, opts PreviewOptions) *image.Gray {
+	bpp := opts.Bpp
+	if bpp == 0 {
+		bpp = 4
+		if opts.Profile != nil {
+			bpp = opts.Profile.bpp()
+		}
+	}
+	d := opts.Ditherer
+	if d == nil && opts.Profile != nil {
+		d = opts.Profile.Ditherer
+	}
+	if d == nil {
+		d = currentDitherer()
+	}
+	area := opts.Area
+	if area.Empty() {
+		area = img.Bounds()
+	}
+	if opts.Profile != nil {
+		img = opts.Profile.Prepare(img, area)
+	}
+	levels := 1 << bpp
+	gray := reduceLevels(img, toGray(img, area), levels, d)
+	// keep the bits sent to the panel, spread back over 0-255
+	var shown [256]uint8
+	for value := range shown {
+		shown[value] = uint8((value >> (8 - bpp)) * 255 / (levels - 1))
+		if inverted {
+			shown[value] = ^shown[value]
+		}
+	}
+	for i, value := range gray.Pix {
+		gray.Pix[i] = shown[value]
+	}
+	return gray
+}
@@ -0,0 +1,145 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"sort"
+)
+
+// SourceProfile bundles the conversion settings suited to a kind of content,
+// e.g. photos (gamma, sharpening and error diffusion at 4bpp) or scanned
+// documents (black and white with an adaptive threshold), so that callers
+// pick one by name rather than tuning each setting
+type SourceProfile struct {
+	Name string
+	// Tone maps the gray levels before anything else, on top of the tone
+	// curve set for the driver (none when nil)
+	Tone *ToneCurve
+	// Sharpen is the amount of unsharp masking, e.g. 0.5 to restore the
+	// edges softened by scaling (none when 0)
+	Sharpen float64
+	// Invert inverts the gray levels, e.g. for light on dark screenshots or
+	// scanned negatives
+	Invert bool
+	// Ditherer reduces the levels, thresholds included (see Otsu and
+	// AdaptiveThreshold), the current one when nil
+	Ditherer Ditherer
+	// Bpp is the depth the content is best shown at (4 when 0)
+	Bpp int
+	// Crop trims the uniform margins before the content is fitted to the
+	// panel (see FileOptions.Crop)
+	Crop bool
+}
+
+var (
+	// sourceProfiles are the profiles selectable by name
+	sourceProfiles = map[string]SourceProfile{
+		"photo": {
+			Name:     "photo",
+			Tone:     GammaCurve(0.8), // panels render midtones dark
+			Sharpen:  0.5,
+			Ditherer: FloydSteinberg,
+			Bpp:      4,
+		},
+		"document": {
+			Name:     "document",
+			Ditherer: AdaptiveThreshold(15, 10),
+			Bpp:      1,
+			Crop:     true,
+		},
+		"screenshot": {
+			Name:     "screenshot",
+			Ditherer: Quantize,
+			Bpp:      4,
+		},
+	}
+)
+
+// RegisterSourceProfile adds or replaces a profile selectable by name
+func RegisterSourceProfile(profile SourceProfile) {
+	sourceProfiles[profile.Name] = profile
+}
+
+// LookupSourceProfile returns the profile with the given name: photo,
+// document, screenshot or one registered with RegisterSourceProfile
+func LookupSourceProfile(name string) (SourceProfile, bool) {
+	profile, ok := sourceProfiles[name]
+	return profile, ok
+}
+
+// SourceProfileNames returns the names of the profiles, sorted
+func SourceProfileNames() []string {
+	names := make([]string, 0, len(sourceProfiles))
+	for name := range sourceProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Prepare returns an area of img converted to gray with the tone curve,
+// sharpening and inversion of the profile applied, ready to be displayed at
+// the profile bpp with its ditherer
+func (profile SourceProfile) Prepare(img image.Image, area image.Rectangle) *image.Gray {
+	gray := toGray(img, area)
+	if profile.Tone != nil {
+		profile.Tone.apply(gray)
+	}
+	if profile.Sharpen > 0 {
+		gray = sharpen(gray, profile.Sharpen)
+	}
+	if profile.Invert {
+		for i, value := range gray.Pix {
+			gray.Pix[i] = ^value
+		}
+	}
+	return gray
+}
+
+// bpp returns the depth of the profile
+func (profile SourceProfile) bpp() int {
+	if profile.Bpp == 0 {
+		return 4
+	}
+	return profile.Bpp
+}
+
+// sharpen returns src with an unsharp mask of the given amount: every pixel
+// moves away from the mean of its 3x3 neighbourhood
+func sharpen(src *image.Gray, amount float64) *image.Gray {
+	bounds := src.Rect
+	dst := image.NewGray(bounds)
+	weight := int(amount * 256)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sum, count := 0, 0
+			for ny := max(y-1, bounds.Min.Y); ny <= min(y+1, bounds.Max.Y-1); ny++ {
+				for nx := max(x-1, bounds.Min.X); nx <= min(x+1, bounds.Max.X-1); nx++ {
+					sum += int(src.Pix[src.PixOffset(nx, ny)])
+					count++
+				}
+			}
+			value := int(src.Pix[src.PixOffset(x, y)])
+			value += (value - sum/count) * weight / 256
+			dst.Pix[dst.PixOffset(x, y)] = uint8(min(max(value, 0), 255))
+		}
+	}
+	return dst
+}
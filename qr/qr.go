@@ -0,0 +1,514 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package qr encodes QR codes, e.g. for the URL of a device control panel
+// shown on its panel (see it8951.NetworkSplash). Data is encoded in byte
+// mode, in the smallest version that holds it.
+package qr
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// Level is the error correction level of a code
+type Level int
+
+// Error correction levels, recovering about 7%, 15%, 25% and 30% of the code
+const (
+	L Level = iota
+	M
+	Q
+	H
+)
+
+// ErrTooLong is returned when data does not fit in a version 40 code
+var ErrTooLong = errors.New("qr: data too long")
+
+// eccCodewords is the number of error correction codewords per block, and
+// eccBlocks the number of blocks, by level and version
+var (
+	eccCodewords = [4][41]int{
+		{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	eccBlocks = [4][41]int{
+		{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+	// formatLevels are the level bits of the format information
+	formatLevels = [4]int{1, 0, 3, 2}
+)
+
+// Code is an encoded QR code
+type Code struct {
+	Version int
+	Size    int // modules per side
+
+	modules  [][]bool // dark modules, by row
+	function [][]bool // modules of the function patterns
+}
+
+// Encode encodes data at the given error correction level
+func Encode(data []byte, level Level) (*Code, error) {
+	version := 1
+	for ; ; version++ {
+		if version > 40 {
+			return nil, ErrTooLong
+		}
+		if 4+countBits(version)+8*len(data) <= 8*dataCodewords(version, level) {
+			break
+		}
+	}
+	capacity := 8 * dataCodewords(version, level)
+	var bits bitBuffer
+	bits.append(0b0100, 4) // byte mode
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, capacity-len(bits))) // terminator
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xec; len(bits) < capacity; pad ^= 0xec ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	c := &Code{Version: version, Size: version*4 + 17}
+	c.modules = newGrid(c.Size)
+	c.function = newGrid(c.Size)
+	c.drawFunctionPatterns()
+	c.drawCodewords(interleave(bits.bytes(), version, level))
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(level, mask)
+		if penalty := c.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		c.applyMask(mask) // undone
+	}
+	c.applyMask(best)
+	c.drawFormat(level, best)
+	return c, nil
+}
+
+// Black tells if the module at column x, row y is dark
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y][x]
+}
+
+// Image returns the code with each module drawn as scale x scale pixels,
+// within the 4 modules wide quiet zone readers need
+func (c *Code) Image(scale int) *image.Gray {
+	side := (c.Size + 8) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			if !c.Black(x/scale-4, y/scale-4) {
+				img.SetGray(x, y, color.Gray{Y: 0xff})
+			}
+		}
+	}
+	return img
+}
+
+// countBits returns the size of the byte count of a version
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawModules returns the number of modules of a version available for
+// data and error correction
+func rawModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		aligns := version/7 + 2
+		result -= (25*aligns-10)*aligns - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// dataCodewords returns the number of data codewords of a version and level
+func dataCodewords(version int, level Level) int {
+	return rawModules(version)/8 - eccCodewords[level][version]*eccBlocks[level][version]
+}
+
+// interleave splits data into blocks, adds their error correction
+// codewords and interleaves them all
+func interleave(data []byte, version int, level Level) []byte {
+	blocks := eccBlocks[level][version]
+	eccLength := eccCodewords[level][version]
+	raw := rawModules(version) / 8
+	shortBlocks := blocks - raw%blocks
+	shortLength := raw / blocks
+	divisor := rsDivisor(eccLength)
+	var all [][]byte
+	for i, k := 0, 0; i < blocks; i++ {
+		length := shortLength - eccLength
+		if i >= shortBlocks {
+			length++
+		}
+		block := append([]byte(nil), data[k:k+length]...)
+		k += length
+		ecc := rsRemainder(block, divisor)
+		if i < shortBlocks {
+			block = append(block, 0) // skipped when interleaving
+		}
+		all = append(all, append(block, ecc...))
+	}
+	result := make([]byte, 0, raw)
+	for i := range all[0] {
+		for j, block := range all {
+			if i != shortLength-eccLength || j >= shortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// newGrid returns a size x size grid
+func newGrid(size int) [][]bool {
+	grid := make([][]bool, size)
+	for y := range grid {
+		grid[y] = make([]bool, size)
+	}
+	return grid
+}
+
+// set sets a function module
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+// drawFunctionPatterns draws the timing, finder and alignment patterns, and
+// reserves the format and version modules
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	for _, center := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && y >= 0 && x < c.Size && y < c.Size {
+					distance := max(abs(dx), abs(dy))
+					c.set(x, y, distance != 2 && distance != 4)
+				}
+			}
+		}
+	}
+	positions := c.alignmentPositions()
+	last := len(positions) - 1
+	for i, y := range positions {
+		for j, x := range positions {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue // finder corners
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	c.drawFormat(0, 0)
+	c.drawVersion()
+}
+
+// alignmentPositions returns the centers of the alignment patterns along
+// each axis
+func (c *Code) alignmentPositions() []int {
+	if c.Version == 1 {
+		return nil
+	}
+	aligns := c.Version/7 + 2
+	step := (c.Version*8 + aligns*3 + 5) / (aligns*4 - 4) * 2
+	positions := make([]int, aligns)
+	positions[0] = 6
+	for i, position := aligns-1, c.Size-7; i >= 1; i, position = i-1, position-step {
+		positions[i] = position
+	}
+	return positions
+}
+
+// formatBits returns the 15 bits of the format information
+func formatBits(level Level, mask int) int {
+	data := formatLevels[level]<<3 | mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = remainder<<1 ^ (remainder>>9)*0x537
+	}
+	return (data<<10 | remainder) ^ 0x5412
+}
+
+// drawFormat draws both copies of the format information
+func (c *Code) drawFormat(level Level, mask int) {
+	bits := formatBits(level, mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true) // dark module
+}
+
+// versionBits returns the 18 bits of the version information
+func versionBits(version int) int {
+	remainder := version
+	for i := 0; i < 12; i++ {
+		remainder = remainder<<1 ^ (remainder>>11)*0x1f25
+	}
+	return version<<12 | remainder
+}
+
+// drawVersion draws both copies of the version information, from version 7
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	bits := versionBits(c.Version)
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.set(a, b, dark)
+		c.set(b, a, dark)
+	}
+}
+
+// drawCodewords fills the data modules, in zigzag pairs of columns from the
+// bottom right corner
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // vertical timing pattern
+		}
+		for vertical := 0; vertical < c.Size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 { // upwards
+					y = c.Size - 1 - vertical
+				}
+				if !c.function[y][x] && i < len(data)*8 {
+					c.modules[y][x] = data[i>>3]>>(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by a mask pattern
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.function[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to read: runs and boxes of the same
+// color, finder-like patterns and unbalanced dark and light modules
+func (c *Code) penalty() int {
+	result := 0
+	dark := 0
+	line := make([]bool, c.Size)
+	for pass := 0; pass < 2; pass++ { // rows, then columns
+		for i := 0; i < c.Size; i++ {
+			for j := range line {
+				if pass == 0 {
+					line[j] = c.modules[i][j]
+				} else {
+					line[j] = c.modules[j][i]
+				}
+			}
+			result += linePenalty(line)
+		}
+	}
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				color := c.modules[y][x]
+				if color == c.modules[y][x-1] && color == c.modules[y-1][x] && color == c.modules[y-1][x-1] {
+					result += 3
+				}
+			}
+		}
+	}
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*10
+}
+
+// finderLike are the module sequences looking like finder patterns
+var finderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// linePenalty scores the runs and finder-like patterns of a row or column
+func linePenalty(line []bool) int {
+	result := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			result += 3 + run - 5
+		}
+		run = 1
+	}
+	for i := 0; i+11 <= len(line); i++ {
+		for _, pattern := range finderLike {
+			match := true
+			for j, dark := range pattern {
+				if line[i+j] != dark {
+					match = false
+					break
+				}
+			}
+			if match {
+				result += 40
+			}
+		}
+	}
+	return result
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// bitBuffer is a sequence of bits
+type bitBuffer []bool
+
+// append appends the low n bits of value, most significant first
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 != 0)
+	}
+}
+
+// bytes returns the bits packed in bytes, most significant first
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, (len(b)+7)/8)
+	for i, bit := range b {
+		if bit {
+			result[i>>3] |= 0x80 >> (i & 7)
+		}
+	}
+	return result
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree,
+// highest coefficients first, the leading 1 omitted
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range divisor {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
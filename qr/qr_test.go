@@ -0,0 +1,130 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package qr_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/peergum/IT8951-go/qr"
+)
+
+// golden matrices in testdata were produced by rsc.io/qr with the mask this
+// encoder picked, one row per line, '#' for a dark module
+var golden = []struct {
+	file    string
+	data    string
+	level   qr.Level
+	version int
+}{
+	{"v1-l.txt", "hello", qr.L, 1},
+	{"v2-m.txt", "https://github.com/peergum", qr.M, 2},
+	{"v4-q.txt", strings.Repeat("IT8951 ", 6), qr.Q, 4},
+	{"v7-h.txt", strings.Repeat("e-paper ", 8), qr.H, 7},
+	{"v10-l.txt", strings.Repeat("0123456789abcdef", 15), qr.L, 10},
+}
+
+// TestEncodeGolden checks codes module by module against a reference
+// encoder, across levels and versions with and without version information
+func TestEncodeGolden(t *testing.T) {
+	for _, g := range golden {
+		t.Run(g.file, func(t *testing.T) {
+			want, err := os.ReadFile("testdata/" + g.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := qr.Encode([]byte(g.data), g.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Version != g.version || c.Size != 4*g.version+17 {
+				t.Fatalf("version %d size %d, want version %d", c.Version, c.Size, g.version)
+			}
+			var got bytes.Buffer
+			for y := 0; y < c.Size; y++ {
+				for x := 0; x < c.Size; x++ {
+					if c.Black(x, y) {
+						got.WriteByte('#')
+					} else {
+						got.WriteByte('.')
+					}
+				}
+				got.WriteByte('\n')
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("matrix differs from reference\ngot:\n%s\nwant:\n%s", got.Bytes(), want)
+			}
+		})
+	}
+}
+
+// TestEncodeCapacity checks the smallest version is picked at the limits of
+// version 1 and 40
+func TestEncodeCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		n       int
+		level   qr.Level
+		version int
+	}{
+		{17, qr.L, 1},
+		{18, qr.L, 2},
+		{7, qr.H, 1},
+		{8, qr.H, 2},
+		{2953, qr.L, 40},
+		{1273, qr.H, 40},
+	} {
+		c, err := qr.Encode(make([]byte, tc.n), tc.level)
+		if err != nil {
+			t.Errorf("%d bytes at level %d: %v", tc.n, tc.level, err)
+			continue
+		}
+		if c.Version != tc.version {
+			t.Errorf("%d bytes at level %d: version %d, want %d", tc.n, tc.level, c.Version, tc.version)
+		}
+	}
+	if _, err := qr.Encode(make([]byte, 2954), qr.L); !errors.Is(err, qr.ErrTooLong) {
+		t.Errorf("2954 bytes: %v, want ErrTooLong", err)
+	}
+}
+
+// TestImage checks the quiet zone and module scaling
+func TestImage(t *testing.T) {
+	c, err := qr.Encode([]byte("hello"), qr.M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := c.Image(3)
+	if size := (c.Size + 8) * 3; img.Rect.Dx() != size || img.Rect.Dy() != size {
+		t.Fatalf("image %v, want %dx%d", img.Rect, size, size)
+	}
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			dark := img.GrayAt((x+4)*3+1, (y+4)*3+1).Y == 0
+			if dark != c.Black(x, y) {
+				t.Fatalf("module %d,%d drawn dark=%v", x, y, dark)
+			}
+		}
+	}
+	if img.GrayAt(0, 0).Y != 0xff {
+		t.Error("quiet zone not white")
+	}
+}
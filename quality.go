@@ -0,0 +1,81 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// QualityPolicy trades quality for speed while frames pile up in the Submit
+// queue: frames are then displayed with a fast mode at a low bpp, without
+// dithering. Once the queue is empty, the areas they covered get a cleanup
+// refresh at full quality. See Display.AdaptQuality.
+type QualityPolicy struct {
+	// Backlog is the number of submissions waiting from which frames are
+	// degraded
+	Backlog int
+	// FastMode and FastBpp are the mode and bpp of degraded frames
+	FastMode DisplayMode
+	FastBpp  int
+	// CleanupMode is the mode of the cleanup refresh, done at 4bpp
+	CleanupMode DisplayMode
+
+	degraded image.Rectangle // area shown at low quality since the last cleanup
+}
+
+// DefaultQualityPolicy returns a policy switching to DU at 1bpp once 2
+// submissions are waiting, and cleaning up with GC16. As mode numbers depend
+// on the panel LUT, it must be called after Init.
+func DefaultQualityPolicy() *QualityPolicy {
+	return &QualityPolicy{
+		Backlog:     2,
+		FastMode:    DUMode,
+		FastBpp:     1,
+		CleanupMode: GC16Mode,
+	}
+}
+
+// AdaptQuality makes Present follow policy, or always use the frame mode and
+// its bpp when nil. Frames must then be submitted with Submit, whose backlog
+// tells when to catch up.
+func (d *Display) AdaptQuality(policy *QualityPolicy) {
+	d.quality = policy
+}
+
+// degrade displays a region of img at low quality
+func (policy *QualityPolicy) degrade(img image.Image, region image.Rectangle) error {
+	Debug("Behind, degrading %v", region)
+	policy.degraded = policy.degraded.Union(region)
+	ditherer := config.Ditherer
+	config.Ditherer = Quantize
+	defer func() { config.Ditherer = ditherer }()
+	return displayImage(img, region, policy.FastBpp, policy.FastMode)
+}
+
+// cleanup displays the areas shown at low quality again from frame, at full
+// quality
+func (policy *QualityPolicy) cleanup(frame image.Image) error {
+	if policy.degraded.Empty() {
+		return nil
+	}
+	Debug("Caught up, cleaning %v", policy.degraded)
+	if err := displayImage(frame, policy.degraded, 4, policy.CleanupMode); err != nil {
+		return err
+	}
+	policy.degraded = image.Rectangle{}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	it "github.com/peergum/IT8951-go"
+	"github.com/peergum/IT8951-go/sim"
+)
+
+// TestQualityUnderLoad checks that frames submitted faster than they are
+// displayed are degraded, then cleaned up once the queue is empty
+func TestQualityUnderLoad(t *testing.T) {
+	c := sim.New(sim.Typical(320, 240))
+	if _, err := it.Init(1500, it.WithTransport(c)); err != nil {
+		t.Fatal(err)
+	}
+	defer it.Exit()
+	var modes []it.DisplayMode
+	it.OnPresented(func(p it.Presented) {
+		modes = append(modes, p.Mode)
+	})
+	defer it.OnPresented(nil)
+
+	display := it.NewDisplay()
+	display.AdaptQuality(it.DefaultQualityPolicy())
+	var last <-chan error
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		frame := image.NewGray(image.Rect(0, 0, 320, 240))
+		for x := 0; x < 320; x++ {
+			frame.SetGray(x, 40*i+20, color.Gray{})
+		}
+		last = display.Submit(func() error {
+			return display.Present(frame, frame.Rect, it.GC16Mode)
+		})
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("submitting took %v", elapsed)
+	}
+	if err := <-last; err != nil {
+		t.Fatal(err)
+	}
+
+	degraded := 0
+	for _, mode := range modes {
+		if mode == it.DUMode {
+			degraded++
+		}
+	}
+	if degraded == 0 {
+		t.Errorf("no frame degraded under load, modes %v", modes)
+	}
+	if len(modes) == 0 || modes[len(modes)-1] != it.GC16Mode {
+		t.Errorf("no cleanup refresh once caught up, modes %v", modes)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951_test
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"testing"
+
+	it "github.com/peergum/IT8951-go"
+	"github.com/peergum/IT8951-go/sim"
+)
+
+// TestConcurrentDraw checks display functions and regions can be used from
+// several goroutines at once (run with -race)
+func TestConcurrentDraw(t *testing.T) {
+	c := sim.New(sim.Typical(320, 240))
+	if _, err := it.Init(1500, it.WithTransport(c)); err != nil {
+		t.Fatal(err)
+	}
+	defer it.Exit()
+	before := it.FrameID()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			img := image.NewGray(image.Rect(0, 0, 64, 64))
+			name := fmt.Sprint("region", i)
+			if err := it.DefineRegion(it.Region{Name: name, Bounds: image.Rect(64*i, 64, 64*i+64, 128), Mode: it.DUMode, Bpp: 4}); err != nil {
+				t.Error(err)
+				return
+			}
+			defer it.RemoveRegion(name)
+			for j := 0; j < 5; j++ {
+				if err := it.DrawImage(img, uint16(64*i), 0, 4, it.DUMode, it.Rotate0); err != nil {
+					t.Error(err)
+				}
+				if err := it.DrawRegion(name, img); err != nil {
+					t.Error(err)
+				}
+				it.RemainingRefresh()
+				it.Regions()
+			}
+		}()
+	}
+	wg.Wait()
+	if frames := it.FrameID() - before; frames != 40 {
+		t.Errorf("%d frames displayed instead of 40", frames)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "fmt"
+
+// RawTransaction sends a command followed by its arguments, then reads
+// readWords words of answer. It is meant for undocumented or user defined I80
+// commands: preambles, chip select and ready polling are handled as for the
+// built-in commands.
+func RawTransaction(cmd Command, args []uint16, readWords int) ([]uint16, error) {
+	if readWords < 0 {
+		return nil, fmt.Errorf("it8951: invalid read size %d", readWords)
+	}
+	Debug("Raw transaction %04x (%d args, %d words to read)", cmd, len(args), readWords)
+	var data DataBuffer
+	if readWords > 0 {
+		data = make(DataBuffer, readWords)
+	}
+	transaction(cmd, func() {
+		WriteCommand(cmd)
+		if len(args) > 0 {
+			DataBuffer(args).WriteBuffer()
+		}
+		if readWords > 0 {
+			data.ReadBuffer()
+		}
+	})
+	return data, nil
+}
@@ -0,0 +1,150 @@
+//go:build linux
+
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sysfsGPIO is the sysfs GPIO interface
+const sysfsGPIO = "/sys/class/gpio"
+
+// sysfsEdge waits for the rising edges of a GPIO line with the sysfs GPIO
+// interface: the kernel wakes the process up from epoll on the interrupt
+// instead of it polling the line
+type sysfsEdge struct {
+	value    *os.File
+	epfd     int
+	exported string // line number to unexport on close, if exported here
+}
+
+// openEdge sets up rising edge detection on a BCM GPIO
+func openEdge(gpio int) (edgeWaiter, error) {
+	base, err := gpioBase()
+	if err != nil {
+		return nil, err
+	}
+	line := strconv.Itoa(base + gpio)
+	dir := filepath.Join(sysfsGPIO, "gpio"+line)
+	edge := &sysfsEdge{epfd: -1}
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(filepath.Join(sysfsGPIO, "export"), []byte(line), 0); err != nil {
+			return nil, err
+		}
+		edge.exported = line
+	}
+	// udev sets the permissions of the line files shortly after export
+	deadline := time.Now().Add(time.Second)
+	for {
+		err = os.WriteFile(filepath.Join(dir, "edge"), []byte("rising"), 0)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		edge.close()
+		return nil, err
+	}
+	if edge.value, err = os.Open(filepath.Join(dir, "value")); err != nil {
+		edge.close()
+		return nil, err
+	}
+	if edge.epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC); err != nil {
+		edge.close()
+		return nil, err
+	}
+	fd := int(edge.value.Fd())
+	event := syscall.EpollEvent{Events: syscall.EPOLLPRI | syscall.EPOLLERR, Fd: int32(fd)}
+	if err := syscall.EpollCtl(edge.epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+		edge.close()
+		return nil, err
+	}
+	return edge, nil
+}
+
+// gpioBase returns the sysfs number of the first line of the Raspberry Pi
+// GPIO controller (0 on older kernels, 512 from 6.6 on)
+func gpioBase() (int, error) {
+	chips, err := filepath.Glob(filepath.Join(sysfsGPIO, "gpiochip*"))
+	if err != nil {
+		return 0, err
+	}
+	for _, chip := range chips {
+		label, err := os.ReadFile(filepath.Join(chip, "label"))
+		if err != nil || !strings.HasPrefix(string(label), "pinctrl-") {
+			continue
+		}
+		base, err := os.ReadFile(filepath.Join(chip, "base"))
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(strings.TrimSpace(string(base)))
+	}
+	return 0, errors.New("it8951: no GPIO controller in " + sysfsGPIO)
+}
+
+// high reads the line, which also acknowledges the edges seen so far
+func (edge *sysfsEdge) high() bool {
+	var value [1]byte
+	n, _ := edge.value.ReadAt(value[:], 0)
+	return n == 1 && value[0] == '1'
+}
+
+// wait waits for the line to be high, for at most timeout (forever when 0)
+func (edge *sysfsEdge) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	events := make([]syscall.EpollEvent, 1)
+	for !edge.high() {
+		ms := -1
+		if timeout > 0 {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return false
+			}
+			ms = int((left + time.Millisecond - 1) / time.Millisecond)
+		}
+		if _, err := syscall.EpollWait(edge.epfd, events, ms); err != nil && err != syscall.EINTR {
+			Debug("Edge wait failed: %v", err)
+			return false
+		}
+	}
+	return true
+}
+
+func (edge *sysfsEdge) close() error {
+	if edge.epfd >= 0 {
+		syscall.Close(edge.epfd)
+	}
+	if edge.value != nil {
+		edge.value.Close()
+	}
+	if edge.exported != "" {
+		return os.WriteFile(filepath.Join(sysfsGPIO, "unexport"), []byte(edge.exported), 0)
+	}
+	return nil
+}
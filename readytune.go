@@ -0,0 +1,131 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"slices"
+	"time"
+)
+
+// tuneReadySamples is the number of register reads timed when tuning the
+// ready line waits
+const tuneReadySamples = 64
+
+// defaultReadyInterval is the time between two reads of the ready line when
+// polling, until tuned
+const defaultReadyInterval = 10 * time.Microsecond
+
+var (
+	readyLatencies []time.Duration // ready line waits timed, while tuning
+)
+
+// pollReady reads the ready line of transport until it is high, for at most
+// timeout (forever when 0), and tells whether it is. It reads it in a loop
+// for stats.ReadySpin first, then every stats.ReadyInterval.
+func pollReady(transport Transport, timeout time.Duration) bool {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	spin := start.Add(stats.ReadySpin)
+	interval := stats.ReadyInterval
+	if interval <= 0 {
+		interval = defaultReadyInterval
+	}
+	for !transport.Ready() {
+		now := time.Now()
+		if timeout > 0 && now.After(deadline) {
+			return false
+		}
+		if now.After(spin) {
+			time.Sleep(interval)
+		}
+	}
+	return true
+}
+
+// readyWait returns how transfers wait for the ready line, ReadyAuto being
+// resolved by tuning at Init
+func readyWait() ReadyWait {
+	if config.ReadyWait == ReadyAuto {
+		return stats.ReadyWait
+	}
+	return config.ReadyWait
+}
+
+// tuneReady times how long the ready line takes to rise during register
+// reads, and sets the polling accordingly: short waits are spun on, as a
+// sleep would last longer than them, others are polled at a quarter of
+// their median, and with ReadyAuto, transports able to sleep until the line
+// rises do so when waits are long enough to be worth a wakeup.
+func tuneReady() {
+	Debug("Tuning ready line waits")
+	readyLatencies = make([]time.Duration, 0, tuneReadySamples)
+	saved := stats.ReadySpin
+	stats.ReadySpin = time.Hour // time waits precisely
+	for i := 0; i < tuneReadySamples; i++ {
+		ReadRegister(LUTAFSR)
+	}
+	stats.ReadySpin = saved
+	latencies := readyLatencies
+	readyLatencies = nil
+	slices.Sort(latencies)
+	waits := latencies[len(latencies)-countWaits(latencies):]
+	if len(waits) == 0 {
+		Debug("The ready line never had to be waited for")
+		stats.ReadyLatency, stats.ReadySpin, stats.ReadyInterval = 0, 0, defaultReadyInterval
+		return
+	}
+	median, p90 := waits[len(waits)/2], waits[len(waits)*9/10]
+	stats.ReadyLatency = median
+	stats.ReadySpin = 0
+	if p90 <= 50*time.Microsecond {
+		stats.ReadySpin = min(2*p90, 100*time.Microsecond)
+	}
+	stats.ReadyInterval = min(max(median/4, defaultReadyInterval), time.Millisecond)
+	if config.ReadyWait == ReadyAuto {
+		stats.ReadyWait = ReadyPoll
+		if _, ok := bus.(ReadyWaiter); ok && median >= 200*time.Microsecond {
+			stats.ReadyWait = ReadyEdge
+		}
+	}
+	Debug("Ready line: %d waits out of %d, median %v, 90th percentile %v: spinning %v, then polling every %v",
+		len(waits), len(latencies), median, p90, stats.ReadySpin, stats.ReadyInterval)
+}
+
+// countWaits returns the number of non-zero latencies
+func countWaits(latencies []time.Duration) int {
+	n := 0
+	for _, latency := range latencies {
+		if latency > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// applyReadyInterval sets the configured polling interval, or tunes it when
+// not set. ReadyAuto always tunes, to pick the wait.
+func applyReadyInterval() {
+	if config.ReadyInterval == 0 || config.ReadyWait == ReadyAuto {
+		tuneReady()
+	}
+	if config.ReadyInterval != 0 {
+		stats.ReadySpin = 0
+		stats.ReadyInterval = config.ReadyInterval
+	}
+}
@@ -0,0 +1,184 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"time"
+)
+
+// RefreshManager keeps A2 ghosting in check: it counts the A2 refreshes of
+// every updated area and refreshes it again with a full waveform (GC16 by
+// default) from the controller memory once it had Threshold A2 updates, or
+// when it was not updated for Idle. Other refreshes covering an area reset
+// its count. Split gives panel regions managers of their own, and widgets
+// declare their refresh policies with Declare.
+type RefreshManager struct {
+	Threshold int           // A2 refreshes before a cleanup, 0 for no limit
+	Idle      time.Duration // delay without update before a cleanup (see Check), 0 to disable
+	Mode      DisplayMode   // cleanup waveform, GC16Mode or InitMode
+
+	areas    []*fastArea
+	cleaning bool
+	region   image.Rectangle   // panel coordinates, for split managers
+	domains  []*RefreshManager // see Split
+	widgets  []widget          // see Declare
+}
+
+// fastArea is a panel area refreshed in A2 mode since its last cleanup
+type fastArea struct {
+	area    image.Rectangle // panel coordinates
+	count   int
+	last    time.Time
+	bitmap  *[2]uint8 // bitmap colors when displayed in 1bpp mode
+	address uint32    // image buffer displayed last
+}
+
+var (
+	refreshes    *RefreshManager
+	bitmapColors *[2]uint8 // colors given to Display1bppRect while it refreshes
+)
+
+// NewRefreshManager returns a manager cleaning areas with GC16 after
+// threshold A2 refreshes or idle time without updates
+func NewRefreshManager(threshold int, idle time.Duration) *RefreshManager {
+	return &RefreshManager{Threshold: threshold, Idle: idle, Mode: GC16Mode}
+}
+
+// SetRefreshManager makes m count the refreshes, nil disabling it
+func SetRefreshManager(m *RefreshManager) {
+	refreshes = m
+}
+
+// Split returns a manager counting the A2 refreshes within a region, in
+// logical coordinates, separately with its own threshold and idle delay, e.g.
+// for a Domain. Refreshes centered in the region are recorded by it rather
+// than by m. Check and CleanAll cover split managers too. As the region is
+// mapped to the panel with the current orientation, it must be called after
+// Init.
+func (m *RefreshManager) Split(region image.Rectangle, threshold int, idle time.Duration) *RefreshManager {
+	bounds := DeviceInfo().Bounds()
+	domain := &RefreshManager{
+		Threshold: threshold,
+		Idle:      idle,
+		Mode:      m.Mode,
+		region:    orientation.ToPanelRect(region.Intersect(orientation.LogicalBounds(bounds)), bounds),
+	}
+	m.domains = append(m.domains, domain)
+	return domain
+}
+
+// Pending returns the number of areas waiting for a cleanup, including those
+// of split managers
+func (m *RefreshManager) Pending() int {
+	pending := len(m.areas)
+	for _, domain := range m.domains {
+		pending += domain.Pending()
+	}
+	return pending
+}
+
+// record counts a refresh of a panel area from the image buffer at address
+func (m *RefreshManager) record(area image.Rectangle, mode DisplayMode, address uint32) {
+	if m.cleaning {
+		return
+	}
+	center := area.Min.Add(area.Size().Div(2))
+	for _, domain := range m.domains {
+		if mode != A2Mode {
+			domain.record(area, mode, address)
+		} else if center.In(domain.region) {
+			domain.record(area, mode, address)
+			return
+		}
+	}
+	if mode != A2Mode {
+		// a full waveform clears the ghosting of the areas it covers
+		kept := m.areas[:0]
+		for _, fast := range m.areas {
+			if !fast.area.In(area) {
+				kept = append(kept, fast)
+			}
+		}
+		m.areas = kept
+		return
+	}
+	merged := &fastArea{area: area, last: time.Now(), bitmap: bitmapColors, address: address}
+	kept := m.areas[:0]
+	for _, fast := range m.areas {
+		if fast.area.Overlaps(area) && fast.address == address && (fast.bitmap == nil) == (bitmapColors == nil) {
+			merged.area = merged.area.Union(fast.area)
+			merged.count = max(merged.count, fast.count)
+			continue
+		}
+		kept = append(kept, fast)
+	}
+	merged.count++
+	m.areas = append(kept, merged)
+	if threshold := m.threshold(merged.area); threshold > 0 && merged.count >= threshold {
+		m.clean(merged)
+	}
+}
+
+// Check cleans the areas not updated for Idle. It should be called
+// periodically.
+func (m *RefreshManager) Check(now time.Time) {
+	for _, domain := range m.domains {
+		domain.Check(now)
+	}
+	if m.Idle <= 0 {
+		return
+	}
+	for _, fast := range append([]*fastArea(nil), m.areas...) {
+		if now.Sub(fast.last) >= m.Idle {
+			m.clean(fast)
+		}
+	}
+}
+
+// CleanAll cleans all the areas refreshed in A2 mode since their last cleanup
+func (m *RefreshManager) CleanAll() {
+	for _, domain := range m.domains {
+		domain.CleanAll()
+	}
+	for _, fast := range append([]*fastArea(nil), m.areas...) {
+		m.clean(fast)
+	}
+}
+
+// clean refreshes an area with the cleanup mode from the controller memory,
+// where its content still is: the image buffer it was last displayed from,
+// e.g. a buffer pool slot
+func (m *RefreshManager) clean(fast *fastArea) {
+	for i, other := range m.areas {
+		if other == fast {
+			m.areas = append(m.areas[:i], m.areas[i+1:]...)
+			break
+		}
+	}
+	Debug("Cleaning %v after %d A2 refreshes", fast.area, fast.count)
+	m.cleaning = true
+	defer func() { m.cleaning = false }()
+	WaitForDisplayReady()
+	if fast.bitmap != nil {
+		Display1bppRect(fast.area, m.Mode, fast.address, fast.bitmap[0], fast.bitmap[1])
+		return
+	}
+	DisplayRectBuffer(fast.area, m.Mode, fast.address)
+}
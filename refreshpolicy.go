@@ -0,0 +1,152 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+// RefreshPolicy is the way a kind of widget prefers to be refreshed, e.g. a
+// clock in A2 at 1bpp, tolerating some ghosting, and a photo in GC16 at 4bpp.
+// Widgets declare their kind to a RefreshManager (see Declare), which
+// arbitrates between them.
+type RefreshPolicy struct {
+	Mode DisplayMode
+	Bpp  int
+	// GhostBudget is the number of A2 refreshes tolerated before a cleanup,
+	// 0 for the manager threshold
+	GhostBudget int
+}
+
+var (
+	refreshPolicies = map[string]func() RefreshPolicy{
+		"clock": func() RefreshPolicy { return RefreshPolicy{Mode: A2Mode, Bpp: 1, GhostBudget: 30} },
+		"photo": func() RefreshPolicy { return RefreshPolicy{Mode: GC16Mode, Bpp: 4} },
+		"text":  func() RefreshPolicy { return RefreshPolicy{Mode: GL16Mode, Bpp: 4} },
+		"chart": func() RefreshPolicy { return RefreshPolicy{Mode: DUMode, Bpp: 1, GhostBudget: 10} },
+	}
+)
+
+// RegisterRefreshPolicy adds or replaces the policy of a kind of widget. The
+// built-in kinds are clock (A2, 1bpp, cleaned after 30 refreshes), photo
+// (GC16, 4bpp), text (GL16, 4bpp) and chart (DU, 1bpp, cleaned after 10
+// refreshes).
+func RegisterRefreshPolicy(kind string, policy RefreshPolicy) {
+	refreshPolicies[kind] = func() RefreshPolicy { return policy }
+}
+
+// LookupRefreshPolicy returns the policy of a kind of widget. As mode
+// numbers depend on the panel LUT, built-in policies must be looked up after
+// Init.
+func LookupRefreshPolicy(kind string) (RefreshPolicy, error) {
+	policy, ok := refreshPolicies[kind]
+	if !ok {
+		return RefreshPolicy{}, fmt.Errorf("it8951: unknown widget kind %q (known: %v)", kind, RefreshPolicyKinds())
+	}
+	return policy(), nil
+}
+
+// RefreshPolicyKinds returns the kinds of widgets with a policy, sorted
+func RefreshPolicyKinds() []string {
+	kinds := make([]string, 0, len(refreshPolicies))
+	for kind := range refreshPolicies {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// widget is a region of the panel declared with a refresh policy
+type widget struct {
+	kind   string
+	region image.Rectangle // panel coordinates
+	policy RefreshPolicy
+}
+
+// Declare tells m that a widget of a kind (see RegisterRefreshPolicy) covers
+// a region, in logical coordinates: Policy then arbitrates the refreshes of
+// areas it covers and the A2 refreshes centered in it are cleaned up after
+// its ghost budget rather than Threshold. As the region is mapped to the
+// panel with the current orientation, it must be called after Init.
+func (m *RefreshManager) Declare(kind string, region image.Rectangle) error {
+	policy, err := LookupRefreshPolicy(kind)
+	if err != nil {
+		return err
+	}
+	bounds := DeviceInfo().Bounds()
+	region = orientation.ToPanelRect(region.Intersect(orientation.LogicalBounds(bounds)), bounds)
+	if region.Empty() {
+		return fmt.Errorf("it8951: %s widget off the panel", kind)
+	}
+	m.widgets = append(m.widgets, widget{kind: kind, region: region, policy: policy})
+	return nil
+}
+
+// Policy arbitrates between the policies of the widgets overlapping an area,
+// in logical coordinates: the one with the highest bpp wins, as the others
+// would lose levels, ties going to the widget covering most of the area. Its
+// ghost budget is the smallest of theirs. It returns false when no widget
+// overlaps the area.
+func (m *RefreshManager) Policy(area image.Rectangle) (RefreshPolicy, bool) {
+	bounds := DeviceInfo().Bounds()
+	return m.policy(orientation.ToPanelRect(area, bounds))
+}
+
+// policy is Policy for a panel area
+func (m *RefreshManager) policy(area image.Rectangle) (RefreshPolicy, bool) {
+	var best RefreshPolicy
+	found, covered := false, 0
+	budget := 0
+	for _, w := range m.widgets {
+		overlap := w.region.Intersect(area)
+		if overlap.Empty() {
+			continue
+		}
+		size := overlap.Dx() * overlap.Dy()
+		if !found || w.policy.Bpp > best.Bpp || w.policy.Bpp == best.Bpp && size > covered {
+			best, covered = w.policy, size
+		}
+		if w.policy.GhostBudget > 0 && (budget == 0 || w.policy.GhostBudget < budget) {
+			budget = w.policy.GhostBudget
+		}
+		found = true
+	}
+	best.GhostBudget = budget
+	return best, found
+}
+
+// threshold returns the number of A2 refreshes of a panel area before its
+// cleanup: the ghost budget of the widgets it is centered in, or Threshold
+func (m *RefreshManager) threshold(area image.Rectangle) int {
+	center := area.Min.Add(area.Size().Div(2))
+	if policy, ok := m.policy(image.Rectangle{Min: center, Max: center.Add(image.Pt(1, 1))}); ok && policy.GhostBudget > 0 {
+		return policy.GhostBudget
+	}
+	return m.Threshold
+}
+
+// FollowPolicies makes Present refresh the areas changed in frames with the
+// policy m arbitrates for the widgets they overlap, rather than with the
+// frame mode and its bpp (see SetModeBpp), which remain for areas covered by
+// no widget. nil stops it.
+func (d *Display) FollowPolicies(m *RefreshManager) {
+	d.policies = m
+}
@@ -0,0 +1,107 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"sync"
+)
+
+// Region is a named part of the panel with its own display settings, so that
+// several sources of content can share a large panel without coordinating
+// rectangles themselves
+type Region struct {
+	Name   string
+	Bounds image.Rectangle // in logical coordinates
+	Mode   DisplayMode     // waveform used to refresh the region
+	Bpp    int             // 2, 4 or 8
+}
+
+var (
+	regions     = map[string]Region{}
+	regionsLock sync.Mutex // guards regions
+)
+
+// DefineRegion adds or replaces a region. Regions must not overlap, and since
+// areas are loaded by whole words, their bounds must fall on multiples of
+// 16/bpp pixels along the panel rows: otherwise refreshing a region would
+// also repaint the edge of its neighbours.
+func DefineRegion(region Region) error {
+	Debug("Define region %s %v", region.Name, region.Bounds)
+	if region.Bpp != 2 && region.Bpp != 4 && region.Bpp != 8 {
+		return fmt.Errorf("it8951: region %s: unsupported %dbpp", region.Name, region.Bpp)
+	}
+	bounds := DeviceInfo().Bounds()
+	if !region.Bounds.In(orientation.LogicalBounds(bounds)) {
+		return fmt.Errorf("it8951: region %s %v is outside of the panel", region.Name, region.Bounds)
+	}
+	area := orientation.ToPanelRect(region.Bounds, bounds)
+	if alignRect(area, region.Bpp, bounds) != area {
+		return fmt.Errorf("it8951: region %s %v is not aligned to %d pixels on the panel", region.Name, region.Bounds, 16/region.Bpp)
+	}
+	regionsLock.Lock()
+	defer regionsLock.Unlock()
+	for name, other := range regions {
+		if name != region.Name && other.Bounds.Overlaps(region.Bounds) {
+			return fmt.Errorf("it8951: region %s overlaps %s", region.Name, name)
+		}
+	}
+	regions[region.Name] = region
+	return nil
+}
+
+// RemoveRegion removes a region
+func RemoveRegion(name string) {
+	regionsLock.Lock()
+	defer regionsLock.Unlock()
+	delete(regions, name)
+}
+
+// Regions returns the defined regions, sorted by name
+func Regions() []Region {
+	regionsLock.Lock()
+	defer regionsLock.Unlock()
+	list := make([]Region, 0, len(regions))
+	for _, region := range regions {
+		list = append(list, region)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// DrawRegion displays img in a region with the region settings, the image
+// origin being placed at the region top left corner. Parts of img outside of
+// the region are cut and uncovered parts of the region get the background gray.
+func DrawRegion(name string, img image.Image) error {
+	regionsLock.Lock()
+	region, ok := regions[name]
+	regionsLock.Unlock()
+	if !ok {
+		return fmt.Errorf("it8951: unknown region %s", name)
+	}
+	Debug("Draw region %s", name)
+	gray := image.NewGray(region.Bounds)
+	draw.Draw(gray, gray.Rect, image.NewUniform(color.Gray{Y: config.Background}), image.Point{}, draw.Src)
+	draw.Draw(gray, gray.Rect, img, img.Bounds().Min, draw.Src)
+	return displayImage(gray, region.Bounds, region.Bpp, region.Mode)
+}
@@ -0,0 +1,154 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ReadRegisters reads several registers, returning their values in the same order
+func ReadRegisters(addresses []Address) []uint16 {
+	Debug("Reading %d registers", len(addresses))
+	values := make([]uint16, len(addresses))
+	transaction(TCONRegRd, func() {
+		for i, address := range addresses {
+			values[i] = readRegister(address)
+		}
+	})
+	return values
+}
+
+// WriteRegisters writes several registers, in increasing address order.
+// In packed mode, address and value of each register go in a single data
+// transfer, saving a chip select cycle and preamble per register.
+func WriteRegisters(values map[Address]uint16) {
+	Debug("Writing %d registers", len(values))
+	addresses := make([]Address, 0, len(values))
+	for address := range values {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	transaction(TCONRegWr, func() {
+		for _, address := range addresses {
+			if !config.PackedMode {
+				writeRegister(address, values[address])
+				continue
+			}
+			DataBuffer{uint16(address), values[address]}.writeCommandBuffer(TCONRegWr)
+		}
+	})
+}
+
+// UpdateRegister replaces the bits of a register selected by mask with those
+// of value, leaving the others unchanged, in a single transaction
+func UpdateRegister(address Address, mask, value uint16) {
+	transaction(TCONRegRd, func() {
+		updateRegister(address, mask, value)
+	})
+}
+
+// SetRegisterBits sets the bits of mask in a register (see UpdateRegister)
+func SetRegisterBits(address Address, mask uint16) {
+	UpdateRegister(address, mask, mask)
+}
+
+// ClearRegisterBits clears the bits of mask in a register (see UpdateRegister)
+func ClearRegisterBits(address Address, mask uint16) {
+	UpdateRegister(address, mask, 0)
+}
+
+// updateRegister is UpdateRegister, within a transaction
+func updateRegister(address Address, mask, value uint16) {
+	current := readRegister(address)
+	updated := current&^mask | value&mask
+	Debug("Register %04x: %04x -> %04x", address, current, updated)
+	writeRegister(address, updated)
+}
+
+// namedRegister is a register listed by DumpRegisters
+type namedRegister struct {
+	name    string
+	address Address
+	wide    bool // 32 bit register
+}
+
+// registerBlocks are the registers listed by DumpRegisters, by block
+var registerBlocks = []struct {
+	name      string
+	registers []namedRegister
+}{
+	{"System", []namedRegister{
+		{"I80CPCR", I80CPCR, false},
+		{"DRVCR", DRVCR, false},
+	}},
+	{"Display", []namedRegister{
+		{"LUT0EWHR", LUT0EWHR, true},
+		{"LUT0XYR", LUT0XYR, true},
+		{"LUT0BADDR", LUT0BADDR, true},
+		{"LUT0MFN", LUT0MFN, true},
+		{"LUT01AF", LUT01AF, false},
+		{"UP0SR", UP0SR, true},
+		{"UP1SR", UP1SR, true},
+		{"LUT0ABFRV", LUT0ABFRV, false},
+		{"UPBBADDR", UPBBADDR, true},
+		{"LUT0IMXY", LUT0IMXY, true},
+		{"LUTAFSR", LUTAFSR, false},
+		{"BGVR", BGVR, false},
+	}},
+	{"Memory converter", []namedRegister{
+		{"MCSR", MCSR, false},
+		{"LISAR", LISAR, true},
+		{"PRXSR", PRXSR, false},
+		{"PRYSR", PRYSR, false},
+		{"PRWR", PRWR, false},
+		{"PRHR", PRHR, false},
+	}},
+}
+
+// DumpRegisters reads the system, display and memory converter registers
+// and returns a readable report of their values, for diagnostics
+func DumpRegisters() string {
+	var addresses []Address
+	for _, block := range registerBlocks {
+		for _, register := range block.registers {
+			addresses = append(addresses, register.address)
+			if register.wide {
+				addresses = append(addresses, register.address+2)
+			}
+		}
+	}
+	values := ReadRegisters(addresses)
+	var report strings.Builder
+	for _, block := range registerBlocks {
+		report.WriteString(block.name + " registers\n")
+		for _, register := range block.registers {
+			if register.wide {
+				fmt.Fprintf(&report, "  %-10s %04x: %08x\n", register.name, register.address,
+					uint32(values[1])<<16|uint32(values[0]))
+				values = values[2:]
+				continue
+			}
+			fmt.Fprintf(&report, "  %-10s %04x: %04x\n", register.name, register.address, values[0])
+			values = values[1:]
+		}
+	}
+	return report.String()
+}
@@ -0,0 +1,63 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "fmt"
+
+var (
+	vcomSetting uint16 // VCOM given to Init or Attach
+)
+
+// RestoreState checks the controller still holds the settings applied by
+// Init (packed mode, driving strength and VCOM), e.g. after a wake or
+// recovery, and writes back those which were lost in a single sequence
+// before checking them again. Display orientation is applied by the host
+// and needs no restore.
+func RestoreState() error {
+	Debug("Restoring controller state")
+	want := []uint16{packedModeValue(), drivingValue(config.DrivingStrength)}
+	registers := []Address{I80CPCR, DRVCR}
+	current := ReadRegisters(registers)
+	vcom := ReadVCOM()
+	if current[0] == want[0] && current[1] == want[1] && vcom == vcomSetting {
+		return nil
+	}
+
+	// packed mode first, since it changes how the next commands are sent
+	if current[0] != want[0] {
+		applyPackedMode()
+	}
+	if current[1] != want[1] {
+		WriteRegisters(map[Address]uint16{DRVCR: want[1]})
+	}
+	if vcom != vcomSetting {
+		WriteVCOM(vcomSetting)
+	}
+
+	current = ReadRegisters(registers)
+	for i, address := range registers {
+		if current[i] != want[i] {
+			return fmt.Errorf("%w: register %04x is %04x, not %04x", ErrVerify, address, current[i], want[i])
+		}
+	}
+	if vcom = ReadVCOM(); vcom != vcomSetting {
+		return fmt.Errorf("%w: VCOM is %d, not %d", ErrVerify, vcom, vcomSetting)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryPolicy tells how failed operations are retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries, including the first one
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each of the
+	// next ones up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable tells whether an error is worth retrying (verification
+	// failures and timeouts when nil). It is not saved by StageConfig.
+	Retryable func(error) bool `json:"-"`
+}
+
+// DefaultRetryPolicy returns the policy used unless WithRetryPolicy is given
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     10 * time.Millisecond,
+		MaxBackoff:  time.Second,
+	}
+}
+
+// WithRetryPolicy sets the policy applied to checked register writes and
+// verified uploads
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Config) {
+		c.Retry = policy
+	}
+}
+
+// retryable tells whether the policy retries err
+func (policy RetryPolicy) retryable(err error) bool {
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+	return errors.Is(err, ErrVerify) || errors.Is(err, ErrTimeout)
+}
+
+// Do runs op until it succeeds, fails with an error which isn't retryable or
+// MaxAttempts is reached, sleeping between attempts. The last error is
+// returned.
+func (policy RetryPolicy) Do(op func() error) error {
+	delay := policy.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return err
+		}
+		Debug("Attempt %d failed (%v), retrying in %v", attempt, err, delay)
+		time.Sleep(delay)
+		delay = min(2*delay, max(policy.MaxBackoff, policy.Backoff))
+	}
+}
+
+// WriteRegisterChecked writes a register and reads it back, retrying
+// according to the configured policy until the value sticks
+func WriteRegisterChecked(address Address, data uint16) error {
+	return config.Retry.Do(func() error {
+		WriteRegister(address, data)
+		if value := ReadRegister(address); value != data {
+			return fmt.Errorf("%w: register %04x is %04x, not %04x", ErrVerify, address, value, data)
+		}
+		return nil
+	})
+}
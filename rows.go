@@ -0,0 +1,57 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+// Rows iterates over the rows of a packed buffer holding an image of the given
+// width (in pixels) and bpp. Each row is yielded with its index as a slice of
+// the buffer itself, so it can be transformed in place without copying.
+//
+// The returned function has the iter.Seq2 signature and can be used with
+// range-over-func:
+//
+//	for y, row := range buffer.Rows(width, 4) {
+//		...
+//	}
+func (buffer DataBuffer) Rows(width int, bpp int) func(yield func(int, DataBuffer) bool) {
+	stride := GetWidthInWords(width, bpp)
+	return func(yield func(int, DataBuffer) bool) {
+		if stride == 0 {
+			return
+		}
+		for y := 0; (y+1)*stride <= len(buffer); y++ {
+			if !yield(y, buffer[y*stride:(y+1)*stride:(y+1)*stride]) {
+				return
+			}
+		}
+	}
+}
+
+// Pixel returns the value of pixel x in a packed row
+func (row DataBuffer) Pixel(x int, bpp int) uint8 {
+	bit := x * bpp
+	mask := uint16(1)<<bpp - 1
+	return uint8(row[bit/16] >> (bit % 16) & mask)
+}
+
+// SetPixel sets the value of pixel x in a packed row
+func (row DataBuffer) SetPixel(x int, bpp int, value uint8) {
+	bit := x * bpp
+	mask := uint16(1)<<bpp - 1
+	row[bit/16] = row[bit/16]&^(mask<<(bit%16)) | (uint16(value)&mask)<<(bit%16)
+}
@@ -0,0 +1,69 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"os"
+	"strconv"
+	"time"
+)
+
+// WakeAlarmPath is the sysfs file of the RTC wake alarm (e.g. a DS3231 with
+// the rtc-ds1307 driver and its alarm wired to the board wake input)
+var WakeAlarmPath = "/sys/class/rtc/rtc0/wakealarm"
+
+// SetWakeAlarm programs the RTC to wake the system up at t. A zero t clears
+// the alarm.
+func SetWakeAlarm(t time.Time) error {
+	Debug("Wake alarm at %v", t)
+	// the kernel refuses to replace a pending alarm: clear it first
+	if err := os.WriteFile(WakeAlarmPath, []byte("0"), 0); err != nil {
+		return err
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return os.WriteFile(WakeAlarmPath, []byte(strconv.FormatInt(t.Unix(), 10)), 0)
+}
+
+// WakeRefreshSleep is the whole cycle of a battery powered display woken up
+// periodically: it wakes the controller, displays img on the whole panel in
+// GC16 mode, puts the controller back to sleep and programs the RTC to wake
+// the system up again at next (unless next is zero). The system itself can
+// then be shut down or suspended.
+func WakeRefreshSleep(img image.Image, next time.Time) error {
+	Debug("Wake, refresh and sleep")
+	if err := Wake(); err != nil {
+		return err
+	}
+	if err := displayImage(img, orientation.LogicalBounds(DeviceInfo().Bounds()), 4, GC16Mode); err != nil {
+		return err
+	}
+	if err := WaitForDisplayReady(); err != nil {
+		return err
+	}
+	if err := Sleep(); err != nil {
+		return err
+	}
+	if next.IsZero() {
+		return nil
+	}
+	return SetWakeAlarm(next)
+}
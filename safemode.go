@@ -0,0 +1,141 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"net"
+	"os"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font/basicfont"
+)
+
+// errorCodes are the codes shown by the safe mode screen, by error. Other
+// errors show code 0.
+var errorCodes = []struct {
+	err  error
+	code int
+}{
+	{ErrInvalidConfig, 1},
+	{ErrNotReady, 2},
+	{ErrTimeout, 3},
+	{ErrVerify, 4},
+	{ErrNoWaveform, 5},
+	{ErrNotInitialized, 6},
+	{ErrDead, 7},
+}
+
+// ErrorCode returns the code shown for err by the safe mode screen, e.g. 1
+// for invalid settings, or 0 for unexpected errors
+func ErrorCode(err error) int {
+	for _, known := range errorCodes {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+	return 0
+}
+
+// WithSafeMode makes Init display an error screen when it fails while the
+// controller answers, e.g. because of an invalid VCOM or panel model, so that
+// field devices show their failure on the panel itself (see SafeModeScreen)
+func WithSafeMode(enabled bool) Option {
+	return func(c *Config) {
+		c.SafeMode = enabled
+	}
+}
+
+// SafeModeScreen returns a minimal error screen of the given bounds, in black
+// and white with the built-in font scaled to the panel: the error code and
+// message, the host name and its IP addresses, to tell which device needs
+// attention and how to reach it
+func SafeModeScreen(err error, bounds image.Rectangle) *image.Gray {
+	scale := max(1, min(bounds.Dx()/320, bounds.Dy()/240))
+	var text strings.Builder
+	fmt.Fprintf(&text, "DISPLAY ERROR E%02d\n\n%v\n\n", ErrorCode(err), err)
+	if host, err := os.Hostname(); err == nil {
+		fmt.Fprintf(&text, "Host %s\n", host)
+	}
+	for _, ip := range hostIPs() {
+		fmt.Fprintf(&text, "IP %s\n", ip)
+	}
+	small := image.Rect(0, 0, bounds.Dx()/scale, bounds.Dy()/scale)
+	margin := small.Dx() / 20
+	rendered := RenderText(text.String(), small.Inset(margin), TextOptions{Face: basicfont.Face7x13})
+	screen := image.NewGray(bounds)
+	draw.Draw(screen, bounds, image.White, image.Point{}, draw.Src)
+	// the anti-aliasing of the text would be lost at 1bpp anyway
+	bw := threshold(rendered, func(x, y int) int { return 0x80 })
+	scaled := image.Rectangle{Min: bw.Rect.Min.Mul(scale), Max: bw.Rect.Max.Mul(scale)}.Add(bounds.Min)
+	xdraw.NearestNeighbor.Scale(screen, scaled, bw, bw.Rect, draw.Src, nil)
+	return screen
+}
+
+// hostIPs returns the IP addresses of the host, except loopback and link
+// local ones
+func hostIPs() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP.String())
+	}
+	return ips
+}
+
+// showSafeMode displays the safe mode screen for an Init failure, when the
+// controller answers. devInfo is the one Init returned, nil when it closed
+// the peripherals (or never opened them, for invalid settings).
+func showSafeMode(devInfo *DevInfo, cause error) {
+	if devInfo == nil {
+		if !errors.Is(cause, ErrInvalidConfig) {
+			return // no answer from the controller
+		}
+		if err := Open(); err != nil {
+			return
+		}
+		defer Close()
+		Reset()
+		SystemRun()
+		devInfo = RefreshDevInfo()
+		if Err() != nil {
+			return
+		}
+		applyModes(devInfo.Firmware())
+		applyPackedMode()
+	}
+	if Err() != nil {
+		return
+	}
+	Debug("Init failed, showing the safe mode screen")
+	bounds := orientation.LogicalBounds(devInfo.Bounds())
+	displayImage(SafeModeScreen(cause, bounds), bounds, 1, GC16Mode)
+	WaitForDisplayReady()
+}
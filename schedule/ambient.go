@@ -0,0 +1,52 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package schedule
+
+// LightSensor measures the ambient light, e.g. sensor.BH1750
+type LightSensor interface {
+	Lux() (float64, error)
+}
+
+// Ambient follows the ambient light to adapt refreshes: cosmetic refreshes
+// are pointless in the dark, and the first light of the day is a good time
+// for a full de-ghosting refresh. Two thresholds avoid flapping at dusk.
+type Ambient struct {
+	Sensor    LightSensor
+	DarkBelow float64 // lux under which it gets dark
+	DawnAbove float64 // lux over which it gets light again (>= DarkBelow)
+
+	dark bool
+}
+
+// Update reads the sensor, returning whether it is dark and whether it just
+// stopped being dark. On sensor errors, the previous state is kept.
+func (a *Ambient) Update() (dark bool, dawn bool) {
+	lux, err := a.Sensor.Lux()
+	if err != nil {
+		return a.dark, false
+	}
+	switch {
+	case !a.dark && lux < a.DarkBelow:
+		a.dark = true
+	case a.dark && lux > a.DawnAbove:
+		a.dark = false
+		return false, true
+	}
+	return a.dark, false
+}
@@ -0,0 +1,120 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression: minute, hour, day of month, month and
+// day of week fields, each being *, a value, a range (a-b), a list (a,b) or
+// any of these with a step (*/15, 8-18/2). Days of week go from 0 (Sunday) to
+// 6; 7 is accepted for Sunday too.
+type Cron struct {
+	minute, hour, day, month, weekday uint64 // bit sets of allowed values
+	anyDay, anyWeekday                bool
+}
+
+// field bounds, in expression order
+var fields = [5]struct{ min, max int }{
+	{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7},
+}
+
+// ParseCron parses a 5 field cron expression
+func ParseCron(spec string) (Cron, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return Cron{}, fmt.Errorf("schedule: %q: expecting 5 fields", spec)
+	}
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i].min, fields[i].max)
+		if err != nil {
+			return Cron{}, fmt.Errorf("schedule: %q: %v", spec, err)
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 { // 7 is Sunday
+		sets[4] |= 1
+	}
+	return Cron{
+		minute: sets[0], hour: sets[1], day: sets[2], month: sets[3], weekday: sets[4],
+		anyDay: parts[2] == "*", anyWeekday: parts[4] == "*",
+	}, nil
+}
+
+// parseField parses a comma separated list of ranges
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		expr, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step in %q", item)
+			}
+		}
+		low, high := min, max
+		if expr != "*" {
+			lowText, highText, isRange := strings.Cut(expr, "-")
+			var err error
+			if low, err = strconv.Atoi(lowText); err != nil {
+				return 0, fmt.Errorf("bad value in %q", item)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highText); err != nil {
+					return 0, fmt.Errorf("bad value in %q", item)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of %d-%d", item, min, max)
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+// Match reports whether the minute of t matches the expression. As in cron,
+// when both day of month and day of week are restricted, either may match.
+func (c Cron) Match(t time.Time) bool {
+	if c.minute&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	day := c.day&(1<<t.Day()) != 0
+	weekday := c.weekday&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
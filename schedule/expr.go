@@ -0,0 +1,342 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Expr is a parsed condition, such as "hour >= 9 && hour < 17" or
+// "weekday % 6 != 0 && !holiday". Expressions are made of integers,
+// variables, parentheses and the operators of Go, with the same precedence:
+//
+//	||  &&  == != < <= > >=  + -  * / %  ! - (unary)
+//
+// As in C, conditions are integers: comparisons and logical operators give 1
+// for true and 0 for false, and any value but 0 is true. true and false are
+// 1 and 0. Nothing but these is available (no calls, no loops, no access to
+// the host), so expressions can come from configuration files safely.
+type Expr struct {
+	source string
+	root   node
+}
+
+// Limits of the expressions accepted by ParseExpr
+const (
+	maxExprLength = 1024
+	maxExprDepth  = 64
+)
+
+// errDivision is returned by divisions by zero
+var errDivision = errors.New("division by zero")
+
+// node is a part of an expression
+type node interface {
+	eval(vars map[string]int) (int, error)
+}
+
+type (
+	number   int
+	variable string
+	unary    struct {
+		op      string
+		operand node
+	}
+	binary struct {
+		op          string
+		left, right node
+	}
+)
+
+func (n number) eval(map[string]int) (int, error) {
+	return int(n), nil
+}
+
+func (v variable) eval(vars map[string]int) (int, error) {
+	value, ok := vars[string(v)]
+	if !ok {
+		return 0, fmt.Errorf("unknown variable %q", string(v))
+	}
+	return value, nil
+}
+
+func (u unary) eval(vars map[string]int) (int, error) {
+	value, err := u.operand.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	if u.op == "!" {
+		return truth(value == 0), nil
+	}
+	return -value, nil
+}
+
+func (b binary) eval(vars map[string]int) (int, error) {
+	left, err := b.left.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	// && and || only evaluate their right operand when needed
+	switch {
+	case b.op == "&&" && left == 0:
+		return 0, nil
+	case b.op == "||" && left != 0:
+		return 1, nil
+	}
+	right, err := b.right.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case "&&", "||":
+		return truth(right != 0), nil
+	case "==":
+		return truth(left == right), nil
+	case "!=":
+		return truth(left != right), nil
+	case "<":
+		return truth(left < right), nil
+	case "<=":
+		return truth(left <= right), nil
+	case ">":
+		return truth(left > right), nil
+	case ">=":
+		return truth(left >= right), nil
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	}
+	if right == 0 {
+		return 0, errDivision
+	}
+	if b.op == "/" {
+		return left / right, nil
+	}
+	return left % right, nil
+}
+
+// truth converts a condition to an integer
+func truth(condition bool) int {
+	if condition {
+		return 1
+	}
+	return 0
+}
+
+// precedence gives the binary operators, by increasing precedence
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// ParseExpr parses an expression
+func ParseExpr(source string) (*Expr, error) {
+	if len(source) > maxExprLength {
+		return nil, fmt.Errorf("schedule: expression longer than %d bytes", maxExprLength)
+	}
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %q: %v", source, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.expression(0, 0)
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %q: %v", source, err)
+	}
+	return &Expr{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression with the given variables
+func (e *Expr) Eval(vars map[string]int) (int, error) {
+	value, err := e.root.eval(vars)
+	if err != nil {
+		return 0, fmt.Errorf("schedule: %q: %v", e.source, err)
+	}
+	return value, nil
+}
+
+// Holds evaluates the expression as a condition
+func (e *Expr) Holds(vars map[string]int) (bool, error) {
+	value, err := e.Eval(vars)
+	return value != 0, err
+}
+
+// TimeVars returns the variables describing t: minute, hour, day (of the
+// month), month, year, weekday (0 for Sunday) and yearday, along with true
+// and false
+func TimeVars(t time.Time) map[string]int {
+	return map[string]int{
+		"minute":  t.Minute(),
+		"hour":    t.Hour(),
+		"day":     t.Day(),
+		"month":   int(t.Month()),
+		"year":    t.Year(),
+		"weekday": int(t.Weekday()),
+		"yearday": t.YearDay(),
+		"true":    1,
+		"false":   0,
+	}
+}
+
+// tokenize splits an expression into numbers, names and operators
+func tokenize(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := source[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+		case isDigit(c):
+			for i < len(source) && isDigit(source[i]) {
+				i++
+			}
+		case isLetter(c):
+			for i < len(source) && (isLetter(source[i]) || isDigit(source[i])) {
+				i++
+			}
+		case i+1 < len(source) && isOperator(source[i:i+2]):
+			i += 2
+		case isOperator(source[i:i+1]) || c == '(' || c == ')' || c == '!':
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+		tokens = append(tokens, source[start:i])
+	}
+	return tokens, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// isOperator tells whether token is a binary operator
+func isOperator(token string) bool {
+	for _, level := range precedence {
+		for _, op := range level {
+			if token == op {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parser is a precedence climbing parser
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+// next returns the next token, "" at the end
+func (p *parser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// expression parses the operators of the given precedence level and above
+func (p *parser) expression(level, depth int) (node, error) {
+	if level == len(precedence) {
+		return p.operand(depth)
+	}
+	left, err := p.expression(level+1, depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.next()
+		found := false
+		for _, candidate := range precedence[level] {
+			found = found || op == candidate
+		}
+		if !found {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.expression(level+1, depth)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+// operand parses a number, a variable, a parenthesized expression or a
+// unary operator and its operand
+func (p *parser) operand(depth int) (node, error) {
+	if depth > maxExprDepth {
+		return nil, errors.New("expression nested too deeply")
+	}
+	token := p.next()
+	p.pos++
+	switch {
+	case token == "":
+		return nil, errors.New("unexpected end")
+	case token == "!" || token == "-":
+		operand, err := p.operand(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: token, operand: operand}, nil
+	case token == "(":
+		inner, err := p.expression(0, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return inner, nil
+	case isDigit(token[0]):
+		value, err := strconv.Atoi(token)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", token)
+		}
+		return number(value), nil
+	case isLetter(token[0]):
+		return variable(token), nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
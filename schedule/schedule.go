@@ -0,0 +1,139 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package schedule picks the content to show on a panel according to the
+// time, with rules such as "a clock at night, the dashboard during the day
+// and photos on weekends".
+package schedule
+
+import (
+	"context"
+	"image"
+	"time"
+)
+
+// Source renders the content of a rule
+type Source func(now time.Time) image.Image
+
+// Rule shows a source during the minutes matched by a cron expression, e.g.
+// "* 22-23,0-6 * * *" for nights or "* * * * 0,6" for weekends, when its
+// condition, if any, holds too
+type Rule struct {
+	Name   string
+	Cron   Cron
+	When   *Expr // condition, evaluated with the scheduler variables
+	Source Source
+}
+
+// Scheduler holds rules, the first matching one winning
+type Scheduler struct {
+	// Ambient, when set, makes Run skip updates in the dark
+	Ambient *Ambient
+	// OnDawn is called by Run when it gets light again, e.g. to run a full
+	// INIT refresh clearing the ghosting left by the night's updates
+	OnDawn func()
+	// Vars, when set, returns variables for the rule conditions, in addition
+	// to those of TimeVars (e.g. "holiday", or sensor readings)
+	Vars func(now time.Time) map[string]int
+
+	rules []Rule
+}
+
+// Add appends a rule, with a lower priority than the previous ones
+func (s *Scheduler) Add(name string, spec string, source Source) error {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	s.rules = append(s.rules, Rule{Name: name, Cron: cron, Source: source})
+	return nil
+}
+
+// AddWhen appends a rule applying only when condition holds as well, e.g.
+// "hour >= 9 && hour < 17" (see ParseExpr), so that simple schedule changes
+// only need a configuration change
+func (s *Scheduler) AddWhen(name string, spec string, condition string, source Source) error {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	when, err := ParseExpr(condition)
+	if err != nil {
+		return err
+	}
+	s.rules = append(s.rules, Rule{Name: name, Cron: cron, When: when, Source: source})
+	return nil
+}
+
+// Active returns the rule applying at t, if any. Rules whose condition fails
+// to evaluate (unknown variable, division by zero) do not apply.
+func (s *Scheduler) Active(t time.Time) (Rule, bool) {
+	var vars map[string]int
+	for _, rule := range s.rules {
+		if !rule.Cron.Match(t) {
+			continue
+		}
+		if rule.When == nil {
+			return rule, true
+		}
+		if vars == nil {
+			vars = s.vars(t)
+		}
+		if holds, err := rule.When.Holds(vars); holds && err == nil {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// vars returns the variables of the rule conditions at t
+func (s *Scheduler) vars(t time.Time) map[string]int {
+	vars := TimeVars(t)
+	if s.Vars != nil {
+		for name, value := range s.Vars(t) {
+			vars[name] = value
+		}
+	}
+	return vars
+}
+
+// Run calls show with the content of the active rule at the start of every
+// minute, until ctx is done, except in the dark (see Ambient). The content is rendered again every minute, so
+// sources such as clocks stay current; show may skip unchanged frames.
+func (s *Scheduler) Run(ctx context.Context, show func(rule string, img image.Image)) error {
+	for {
+		now := time.Now()
+		dark := false
+		if s.Ambient != nil {
+			var dawn bool
+			dark, dawn = s.Ambient.Update()
+			if dawn && s.OnDawn != nil {
+				s.OnDawn()
+			}
+		}
+		if rule, ok := s.Active(now); ok && !dark {
+			show(rule.Name, rule.Source(now))
+		}
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
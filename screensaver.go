@@ -0,0 +1,87 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"image/color"
+	"time"
+)
+
+// Screensaver replaces static content after a period without activity, with
+// artwork or a blank screen, and puts the controller to sleep. The previous
+// frame is restored from a shadow frame on the next activity.
+type Screensaver struct {
+	Idle    time.Duration      // inactivity before starting
+	Artwork func() image.Image // content shown meanwhile, blank (background gray) when nil
+	Shadow  *Shadow            // last frame shown, in logical coordinates, kept up to date by the application
+	Sleep   bool               // put the controller to sleep once the artwork is displayed
+
+	last   time.Time
+	active bool
+}
+
+// Active reports whether the screensaver is showing
+func (s *Screensaver) Active() bool {
+	return s.active
+}
+
+// Activity records user activity (e.g. a touch or button event), restoring
+// the previous frame if the screensaver was showing
+func (s *Screensaver) Activity() error {
+	s.last = time.Now()
+	if !s.active {
+		return nil
+	}
+	Debug("Screensaver stop")
+	s.active = false
+	if s.Sleep {
+		if err := Wake(); err != nil {
+			return err
+		}
+	}
+	if s.Shadow != nil {
+		frame := s.Shadow.Image()
+		displayImage(frame, frame.Rect, 4, GC16Mode)
+	}
+	return nil
+}
+
+// Check starts the screensaver when there was no activity for Idle. It
+// should be called periodically.
+func (s *Screensaver) Check(now time.Time) {
+	if s.last.IsZero() {
+		s.last = now
+	}
+	if s.active || now.Sub(s.last) < s.Idle {
+		return
+	}
+	Debug("Screensaver start")
+	s.active = true
+	logical := orientation.LogicalBounds(DeviceInfo().Bounds())
+	var artwork image.Image = image.NewUniform(color.Gray{Y: config.Background})
+	if s.Artwork != nil {
+		artwork = s.Artwork()
+	}
+	displayImage(artwork, logical, 4, GC16Mode)
+	if s.Sleep {
+		WaitForDisplayReady()
+		Sleep()
+	}
+}
@@ -0,0 +1,69 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package sensor reads the environment sensors useful next to an e-paper
+// panel, through go-rpio (see it8951.Open)
+package sensor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+// BH1750 addresses, selected by the ADDR pin
+const (
+	BH1750AddressLow  = 0x23
+	BH1750AddressHigh = 0x5c
+)
+
+// BH1750 commands
+const (
+	bh1750PowerOn      = 0x01
+	bh1750OneTimeHiRes = 0x20 // one measure, 1 lx resolution, then power down
+)
+
+// bh1750Measure is the longest high resolution measurement time
+const bh1750Measure = 180 * time.Millisecond
+
+// BH1750 is an ambient light sensor on the I2C bus 1
+type BH1750 struct {
+	Address uint32 // BH1750AddressLow or BH1750AddressHigh
+}
+
+// Lux measures the ambient light, in lux
+func (s BH1750) Lux() (float64, error) {
+	device, err := rpio.I2cBegin(rpio.I2c1, s.Address)
+	if err != nil {
+		return 0, err
+	}
+	defer device.I2cEnd()
+	if reason := device.I2cWrite(bh1750PowerOn); reason != 0 {
+		return 0, fmt.Errorf("sensor: BH1750 power on failed (%d)", reason)
+	}
+	if reason := device.I2cWrite(bh1750OneTimeHiRes); reason != 0 {
+		return 0, fmt.Errorf("sensor: BH1750 measure failed (%d)", reason)
+	}
+	time.Sleep(bh1750Measure)
+	data := make([]byte, 2)
+	if reason := device.I2cRead(data, 2); reason != 0 {
+		return 0, fmt.Errorf("sensor: BH1750 read failed (%d)", reason)
+	}
+	return float64(uint16(data[0])<<8|uint16(data[1])) / 1.2, nil
+}
@@ -0,0 +1,107 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"bytes"
+	"image"
+)
+
+// Shadow keeps a copy of the last frame sent to the panel, so that only the
+// changed area of a new frame needs to be uploaded and refreshed. Its pixels
+// live either on the heap (NewShadow) or in a memory mapped file
+// (NewMappedShadow), which keeps them out of the process memory on boards
+// with little RAM.
+type Shadow struct {
+	gray   *image.Gray
+	unmap  func() error
+	buffer []uint8 // row scratch buffer
+}
+
+// NewShadow returns a white shadow frame of the given bounds, held in memory
+func NewShadow(bounds image.Rectangle) *Shadow {
+	shadow := &Shadow{gray: image.NewGray(bounds)}
+	for i := range shadow.gray.Pix {
+		shadow.gray.Pix[i] = 0xff
+	}
+	return shadow
+}
+
+// Image returns the shadow frame. Its pixels must not be modified.
+func (shadow *Shadow) Image() *image.Gray {
+	return shadow.gray
+}
+
+// Update copies img into the shadow frame and returns the bounding box of
+// the pixels that changed, which is empty when the frame is the same.
+// Pixels of img are read one row at a time.
+func (shadow *Shadow) Update(img image.Image) image.Rectangle {
+	area := img.Bounds().Intersect(shadow.gray.Rect)
+	if area.Empty() {
+		return image.Rectangle{}
+	}
+	width := area.Dx()
+	if cap(shadow.buffer) < width {
+		shadow.buffer = make([]uint8, width)
+	}
+	row := shadow.buffer[:width]
+	src := ImageSource(img)
+	offset := area.Min.Sub(img.Bounds().Min)
+	changed := image.Rectangle{}
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		sourceRow(src, offset.Y+y-area.Min.Y, offset.X, row)
+		start := shadow.gray.PixOffset(area.Min.X, y)
+		pix := shadow.gray.Pix[start : start+width]
+		if bytes.Equal(pix, row) {
+			continue
+		}
+		first, last := 0, width-1
+		for pix[first] == row[first] {
+			first++
+		}
+		for pix[last] == row[last] {
+			last--
+		}
+		copy(pix, row)
+		changed = changed.Union(image.Rect(area.Min.X+first, y, area.Min.X+last+1, y+1))
+	}
+	Debug("Shadow update changed %v", changed)
+	return changed
+}
+
+// Close releases the shadow frame, unmapping its file if any
+func (shadow *Shadow) Close() error {
+	shadow.gray = nil
+	if shadow.unmap != nil {
+		return shadow.unmap()
+	}
+	return nil
+}
+
+// sourceRow reads width pixels of line y of src starting at x into row
+func sourceRow(src PixelSource, y, x int, row []uint8) {
+	if x == 0 {
+		src.GrayRow(y, row)
+		return
+	}
+	width, _ := src.Size()
+	full := make([]uint8, width)
+	src.GrayRow(y, full)
+	copy(row, full[x:])
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"os"
+	"syscall"
+)
+
+// NewMappedShadow returns a shadow frame of the given bounds stored in the
+// file at path, which is created if needed and mapped in memory: the kernel
+// pages the frame in and out instead of keeping it in the process memory.
+// An existing file of the right size is reused as is, so the shadow frame
+// survives restarts (see Attach); otherwise the frame starts white.
+func NewMappedShadow(path string, bounds image.Rectangle) (*Shadow, error) {
+	size := bounds.Dx() * bounds.Dy()
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fresh := info.Size() != int64(size)
+	if fresh {
+		if err := file.Truncate(int64(size)); err != nil {
+			return nil, err
+		}
+	}
+	pix, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	if fresh {
+		for i := range pix {
+			pix[i] = 0xff
+		}
+	}
+	return &Shadow{
+		gray:  &image.Gray{Pix: pix, Stride: bounds.Dx(), Rect: bounds},
+		unmap: func() error { return syscall.Munmap(pix) },
+	}, nil
+}
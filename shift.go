@@ -0,0 +1,90 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"image/color"
+)
+
+var (
+	shiftOffsets []image.Point // positions cycled through, empty when disabled
+	shiftIndex   int
+)
+
+// SetPixelShift enables shifting the whole content by up to max pixels in
+// each direction, moving to the next position on every full panel refresh,
+// to limit ghosting and wear from static layouts. The content moves as a
+// whole so its alignment is kept, and partial updates follow the current
+// position. A max of 0 disables shifting.
+func SetPixelShift(max int) {
+	shiftOffsets = nil
+	shiftIndex = 0
+	if max <= 0 {
+		return
+	}
+	// snake through the square so that every step moves a single pixel
+	for y := -max; y <= max; y++ {
+		for i := 0; i <= 2*max; i++ {
+			x := -max + i
+			if (y+max)%2 == 1 {
+				x = max - i
+			}
+			shiftOffsets = append(shiftOffsets, image.Pt(x, y))
+		}
+	}
+}
+
+// PixelShift returns the current content offset
+func PixelShift() image.Point {
+	if len(shiftOffsets) == 0 {
+		return image.Point{}
+	}
+	return shiftOffsets[shiftIndex]
+}
+
+// shiftContent moves the logical content of a refresh by the current offset,
+// stepping to the next one first when the whole panel is refreshed
+func shiftContent(img image.Image, region image.Rectangle, logical image.Rectangle) (image.Image, image.Rectangle) {
+	if len(shiftOffsets) == 0 {
+		return img, region
+	}
+	if logical.In(region) {
+		// full refresh: pixels uncovered by the move get the background
+		shiftIndex = (shiftIndex + 1) % len(shiftOffsets)
+		Debug("Pixel shift %v", shiftOffsets[shiftIndex])
+		return shiftedImage{Image: img, offset: shiftOffsets[shiftIndex]}, region
+	}
+	offset := shiftOffsets[shiftIndex]
+	return shiftedImage{Image: img, offset: offset}, region.Add(offset)
+}
+
+// shiftedImage is an image moved by an offset
+type shiftedImage struct {
+	image.Image
+	offset image.Point
+}
+
+func (s shiftedImage) Bounds() image.Rectangle {
+	return s.Image.Bounds().Add(s.offset)
+}
+
+func (s shiftedImage) At(x, y int) color.Color {
+	return s.Image.At(x-s.offset.X, y-s.offset.Y)
+}
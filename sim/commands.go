@@ -0,0 +1,192 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package sim
+
+import (
+	"time"
+
+	it8951 "github.com/peergum/IT8951-go"
+)
+
+// argCounts is the number of parameters of the commands taking some
+var argCounts = map[it8951.Command]int{
+	it8951.TCONRegRd:         1,
+	it8951.TCONRegWr:         2,
+	it8951.TCONMemBstRdT:     4,
+	it8951.TCONMemBstWr:      4,
+	it8951.UserCmdDpyArea:    5,
+	it8951.UserCmdDpyBufArea: 7,
+	it8951.UserCmdVCOM:       1,
+	it8951.UserCmdTemp:       1,
+}
+
+// startCommand starts a command, running it at once when it has no parameters
+func (c *Controller) startCommand(command it8951.Command) {
+	c.endCommand()
+	c.command = command
+	if c.argCount() == 0 {
+		c.run()
+	}
+}
+
+// argCount returns the number of parameters of the current command. Load
+// image commands only take parameters in packed mode, otherwise they come
+// from the memory converter registers.
+func (c *Controller) argCount() int {
+	switch c.command {
+	case it8951.TCONLdImg:
+		if c.packed() {
+			return 1
+		}
+		return 0
+	case it8951.TCONLdImgArea:
+		if c.packed() {
+			return 5
+		}
+		return 0
+	}
+	return argCounts[c.command]
+}
+
+// endCommand drops the current command
+func (c *Controller) endCommand() {
+	c.command = 0
+	c.args = c.args[:0]
+	c.stream = nil
+}
+
+// packed tells whether the I80 parameter packed mode is on
+func (c *Controller) packed() bool {
+	return c.registers[it8951.I80CPCR]&1 != 0
+}
+
+// data handles a data word sent by the host
+func (c *Controller) data(word uint16) {
+	if c.stream != nil {
+		c.stream(word)
+		return
+	}
+	if c.command == 0 {
+		return
+	}
+	c.args = append(c.args, word)
+	if c.command == it8951.UserCmdVCOM && len(c.args) == 1 && it8951.VCOMCommand(word) == it8951.SetVCOM {
+		return // value follows
+	}
+	if c.command == it8951.UserCmdTemp && len(c.args) == 1 && it8951.TempCommand(word) == it8951.SetTemp {
+		return // temperature follows
+	}
+	if len(c.args) == c.argCount() || c.command == it8951.UserCmdVCOM || c.command == it8951.UserCmdTemp {
+		c.run()
+	}
+}
+
+// run executes the current command with its parameters
+func (c *Controller) run() {
+	args := c.args
+	switch c.command {
+	case it8951.TCONRegRd:
+		c.reads = []uint16{c.registers[it8951.Address(args[0])]}
+		if it8951.Address(args[0]) == it8951.LUTAFSR && time.Now().Before(c.busyUntil) {
+			c.reads[0] = 1
+		}
+	case it8951.TCONRegWr:
+		c.registers[it8951.Address(args[0])] = args[1]
+	case it8951.UserCmdGetDevInfo:
+		c.reads = c.devInfo()
+	case it8951.UserCmdVCOM:
+		if it8951.VCOMCommand(args[0]) == it8951.SetVCOM {
+			c.vcom = args[1]
+		} else {
+			c.reads = []uint16{c.vcom}
+		}
+	case it8951.UserCmdTemp:
+		if it8951.TempCommand(args[0]) == it8951.SetTemp {
+			forced := args[1]
+			c.forced = &forced
+			break
+		}
+		temperature := uint16(c.panel.Temperature)
+		forced := temperature
+		if c.forced != nil {
+			forced = *c.forced
+		}
+		c.reads = []uint16{temperature, forced}
+	case it8951.TCONMemBstWr:
+		address := uint32(args[0]) | uint32(args[1])<<16
+		c.stream = func(word uint16) {
+			c.store(address, uint8(word))
+			c.store(address+1, uint8(word>>8))
+			address += 2
+		}
+		return
+	case it8951.TCONMemBstRdT:
+		c.burst = append(c.burst[:0], args...)
+	case it8951.TCONMemBstRdS:
+		if len(c.burst) == 4 {
+			address := uint32(c.burst[0]) | uint32(c.burst[1])<<16
+			count := int(c.burst[2]) | int(c.burst[3])<<16
+			c.reads = make([]uint16, count)
+			for i := range c.reads {
+				c.reads[i] = uint16(c.load(address+uint32(2*i))) | uint16(c.load(address+uint32(2*i+1)))<<8
+			}
+		}
+	case it8951.TCONLdImg:
+		if c.packed() {
+			c.loadImage(args[0])
+		} else {
+			c.loadImage(c.registers[it8951.MCSR])
+		}
+		return
+	case it8951.TCONLdImgArea:
+		if c.packed() {
+			c.loadArea(args[0], args[1], args[2], args[3], args[4])
+		} else {
+			c.loadArea(c.registers[it8951.MCSR], c.registers[it8951.PRXSR], c.registers[it8951.PRYSR],
+				c.registers[it8951.PRWR], c.registers[it8951.PRHR])
+		}
+		return
+	case it8951.UserCmdDpyArea:
+		c.display(int(args[0]), int(args[1]), int(args[2]), int(args[3]), c.panel.Address)
+		c.refresh(it8951.DisplayMode(args[4]))
+	case it8951.UserCmdDpyBufArea:
+		c.display(int(args[0]), int(args[1]), int(args[2]), int(args[3]), uint32(args[5])|uint32(args[6])<<16)
+		c.refresh(it8951.DisplayMode(args[4]))
+	}
+	c.args = c.args[:0]
+}
+
+// devInfo returns the system info words
+func (c *Controller) devInfo() []uint16 {
+	info := []uint16{
+		uint16(c.panel.Width), uint16(c.panel.Height),
+		uint16(c.panel.Address), uint16(c.panel.Address >> 16),
+	}
+	info = append(info, versionWords(c.panel.Firmware)...)
+	return append(info, versionWords(c.panel.LUT)...)
+}
+
+// versionWords returns a version string as 8 words, two characters each
+func versionWords(version string) []uint16 {
+	words := make([]uint16, 8)
+	for i := 0; i < len(version) && i < 16; i++ {
+		words[i/2] |= uint16(version[i]) << (8 * (i % 2))
+	}
+	return words
+}
@@ -0,0 +1,110 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package sim
+
+import it8951 "github.com/peergum/IT8951-go"
+
+// store writes a byte of the image memory, ignoring addresses out of it
+func (c *Controller) store(address uint32, value uint8) {
+	if address >= c.panel.Address && int(address-c.panel.Address) < len(c.memory) {
+		c.memory[address-c.panel.Address] = value
+	}
+}
+
+// load reads a byte of the image memory, 0 for addresses out of it
+func (c *Controller) load(address uint32) uint8 {
+	if address >= c.panel.Address && int(address-c.panel.Address) < len(c.memory) {
+		return c.memory[address-c.panel.Address]
+	}
+	return 0
+}
+
+// target returns the load image start address (LISAR)
+func (c *Controller) target() uint32 {
+	return uint32(c.registers[it8951.LISAR]) | uint32(c.registers[it8951.LISAR+2])<<16
+}
+
+// pixels calls store for each pixel of a host word, as 8bpp gray levels
+func pixels(setting uint16, word uint16, store func(i int, gray uint8)) {
+	converter := it8951.ParseMemoryConverter(setting)
+	bpp := [...]int{2, 3, 4, 8}[converter.PixelFormat]
+	if bpp == 3 { // stored as 4 bits
+		bpp = 4
+	}
+	if converter.EndianType == it8951.LoadImgBigEndian {
+		word = word<<8 | word>>8
+	}
+	levels := 1 << bpp
+	for i := 0; i < 16/bpp; i++ {
+		value := int(word>>(i*bpp)) & (levels - 1)
+		store(i, uint8(value*255/(levels-1)))
+	}
+}
+
+// loadImage stores the pixels of the next data words from the target address
+func (c *Controller) loadImage(setting uint16) {
+	address := c.target()
+	c.stream = func(word uint16) {
+		pixels(setting, word, func(_ int, gray uint8) {
+			c.store(address, gray)
+			address++
+		})
+	}
+}
+
+// loadArea stores the pixels of the next data words in an area of the image
+// buffer at the target address, each row starting on a new word
+func (c *Controller) loadArea(setting, x, y, w, h uint16) {
+	base := c.target()
+	perWord := 16 / [...]int{2, 4, 4, 8}[it8951.ParseMemoryConverter(setting).PixelFormat]
+	rowWords := (int(w) + perWord - 1) / perWord
+	count := 0
+	c.stream = func(word uint16) {
+		row, col := count/rowWords, count%rowWords*perWord
+		count++
+		pixels(setting, word, func(i int, gray uint8) {
+			if col+i < int(w) && row < int(h) {
+				c.store(base+uint32((int(y)+row)*c.panel.Width+int(x)+col+i), gray)
+			}
+		})
+	}
+}
+
+// display shows an area of the image buffer at address, as 16 gray levels or
+// as a bitmap in 1bpp mode
+func (c *Controller) display(x, y, w, h int, address uint32) {
+	bitmap := c.registers[it8951.UP1SR+2]&uint16(it8951.BitmapMode>>16) != 0
+	colors := c.registers[it8951.BGVR]
+	for py := max(y, 0); py < min(y+h, c.panel.Height); py++ {
+		for px := max(x, 0); px < min(x+w, c.panel.Width); px++ {
+			offset := address + uint32(py*c.panel.Width)
+			var gray uint8
+			if bitmap {
+				if c.load(offset+uint32(px/8))>>(px%8)&1 != 0 {
+					gray = uint8(colors)
+				} else {
+					gray = uint8(colors >> 8)
+				}
+			} else {
+				gray = c.load(offset + uint32(px))
+			}
+			c.screen.Pix[c.screen.PixOffset(px, py)] = gray >> 4 * 0x11
+		}
+	}
+}
@@ -0,0 +1,269 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package sim simulates an IT8951 controller and its panel in memory. A
+// Controller is an it8951.Transport: it decodes the command flow sent by the
+// driver (registers, VCOM, system info, image loads, memory bursts and
+// display commands, in 8bpp or 1bpp bitmap mode), keeps the image buffer and
+// renders displayed areas to a screen image which can be saved as PNG. This
+// exercises applications without a Raspberry Pi:
+//
+//	panel := sim.New(sim.Panel{Width: 1872, Height: 1404})
+//	devInfo, err := it8951.Init(1500, it8951.WithTransport(panel))
+//	...
+//	err = panel.SavePNG("screen.png")
+//
+// Refreshes complete at once and transfers take no time, unless the panel
+// gives a Bandwidth and Refresh times (see Typical), to get a feel of the
+// pacing of a real panel. Rotated image loads are stored unrotated.
+package sim
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"sync"
+	"time"
+
+	it8951 "github.com/peergum/IT8951-go"
+)
+
+// Panel describes the simulated hardware
+type Panel struct {
+	Width, Height int
+	Address       uint32 // image buffer address (0x119f00 when 0)
+	Firmware      string // firmware version ("SIM" when empty)
+	LUT           string // LUT version ("SIM" when empty)
+	VCOM          uint16 // VCOM at power on
+	Temperature   int16  // panel temperature in °C
+	Frames        int    // panel sized image buffers the memory holds (2 when 0)
+
+	// Bandwidth is the simulated SPI throughput in bytes/s, transfers being
+	// slowed down to it (instant when 0)
+	Bandwidth int
+	// Refresh is the time refreshes take by display mode (instant for modes
+	// missing). The screen shows the new content at once, but the LUT status
+	// register reads busy until the refresh ends, refreshes started
+	// meanwhile being queued as on the controller.
+	Refresh map[it8951.DisplayMode]time.Duration
+}
+
+// Typical returns a panel of the given size with the pacing of a Waveshare
+// HAT: SPI at 1.5MB/s (24MHz, less the ready line waits) and the refresh
+// times of the M841 LUT waveforms
+func Typical(width, height int) Panel {
+	return Panel{
+		Width:     width,
+		Height:    height,
+		Bandwidth: 1500000,
+		Refresh: map[it8951.DisplayMode]time.Duration{
+			0: 2000 * time.Millisecond, // INIT
+			1: 260 * time.Millisecond,  // DU
+			2: 450 * time.Millisecond,  // GC16
+			3: 450 * time.Millisecond,  // GL16
+			4: 450 * time.Millisecond,  // GLR16
+			5: 450 * time.Millisecond,  // GLD16
+			6: 120 * time.Millisecond,  // A2
+			7: 290 * time.Millisecond,  // DU4
+		},
+	}
+}
+
+// Controller is a simulated controller
+type Controller struct {
+	panel Panel
+
+	mu        sync.Mutex
+	memory    []uint8 // from panel.Address, room for two image buffers
+	screen    *image.Gray
+	registers map[it8951.Address]uint16
+	vcom      uint16
+	forced    *uint16 // forced temperature
+
+	// transfer state
+	selected bool
+	bytes    []byte           // bytes of the word being received
+	preamble *it8951.Preamble // first word of the transfer, once received
+	reads    []uint16         // words to be read, dummy word first
+
+	// command state
+	command it8951.Command
+	args    []uint16
+	burst   []uint16          // memory burst read parameters
+	stream  func(word uint16) // consumer of data words past the arguments
+
+	busyUntil time.Time // end of the refreshes started
+}
+
+// New returns a simulated controller with a white screen
+func New(panel Panel) *Controller {
+	if panel.Address == 0 {
+		panel.Address = 0x119f00
+	}
+	if panel.Firmware == "" {
+		panel.Firmware = "SIM"
+	}
+	if panel.LUT == "" {
+		panel.LUT = "SIM"
+	}
+	if panel.Frames == 0 {
+		panel.Frames = 2
+	}
+	c := &Controller{
+		panel:     panel,
+		memory:    make([]uint8, panel.Frames*panel.Width*panel.Height),
+		screen:    image.NewGray(image.Rect(0, 0, panel.Width, panel.Height)),
+		registers: map[it8951.Address]uint16{},
+		vcom:      panel.VCOM,
+	}
+	for i := range c.screen.Pix {
+		c.screen.Pix[i] = 0xff
+	}
+	for i := range c.memory {
+		c.memory[i] = 0xff
+	}
+	return c
+}
+
+// Screen returns a copy of what the panel shows
+func (c *Controller) Screen() *image.Gray {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	screen := image.NewGray(c.screen.Rect)
+	copy(screen.Pix, c.screen.Pix)
+	return screen
+}
+
+// SavePNG writes what the panel shows to a PNG file
+func (c *Controller) SavePNG(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, c.Screen()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Register returns the value of a register
+func (c *Controller) Register(address it8951.Address) uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.registers[address]
+}
+
+func (c *Controller) Open() error  { return nil }
+func (c *Controller) Close() error { return nil }
+func (c *Controller) Ready() bool  { return true }
+
+// Reset clears the registers when the reset line is asserted
+func (c *Controller) Reset(asserted bool) {
+	if !asserted {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registers = map[it8951.Address]uint16{}
+	c.vcom = c.panel.VCOM
+	c.endCommand()
+}
+
+// Select starts or ends a transfer
+func (c *Controller) Select(selected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.selected = selected
+	c.bytes = c.bytes[:0]
+	c.preamble = nil
+}
+
+// Transmit receives bytes from the host, as big endian words
+func (c *Controller) Transmit(data ...byte) {
+	c.transfer(len(data))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.selected {
+		return
+	}
+	for _, b := range data {
+		c.bytes = append(c.bytes, b)
+		if len(c.bytes) < 2 {
+			continue
+		}
+		word := uint16(c.bytes[0])<<8 | uint16(c.bytes[1])
+		c.bytes = c.bytes[:0]
+		c.receive(word)
+	}
+}
+
+// Receive sends bytes to the host, the first word after a read preamble
+// being a dummy one
+func (c *Controller) Receive(n int) []byte {
+	c.transfer(n)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := make([]byte, n)
+	for i := 0; i+1 < n; i += 2 {
+		if len(c.reads) == 0 {
+			continue
+		}
+		data[i], data[i+1] = byte(c.reads[0]>>8), byte(c.reads[0])
+		c.reads = c.reads[1:]
+	}
+	return data
+}
+
+// transfer waits for as long as n bytes take at the panel bandwidth
+func (c *Controller) transfer(n int) {
+	if c.panel.Bandwidth > 0 {
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(c.panel.Bandwidth))
+	}
+}
+
+// refresh starts a refresh with mode, after those running
+func (c *Controller) refresh(mode it8951.DisplayMode) {
+	duration, ok := c.panel.Refresh[mode]
+	if !ok {
+		return
+	}
+	start := time.Now()
+	if c.busyUntil.After(start) {
+		start = c.busyUntil
+	}
+	c.busyUntil = start.Add(duration)
+}
+
+// receive handles a word sent by the host
+func (c *Controller) receive(word uint16) {
+	if c.preamble == nil {
+		preamble := it8951.Preamble(word)
+		c.preamble = &preamble
+		if preamble == it8951.ReadPreamble {
+			c.reads = append([]uint16{0}, c.reads...)
+		}
+		return
+	}
+	switch *c.preamble {
+	case it8951.CommandPreamble:
+		c.startCommand(it8951.Command(word))
+	case it8951.WritePreamble:
+		c.data(word)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+)
+
+// Snapshot reads a region of the image buffer back from the controller
+// memory, in logical coordinates (see SetOrientation). This is what the panel
+// shows after the last refresh of the region, as long as it was loaded as
+// grayscale data: 1bpp areas are stored as bitmaps and do not read back as
+// gray levels.
+func Snapshot(region image.Rectangle) *image.Gray {
+	Debug("Snapshot %v", region)
+	devInfo := DeviceInfo()
+	bounds := devInfo.Bounds()
+	region = region.Intersect(orientation.LogicalBounds(bounds))
+	area := orientation.ToPanelRect(region, bounds)
+	panel := readPanel(area, devInfo.TargetAddress(), int(devInfo.PanelW))
+	if orientation == Landscape {
+		return panel
+	}
+	gray := image.NewGray(region)
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			p := orientation.ToPanel(image.Pt(x, y), bounds)
+			gray.Pix[gray.PixOffset(x, y)] = panel.Pix[panel.PixOffset(p.X, p.Y)]
+		}
+	}
+	return gray
+}
+
+// CaptureArea reads the w×h area at x, y (panel coordinates) of the image
+// buffer back from the controller memory, as loaded at bpp: levels are cut to
+// their bpp most significant bits and spread over 0-255 again, as in the
+// images given to DrawImage. At 1bpp, the area holds bitmap bytes (see
+// Write1bppRect), unpacked with set bits reading as white; x and w must then
+// be multiples of 8.
+func CaptureArea(x, y, w, h uint16, bpp int) (*image.Gray, error) {
+	switch bpp {
+	case 1, 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("it8951: unsupported bpp %d", bpp)
+	}
+	devInfo := DeviceInfo()
+	area := Rect(x, y, w, h)
+	if !area.In(devInfo.Bounds()) {
+		return nil, fmt.Errorf("it8951: area %v off the panel", area)
+	}
+	Debug("Capture %v at %dbpp", area, bpp)
+	stride := int(devInfo.PanelW)
+	if bpp == 1 {
+		if x%8 != 0 || w%8 != 0 {
+			return nil, fmt.Errorf("it8951: 1bpp areas need x and width multiple of 8")
+		}
+		bitmap := readPanel(image.Rect(area.Min.X/8, area.Min.Y, area.Max.X/8, area.Max.Y), devInfo.TargetAddress(), stride)
+		gray := image.NewGray(area)
+		for i := range gray.Pix {
+			row, column := i/area.Dx(), i%area.Dx()
+			if bitmap.Pix[row*bitmap.Stride+column/8]&(1<<(column%8)) != 0 {
+				gray.Pix[i] = 0xff
+			}
+		}
+		return gray, Err()
+	}
+	gray := readPanel(area, devInfo.TargetAddress(), stride)
+	levels := 1 << bpp
+	for i, value := range gray.Pix {
+		gray.Pix[i] = uint8(int(value>>(8-bpp)) * 255 / (levels - 1))
+	}
+	return gray, Err()
+}
+
+// readPanel reads a panel area of the 8bpp image buffer at base, stride
+// being the buffer width in pixels
+func readPanel(area image.Rectangle, base uint32, stride int) *image.Gray {
+	gray := image.NewGray(area)
+	if area.Empty() {
+		return gray
+	}
+	// memory is read by words: start on an even pixel
+	x0 := area.Min.X &^ 1
+	words := (area.Max.X - x0 + 1) / 2
+	row := make(DataBuffer, words)
+	pix := make([]uint8, 2*words)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		address := ImageAddress(base, x0, y, stride)
+		for start := 0; start < words; start += verifyChunkWords {
+			end := min(start+verifyChunkWords, words)
+			transaction(TCONMemBstRdT, func() {
+				memBurstRead(address+uint32(2*start), row[start:end])
+			})
+		}
+		for i, word := range row {
+			pix[2*i], pix[2*i+1] = uint8(word), uint8(word>>8)
+		}
+		offset := gray.PixOffset(area.Min.X, y)
+		copy(gray.Pix[offset:offset+area.Dx()], pix[area.Min.X-x0:])
+	}
+	return gray
+}
+
+// DiffRatio compares the gray levels of two images over the bounds of a,
+// b being aligned on a. It returns the ratio (0 to 1) of pixels differing by
+// more than tolerance, pixels of a outside of b counting as different.
+func DiffRatio(a, b image.Image, tolerance uint8) float64 {
+	bounds := a.Bounds()
+	if bounds.Empty() {
+		return 0
+	}
+	ga := toGray(a, bounds)
+	gb := toGray(b, b.Bounds())
+	offset := b.Bounds().Min.Sub(bounds.Min)
+	different := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := image.Pt(x, y).Add(offset)
+			if !p.In(gb.Rect) {
+				different++
+				continue
+			}
+			va, vb := int(ga.Pix[ga.PixOffset(x, y)]), int(gb.Pix[gb.PixOffset(p.X, p.Y)])
+			if max(va-vb, vb-va) > int(tolerance) {
+				different++
+			}
+		}
+	}
+	return float64(different) / float64(bounds.Dx()*bounds.Dy())
+}
@@ -0,0 +1,92 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"image/color"
+)
+
+// PixelSource supplies an image one row at a time, so that large images can
+// be uploaded without holding the whole packed frame in memory
+type PixelSource interface {
+	// Size returns the image width and height in pixels
+	Size() (width, height int)
+	// GrayRow fills row (width pixels long) with the gray levels of line y,
+	// 0 being black and 255 white
+	GrayRow(y int, row []uint8)
+}
+
+// imageSource reads the rows of an image as needed
+type imageSource struct {
+	img image.Image
+}
+
+// ImageSource returns a PixelSource reading the rows of img on demand
+func ImageSource(img image.Image) PixelSource {
+	return imageSource{img: img}
+}
+
+// Size returns the image size
+func (src imageSource) Size() (width, height int) {
+	bounds := src.img.Bounds()
+	return bounds.Dx(), bounds.Dy()
+}
+
+// GrayRow converts line y of the image to gray levels
+func (src imageSource) GrayRow(y int, row []uint8) {
+	bounds := src.img.Bounds()
+	if gray, ok := src.img.(*image.Gray); ok {
+		offset := gray.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+		copy(row, gray.Pix[offset:offset+len(row)])
+		return
+	}
+	for x := range row {
+		row[x] = color.GrayModel.Convert(src.img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+	}
+}
+
+// LoadPixelSource uploads src at position at of the image buffer at
+// targetAddress, packing and sending one row at a time: only a row of the
+// image is ever held in memory. Pixels are quantized to bpp (2, 4 or 8)
+// without dithering, since error diffusion needs the following rows.
+// The size of src must be below 2048x2048.
+func LoadPixelSource(src PixelSource, at image.Point, bpp int, targetAddress uint32) {
+	width, height := src.Size()
+	area := image.Rectangle{Min: at, Max: at.Add(image.Pt(width, height))}
+	Debug("Loading pixel source at %v (%dbpp)", area, bpp)
+	WaitForDisplayReady()
+
+	imageInfo := LoadImgInfo{
+		EndianType:    LoadImgLittleEndian,
+		PixelFormat:   Bpp(bpp),
+		Rotate:        Rotate0,
+		TargetMemAddr: targetAddress,
+	}
+	areaInfo := AreaFromRect(area)
+	pix := make([]uint8, width)
+	row := make(DataBuffer, GetWidthInWords(width, bpp))
+	imageInfo.loadImage(&areaInfo, func() {
+		for y := 0; y < height; y++ {
+			src.GrayRow(y, pix)
+			packRow(pix, row, bpp)
+			row.WriteBuffer()
+		}
+	})
+}
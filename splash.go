@@ -0,0 +1,126 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+
+	"github.com/peergum/IT8951-go/qr"
+)
+
+// SplashOptions are the settings of NetworkSplash
+type SplashOptions struct {
+	// Title is shown above the network information (e.g. the product name)
+	Title string
+	// URL is encoded in a QR code, e.g. the web control panel of the
+	// device, "{ip}" standing for its first IP address (IPv4 preferred):
+	// "http://{ip}:8080/". No code is drawn when empty.
+	URL  string
+	Face font.Face // DefaultFace when nil
+}
+
+// NetworkSplash returns the first boot screen of a headless device, of the
+// given bounds: its host name and IP addresses, next to a QR code of its
+// control panel URL, to the right in landscape and below in portrait
+func NetworkSplash(bounds image.Rectangle, opts SplashOptions) (*image.Gray, error) {
+	ips := hostIPs()
+	var text strings.Builder
+	if opts.Title != "" {
+		fmt.Fprintf(&text, "%s\n\n", opts.Title)
+	}
+	if host, err := os.Hostname(); err == nil {
+		fmt.Fprintf(&text, "%s\n", host)
+	}
+	for _, ip := range ips {
+		fmt.Fprintf(&text, "%s\n", ip)
+	}
+	if len(ips) == 0 {
+		text.WriteString("no network\n")
+	}
+
+	screen := image.NewGray(bounds)
+	draw.Draw(screen, bounds, image.NewUniform(color.Gray{Y: config.Background}), image.Point{}, draw.Src)
+	textBox := bounds
+	if opts.URL != "" {
+		url := strings.ReplaceAll(opts.URL, "{ip}", urlHost(ips))
+		code, err := qr.Encode([]byte(url), qr.M)
+		if err != nil {
+			return nil, err
+		}
+		var codeBox image.Rectangle
+		if bounds.Dx() >= bounds.Dy() {
+			side := min(bounds.Dy(), bounds.Dx()/2)
+			codeBox = image.Rectangle{Min: image.Pt(bounds.Max.X-side, bounds.Min.Y), Max: image.Pt(bounds.Max.X, bounds.Min.Y+side)}
+			codeBox = codeBox.Add(image.Pt(0, (bounds.Dy()-side)/2))
+			textBox.Max.X = codeBox.Min.X
+		} else {
+			side := min(bounds.Dx(), bounds.Dy()/2)
+			codeBox = image.Rectangle{Min: image.Pt(bounds.Min.X, bounds.Max.Y-side), Max: image.Pt(bounds.Min.X+side, bounds.Max.Y)}
+			codeBox = codeBox.Add(image.Pt((bounds.Dx()-side)/2, 0))
+			textBox.Max.Y = codeBox.Min.Y
+		}
+		// whole pixels per module keep the code sharp
+		img := code.Image(1)
+		side := codeBox.Dx() / img.Rect.Dx() * img.Rect.Dx()
+		if side == 0 {
+			return nil, fmt.Errorf("it8951: %v too small for a QR code of %d modules", bounds, img.Rect.Dx())
+		}
+		at := codeBox.Min.Add(image.Pt(codeBox.Dx()-side, codeBox.Dy()-side).Div(2))
+		codeBox = image.Rectangle{Min: at, Max: at.Add(image.Pt(side, side))}
+		xdraw.NearestNeighbor.Scale(screen, codeBox, img, img.Rect, draw.Src, nil)
+		text.WriteString("\n" + url)
+	}
+	margin := min(textBox.Dx(), textBox.Dy()) / 10
+	rendered := RenderText(text.String(), textBox.Inset(margin), TextOptions{Face: opts.Face, Align: Center})
+	draw.Draw(screen, rendered.Rect, rendered, rendered.Rect.Min, draw.Src)
+	return screen, nil
+}
+
+// DisplayNetworkSplash displays the first boot screen (see NetworkSplash)
+// over the safe area, in GC16 mode
+func DisplayNetworkSplash(opts SplashOptions) error {
+	area := SafeArea()
+	screen, err := NetworkSplash(area, opts)
+	if err != nil {
+		return err
+	}
+	return displayImage(screen, area, 4, GC16Mode)
+}
+
+// urlHost returns the first IPv4 address of ips, or else the first one in
+// brackets, as the host of a URL
+func urlHost(ips []string) string {
+	for _, ip := range ips {
+		if !strings.Contains(ip, ":") {
+			return ip
+		}
+	}
+	if len(ips) > 0 {
+		return "[" + ips[0] + "]"
+	}
+	return "localhost"
+}
@@ -0,0 +1,123 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+)
+
+// stagedState is the content of a staged configuration file
+type stagedState struct {
+	Current  Config  // configuration known to work
+	Staged   *Config // configuration to try on next init, if any
+	Attempts int     // failed or interrupted inits with the staged configuration
+}
+
+// WithConfig replaces the whole configuration
+func WithConfig(c Config) Option {
+	return func(config *Config) {
+		*config = c
+	}
+}
+
+// StageConfig records c in the file at path (see SetStore), to be tried by
+// the next InitStaged using that file
+func StageConfig(path string, c Config) error {
+	state, err := loadStaged(path)
+	if err != nil {
+		return err
+	}
+	state.Staged = &c
+	state.Attempts = 0
+	return saveStaged(path, state)
+}
+
+// InitStaged initializes the controller with the configuration stored in the
+// file at path (see SetStore), making remote configuration changes safe on unattended
+// devices. A configuration recorded with StageConfig is used instead of the
+// current one until an init with it succeeds, when it becomes current. Every
+// init with the staged configuration is counted before starting, so that
+// hangs and crashes count as failures too: after maxAttempts failures, the
+// staged configuration is dropped and the current one is used again.
+//
+// An init fails when the controller does not answer with sane system info
+// (ErrNotInitialized). A missing file stands for the default configuration.
+func InitStaged(path string, vcom uint16, maxAttempts int) (*DevInfo, error) {
+	state, err := loadStaged(path)
+	if err != nil {
+		return nil, err
+	}
+	c := state.Current
+	if state.Staged != nil {
+		if state.Attempts >= maxAttempts {
+			Debug("Staged configuration failed %d times, reverting", state.Attempts)
+			state.Staged = nil
+			state.Attempts = 0
+		} else {
+			state.Attempts++
+			c = *state.Staged
+		}
+		if err := saveStaged(path, state); err != nil {
+			return nil, err
+		}
+	}
+
+	devInfo, err := Init(vcom, WithConfig(c))
+	if err != nil {
+		return nil, err
+	}
+	if err := probe(devInfo, vcom); err != nil {
+		return nil, err
+	}
+	if state.Staged != nil {
+		Debug("Staged configuration applied")
+		state.Current = *state.Staged
+		state.Staged = nil
+		state.Attempts = 0
+		if err := saveStaged(path, state); err != nil {
+			return devInfo, err
+		}
+	}
+	return devInfo, nil
+}
+
+// loadStaged reads a staged configuration file
+func loadStaged(path string) (stagedState, error) {
+	state := stagedState{Current: DefaultConfig()}
+	data, err := store.Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// saveStaged writes a staged configuration file, replacing it atomically
+func saveStaged(path string, state stagedState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Save(path, data)
+}
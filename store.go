@@ -0,0 +1,177 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"errors"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists the driver state (staged configuration, wear counts, panel
+// profiles, shadow frames, the ipc frame journal) by key. The keys are the
+// paths given to the functions saving state. By default they are files
+// (DirStore("")), but deployments with a read-only root filesystem can
+// redirect the state to tmpfs or keep it elsewhere:
+//
+//	it8951.SetStore(it8951.DirStore("/run/epd"))
+//
+// Memory mapped shadows (NewMappedShadow) and the panel lease need real files
+// and are not kept in the store.
+type Store interface {
+	// Load returns the data saved for key, or an error matching
+	// fs.ErrNotExist when there is none
+	Load(key string) ([]byte, error)
+	// Save replaces the data of key, never leaving it half written
+	Save(key string, data []byte) error
+	// Delete removes key, if it exists
+	Delete(key string) error
+}
+
+var (
+	store Store = DirStore("")
+)
+
+// SetStore sets where the driver state is persisted (files by default, nil
+// restoring them)
+func SetStore(s Store) {
+	if s == nil {
+		s = DirStore("")
+	}
+	store = s
+}
+
+// CurrentStore returns where the driver state is persisted
+func CurrentStore() Store {
+	return store
+}
+
+// DirStore keeps state in files, keys being paths within the directory: an
+// absolute key lands under it too. The empty DirStore uses the keys as paths.
+type DirStore string
+
+// path returns the file of a key
+func (dir DirStore) path(key string) string {
+	return filepath.Join(string(dir), key)
+}
+
+// Load reads the file of key
+func (dir DirStore) Load(key string) ([]byte, error) {
+	return os.ReadFile(dir.path(key))
+}
+
+// Save writes the file of key, creating its directory if needed, through a
+// temporary file renamed once synced
+func (dir DirStore) Save(key string, data []byte) error {
+	path := dir.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// Delete removes the file of key
+func (dir DirStore) Delete(key string) error {
+	if err := os.Remove(dir.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MemoryStore keeps state in memory, e.g. for tests or devices which need
+// not remember anything across restarts
+type MemoryStore struct {
+	lock sync.Mutex
+	data map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: map[string][]byte{}}
+}
+
+// Load returns a copy of the data of key
+func (m *MemoryStore) Load(key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.data[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "load", Path: key, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Save keeps a copy of data for key
+func (m *MemoryStore) Save(key string, data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.data[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// Delete forgets key
+func (m *MemoryStore) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+// SaveShadow saves the pixels of a shadow frame to the store, so that a
+// heap shadow survives restarts too (see LoadShadow)
+func SaveShadow(key string, shadow *Shadow) error {
+	return store.Save(key, shadow.gray.Pix)
+}
+
+// LoadShadow returns a shadow frame of the given bounds with the pixels
+// saved by SaveShadow, or a white one when none were saved, or for other
+// bounds
+func LoadShadow(key string, bounds image.Rectangle) (*Shadow, error) {
+	shadow := NewShadow(bounds)
+	data, err := store.Load(key)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case len(data) == len(shadow.gray.Pix):
+		copy(shadow.gray.Pix, data)
+	default:
+		Debug("Shadow %s: %d bytes saved, not %d", key, len(data), len(shadow.gray.Pix))
+	}
+	return shadow, nil
+}
@@ -0,0 +1,143 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+// Supervisor limits how many times a failing controller is recovered.
+// Applications call Recover when an operation fails; after Budget consecutive
+// failed recoveries, the panel power is cycled if a PowerPin is set and a last
+// recovery is tried. When that fails too (or without a power pin) the
+// supervisor gives up for good: OnFatal is called and every later Recover
+// returns ErrDead, so a dead panel is reported instead of being reset forever.
+type Supervisor struct {
+	// Budget is the number of consecutive failed recoveries allowed (3 when 0)
+	Budget int
+	// PowerPin switches the panel power, high being on unless PowerActiveLow
+	// is set. GPIO 0 (the HAT EEPROM line) means no power pin.
+	PowerPin       rpio.Pin
+	PowerActiveLow bool
+	// PowerOff is how long the power is cut (1s when 0)
+	PowerOff time.Duration
+	// OnFatal is called once, with an error wrapping ErrDead, when giving up
+	OnFatal func(error)
+
+	failures int
+	dead     error
+}
+
+// Failures returns the number of consecutive failed recoveries
+func (s *Supervisor) Failures() int {
+	return s.failures
+}
+
+// Recover resets the controller and applies the settings of the last Init or
+// Attach again, checking the controller answers with sane values
+func (s *Supervisor) Recover() error {
+	if s.dead != nil {
+		return s.dead
+	}
+	err := reinitialize()
+	if err == nil {
+		s.failures = 0
+		return nil
+	}
+	s.failures++
+	Debug("Recovery %d failed: %v", s.failures, err)
+	budget := s.Budget
+	if budget == 0 {
+		budget = 3
+	}
+	if s.failures < budget {
+		return err
+	}
+	if s.PowerPin != 0 {
+		s.powerCycle()
+		if err = reinitialize(); err == nil {
+			s.failures = 0
+			return nil
+		}
+	}
+	s.dead = fmt.Errorf("%w after %d recoveries: %v", ErrDead, s.failures, err)
+	if s.OnFatal != nil {
+		s.OnFatal(s.dead)
+	}
+	return s.dead
+}
+
+// powerCycle cuts the panel power and restores it
+func (s *Supervisor) powerCycle() {
+	Debug("Power cycling the panel")
+	off := s.PowerOff
+	if off == 0 {
+		off = time.Second
+	}
+	s.PowerPin.Output()
+	s.setPower(false)
+	time.Sleep(off)
+	s.setPower(true)
+	time.Sleep(time.Duration(200) * time.Millisecond)
+}
+
+// setPower drives the power pin
+func (s *Supervisor) setPower(on bool) {
+	if on != s.PowerActiveLow {
+		s.PowerPin.High()
+	} else {
+		s.PowerPin.Low()
+	}
+}
+
+// reinitialize resets the controller and restores its settings. The ready
+// line is checked with a timeout first, since a dead controller would
+// otherwise block the next command forever.
+func reinitialize() error {
+	Reset()
+	if err := waitReadyTimeout(config.WakeTimeout); err != nil {
+		return err
+	}
+	SystemRun()
+	devInfo := RefreshDevInfo()
+	if err := RestoreState(); err != nil {
+		invalidateDevInfo()
+		return err
+	}
+	if err := probe(devInfo, vcomSetting); err != nil {
+		invalidateDevInfo()
+		return err
+	}
+	return nil
+}
+
+// waitReadyTimeout waits for the ready line, returning ErrTimeout after timeout
+func waitReadyTimeout(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !bus.Ready() {
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(time.Duration(10) * time.Microsecond)
+	}
+	return nil
+}
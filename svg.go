@@ -0,0 +1,58 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// SVGRasterizer renders an SVG document to an image of the given size
+type SVGRasterizer func(data []byte, width, height int) (image.Image, error)
+
+var (
+	svgRasterizer SVGRasterizer
+)
+
+// ErrNoRasterizer is returned by DrawSVG when no SVG rasterizer is set
+var ErrNoRasterizer = errors.New("it8951: no SVG rasterizer")
+
+// SetSVGRasterizer sets the function rendering SVG documents for DrawSVG.
+// Importing github.com/peergum/IT8951-go/svg sets a default one.
+func SetSVGRasterizer(r SVGRasterizer) {
+	svgRasterizer = r
+}
+
+// DrawSVG renders an SVG document to the size of rect (in logical
+// coordinates) and displays it there in GC16 mode, so vector content is drawn
+// at the panel resolution instead of being scaled from a bitmap.
+func DrawSVG(data []byte, rect image.Rectangle) error {
+	Debug("Draw SVG in %v", rect)
+	if svgRasterizer == nil {
+		return ErrNoRasterizer
+	}
+	rendered, err := svgRasterizer(data, rect.Dx(), rect.Dy())
+	if err != nil {
+		return err
+	}
+	img := image.NewGray(rect)
+	draw.Draw(img, rect, rendered, rendered.Bounds().Min, draw.Src)
+	return displayImage(img, rect, 4, GC16Mode)
+}
@@ -0,0 +1,53 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package svg renders SVG documents for it8951.DrawSVG. Importing it sets
+// its rasterizer as the default one:
+//
+//	import _ "github.com/peergum/IT8951-go/svg"
+package svg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/peergum/IT8951-go"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+func init() {
+	it8951.SetSVGRasterizer(Rasterize)
+}
+
+// Rasterize renders an SVG document on a white image of the given size, the
+// document being stretched to fill it. Unsupported elements are skipped.
+func Rasterize(data []byte, width, height int) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
@@ -0,0 +1,213 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// MeasureText returns the size of a line of text drawn with face, without
+// drawing it: its advance width once shaped (see VisualText), its height from
+// the face ascent to its descent, and the baseline offset from the top (the
+// ascent)
+func MeasureText(face font.Face, text string) (w, h, baseline int) {
+	metrics := face.Metrics()
+	baseline = metrics.Ascent.Ceil()
+	return font.MeasureString(face, VisualText(text)).Ceil(), baseline + metrics.Descent.Ceil(), baseline
+}
+
+// WrapText breaks text into the lines it would take in a box of the given
+// width, breaking at spaces and within words longer than a line. Newlines
+// always start a new line. The height needed is len(lines) times the face
+// line height (face.Metrics().Height). Lines are in logical order: pass each
+// of them to VisualText before drawing it.
+func WrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.MeasureString(face, candidate).Ceil() <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			line = word
+			for font.MeasureString(face, line).Ceil() > width {
+				head := fitRunes(face, line, width)
+				lines = append(lines, head)
+				line = line[len(head):]
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fitRunes returns the longest prefix of text fitting width, at least one rune
+func fitRunes(face font.Face, text string, width int) string {
+	end := 0
+	for i, r := range text {
+		next := i + len(string(r))
+		if end > 0 && font.MeasureString(face, text[:next]).Ceil() > width {
+			break
+		}
+		end = next
+	}
+	return text[:end]
+}
+
+// DrawText draws a line of text on dst with src (e.g. image.Black), rotated
+// clockwise by degrees (0, 90, 180 or 270): 90 reads top to bottom as on book
+// spines, 270 bottom to top as on chart axes. The text is rendered into a
+// glyph mask, which is rotated then blitted with its top left corner at at.
+// The area covered is returned.
+func DrawText(dst draw.Image, at image.Point, face font.Face, text string, src image.Image, degrees int) (image.Rectangle, error) {
+	mask, err := textMask(face, text, degrees)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	area := mask.Rect.Add(at)
+	draw.DrawMask(dst, area, src, image.Point{}, mask, image.Point{}, draw.Over)
+	return area.Intersect(dst.Bounds()), nil
+}
+
+// textMask renders a line of text as an alpha mask, from 0,0, rotated
+// clockwise by degrees
+func textMask(face font.Face, text string, degrees int) (*image.Alpha, error) {
+	degrees = (degrees%360 + 360) % 360
+	if degrees%90 != 0 {
+		return nil, fmt.Errorf("it8951: unsupported text rotation %d", degrees)
+	}
+	w, h, baseline := MeasureText(face, text)
+	mask := image.NewAlpha(image.Rect(0, 0, w, h))
+	drawer := font.Drawer{Dst: mask, Src: image.Opaque, Face: face, Dot: fixed.P(0, baseline)}
+	drawer.DrawString(VisualText(text))
+	if degrees == 0 {
+		return mask, nil
+	}
+	size := image.Pt(w, h)
+	if degrees != 180 {
+		size = image.Pt(h, w)
+	}
+	rotated := image.NewAlpha(image.Rectangle{Max: size})
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var p image.Point
+			switch degrees {
+			case 90:
+				p = image.Pt(h-1-y, x)
+			case 180:
+				p = image.Pt(w-1-x, h-1-y)
+			case 270:
+				p = image.Pt(y, w-1-x)
+			}
+			rotated.Pix[rotated.PixOffset(p.X, p.Y)] = mask.Pix[mask.PixOffset(x, y)]
+		}
+	}
+	return rotated, nil
+}
+
+// LoadFont reads a TrueType or OpenType font file, to get faces of any size
+// from with NewFace
+func LoadFont(path string) (*sfnt.Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.Parse(data)
+}
+
+// NewFace returns an anti-aliased face of f, size pixels high (the em size)
+func NewFace(f *sfnt.Font, size float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// TextOptions are the settings of RenderText and DisplayText. The zero value
+// draws black text in the default face (see DefaultFace) from the top left
+// corner of the box.
+type TextOptions struct {
+	Face  font.Face  // DefaultFace when nil
+	Align Anchor     // position of the text within the box
+	Color color.Gray // text gray, the box getting the background gray
+}
+
+// RenderText draws text in a box: wrapped to the box width (see WrapText),
+// each line aligned and the lines together placed within the box as
+// opts.Align tells. Glyphs are anti-aliased; lines not fitting in the box
+// are cut.
+func RenderText(text string, box image.Rectangle, opts TextOptions) *image.Gray {
+	face := opts.Face
+	if face == nil {
+		face = DefaultFace()
+	}
+	frame := image.NewGray(box)
+	draw.Draw(frame, box, image.NewUniform(color.Gray{Y: config.Background}), image.Point{}, draw.Src)
+	lines := WrapText(face, text, box.Dx())
+	metrics := face.Metrics()
+	height := metrics.Height.Ceil()
+	top := box.Min.Y
+	switch opts.Align / 3 { // row
+	case 1:
+		top += (box.Dy() - len(lines)*height) / 2
+	case 2:
+		top = box.Max.Y - len(lines)*height
+	}
+	src := image.NewUniform(opts.Color)
+	for i, line := range lines {
+		line = VisualText(line)
+		left := box.Min.X
+		width := font.MeasureString(face, line).Ceil()
+		switch opts.Align % 3 { // column
+		case 1:
+			left += (box.Dx() - width) / 2
+		case 2:
+			left = box.Max.X - width
+		}
+		drawer := font.Drawer{Dst: frame, Src: src, Face: face, Dot: fixed.P(left, top+i*height+metrics.Ascent.Ceil())}
+		drawer.DrawString(line)
+	}
+	return frame
+}
+
+// DisplayText renders text in a box (see RenderText), in logical coordinates,
+// and displays the box at the given bpp (1, 2, 4 or 8) with mode
+func DisplayText(text string, box image.Rectangle, opts TextOptions, bpp int, mode DisplayMode) error {
+	switch bpp {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("it8951: unsupported bpp %d", bpp)
+	}
+	return displayImage(RenderText(text, box, opts), box, bpp, mode)
+}
@@ -0,0 +1,117 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// Thresholding ditherers turn grayscale content into black and white, which
+// suits text and scanned documents better than a fixed 50% cut. They only
+// apply to 1bpp conversions (2 levels): other depths are quantized.
+
+// Otsu binarizes with the global threshold that best separates the dark and
+// light pixels of the image (Otsu's method)
+var Otsu Ditherer = DithererFunc(otsu)
+
+func otsu(src *image.Gray, levels int) *image.Gray {
+	if levels != 2 {
+		return quantize(src, levels)
+	}
+	level := int(OtsuThreshold(src))
+	return threshold(src, func(x, y int) int { return level })
+}
+
+// OtsuThreshold returns the gray level separating the dark and light pixels
+// of gray with the largest between-class variance
+func OtsuThreshold(gray *image.Gray) uint8 {
+	var histogram [256]int
+	bounds := gray.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	for y := 0; y < h; y++ {
+		for _, value := range gray.Pix[y*gray.Stride : y*gray.Stride+w] {
+			histogram[value]++
+		}
+	}
+	total := w * h
+	sum := 0
+	for level, count := range histogram {
+		sum += level * count
+	}
+	best, bestVariance := 0, 0.0
+	darkCount, darkSum := 0, 0
+	for level, count := range histogram {
+		darkCount += count
+		darkSum += level * count
+		lightCount := total - darkCount
+		if darkCount == 0 || lightCount == 0 {
+			continue
+		}
+		darkMean := float64(darkSum) / float64(darkCount)
+		lightMean := float64(sum-darkSum) / float64(lightCount)
+		variance := float64(darkCount) * float64(lightCount) * (darkMean - lightMean) * (darkMean - lightMean)
+		if variance > bestVariance {
+			best, bestVariance = level, variance
+		}
+	}
+	// pixels up to best are dark
+	return uint8(best + 1)
+}
+
+// AdaptiveThreshold returns a ditherer binarizing each pixel against the mean
+// of the (2*radius+1)² pixels around it, minus offset. It copes with uneven
+// backgrounds, e.g. shadows on scanned pages.
+func AdaptiveThreshold(radius int, offset int) Ditherer {
+	return DithererFunc(func(src *image.Gray, levels int) *image.Gray {
+		if levels != 2 {
+			return quantize(src, levels)
+		}
+		bounds := src.Bounds()
+		w, h := bounds.Dx(), bounds.Dy()
+		// integral image, with a zero first row and column
+		integral := make([]int, (w+1)*(h+1))
+		for y := 0; y < h; y++ {
+			rowSum := 0
+			for x, value := range src.Pix[y*src.Stride : y*src.Stride+w] {
+				rowSum += int(value)
+				integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + rowSum
+			}
+		}
+		return threshold(src, func(x, y int) int {
+			x0, y0 := max(x-radius, 0), max(y-radius, 0)
+			x1, y1 := min(x+radius+1, w), min(y+radius+1, h)
+			sum := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] - integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+			return sum/((x1-x0)*(y1-y0)) - offset
+		})
+	})
+}
+
+// threshold returns src in black and white, pixels at or above the
+// threshold returned for their position (relative to the image origin) being white
+func threshold(src *image.Gray, thresholdAt func(x, y int) int) *image.Gray {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewGray(bounds)
+	for y := 0; y < h; y++ {
+		for x, value := range src.Pix[y*src.Stride : y*src.Stride+w] {
+			if int(value) >= thresholdAt(x, y) {
+				dst.Pix[y*dst.Stride+x] = 0xff
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,157 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// historyWeight is the weight of a new measure in the refresh duration averages
+const historyWeight = 0.25
+
+// pendingRefresh is the last display command sent
+type pendingRefresh struct {
+	frame uint64
+	mode  DisplayMode
+	start time.Time
+	area  image.Rectangle
+}
+
+// Presented describes a refresh whose waveform completed (see OnPresented)
+type Presented struct {
+	Frame   uint64          // frame ID (see FrameID)
+	Area    image.Rectangle // panel area refreshed
+	Mode    DisplayMode
+	Sent    time.Time // display command sent
+	Visible time.Time // waveform completed: the content is on the glass
+}
+
+// refreshLock guards the refresh bookkeeping, which display functions update
+// outside of the bus lock, from several goroutines
+var (
+	refreshLock     sync.Mutex
+	pending         *pendingRefresh
+	refreshDuration = map[DisplayMode]time.Duration{} // measured averages
+	onPresented     func(Presented)                   // see OnPresented
+	frameID         atomic.Uint64                     // see FrameID
+)
+
+// FrameID returns the ID of the last frame displayed, 0 before the first
+// one. Every display command gets the next ID, from 1, for the life of the
+// process, so that "frame 1234 looked wrong" is traced across the renderer,
+// daemon and driver: driver messages carry it as their frame attribute (see
+// SetLogger), OnPresented gets it, and the ipc server acknowledges displays
+// with it.
+func FrameID() uint64 {
+	return frameID.Load()
+}
+
+// OnPresented sets a function called once the waveform of each refresh
+// completed, e.g. to trigger a camera photographing the panel when the
+// content is truly visible (nil to stop). While it is set, display functions
+// wait for the end of their refresh, so that its completion is seen within
+// the polling period rather than when the next command waits for it: the
+// next frame can then no longer be prepared during refreshes. fn runs on the
+// goroutine displaying and must not call the driver.
+func OnPresented(fn func(Presented)) {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+	onPresented = fn
+}
+
+// startRefresh records the start of a refresh of an area of the image buffer
+// at address, until WaitForDisplayReady ends it
+func startRefresh(area image.Rectangle, mode DisplayMode, address uint32) {
+	id := frameID.Add(1)
+	Debug("Frame %d: mode %d on %v", id, mode, area)
+	refreshLock.Lock()
+	pending = &pendingRefresh{frame: id, mode: mode, start: time.Now(), area: area}
+	countWear(area)
+	if refreshes != nil {
+		refreshes.record(area, mode, address)
+	}
+	wait := onPresented != nil
+	refreshLock.Unlock()
+	if wait {
+		WaitForDisplayReady()
+	}
+}
+
+// endRefresh adds the duration of the pending refresh to the history
+func endRefresh() {
+	refreshLock.Lock()
+	done, presented := pending, onPresented
+	if done == nil {
+		refreshLock.Unlock()
+		return
+	}
+	pending = nil
+	now := time.Now()
+	measured := now.Sub(done.start)
+	average, ok := refreshDuration[done.mode]
+	if ok {
+		average += time.Duration(historyWeight * float64(measured-average))
+	} else {
+		average = measured
+	}
+	refreshDuration[done.mode] = average
+	refreshLock.Unlock()
+	Debug("Mode %d refresh took %v (average %v)", done.mode, measured, average)
+	if presented != nil && busErr == nil {
+		presented(Presented{Frame: done.frame, Area: done.area, Mode: done.mode, Sent: done.start, Visible: now})
+	}
+}
+
+// EstimateRefreshDuration returns the expected time for the panel to refresh
+// area in the given mode, once the display command is sent. It is based on
+// the durations measured by WaitForDisplayReady for this mode, or on typical
+// waveform durations until a refresh has been measured. Waveforms run on all
+// pixels of the area in parallel, so only empty areas make a difference.
+func EstimateRefreshDuration(mode DisplayMode, area image.Rectangle) time.Duration {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+	return refreshEstimate(mode, area)
+}
+
+// refreshEstimate is EstimateRefreshDuration, refreshLock being held
+func refreshEstimate(mode DisplayMode, area image.Rectangle) time.Duration {
+	if area.Empty() {
+		return 0
+	}
+	if average, ok := refreshDuration[mode]; ok {
+		return average
+	}
+	return waveformDuration(mode)
+}
+
+// RemainingRefresh returns the expected time left before the last refresh
+// completes, so the next frame can be prepared meanwhile instead of after
+// WaitForDisplayReady returns. It returns 0 when no refresh is pending.
+func RemainingRefresh() time.Duration {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+	if pending == nil {
+		return 0
+	}
+	remaining := refreshEstimate(pending.mode, pending.area) - time.Since(pending.start)
+	return max(remaining, 0)
+}
@@ -0,0 +1,104 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"image"
+	"math"
+)
+
+// ToneCurve maps the gray levels of images before they are reduced to the
+// panel levels, to compensate for how the panel renders midtones: the level
+// of value v is displayed as curve[v]
+type ToneCurve [256]uint8
+
+var (
+	toneCurve *ToneCurve // see SetToneCurve
+)
+
+// GammaCurve returns a tone curve applying a gamma: values above 1 darken
+// midtones, values below 1 lighten them
+func GammaCurve(gamma float64) *ToneCurve {
+	curve := &ToneCurve{}
+	for value := range curve {
+		curve[value] = uint8(math.Round(255 * math.Pow(float64(value)/255, gamma)))
+	}
+	return curve
+}
+
+// LevelCurve returns a tone curve from the values to display for each of the
+// 16 panel levels (0x00, 0x11, ... 0xff), interpolated linearly in between
+func LevelCurve(levels [16]uint8) *ToneCurve {
+	curve := &ToneCurve{}
+	for value := range curve {
+		i, rest := value/17, value%17
+		if rest == 0 {
+			curve[value] = levels[i]
+			continue
+		}
+		low, high := int(levels[i]), int(levels[i+1])
+		curve[value] = uint8(low + ((high-low)*rest+sign(high-low)*17/2)/17)
+	}
+	return curve
+}
+
+// sign returns -1, 0 or 1 as n is negative, 0 or positive
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// SetToneCurve sets the tone curve applied when converting images for the
+// panel, when the configuration gives none (see WithToneCurve). Images are
+// converted as they are when nil, the default.
+func SetToneCurve(curve *ToneCurve) {
+	toneCurve = curve
+}
+
+// WithToneCurve sets the tone curve applied when converting images for the
+// panel (the one set with SetToneCurve when nil)
+func WithToneCurve(curve *ToneCurve) Option {
+	return func(c *Config) {
+		c.ToneCurve = curve
+	}
+}
+
+// currentToneCurve returns the configured tone curve, or the one set with
+// SetToneCurve
+func currentToneCurve() *ToneCurve {
+	if config.ToneCurve != nil {
+		return config.ToneCurve
+	}
+	return toneCurve
+}
+
+// apply maps the pixels of gray through the curve, in place
+func (curve *ToneCurve) apply(gray *image.Gray) {
+	for y := gray.Rect.Min.Y; y < gray.Rect.Max.Y; y++ {
+		row := gray.Pix[gray.PixOffset(gray.Rect.Min.X, y):gray.PixOffset(gray.Rect.Max.X, y)]
+		for x, value := range row {
+			row[x] = curve[value]
+		}
+	}
+}
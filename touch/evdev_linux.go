@@ -0,0 +1,188 @@
+//go:build linux
+
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package touch
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// input event types and codes (linux/input-event-codes.h)
+const (
+	evSyn = 0x00
+	evKey = 0x01
+	evAbs = 0x03
+
+	synReport = 0x00
+
+	btnTouch = 0x14a
+
+	absX            = 0x00
+	absY            = 0x01
+	absMTSlot       = 0x2f
+	absMTPositionX  = 0x35
+	absMTPositionY  = 0x36
+	absMTTrackingID = 0x39
+	maxSlots        = 10
+	singleTouchSlot = 0
+	noContact       = -1
+)
+
+// inputEvent is struct input_event
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// contact is the state of a touch slot
+type contact struct {
+	id      int // tracking id, noContact when lifted
+	x, y    int
+	changed bool
+	down    bool // reported as down
+}
+
+// Device is an open touch input device
+type Device struct {
+	file        *os.File
+	panel       image.Rectangle
+	calibration Calibration
+	slot        int
+	multiTouch  bool // reports MT events, legacy single touch ones are then ignored
+	contacts    [maxSlots]contact
+	pending     []Event
+}
+
+// Open opens the input device at path (e.g. /dev/input/event0) for a panel
+// of the given bounds (it8951.DevInfo.Bounds)
+func Open(path string, panel image.Rectangle, calibration Calibration) (*Device, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Device{file: file, panel: panel, calibration: calibration}
+	for i := range d.contacts {
+		d.contacts[i].id = noContact
+	}
+	return d, nil
+}
+
+// Close closes the device
+func (d *Device) Close() error {
+	return d.file.Close()
+}
+
+// Next waits for the next touch event
+func (d *Device) Next() (Event, error) {
+	for len(d.pending) == 0 {
+		var event inputEvent
+		if err := binary.Read(d.file, binary.LittleEndian, &event); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return Event{}, err
+		}
+		d.handle(event)
+	}
+	event := d.pending[0]
+	d.pending = d.pending[1:]
+	return event, nil
+}
+
+// handle updates the contacts with an input event, queuing touch events
+// at the end of each report
+func (d *Device) handle(event inputEvent) {
+	current := &d.contacts[d.slot]
+	if event.Type == evAbs && event.Code >= absMTSlot {
+		d.multiTouch = true
+	}
+	switch {
+	case event.Type == evSyn && event.Code == synReport:
+		when := time.Unix(int64(event.Time.Sec), int64(event.Time.Usec)*1000)
+		d.report(when)
+	case event.Type == evAbs && event.Code == absMTSlot:
+		if event.Value >= 0 && event.Value < maxSlots {
+			d.slot = int(event.Value)
+		}
+	case event.Type == evAbs && event.Code == absMTTrackingID:
+		current.id = int(event.Value)
+		current.changed = true
+	case event.Type == evAbs && event.Code == absMTPositionX:
+		current.x = int(event.Value)
+		current.changed = true
+	case event.Type == evAbs && event.Code == absMTPositionY:
+		current.y = int(event.Value)
+		current.changed = true
+	case d.multiTouch:
+		// legacy events emulated from the MT ones
+	case event.Type == evAbs && (event.Code == absX || event.Code == absY):
+		single := &d.contacts[singleTouchSlot]
+		if event.Code == absX {
+			single.x = int(event.Value)
+		} else {
+			single.y = int(event.Value)
+		}
+		single.changed = true
+	case event.Type == evKey && event.Code == btnTouch:
+		single := &d.contacts[singleTouchSlot]
+		if event.Value == 0 {
+			single.id = noContact
+		} else if single.id == noContact {
+			single.id = 0
+		}
+		single.changed = true
+	}
+}
+
+// report queues the events of the contacts changed since the last report
+func (d *Device) report(when time.Time) {
+	for slot := range d.contacts {
+		c := &d.contacts[slot]
+		if !c.changed {
+			continue
+		}
+		c.changed = false
+		event := Event{
+			Slot:  slot,
+			Point: d.calibration.toLogical(c.x, c.y, d.panel),
+			Time:  when,
+		}
+		switch {
+		case c.id == noContact && c.down:
+			event.Phase = Up
+			c.down = false
+		case c.id == noContact:
+			continue
+		case !c.down:
+			event.Phase = Down
+			c.down = true
+		default:
+			event.Phase = Move
+		}
+		d.pending = append(d.pending, event)
+	}
+}
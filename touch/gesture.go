@@ -0,0 +1,158 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package touch
+
+import (
+	"image"
+	"time"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// GestureKind is the type of a recognized gesture
+type GestureKind uint8
+
+// Gesture kinds
+const (
+	Tap GestureKind = iota
+	LongPress
+	SwipeLeft
+	SwipeRight
+	SwipeUp
+	SwipeDown
+)
+
+// Gesture is a recognized gesture
+type Gesture struct {
+	Kind       GestureKind
+	Start, End image.Point   // logical display coordinates
+	Region     string        // name of the display region (see it8951.DefineRegion) where it started, if any
+	Duration   time.Duration // from touch down to touch up, or to recognition for long presses
+}
+
+// Recognizer turns the touch events of a contact into gestures
+type Recognizer struct {
+	LongPress     time.Duration // hold time of a long press
+	SwipeDistance int           // shortest move of a swipe, in pixels
+	Slop          int           // largest move of a tap or long press, in pixels
+
+	touches map[int]*touchStart
+}
+
+// touchStart is a contact being tracked
+type touchStart struct {
+	event    Event
+	last     image.Point
+	reported bool // long press already reported
+}
+
+// NewRecognizer returns a recognizer with default thresholds
+func NewRecognizer() *Recognizer {
+	return &Recognizer{
+		LongPress:     500 * time.Millisecond,
+		SwipeDistance: 50,
+		Slop:          15,
+		touches:       map[int]*touchStart{},
+	}
+}
+
+// Feed processes a touch event, returning the gesture it completes if any
+func (r *Recognizer) Feed(e Event) (Gesture, bool) {
+	switch e.Phase {
+	case Down:
+		r.touches[e.Slot] = &touchStart{event: e, last: e.Point}
+	case Move:
+		if t, ok := r.touches[e.Slot]; ok {
+			t.last = e.Point
+		}
+	case Up:
+		t, ok := r.touches[e.Slot]
+		if !ok {
+			break
+		}
+		delete(r.touches, e.Slot)
+		if t.reported {
+			break
+		}
+		return r.classify(t.event, e.Point, e.Time.Sub(t.event.Time))
+	}
+	return Gesture{}, false
+}
+
+// Check reports long presses of contacts still held at now, so they can be
+// acted upon before the finger is lifted. It should be called periodically
+// while touches are in progress.
+func (r *Recognizer) Check(now time.Time) (Gesture, bool) {
+	for _, t := range r.touches {
+		held := now.Sub(t.event.Time)
+		if t.reported || held < r.LongPress || distance(t.event.Point, t.last) > r.Slop {
+			continue
+		}
+		t.reported = true
+		return r.gesture(LongPress, t.event, t.last, held), true
+	}
+	return Gesture{}, false
+}
+
+// classify recognizes a completed touch
+func (r *Recognizer) classify(start Event, end image.Point, held time.Duration) (Gesture, bool) {
+	move := end.Sub(start.Point)
+	switch {
+	case distance(start.Point, end) <= r.Slop && held >= r.LongPress:
+		return r.gesture(LongPress, start, end, held), true
+	case distance(start.Point, end) <= r.Slop:
+		return r.gesture(Tap, start, end, held), true
+	case abs(move.X) >= r.SwipeDistance && abs(move.X) >= abs(move.Y):
+		if move.X < 0 {
+			return r.gesture(SwipeLeft, start, end, held), true
+		}
+		return r.gesture(SwipeRight, start, end, held), true
+	case abs(move.Y) >= r.SwipeDistance:
+		if move.Y < 0 {
+			return r.gesture(SwipeUp, start, end, held), true
+		}
+		return r.gesture(SwipeDown, start, end, held), true
+	}
+	return Gesture{}, false
+}
+
+// gesture builds a gesture, hit-testing its start against the display regions
+func (r *Recognizer) gesture(kind GestureKind, start Event, end image.Point, held time.Duration) Gesture {
+	g := Gesture{Kind: kind, Start: start.Point, End: end, Duration: held}
+	for _, region := range it8951.Regions() {
+		if start.Point.In(region.Bounds) {
+			g.Region = region.Name
+			break
+		}
+	}
+	return g
+}
+
+// distance returns the largest coordinate difference between two points
+func distance(a, b image.Point) int {
+	d := a.Sub(b)
+	return max(abs(d.X), abs(d.Y))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
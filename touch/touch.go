@@ -0,0 +1,79 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package touch reads the capacitive touch overlays fitted to many IT8951
+// panels (GT911, FT5x06...) through the Linux input subsystem, whose kernel
+// drivers (goodix, edt-ft5x06) expose them as /dev/input/event* devices.
+// Touch points are reported in the logical coordinates of the display (see
+// it8951.SetOrientation), like the images drawn on it.
+package touch
+
+import (
+	"image"
+	"time"
+
+	"github.com/peergum/IT8951-go"
+)
+
+// Phase is the stage of a touch
+type Phase uint8
+
+// Phases
+const (
+	Down Phase = iota // finger put on the panel
+	Move              // finger moved
+	Up                // finger lifted, Point is the last position
+)
+
+// Event is a touch event
+type Event struct {
+	Slot  int         // contact number, for multi-touch panels
+	Phase Phase       // stage of the touch
+	Point image.Point // in logical display coordinates
+	Time  time.Time
+}
+
+// Calibration maps the touch coordinates onto the panel
+type Calibration struct {
+	Width, Height int  // range of the touch coordinates, 0 when equal to the panel size
+	SwapXY        bool // touch X is along the panel height
+	InvertX       bool // touch X grows right to left on the panel (after swapping)
+	InvertY       bool // touch Y grows bottom to top on the panel (after swapping)
+}
+
+// toLogical maps raw touch coordinates to logical display coordinates
+func (c Calibration) toLogical(x, y int, panel image.Rectangle) image.Point {
+	if c.SwapXY {
+		x, y = y, x
+	}
+	w, h := panel.Dx(), panel.Dy()
+	if c.Width > 0 {
+		x = x * w / c.Width
+	}
+	if c.Height > 0 {
+		y = y * h / c.Height
+	}
+	if c.InvertX {
+		x = w - 1 - x
+	}
+	if c.InvertY {
+		y = h - 1 - y
+	}
+	x, y = min(max(x, 0), w-1), min(max(y, 0), h-1)
+	return it8951.CurrentOrientation().FromPanel(image.Pt(x, y), panel)
+}
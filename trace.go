@@ -0,0 +1,77 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+)
+
+// transaction tracer
+type tracer struct {
+	w     *csv.Writer
+	start time.Time
+}
+
+var (
+	trace *tracer
+)
+
+// EnableTrace writes every bus transaction to w as CSV lines with a time in
+// seconds since the trace started, an event and a hexadecimal value:
+//
+//	time,event,value
+//	0.000012,cs,0
+//	0.000015,preamble,6000
+//	0.000021,command,0302
+//	0.000030,cs,1
+//
+// Events are cs (0 when the controller is selected, 1 when released),
+// preamble, command, write and read (data words) and burst-write and
+// burst-read (word count of a bulk transfer). Times come from the host clock,
+// so lining a trace up with a logic analyzer capture only requires matching
+// the first CS falling edge. A nil w disables tracing.
+func EnableTrace(w io.Writer) {
+	if w == nil {
+		trace = nil
+		return
+	}
+	trace = &tracer{w: csv.NewWriter(w), start: time.Now()}
+	trace.w.Write([]string{"time", "event", "value"})
+}
+
+// FlushTrace flushes buffered trace lines
+func FlushTrace() error {
+	if trace == nil {
+		return nil
+	}
+	trace.w.Flush()
+	return trace.w.Error()
+}
+
+// traceEvent logs an event if tracing is enabled
+func traceEvent(event string, value int) {
+	if trace == nil {
+		return
+	}
+	t := time.Since(trace.start).Seconds()
+	trace.w.Write([]string{fmt.Sprintf("%.6f", t), event, fmt.Sprintf("%04x", value)})
+}
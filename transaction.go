@@ -0,0 +1,73 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "sync"
+
+// busLock is held for the duration of a transaction: a command with its
+// parameters and data phase, or a sequence of commands which must not be
+// interleaved with others (e.g. setting the target address, then loading an
+// image). It makes the functions of the package safe for concurrent use, each
+// call going through as a whole; sequences of calls still need Display.Do
+// (or Display.Submit) to run together.
+var (
+	busLock  sync.Mutex
+	wakeLock sync.Mutex // held while waking up, see wake
+)
+
+// isPowerCommand tells whether command changes the power state, and may be
+// sent whatever the current one
+func isPowerCommand(command Command) bool {
+	switch command {
+	case TCONSysRun, TCONStandby, TCONSleep:
+		return true
+	}
+	return false
+}
+
+// transaction runs fn holding the bus lock, command being the first command
+// it sends. The controller is woken up first if needed, outside of the lock
+// since waking up takes transactions of its own.
+func transaction(command Command, fn func()) error {
+	busLock.Lock()
+	for power != PowerRun && !isPowerCommand(command) {
+		busLock.Unlock()
+		if err := wake(); err != nil {
+			return err
+		}
+		busLock.Lock()
+	}
+	defer busLock.Unlock()
+	fn()
+	return busErr
+}
+
+// wake wakes the controller up for transactions, once when several of them
+// need it together
+func wake() error {
+	wakeLock.Lock()
+	defer wakeLock.Unlock()
+	busLock.Lock()
+	running := power == PowerRun
+	busLock.Unlock()
+	if running {
+		return nil
+	}
+	return Wake()
+}
@@ -0,0 +1,156 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "time"
+
+// maxChunkSize is the size of the controller FIFO, in bytes
+const maxChunkSize = 2048
+
+// chunkSizes are the transfer sizes compared when tuning, in bytes
+var chunkSizes = []int{2, 64, 256, 1024, maxChunkSize}
+
+// tuneWords is the size of the sample written when tuning, in words
+const tuneWords = 4096
+
+// ChunkAuto makes Init tune the transfer size (see Config.ChunkSize)
+const ChunkAuto = -1
+
+// TransferStats reports the SPI transfer settings and counters
+type TransferStats struct {
+	ChunkSize    int     // bytes sent per SPI transfer in bulk writes
+	Throughput   float64 // bulk write speed measured at ChunkSize, in bytes/s (0 when not tuned)
+	WordsWritten uint64  // data words written
+	WordsRead    uint64  // data words read
+
+	ReadyLatency  time.Duration // median wait for the ready line measured at Init (0 when not tuned or never waited for)
+	ReadySpin     time.Duration // time the ready line is read in a loop before sleeping between reads
+	ReadyInterval time.Duration // sleep between two reads of the ready line after ReadySpin
+	ReadyWait     ReadyWait     // wait chosen at Init with ReadyAuto
+
+	ProtocolViolations uint64 // violations logged by the protocol audit (see WithAudit)
+}
+
+var (
+	stats = TransferStats{ChunkSize: maxChunkSize, ReadyInterval: defaultReadyInterval}
+	tuned *TransferStats // chunk size and throughput tuned by the first Init with ChunkAuto
+)
+
+// Stats returns the transfer statistics
+func Stats() TransferStats {
+	return stats
+}
+
+// setChunkSize sets the bulk transfer size, rounded to whole words and
+// limited to the controller FIFO size
+func setChunkSize(size int) {
+	size = min(max(size&^1, 2), maxChunkSize)
+	stats.ChunkSize = size
+}
+
+// writeWords sends words in transfers of stats.ChunkSize bytes, waiting for
+// the controller to be ready before each of them
+func writeWords(words DataBuffer) {
+	perChunk := stats.ChunkSize / 2
+	chunk := make([]byte, 2*min(perChunk, len(words)))
+	for start := 0; start < len(words); start += perChunk {
+		part := words[start:min(start+perChunk, len(words))]
+		data := chunk[:2*len(part)]
+		putWords(data, part)
+		waitReady()
+		bus.Transmit(data...)
+	}
+	stats.WordsWritten += uint64(len(words))
+}
+
+// writeBytes sends data as is in transfers of stats.ChunkSize bytes, waiting
+// for the controller to be ready before each of them
+func writeBytes(data []byte) {
+	for start := 0; start < len(data); start += stats.ChunkSize {
+		waitReady()
+		bus.Transmit(data[start:min(start+stats.ChunkSize, len(data))]...)
+	}
+	stats.WordsWritten += uint64(len(data) / 2)
+}
+
+// readWords fills words in transfers of stats.ChunkSize bytes, waiting for
+// the controller to be ready before each of them
+func readWords(words DataBuffer) {
+	perChunk := stats.ChunkSize / 2
+	for start := 0; start < len(words); start += perChunk {
+		part := words[start:min(start+perChunk, len(words))]
+		waitReady()
+		data := bus.Receive(2 * len(part))
+		for i := range part {
+			part[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		}
+	}
+}
+
+// tuneChunkSize measures the bulk write speed for every chunk size and keeps
+// the fastest one. The sample is written to the controller memory at address
+// after reading it back, so memory content is left unchanged.
+func tuneChunkSize(address uint32) {
+	Debug("Tuning SPI transfer size at %08x", address)
+	sample := make(DataBuffer, tuneWords)
+	transaction(TCONMemBstRdT, func() {
+		memBurstRead(address, sample)
+	})
+	best, bestRate := stats.ChunkSize, 0.0
+	for _, size := range chunkSizes {
+		setChunkSize(size)
+		start := time.Now()
+		transaction(TCONMemBstWr, func() {
+			memBurstWrite(address, sample)
+		})
+		rate := float64(2*len(sample)) / time.Since(start).Seconds()
+		Debug("%d bytes per transfer: %.0f bytes/s", size, rate)
+		if rate > bestRate {
+			best, bestRate = size, rate
+		}
+	}
+	setChunkSize(best)
+	stats.Throughput = bestRate
+	Debug("Using %d bytes per transfer", best)
+}
+
+// applyChunkSize sets the configured transfer size, the FIFO size when not
+// set. With ChunkAuto, the size is tuned by the first Init of the process, in
+// the last spare frame of the controller memory so that the image buffer is
+// not touched; later Inits reuse it. It must be called once the frame
+// capacity is known (see applyMemorySize).
+func applyChunkSize(devInfo *DevInfo) {
+	stats.Throughput = 0
+	switch {
+	case config.ChunkSize == ChunkAuto && tuned != nil:
+		setChunkSize(tuned.ChunkSize)
+		stats.Throughput = tuned.Throughput
+	case config.ChunkSize == ChunkAuto && frameCapacity >= 2:
+		end := devInfo.TargetAddress() + uint32(frameCapacity)*devInfo.frameSize()
+		tuneChunkSize(end - 2*tuneWords)
+		tuned = &TransferStats{ChunkSize: stats.ChunkSize, Throughput: stats.Throughput}
+	case config.ChunkSize == ChunkAuto:
+		Debug("No spare frame to tune the SPI transfer size in")
+		setChunkSize(maxChunkSize)
+	case config.ChunkSize == 0:
+		setChunkSize(maxChunkSize)
+	default:
+		setChunkSize(config.ChunkSize)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import "image"
+
+// Mirror flips images along an axis
+type Mirror uint8
+
+// Mirrors
+const (
+	NoMirror         Mirror = iota
+	MirrorHorizontal        // left and right swapped
+	MirrorVertical          // top and bottom swapped
+)
+
+// TransformBuffer mirrors then rotates clockwise a packed buffer (see
+// PackImage) holding an image of the given size at bpp (1, 2, 4 or 8). The
+// transform runs on the host, so that loads can always use Rotate0 whatever
+// the controller makes of its rotation modes. It returns the new buffer and
+// its size, width and height being swapped by 90 and 270 rotations.
+func TransformBuffer(buffer DataBuffer, size image.Point, bpp int, rotation Rotate, mirror Mirror) (DataBuffer, image.Point) {
+	if rotation == Rotate0 && mirror == NoMirror {
+		return buffer, size
+	}
+	w, h := size.X, size.Y
+	out := size
+	if rotation == Rotate90 || rotation == Rotate270 {
+		out = image.Pt(h, w)
+	}
+	srcStride := GetWidthInWords(w, bpp)
+	dstStride := GetWidthInWords(out.X, bpp)
+	transformed := make(DataBuffer, dstStride*out.Y)
+	for y := 0; y < h; y++ {
+		row := buffer[y*srcStride : (y+1)*srcStride]
+		for x := 0; x < w; x++ {
+			mx, my := x, y
+			switch mirror {
+			case MirrorHorizontal:
+				mx = w - 1 - x
+			case MirrorVertical:
+				my = h - 1 - y
+			}
+			p := rotatePoint(mx, my, size, rotation)
+			transformed[p.Y*dstStride:(p.Y+1)*dstStride].SetPixel(p.X, bpp, row.Pixel(x, bpp))
+		}
+	}
+	return transformed, out
+}
+
+// rotatePoint maps a point of an image of the given size rotated clockwise
+func rotatePoint(x, y int, size image.Point, rotation Rotate) image.Point {
+	w, h := size.X, size.Y
+	switch rotation {
+	case Rotate90:
+		return image.Pt(h-1-y, x)
+	case Rotate180:
+		return image.Pt(w-1-x, h-1-y)
+	case Rotate270:
+		return image.Pt(y, w-1-x)
+	}
+	return image.Pt(x, y)
+}
+
+// rotateGray rotates an image clockwise, its top left corner staying in place
+func rotateGray(src *image.Gray, rotation Rotate) *image.Gray {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	size := image.Pt(w, h)
+	if rotation == Rotate90 || rotation == Rotate270 {
+		size = image.Pt(h, w)
+	}
+	dst := image.NewGray(image.Rectangle{Min: src.Rect.Min, Max: src.Rect.Min.Add(size)})
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := rotatePoint(x, y, src.Rect.Size(), rotation)
+			dst.Pix[p.Y*dst.Stride+p.X] = src.Pix[y*src.Stride+x]
+		}
+	}
+	return dst
+}
+
+// rotateLoad rotates a buffer covering an area given in coordinates rotated
+// clockwise from the panel ones, as the controller rotation modes take them,
+// returning the buffer and area to load with Rotate0
+func rotateLoad(buffer DataBuffer, area image.Rectangle, bpp int, rotation Rotate) (DataBuffer, image.Rectangle) {
+	if rotation == Rotate0 {
+		return buffer, area
+	}
+	buffer, _ = TransformBuffer(buffer, area.Size(), bpp, rotation, NoMirror)
+	return buffer, rotatedArea(area, rotation)
+}
+
+// rotatedArea maps an area in coordinates rotated clockwise from the panel
+// ones to the panel
+func rotatedArea(area image.Rectangle, rotation Rotate) image.Rectangle {
+	if rotation == Rotate0 {
+		return area
+	}
+	return Orientation(rotation).ToPanelRect(area, DeviceInfo().Bounds())
+}
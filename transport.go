@@ -0,0 +1,201 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"time"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+// Transport gives access to the controller host interface: the SPI bus and
+// the chip select, reset and ready (HRDY) lines. The default one uses go-rpio
+// on a Raspberry Pi; others (periph.io, Linux spidev and gpiod, a mock for
+// tests...) can be given to Init or Attach with WithTransport. PWMLight, the
+// Supervisor power pin and the button and sensor packages still use go-rpio.
+type Transport interface {
+	// Open sets up the bus and lines
+	Open() error
+	// Close releases them
+	Close() error
+	// Transmit sends bytes on the SPI bus
+	Transmit(data ...byte)
+	// Receive reads n bytes from the SPI bus
+	Receive(n int) []byte
+	// Select drives the chip select line, active when selected is true
+	Select(selected bool)
+	// Reset drives the reset line, active when asserted is true
+	Reset(asserted bool)
+	// Ready reads the ready line, true when the controller can take a transfer
+	Ready() bool
+}
+
+// ReadyWaiter is implemented by transports which can sleep until the ready
+// line rises, used instead of polling it when ReadyWait is ReadyEdge
+type ReadyWaiter interface {
+	// WaitReady waits for the ready line to be high, for at most timeout
+	// (forever when 0), and tells whether it is
+	WaitReady(timeout time.Duration) bool
+}
+
+// ReadyWait is how transfers wait for the controller ready line
+type ReadyWait uint8
+
+// Ready line wait strategies
+const (
+	ReadyPoll ReadyWait = iota // read the line in a loop, at the interval tuned at Init (see Stats)
+	ReadyEdge                  // sleep until it rises, with a ReadyWaiter transport (polling otherwise)
+	ReadyAuto                  // ReadyEdge when the waits measured at Init are long enough, ReadyPoll otherwise
+)
+
+// edgeWaiter waits for the rising edges of a GPIO line (see openEdge)
+type edgeWaiter interface {
+	wait(timeout time.Duration) bool
+	close() error
+}
+
+// WithTransport sets the transport used to talk to the controller
+func WithTransport(transport Transport) Option {
+	return func(c *Config) {
+		c.Transport = transport
+	}
+}
+
+// RPIOConfig sets the pins and SPI bus of the go-rpio transport
+type RPIOConfig struct {
+	ResetPin   int         // BCM GPIO of the reset line
+	CSPin      int         // BCM GPIO of the chip select line, driven by the driver
+	BusyPin    int         // BCM GPIO of the ready (HRDY) line
+	SPI        rpio.SpiDev // SPI bus
+	ChipSelect uint8       // hardware chip select of the bus (CE0, CE1...)
+	Speed      int         // SPI clock, in Hz
+}
+
+// DefaultRPIOConfig returns the Waveshare HAT wiring: SPI0 at 24MHz, CE0 and
+// the EpdRstPin, EpdCsPin and EpdBusyPin GPIOs
+func DefaultRPIOConfig() RPIOConfig {
+	return RPIOConfig{
+		ResetPin:   EpdRstPin,
+		CSPin:      EpdCsPin,
+		BusyPin:    EpdBusyPin,
+		SPI:        rpio.Spi0,
+		ChipSelect: 0,
+		Speed:      24000000, // 24MHz
+	}
+}
+
+// RPIO returns a go-rpio transport using the given pins and SPI bus, e.g.
+// for HATs wired to other pins or several controllers sharing a bus:
+//
+//	wiring := it8951.DefaultRPIOConfig()
+//	wiring.CSPin, wiring.ChipSelect = 7, 1 // CE1
+//	devInfo, err := it8951.Init(vcom, it8951.WithTransport(it8951.RPIO(wiring)))
+func RPIO(wiring RPIOConfig) Transport {
+	return &rpioTransport{wiring: wiring}
+}
+
+// rpioTransport is the go-rpio transport
+type rpioTransport struct {
+	wiring   RPIOConfig
+	rstPin   rpio.Pin
+	csPin    rpio.Pin
+	readyPin rpio.Pin
+	edge     edgeWaiter // set up on the first WaitReady
+	noEdge   bool       // edge detection is not available
+}
+
+func (t *rpioTransport) Open() error {
+	if err := rpio.Open(); err != nil {
+		return err
+	}
+
+	Debug("Initializing SPI")
+	if err := rpio.SpiBegin(t.wiring.SPI); err != nil {
+		return err
+	}
+	rpio.SpiChipSelect(t.wiring.ChipSelect)
+	rpio.SpiSpeed(t.wiring.Speed)
+	rpio.SpiMode(0, 0)
+
+	Debug("Initializing GPIO pins")
+	t.rstPin = rpio.Pin(t.wiring.ResetPin)
+	t.csPin = rpio.Pin(t.wiring.CSPin)
+	t.readyPin = rpio.Pin(t.wiring.BusyPin)
+	t.rstPin.Output()
+	t.csPin.Output()
+	t.readyPin.Input()
+	return nil
+}
+
+func (t *rpioTransport) Close() error {
+	if t.edge != nil {
+		t.edge.close()
+		t.edge = nil
+	}
+	t.csPin.Low()
+	t.rstPin.Low()
+	rpio.SpiEnd(t.wiring.SPI)
+	return rpio.Close()
+}
+
+func (t *rpioTransport) Transmit(data ...byte) {
+	rpio.SpiTransmit(data...)
+}
+
+func (t *rpioTransport) Receive(n int) []byte {
+	return rpio.SpiReceive(n)
+}
+
+func (t *rpioTransport) Select(selected bool) {
+	if selected {
+		t.csPin.Low()
+	} else {
+		t.csPin.High()
+	}
+}
+
+func (t *rpioTransport) Reset(asserted bool) {
+	if asserted {
+		t.rstPin.Low()
+	} else {
+		t.rstPin.High()
+	}
+}
+
+func (t *rpioTransport) Ready() bool {
+	return t.readyPin.Read() == rpio.High
+}
+
+// WaitReady waits for a rising edge of the ready line through the kernel
+// GPIO interrupt, falling back to polling when edge detection is not
+// available (e.g. the sysfs GPIO interface is disabled)
+func (t *rpioTransport) WaitReady(timeout time.Duration) bool {
+	if t.edge == nil && !t.noEdge {
+		edge, err := openEdge(t.wiring.BusyPin)
+		if err != nil {
+			Debug("No edge detection on the ready line, polling it: %v", err)
+			t.noEdge = true
+		}
+		t.edge = edge
+	}
+	if t.edge == nil {
+		return pollReady(t, timeout)
+	}
+	return t.edge.wait(timeout)
+}
@@ -0,0 +1,92 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+// UpdateParam is a set of bits of the 32 bit update parameter registers (UP0SR, UP1SR)
+type UpdateParam uint32
+
+// Update parameter bits
+//
+// Only the bits described in the IT8951 programming guide are named here, other
+// bits of UP0SR/UP1SR can be used as UpdateParam(1 << n).
+const (
+	BitmapMode UpdateParam = 1 << 18 // UP1SR: 1bpp bitmap mode, pixels use the BGVR colors
+)
+
+// ReadRegister32 reads a 32 bit register (low word at address, high word at address+2)
+func ReadRegister32(address Address) (value uint32) {
+	transaction(TCONRegRd, func() {
+		value = uint32(readRegister(address+2))<<16 | uint32(readRegister(address))
+	})
+	return
+}
+
+// WriteRegister32 writes a 32 bit register (low word at address, high word at address+2)
+func WriteRegister32(address Address, value uint32) {
+	transaction(TCONRegWr, func() {
+		writeRegister(address, uint16(value&0xffff))
+		writeRegister(address+2, uint16(value>>16))
+	})
+}
+
+// ReadUpdateParams reads one of the update parameter registers (UP0SR or UP1SR)
+func ReadUpdateParams(register Address) UpdateParam {
+	params := UpdateParam(ReadRegister32(register))
+	Debug("Update parameters %04x = %08x", register, uint32(params))
+	return params
+}
+
+// WriteUpdateParams writes one of the update parameter registers (UP0SR or UP1SR)
+func WriteUpdateParams(register Address, params UpdateParam) {
+	Debug("Writing update parameters %04x = %08x", register, uint32(params))
+	WriteRegister32(register, uint32(params))
+}
+
+// SetUpdateParams sets (on) or clears (!on) bits of an update parameter register,
+// leaving the others unchanged. Only the 16 bit halves holding the bits are accessed.
+func SetUpdateParams(register Address, params UpdateParam, on bool) {
+	transaction(TCONRegRd, func() {
+		for half := Address(0); half < 4; half += 2 {
+			mask := uint16(uint32(params) >> (8 * half))
+			if mask == 0 {
+				continue
+			}
+			var bits uint16
+			if on {
+				bits = mask
+			}
+			updateRegister(register+half, mask, bits)
+		}
+	})
+}
+
+// SetBitmapColors sets the gray values displayed for 0 and 1 bits in bitmap (1bpp) mode (BGVR)
+func SetBitmapColors(off, on uint8) {
+	WriteRegister(BGVR, uint16(off)<<8|uint16(on))
+}
+
+// ReadAlphaFillValue reads the LUT0 alpha blend and fill rectangle value register (LUT0ABFRV)
+func ReadAlphaFillValue() uint16 {
+	return ReadRegister(LUT0ABFRV)
+}
+
+// WriteAlphaFillValue writes the LUT0 alpha blend and fill rectangle value register (LUT0ABFRV)
+func WriteAlphaFillValue(value uint16) {
+	WriteRegister(LUT0ABFRV, value)
+}
@@ -0,0 +1,131 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// VCOM is a panel common voltage, as the magnitude in mV of a negative
+// voltage: 1580 is -1.58V. Panels are printed with their own value, and
+// driving them far from it can damage them.
+type VCOM uint16
+
+const (
+	// MinVCOM and MaxVCOM bound the values accepted as VCOM. The IT8951
+	// drives VCOM between 0 and -5V, panels needing a few hundred mV at
+	// least.
+	MinVCOM VCOM = 200
+	MaxVCOM VCOM = 5000
+)
+
+// VCOMFromVolts returns the VCOM for a voltage, e.g. -1.58, the sign being
+// ignored since VCOM is always negative
+func VCOMFromVolts(volts float64) (VCOM, error) {
+	return VCOMFromMillivolts(int(math.Round(math.Abs(volts) * 1000)))
+}
+
+// VCOMFromMillivolts returns the VCOM for a voltage in mV, e.g. 1580 or
+// -1580, the sign being ignored since VCOM is always negative
+func VCOMFromMillivolts(millivolts int) (VCOM, error) {
+	if millivolts < 0 {
+		millivolts = -millivolts
+	}
+	if millivolts > int(MaxVCOM) {
+		return 0, fmt.Errorf("%w: VCOM -%dmV out of range", ErrInvalidConfig, millivolts)
+	}
+	vcom := VCOM(millivolts)
+	return vcom, vcom.Validate()
+}
+
+// ParseVCOM parses a VCOM given in V (e.g. -1.58) or mV (1580), the sign
+// being ignored
+func ParseVCOM(value string) (VCOM, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("it8951: bad VCOM %q", value)
+	}
+	if math.Abs(number) < 10 {
+		return VCOMFromVolts(number)
+	}
+	return VCOMFromMillivolts(int(math.Round(number)))
+}
+
+// Validate checks the VCOM is within MinVCOM and MaxVCOM
+func (vcom VCOM) Validate() error {
+	if vcom < MinVCOM || vcom > MaxVCOM {
+		return fmt.Errorf("%w: VCOM %v out of range (%v to %v)", ErrInvalidConfig, vcom, MinVCOM, MaxVCOM)
+	}
+	return nil
+}
+
+// Volts returns the VCOM voltage, negative
+func (vcom VCOM) Volts() float64 {
+	return -float64(vcom) / 1000
+}
+
+// String returns the VCOM voltage, e.g. "-1.58V"
+func (vcom VCOM) String() string {
+	return fmt.Sprintf("%.2fV", vcom.Volts())
+}
+
+// PanelVCOM reads the VCOM the controller currently drives
+func PanelVCOM() (VCOM, error) {
+	var vcom uint16
+	err := transaction(UserCmdVCOM, func() {
+		WriteCommand(UserCmdVCOM)
+		WriteData(uint16(GetVCOM))
+		vcom = ReadData()
+	})
+	Debug("Read VCOM = %d", vcom)
+	return VCOM(vcom), err
+}
+
+// SetPanelVCOM validates vcom and makes the controller drive it, reading it
+// back to check it was taken. Nothing is written when the current VCOM is
+// already within the configured tolerance (see WithVCOMTolerance), which
+// spares the controller settings stored in flash.
+func SetPanelVCOM(vcom VCOM) error {
+	if err := vcom.Validate(); err != nil {
+		return err
+	}
+	current, err := PanelVCOM()
+	if err != nil {
+		return err
+	}
+	if diff := int(current) - int(vcom); max(diff, -diff) <= int(config.VCOMTolerance) {
+		Debug("VCOM %v within %dmV of %v, not written", current, config.VCOMTolerance, vcom)
+		vcomSetting = uint16(current)
+		return nil
+	}
+	if err := WriteVCOM(uint16(vcom)); err != nil {
+		return err
+	}
+	vcomSetting = uint16(vcom)
+	if current, err = PanelVCOM(); err != nil {
+		return err
+	}
+	if current != vcom {
+		return fmt.Errorf("%w: VCOM is %v, not %v", ErrVerify, current, vcom)
+	}
+	Debug("VCOM = %v", current)
+	return nil
+}
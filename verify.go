@@ -0,0 +1,170 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// verifyChunkWords is the size of the chunks checked by verified uploads,
+// matching the 2KB controller FIFO
+const verifyChunkWords = 1024
+
+// memBurstWrite writes data to the controller memory at address using a
+// memory burst write. Data goes to memory as is (8bpp, no conversion).
+// It must be called within a transaction, as memBurstRead.
+func memBurstWrite(address uint32, data DataBuffer) {
+	Trace("Burst write %d words at %08x", len(data), address)
+	WriteCommand(TCONMemBstWr)
+	DataBuffer{
+		uint16(address), uint16(address >> 16),
+		uint16(len(data)), uint16(len(data) >> 16),
+	}.WriteBuffer()
+	data.WriteBuffer()
+	WriteCommand(TCONMemBstEnd)
+}
+
+// memBurstRead fills data from the controller memory at address using a
+// memory burst read
+func memBurstRead(address uint32, data DataBuffer) {
+	Trace("Burst read %d words at %08x", len(data), address)
+	WriteCommand(TCONMemBstRdT)
+	DataBuffer{
+		uint16(address), uint16(address >> 16),
+		uint16(len(data)), uint16(len(data) >> 16),
+	}.WriteBuffer()
+	WriteCommand(TCONMemBstRdS)
+	data.ReadBuffer()
+	WriteCommand(TCONMemBstEnd)
+}
+
+// MemBurstWrite writes bytes to the controller memory at address, as is,
+// e.g. to stage frames out of the displayed image buffer (see
+// DisplayRectBuffer). An odd trailing byte is merged with the byte following
+// it in memory, which is read first.
+func MemBurstWrite(address uint32, data []byte) error {
+	words := make(DataBuffer, (len(data)+1)/2)
+	for i := range words {
+		words[i] = uint16(data[2*i])
+		if 2*i+1 < len(data) {
+			words[i] |= uint16(data[2*i+1]) << 8
+		}
+	}
+	return transaction(TCONMemBstWr, func() {
+		if len(data)%2 != 0 {
+			last := DataBuffer{0}
+			memBurstRead(address+uint32(len(data)-1), last)
+			words[len(words)-1] |= last[0] & 0xff00
+		}
+		memBurstWrite(address, words)
+	})
+}
+
+// MemBurstRead reads n bytes of the controller memory at address, e.g. the
+// image buffer for debugging (see Snapshot)
+func MemBurstRead(address uint32, n int) ([]byte, error) {
+	words := make(DataBuffer, (n+1)/2)
+	err := transaction(TCONMemBstRdT, func() {
+		memBurstRead(address, words)
+	})
+	data := make([]byte, 2*len(words))
+	for i, word := range words {
+		data[2*i], data[2*i+1] = byte(word), byte(word>>8)
+	}
+	return data[:n], err
+}
+
+// bufferCRC returns the CRC32 of a buffer, in memory (little endian) order
+func bufferCRC(data DataBuffer) uint32 {
+	bytes := make([]byte, 2*len(data))
+	for i, d := range data {
+		bytes[2*i] = byte(d)
+		bytes[2*i+1] = byte(d >> 8)
+	}
+	return crc32.ChecksumIEEE(bytes)
+}
+
+// writeChunkVerified writes a chunk and reads it back until the CRCs match,
+// following policy
+func writeChunkVerified(address uint32, chunk DataBuffer, policy RetryPolicy) error {
+	want := bufferCRC(chunk)
+	readBack := make(DataBuffer, len(chunk))
+	return policy.Do(func() error {
+		transaction(TCONMemBstWr, func() {
+			memBurstWrite(address, chunk)
+			memBurstRead(address, readBack)
+		})
+		if bufferCRC(readBack) != want {
+			return fmt.Errorf("%w: CRC mismatch at %08x", ErrVerify, address)
+		}
+		return nil
+	})
+}
+
+// WriteAreaVerified uploads the source buffer to area of the image buffer,
+// stride being the image buffer width in pixels (usually the panel width).
+// The area is written row by row with memory bursts in chunks of at most 2KB,
+// each chunk being read back and checked against its CRC32. Failed chunks are
+// written again up to retries times, waiting as set by the configured
+// RetryPolicy.
+//
+// This is much slower than HostAreaPackedPixelWrite but survives noisy links,
+// such as long cables between the host and the controller. Memory bursts
+// bypass the memory converter: 2bpp and 4bpp pixels are expanded to 8bpp on the
+// host, rotation is not supported and X and W must be even (in bytes for 1bpp
+// areas, which are already divided by 8 as for HostAreaPackedPixelWrite).
+func (imageInfo LoadImgInfo) WriteAreaVerified(area AreaImgInfo, bpp int, stride int, retries int) error {
+	Debug("WriteAreaVerified")
+	if imageInfo.Rotate != Rotate0 {
+		return fmt.Errorf("it8951: verified upload does not support rotation")
+	}
+	if area.X%2 != 0 || area.W%2 != 0 {
+		return fmt.Errorf("it8951: verified upload needs an even X and width")
+	}
+	if bpp == 1 { // 1bpp areas are loaded as 8bpp bytes
+		bpp = 8
+	}
+	width := int(area.W)
+	levels := 1 << bpp
+	policy := config.Retry
+	policy.MaxAttempts = retries + 1
+	var err error
+	imageInfo.SourceBufferAddr.Rows(width, bpp)(func(y int, row DataBuffer) bool {
+		if y >= int(area.H) {
+			return false
+		}
+		memRow := row
+		if bpp != 8 {
+			memRow = make(DataBuffer, width/2)
+			for x := 0; x < width; x++ {
+				memRow.SetPixel(x, 8, uint8(int(row.Pixel(x, bpp))*255/(levels-1)))
+			}
+		}
+		address := ImageAddress(imageInfo.TargetMemAddr, int(area.X), int(area.Y)+y, stride)
+		for start := 0; start < len(memRow); start += verifyChunkWords {
+			end := min(start+verifyChunkWords, len(memRow))
+			if err = writeChunkVerified(address+uint32(2*start), memRow[start:end], policy); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	return err
+}
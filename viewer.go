@@ -0,0 +1,373 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"fmt"
+	"image"
+	"slices"
+)
+
+// PageSource supplies the pages of a document, e.g. rendered from a PDF
+type PageSource interface {
+	// Pages returns the number of pages
+	Pages() int
+	// Page returns a page, numbered from 0
+	Page(n int) (image.Image, error)
+}
+
+// Zoom is the way a Viewer fits pages to the panel
+type Zoom uint8
+
+// Zooms
+const (
+	// ZoomFitPage shows whole pages
+	ZoomFitPage Zoom = iota
+	// ZoomFitWidth scales pages to the panel width, scrolling through
+	// their height
+	ZoomFitWidth
+)
+
+// ViewerAction is an action of a Viewer, bound to inputs with Bind
+type ViewerAction uint8
+
+// Viewer actions
+const (
+	NextPage ViewerAction = iota
+	PreviousPage
+	FirstPage
+	LastPage
+	ScrollDown // next screen of the page, or the next page
+	ScrollUp   // previous screen of the page, or the previous page
+	FitPage
+	FitWidth
+	ToggleZoom
+)
+
+// viewerView is what a Viewer shows: a page, zoomed and scrolled
+type viewerView struct {
+	page   int
+	zoom   Zoom
+	scroll int // pixels from the top of the zoomed page
+}
+
+// Viewer shows a document a page at a time, keeping the pages around the
+// current one pre-loaded in the slots of a buffer pool, so that page turns
+// only need a display command. Inputs, e.g. button actions or gesture names,
+// trigger actions through bindings:
+//
+//	pool, _ := it8951.NewBufferPool(4, 4)
+//	viewer := it8951.NewViewer(doc, pool)
+//	viewer.Bind("swipe-left", it8951.NextPage)
+//	viewer.Show()
+//	for event := range buttons {
+//		if event.Pressed {
+//			viewer.Handle(event.Action)
+//		}
+//	}
+//
+// A Viewer is not safe for concurrent use: workers sharing the controller
+// call it within Display.Do.
+type Viewer struct {
+	// Mode is the waveform of page changes (GC16Mode when 0)
+	Mode DisplayMode
+	// Profile, when set, prepares pages for display (see SourceProfile),
+	// e.g. the document one
+	Profile *SourceProfile
+	// Overlap is the part of the screen height kept in view when scrolling
+	// in ZoomFitWidth, in percent (10 when 0)
+	Overlap int
+
+	source   PageSource
+	pool     *BufferPool
+	view     viewerView
+	slots    []*viewerView       // view held by each slot, if any
+	pages    map[int]image.Image // pages around the current one
+	bindings map[string]ViewerAction
+}
+
+// NewViewer returns a viewer of the pages of source on the first page,
+// fitting whole pages, with the default bindings: "next", "previous",
+// "first", "last", "down", "up", "fit-page", "fit-width" and "zoom" (see
+// Bind). It needs 2 pool slots at least, one per page pre-loaded beyond the
+// current one.
+func NewViewer(source PageSource, pool *BufferPool) *Viewer {
+	return &Viewer{
+		source: source,
+		pool:   pool,
+		slots:  make([]*viewerView, pool.Slots()),
+		pages:  map[int]image.Image{},
+		bindings: map[string]ViewerAction{
+			"next":      NextPage,
+			"previous":  PreviousPage,
+			"first":     FirstPage,
+			"last":      LastPage,
+			"down":      ScrollDown,
+			"up":        ScrollUp,
+			"fit-page":  FitPage,
+			"fit-width": FitWidth,
+			"zoom":      ToggleZoom,
+		},
+	}
+}
+
+// Bind makes an input trigger an action, replacing its previous binding
+func (v *Viewer) Bind(input string, action ViewerAction) {
+	v.bindings[input] = action
+}
+
+// Handle runs the action bound to an input. Unbound inputs are ignored.
+func (v *Viewer) Handle(input string) error {
+	action, ok := v.bindings[input]
+	if !ok {
+		return nil
+	}
+	return v.Do(action)
+}
+
+// Page returns the current page, numbered from 0
+func (v *Viewer) Page() int {
+	return v.view.page
+}
+
+// Zoom returns the current zoom
+func (v *Viewer) Zoom() Zoom {
+	return v.view.zoom
+}
+
+// Show displays the current view
+func (v *Viewer) Show() error {
+	return v.show(v.view)
+}
+
+// GoTo displays the top of a page
+func (v *Viewer) GoTo(page int) error {
+	if page < 0 || page >= v.source.Pages() {
+		return fmt.Errorf("it8951: no page %d", page)
+	}
+	return v.show(viewerView{page: page, zoom: v.view.zoom})
+}
+
+// SetZoom displays the top of the current page with another zoom
+func (v *Viewer) SetZoom(zoom Zoom) error {
+	return v.show(viewerView{page: v.view.page, zoom: zoom})
+}
+
+// Do runs an action. Moving past the first or last page does nothing.
+func (v *Viewer) Do(action ViewerAction) error {
+	view := v.view
+	switch action {
+	case NextPage:
+		view = viewerView{page: view.page + 1, zoom: view.zoom}
+	case PreviousPage:
+		view = viewerView{page: view.page - 1, zoom: view.zoom}
+	case FirstPage:
+		view = viewerView{zoom: view.zoom}
+	case LastPage:
+		view = viewerView{page: v.source.Pages() - 1, zoom: view.zoom}
+	case ScrollDown:
+		view = v.next(view)
+	case ScrollUp:
+		view = v.previous(view)
+	case FitPage:
+		view = viewerView{page: view.page, zoom: ZoomFitPage}
+	case FitWidth:
+		view = viewerView{page: view.page, zoom: ZoomFitWidth}
+	case ToggleZoom:
+		view = viewerView{page: view.page, zoom: 1 - view.zoom}
+	default:
+		return fmt.Errorf("it8951: unknown viewer action %d", action)
+	}
+	if view.page < 0 || view.page >= v.source.Pages() {
+		return nil
+	}
+	return v.show(view)
+}
+
+// next returns the view after view: the next screen of the page, or the
+// top of the next page
+func (v *Viewer) next(view viewerView) viewerView {
+	if view.zoom == ZoomFitWidth {
+		if bottom, err := v.bottom(view.page); err == nil && view.scroll < bottom {
+			view.scroll = min(view.scroll+v.step(), bottom)
+			return view
+		}
+	}
+	return viewerView{page: view.page + 1, zoom: view.zoom}
+}
+
+// previous returns the view before view: the previous screen of the page,
+// or the bottom of the previous page
+func (v *Viewer) previous(view viewerView) viewerView {
+	if view.scroll > 0 {
+		view.scroll = max(view.scroll-v.step(), 0)
+		return view
+	}
+	previous := viewerView{page: view.page - 1, zoom: view.zoom}
+	if view.zoom == ZoomFitWidth && previous.page >= 0 {
+		previous.scroll, _ = v.bottom(previous.page)
+	}
+	return previous
+}
+
+// step returns the scroll distance of one screen
+func (v *Viewer) step() int {
+	overlap := v.Overlap
+	if overlap == 0 {
+		overlap = 10
+	}
+	return SafeArea().Dy() * (100 - overlap) / 100
+}
+
+// bottom returns the largest scroll of a page in ZoomFitWidth
+func (v *Viewer) bottom(page int) (int, error) {
+	img, err := v.page(page)
+	if err != nil {
+		return 0, err
+	}
+	area := SafeArea()
+	size := img.Bounds().Size()
+	if size.X == 0 {
+		return 0, nil
+	}
+	return max(0, size.Y*area.Dx()/size.X-area.Dy()), nil
+}
+
+// page returns a page, keeping those around the current one
+func (v *Viewer) page(n int) (image.Image, error) {
+	if img, ok := v.pages[n]; ok {
+		return img, nil
+	}
+	img, err := v.source.Page(n)
+	if err != nil {
+		return nil, err
+	}
+	for kept := range v.pages {
+		if kept < v.view.page-1 || kept > v.view.page+1 {
+			delete(v.pages, kept)
+		}
+	}
+	v.pages[n] = img
+	return img, nil
+}
+
+// render returns a view as shown on the panel, over the safe area
+func (v *Viewer) render(view viewerView) (image.Image, error) {
+	img, err := v.page(view.page)
+	if err != nil {
+		return nil, err
+	}
+	area := SafeArea()
+	within := area
+	if view.zoom == ZoomFitWidth {
+		size := img.Bounds().Size()
+		height := area.Dy()
+		if size.X > 0 {
+			height = max(height, size.Y*area.Dx()/size.X)
+		}
+		within = image.Rect(area.Min.X, area.Min.Y-view.scroll, area.Max.X, area.Min.Y-view.scroll+height)
+	}
+	frame := FitImage(img, within, FitInside)
+	if v.Profile != nil {
+		frame = v.Profile.Prepare(frame, frame.Rect.Intersect(area))
+	}
+	return frame.SubImage(area), nil
+}
+
+// show displays a view from the slot holding it, loading it first if none
+// does, then pre-loads the neighbouring views into the other slots while the
+// panel refreshes
+func (v *Viewer) show(view viewerView) error {
+	slot := v.slotOf(view)
+	if slot < 0 {
+		slot = v.freeSlot(view)
+		if err := v.load(slot, view); err != nil {
+			return err
+		}
+	}
+	mode := v.Mode
+	if mode == 0 {
+		mode = GC16Mode
+	}
+	if err := v.pool.Show(slot, mode); err != nil {
+		return err
+	}
+	v.view = view
+	neighbours := []viewerView{v.next(view), v.previous(view)}
+	for _, neighbour := range neighbours {
+		if neighbour.page < 0 || neighbour.page >= v.source.Pages() || v.slotOf(neighbour) >= 0 {
+			continue
+		}
+		slot := v.freeSlot(neighbour, append(neighbours, view)...)
+		if slot < 0 {
+			continue
+		}
+		if err := v.load(slot, neighbour); err != nil {
+			Debug("Prefetching page %d: %v", neighbour.page, err)
+		}
+	}
+	return nil
+}
+
+// load renders a view into a slot
+func (v *Viewer) load(slot int, view viewerView) error {
+	v.slots[slot] = nil
+	frame, err := v.render(view)
+	if err != nil {
+		return err
+	}
+	if err := v.pool.LoadSlot(slot, frame, orientation.LogicalBounds(DeviceInfo().Bounds())); err != nil {
+		return err
+	}
+	v.slots[slot] = &view
+	return nil
+}
+
+// slotOf returns the slot holding a view, or -1
+func (v *Viewer) slotOf(view viewerView) int {
+	for slot, held := range v.slots {
+		if held != nil && *held == view {
+			return slot
+		}
+	}
+	return -1
+}
+
+// freeSlot returns the slot to load a view into, other than the front one:
+// an empty one, or else the one holding the page furthest from it, unless
+// its view is to be kept. It returns -1 when there is none.
+func (v *Viewer) freeSlot(view viewerView, keep ...viewerView) int {
+	best, distance := -1, -1
+	for slot, held := range v.slots {
+		if slot == v.pool.Front() {
+			continue
+		}
+		if held == nil {
+			return slot
+		}
+		if slices.Contains(keep, *held) {
+			continue
+		}
+		d := max(held.page-view.page, view.page-held.page)
+		if d > distance {
+			best, distance = slot, d
+		}
+	}
+	return best
+}
@@ -0,0 +1,160 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"io/fs"
+)
+
+// wearSaveInterval is the number of refreshes between two automatic saves
+const wearSaveInterval = 100
+
+// WearReport holds the number of refreshes of every cell of a coarse grid
+// laid on the panel (in panel coordinates)
+type WearReport struct {
+	Cell       int        // cell size in pixels
+	Cols, Rows int        // grid size
+	Counts     [][]uint64 // Counts[row][col]
+}
+
+// wearTracker counts refreshes and persists them
+type wearTracker struct {
+	path    string
+	report  WearReport
+	pending int // refreshes not saved yet
+}
+
+var (
+	wear *wearTracker
+)
+
+// EnableWearTracking counts the refreshes of every cell of a grid of cell
+// pixels, persisted to the file at path (see SetStore), loaded if it exists,
+// every 100 refreshes and by SaveWear. This lets long lived installations
+// find the over-refreshed parts of the panel.
+func EnableWearTracking(path string, cell int) error {
+	bounds := DeviceInfo().Bounds()
+	report := WearReport{
+		Cell: cell,
+		Cols: (bounds.Dx() + cell - 1) / cell,
+		Rows: (bounds.Dy() + cell - 1) / cell,
+	}
+	data, err := store.Load(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		var saved WearReport
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return err
+		}
+		if saved.Cell == report.Cell && saved.Cols == report.Cols && saved.Rows == report.Rows {
+			report = saved
+		}
+	}
+	if report.Counts == nil {
+		report.Counts = make([][]uint64, report.Rows)
+		for row := range report.Counts {
+			report.Counts[row] = make([]uint64, report.Cols)
+		}
+	}
+	wear = &wearTracker{path: path, report: report}
+	return nil
+}
+
+// SaveWear saves the refresh counts
+func SaveWear() error {
+	if wear == nil {
+		return nil
+	}
+	data, err := json.Marshal(wear.report)
+	if err != nil {
+		return err
+	}
+	wear.pending = 0
+	return store.Save(wear.path, data)
+}
+
+// Wear returns the refresh counts, or an empty report when not tracking
+func Wear() WearReport {
+	if wear == nil {
+		return WearReport{}
+	}
+	report := wear.report
+	report.Counts = make([][]uint64, len(wear.report.Counts))
+	for row, counts := range wear.report.Counts {
+		report.Counts[row] = append([]uint64(nil), counts...)
+	}
+	return report
+}
+
+// countWear adds a refresh of a panel area
+func countWear(area image.Rectangle) {
+	if wear == nil {
+		return
+	}
+	cell := wear.report.Cell
+	area = area.Canon().Intersect(image.Rect(0, 0, wear.report.Cols*cell, wear.report.Rows*cell))
+	if area.Empty() {
+		return
+	}
+	for row := area.Min.Y / cell; row <= (area.Max.Y-1)/cell; row++ {
+		for col := area.Min.X / cell; col <= (area.Max.X-1)/cell; col++ {
+			wear.report.Counts[row][col]++
+		}
+	}
+	wear.pending++
+	if wear.pending >= wearSaveInterval {
+		if err := SaveWear(); err != nil {
+			Debug("Saving wear failed: %v", err)
+		}
+	}
+}
+
+// Max returns the highest cell count
+func (r WearReport) Max() uint64 {
+	var highest uint64
+	for _, counts := range r.Counts {
+		for _, count := range counts {
+			highest = max(highest, count)
+		}
+	}
+	return highest
+}
+
+// Heatmap returns the report as an image of one pixel per cell, the most
+// refreshed cells being black and cells never refreshed white
+func (r WearReport) Heatmap() *image.Gray {
+	heatmap := image.NewGray(image.Rect(0, 0, r.Cols, r.Rows))
+	highest := r.Max()
+	for row, counts := range r.Counts {
+		for col, count := range counts {
+			level := uint8(255)
+			if highest > 0 {
+				level = uint8(255 - count*255/highest)
+			}
+			heatmap.Pix[heatmap.PixOffset(col, row)] = level
+		}
+	}
+	return heatmap
+}
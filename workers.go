@@ -0,0 +1,293 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Display owns the background workers driving the panel (schedulers, button
+// and touch watchers, screensaver checks...), so they can all be stopped
+// together on shutdown:
+//
+//	display := it8951.NewDisplay()
+//	display.Go(func(ctx context.Context) error {
+//		return scheduler.Run(ctx, show)
+//	})
+//	...
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	err := display.Close(ctx)
+//
+// Workers must return when their context is canceled. Those accessing the
+// controller should do so through Do, or Submit, which serialize them.
+type Display struct {
+	// SleepDwell is the shortest frame dwell for which Play puts the
+	// controller to sleep (never when 0)
+	SleepDwell time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	lock   sync.Mutex // controller access
+	errs   []error
+	errsMu sync.Mutex
+	shadow *Shadow // last frame played
+
+	middlewares []Middleware
+	bpp         map[DisplayMode]int // see SetModeBpp
+
+	queue   submitQueue    // see Submit
+	quality *QualityPolicy // see AdaptQuality
+	domains []*Domain      // see AddDomain
+
+	policies *RefreshManager // see FollowPolicies
+}
+
+// submitQueue runs submitted functions one at a time. Submissions are kept
+// in an unbounded slice, so that submitting never waits for the worker.
+type submitQueue struct {
+	once    sync.Once
+	wake    chan struct{} // signals new submissions to the worker
+	mu      sync.Mutex
+	pending []submission  // submissions not started yet
+	busy    bool          // a submission is running
+	closing bool          // new submissions are refused, see drain
+	drained chan struct{} // closed once the queue is empty while draining
+	closed  bool          // worker returned
+}
+
+// submission is a function queued by Submit
+type submission struct {
+	fn   func() error
+	done chan error
+}
+
+// NewDisplay returns a Display with no worker
+func NewDisplay() *Display {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Display{ctx: ctx, cancel: cancel}
+}
+
+// Go starts a worker. Its error, if any, is returned by Close.
+func (d *Display) Go(worker func(ctx context.Context) error) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		if err := worker(d.ctx); err != nil && !errors.Is(err, context.Canceled) {
+			Debug("Worker failed: %v", err)
+			d.errsMu.Lock()
+			d.errs = append(d.errs, err)
+			d.errsMu.Unlock()
+		}
+	}()
+}
+
+// Do runs fn with exclusive access to the controller
+func (d *Display) Do(fn func() error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return fn()
+}
+
+// TryDo runs fn like Do when no one else accesses the controller, and
+// returns false without running it otherwise
+func (d *Display) TryDo(fn func() error) (bool, error) {
+	if !d.lock.TryLock() {
+		return false, nil
+	}
+	defer d.lock.Unlock()
+	return true, fn()
+}
+
+// Submit queues fn to run with exclusive access to the controller, after the
+// functions submitted before it, and returns at once. The returned channel
+// receives the error of fn once it ran, context.Canceled if Close was called
+// before, or the context of Close expired before it ran:
+//
+//	done := display.Submit(func() error {
+//		return it8951.DrawImage(img, 0, 0, 4, it8951.GC16Mode, it8951.Rotate0)
+//	})
+//	...
+//	if err := <-done; err != nil {
+//		...
+//	}
+func (d *Display) Submit(fn func() error) <-chan error {
+	return d.queue.submit(d, fn)
+}
+
+// Backlog returns the number of submitted functions waiting to run
+func (d *Display) Backlog() int {
+	return d.queue.backlog()
+}
+
+// submit queues fn to run through d.Do, starting the queue worker on first
+// use
+func (q *submitQueue) submit(d *Display, fn func() error) <-chan error {
+	q.once.Do(func() {
+		q.wake = make(chan struct{}, 1)
+		d.Go(func(ctx context.Context) error {
+			return q.run(ctx, d)
+		})
+	})
+	s := submission{fn: fn, done: make(chan error, 1)}
+	q.mu.Lock()
+	if q.closed || q.closing || d.ctx.Err() != nil {
+		q.mu.Unlock()
+		s.done <- context.Canceled
+		return s.done
+	}
+	q.pending = append(q.pending, s)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default: // worker already signaled
+	}
+	return s.done
+}
+
+// backlog returns the number of submissions not started yet
+func (q *submitQueue) backlog() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// next removes and returns the oldest submission not started yet, marking
+// the queue busy until finish
+func (q *submitQueue) next() (submission, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return submission{}, false
+	}
+	s := q.pending[0]
+	q.pending[0] = submission{}
+	q.pending = q.pending[1:]
+	q.busy = true
+	return s, true
+}
+
+// finish marks the submission returned by next as done, signaling drain
+// when it was the last one
+func (q *submitQueue) finish() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.busy = false
+	if len(q.pending) == 0 && q.drained != nil {
+		close(q.drained)
+		q.drained = nil
+	}
+}
+
+// drain refuses new submissions, then waits for those queued to run, or
+// for ctx to be done
+func (q *submitQueue) drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.closing = true
+	if len(q.pending) == 0 && !q.busy {
+		q.mu.Unlock()
+		return nil
+	}
+	if q.drained == nil {
+		q.drained = make(chan struct{})
+	}
+	drained := q.drained
+	q.mu.Unlock()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run is the worker running submitted functions, one at a time. Those still
+// pending when ctx is canceled receive the context error.
+func (q *submitQueue) run(ctx context.Context, d *Display) error {
+	for {
+		if ctx.Err() != nil {
+			break
+		}
+		if s, ok := q.next(); ok {
+			s.done <- d.Do(s.fn)
+			q.finish()
+			continue
+		}
+		select {
+		case <-q.wake:
+		case <-ctx.Done():
+		}
+	}
+	q.mu.Lock()
+	pending := q.pending
+	q.pending, q.closed = nil, true
+	q.mu.Unlock()
+	for _, s := range pending {
+		s.done <- ctx.Err()
+	}
+	return ctx.Err()
+}
+
+// Close refuses new submissions and runs those already queued (see Submit,
+// Domain.Submit), then stops the workers and waits for them to return, then
+// waits for the refresh in progress and closes peripherals (see Exit). If
+// ctx expires first, the context error is returned, submissions not started
+// get it too, and peripherals are left open, since some workers may still
+// use them.
+func (d *Display) Close(ctx context.Context) error {
+	queues := []*submitQueue{&d.queue}
+	for _, domain := range d.domains {
+		queues = append(queues, &domain.queue)
+	}
+	for _, q := range queues {
+		q.mu.Lock()
+		q.closing = true
+		q.mu.Unlock()
+	}
+	for _, q := range queues {
+		if err := q.drain(ctx); err != nil {
+			d.cancel()
+			return err
+		}
+	}
+	d.cancel()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if cachedDevInfo != nil { // controller in use
+		WaitForDisplayReady()
+	}
+	Exit()
+	d.errsMu.Lock()
+	defer d.errsMu.Unlock()
+	return errors.Join(d.errs...)
+}
@@ -0,0 +1,72 @@
+/*
+   it8951,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package it8951_test
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	it "github.com/peergum/IT8951-go"
+	"github.com/peergum/IT8951-go/sim"
+)
+
+// TestCloseDrains checks Close runs the functions already submitted, to the
+// display and its domains, and refuses those submitted afterwards
+func TestCloseDrains(t *testing.T) {
+	c := sim.New(sim.Typical(320, 240))
+	if _, err := it.Init(1500, it.WithTransport(c)); err != nil {
+		t.Fatal(err)
+	}
+	display := it.NewDisplay()
+	domain, err := display.AddDomain("ticker", image.Rect(0, 0, 160, 240))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 10
+	var ran atomic.Int32
+	var done []<-chan error
+	for i := 0; i < n; i++ {
+		job := func() error {
+			time.Sleep(5 * time.Millisecond)
+			ran.Add(1)
+			return nil
+		}
+		done = append(done, display.Submit(job), domain.Submit(job))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := display.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran.Load(); got != 2*n {
+		t.Errorf("%d functions ran instead of %d", got, 2*n)
+	}
+	for _, ch := range done {
+		if err := <-ch; err != nil {
+			t.Errorf("submission failed: %v", err)
+		}
+	}
+	if err := <-display.Submit(func() error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("submission after Close: %v, want context.Canceled", err)
+	}
+}